@@ -46,6 +46,18 @@ func init() {
 	rootCmd.Flags().StringVarP(&cfg.Monitor.ExcludeSubnets, "monitor-exclude-subnets", "e", cfg.Monitor.ExcludeSubnets, "排除的子网（逗号分隔，如：127.0.0.1/8,192.168.0.0/16）")
 	rootCmd.Flags().IntVarP(&cfg.Monitor.Window, "monitor-window", "w", cfg.Monitor.Window, "监控时间窗口（秒）")
 	rootCmd.Flags().IntVarP(&cfg.Monitor.Timeout, "monitor-timeout", "t", cfg.Monitor.Timeout, "连接超时时间（秒）")
+	rootCmd.Flags().StringVar(&cfg.Monitor.Source, "monitor-source", cfg.Monitor.Source, "流量数据源 (pcap|conntrack|flow)")
+	rootCmd.Flags().IntVar(&cfg.Monitor.PollInterval, "monitor-poll-interval", cfg.Monitor.PollInterval, "conntrack数据源轮询间隔（秒）")
+	rootCmd.Flags().BoolVar(&cfg.Monitor.ConntrackAcct, "monitor-conntrack-acct", cfg.Monitor.ConntrackAcct, "conntrack数据源启动时自动开启nf_conntrack_acct")
+	rootCmd.Flags().StringVar(&cfg.Monitor.Collector.Listen, "monitor-collector-listen", cfg.Monitor.Collector.Listen, "flow数据源的UDP监听地址（逗号分隔）")
+	rootCmd.Flags().StringVar(&cfg.Monitor.LocalSubnets, "monitor-local-subnets", cfg.Monitor.LocalSubnets, "额外视为本地的子网（逗号分隔）")
+	rootCmd.Flags().StringVar(&cfg.Monitor.Mode, "monitor-mode", cfg.Monitor.Mode, "监控模式 (host|router)")
+	rootCmd.Flags().IntVar(&cfg.Monitor.SampleRate, "monitor-sample-rate", cfg.Monitor.SampleRate, "pcap抓包抽样率，每N个包处理1个（1表示不抽样）")
+	rootCmd.Flags().BoolVar(&cfg.Monitor.InspectPayload, "monitor-inspect-payload", cfg.Monitor.InspectPayload, "从包内容中提取TLS SNI和HTTP Host")
+	rootCmd.Flags().StringVar(&cfg.Monitor.InternalSubnets, "monitor-internal-subnets", cfg.Monitor.InternalSubnets, "路由模式下的内网子网（逗号分隔）")
+	rootCmd.Flags().BoolVar(&cfg.Monitor.Process.Enabled, "monitor-process", cfg.Monitor.Process.Enabled, "将流量归属到本地进程和容器（需要读取/proc）")
+	rootCmd.Flags().BoolVar(&cfg.Monitor.Export.Enabled, "monitor-export", cfg.Monitor.Export.Enabled, "以NetFlow v9或IPFIX导出流记录")
+	rootCmd.Flags().StringVar(&cfg.Monitor.Export.Collectors, "monitor-export-collectors", cfg.Monitor.Export.Collectors, "流采集器地址（逗号分隔，如：10.0.0.1:2055）")
 
 	// 防火墙配置
 	rootCmd.Flags().StringVarP(&cfg.Firewall.Chain, "firewall-chain", "n", cfg.Firewall.Chain, "iptables链名称")
@@ -65,6 +77,15 @@ func init() {
 	rootCmd.Flags().StringVar(&cfg.Database.DSN, "db-dsn", cfg.Database.DSN, "数据库连接字符串")
 	rootCmd.Flags().StringVar(&cfg.Database.LogLevel, "db-log-level", cfg.Database.LogLevel, "SQL日志级别 (silent|error|warn|info)")
 
+	// 流量快照配置
+	rootCmd.Flags().BoolVar(&cfg.Snapshot.Enabled, "snapshot-enabled", cfg.Snapshot.Enabled, "定期将流量写入数据库，用于历史查询")
+
+	// 按需抓包参数
+	rootCmd.Flags().StringVar(&cfg.Capture.Dir, "capture-dir", cfg.Capture.Dir, "按需抓包的pcap文件保存目录")
+
+	// 带宽配额参数
+	rootCmd.Flags().BoolVar(&cfg.Quota.Enabled, "quota-enabled", cfg.Quota.Enabled, "按配额规则限制远程IP每个周期的流量")
+
 	// 添加使用示例
 	rootCmd.Example = `  # 使用默认配置启动（ipset模式）
   netbouncer
@@ -87,6 +108,21 @@ func init() {
   # 排除特定子网
   netbouncer -e "127.0.0.1/8,192.168.0.0/16"
 
+  # 使用conntrack数据源（无需抓包）
+  netbouncer --monitor-source conntrack
+
+  # 接收边界路由器发来的NetFlow/IPFIX/sFlow，统计整个网络的流量
+  netbouncer --monitor-source flow --monitor-collector-listen :2055,:6343 --monitor-local-subnets 203.0.113.0/24
+
+  # 在网关上统计内网主机经本机转发的流量
+  netbouncer --monitor-mode router --monitor-internal-subnets 192.168.1.0/24
+
+  # 高速链路上每100个包抽样1个，降低CPU占用
+  netbouncer --monitor-sample-rate 100
+
+  # 将流记录以IPFIX发送给采集器
+  netbouncer --monitor-export --monitor-export-collectors 10.0.0.1:4739
+
   # 使用MySQL数据库
   netbouncer --db-driver mysql --db-host localhost --db-name netbouncer`
 }
@@ -135,15 +171,35 @@ func run() error {
 	}
 
 	// 创建防火墙
-	fw, err := core.NewFirewallFromConfig(&cfg.Firewall)
+	fw, err := core.NewFirewallFromConfig(&cfg.Firewall, config.MonitorMode(cfg.Monitor.Mode) == config.MonitorModeRouter)
 	if err != nil {
 		return fmt.Errorf("创建防火墙失败: %w", err)
 	}
 
-	svc := service.NewNetService(mon, fw, store)
+	// 创建按需抓包管理器
+	capturer := core.NewCapturer(&cfg.Capture, cfg.Monitor.Interface)
+
+	svc := service.NewNetService(mon, fw, capturer, store)
 	if err := svc.Init(cfg.Rules); err != nil {
 		return fmt.Errorf("初始化失败: %w", err)
 	}
+	svc.StartWatchRoutine()
+	if cfg.Snapshot.Enabled {
+		svc.StartSnapshotRoutine(&cfg.Snapshot)
+	}
+	if cfg.Monitor.InspectPayload {
+		svc.StartFingerprintRoutine()
+	}
+	if cfg.Quota.Enabled {
+		if err := svc.StartQuotaRoutine(&cfg.Quota); err != nil {
+			return fmt.Errorf("启动带宽配额失败: %w", err)
+		}
+	}
+	if cfg.Monitor.Detection.AutoBan && mon.DetectionEnabled() {
+		if err := svc.StartDetectionRoutine(&cfg.Monitor.Detection); err != nil {
+			return fmt.Errorf("启动检测事件自动封禁失败: %w", err)
+		}
+	}
 
 	// 创建认证处理器
 	authHandler, err := web.NewAuthHandler(context.Background(), &web.AuthConfig{
@@ -6,17 +6,117 @@ type Config struct {
 	Firewall FirewallConfig    `yaml:"firewall"`
 	Web      WebConfig         `yaml:"web"`
 	Database DatabaseConfig    `yaml:"database"`
+	Snapshot SnapshotConfig    `yaml:"snapshot"`
+	Capture  CaptureConfig     `yaml:"capture"`
+	Quota    QuotaConfig       `yaml:"quota"`
 	Rules    []RulesInitConfig `yaml:"rules"` // 初始化的默认规则
 }
 
 // MonitorConfig 网络和监控配置
 type MonitorConfig struct {
-	Interface      string `yaml:"interface"`       // 网络接口名称
-	ExcludeSubnets string `yaml:"exclude_subnets"` // 排除的子网（逗号分隔）
-	Window         int    `yaml:"window"`          // 监控时间窗口（秒）
-	Timeout        int    `yaml:"timeout"`         // 连接超时时间（秒）
+	Interface       string  `yaml:"interface"`        // 网络接口名称
+	ExcludeSubnets  string  `yaml:"exclude_subnets"`  // 排除的子网（逗号分隔）
+	LocalSubnets    string  `yaml:"local_subnets"`    // 额外视为本地的子网（逗号分隔）
+	Mode            string  `yaml:"mode"`             // 监控模式: "host" 或 "router"
+	InternalSubnets string  `yaml:"internal_subnets"` // 路由模式下的内网子网（逗号分隔），视为本地
+	Window          int     `yaml:"window"`           // 监控时间窗口（秒）
+	WindowBucket    int     `yaml:"window_bucket"`    // 时间窗口中单个时间桶的长度（秒）
+	Timeout         int     `yaml:"timeout"`          // 连接超时时间（秒）
+	Source          string  `yaml:"source"`           // 流量数据源: "pcap"、"conntrack" 或 "flow"
+	PollInterval    int     `yaml:"poll_interval"`    // conntrack数据源轮询间隔（秒）
+	ConntrackAcct   bool    `yaml:"conntrack_acct"`   // conntrack数据源启动时是否自动开启内核的nf_conntrack_acct
+	FlowTCPTimeout  int     `yaml:"flow_tcp_timeout"` // 已建立TCP连接的空闲超时（秒）
+	FlowUDPTimeout  int     `yaml:"flow_udp_timeout"` // UDP流的空闲超时（秒）
+	TopPorts        int     `yaml:"top_ports"`        // 流量详情中返回的本地端口数量
+	MaxTrackedIPs   int     `yaml:"max_tracked_ips"`  // 最多跟踪的远程IP数量，0表示不限制
+	EvictionPolicy  string  `yaml:"eviction_policy"`  // 超出上限时的淘汰策略: "lru" 或 "least_traffic"
+	HeavyHitters    int     `yaml:"heavy_hitters"`    // 流量排行（/api/traffic/top）返回的IP数量
+	HistorySeconds  int     `yaml:"history_seconds"`  // 每个IP保留的秒级历史速率点数，最多3600
+	DropWarnRatio   float64 `yaml:"drop_warn_ratio"`  // 抓包丢包率超过该值时告警
+	SampleRate      int     `yaml:"sample_rate"`      // pcap抓包抽样率，每N个包处理1个，1表示不抽样
+	DNSCacheSize    int     `yaml:"dns_cache_size"`   // 被动DNS缓存的IP数量上限
+	Snaplen         int     `yaml:"snaplen"`          // pcap每个包捕获的最大字节数
+	InspectPayload  bool    `yaml:"inspect_payload"`  // 是否从包内容中提取TLS SNI和HTTP Host
+
+	Detection DetectionConfig `yaml:"detection"` // 端口扫描和SYN洪水检测
+	Baseline  BaselineConfig  `yaml:"baseline"`  // 按本地端口的流量基线和异常检测
+	Process   ProcessConfig   `yaml:"process"`   // 将流量归属到本地进程和容器
+	Export    ExportConfig    `yaml:"export"`    // 以NetFlow v9或IPFIX导出流记录
+	Collector CollectorConfig `yaml:"collector"` // flow数据源接收NetFlow/IPFIX/sFlow的配置
+}
+
+// CollectorConfig flow数据源的配置，接收路由器等设备发来的NetFlow v5/v9、IPFIX和sFlow v5
+type CollectorConfig struct {
+	Listen     string `yaml:"listen"`      // UDP监听地址（逗号分隔），所有格式可以共用同一端口
+	SampleRate int    `yaml:"sample_rate"` // 记录中和选项模板中都没有抽样率时使用的抽样率
+}
+
+// ExportConfig 将监控到的流以NetFlow v9或IPFIX格式通过UDP发送给采集器的配置
+type ExportConfig struct {
+	Enabled         bool   `yaml:"enabled"`          // 是否导出流记录
+	Protocol        string `yaml:"protocol"`         // 导出格式: "netflow9" 或 "ipfix"
+	Collectors      string `yaml:"collectors"`       // 采集器地址（逗号分隔，host:port）
+	ActiveTimeout   int    `yaml:"active_timeout"`   // 持续活动的流每隔多久导出一次（秒）
+	InactiveTimeout int    `yaml:"inactive_timeout"` // 流空闲多久后导出并结束（秒）
+	MaxFlows        int    `yaml:"max_flows"`        // 等待导出的流数量上限，超出后新的流不再记录
+}
+
+// ProcessConfig 将流量归属到本地进程和容器的配置，通过扫描/proc实现，只对本机的socket有效
+type ProcessConfig struct {
+	Enabled  bool `yaml:"enabled"`  // 是否将远程IP的流量归属到本地进程和容器
+	Interval int  `yaml:"interval"` // 重新扫描/proc的间隔（秒）
+}
+
+// BaselineConfig 按本地端口学习入站流量基线的配置
+type BaselineConfig struct {
+	Enabled         bool    `yaml:"enabled"`          // 是否启用基线学习和异常检测
+	Interval        int     `yaml:"interval"`         // 统计周期（秒），每个周期计算一次各项指标
+	Alpha           float64 `yaml:"alpha"`            // EWMA平滑系数，越大基线跟随越快
+	Threshold       float64 `yaml:"threshold"`        // 偏离基线超过多少个标准差时产生异常事件
+	WarmUp          int     `yaml:"warm_up"`          // 学习多少个周期后才开始检测
+	TopContributors int     `yaml:"top_contributors"` // 异常事件中列出的贡献最多的远程IP数量
 }
 
+// DetectionConfig 端口扫描和SYN洪水检测配置，只对pcap数据源生效
+type DetectionConfig struct {
+	Enabled          bool   `yaml:"enabled"`             // 是否启用检测
+	PortScanPorts    int    `yaml:"port_scan_ports"`     // 时间窗口内访问的不同本地端口数达到该值时视为端口扫描
+	PortScanWindow   int    `yaml:"port_scan_window"`    // 端口扫描的统计窗口（秒）
+	SynFloodHalfOpen int    `yaml:"syn_flood_half_open"` // 远程发起的未完成握手的连接数达到该值时视为SYN洪水
+	Cooldown         int    `yaml:"cooldown"`            // 同一IP同一类型的检测事件最短间隔（秒）
+	AutoBan          bool   `yaml:"auto_ban"`            // 是否自动封禁被检测到的IP
+	AutoBanGroup     string `yaml:"auto_ban_group"`      // 自动封禁的IP加入的组名，为空时使用默认组
+}
+
+type MonitorSourceType string
+
+const (
+	MonitorSourcePcap      MonitorSourceType = "pcap"
+	MonitorSourceConntrack MonitorSourceType = "conntrack"
+	MonitorSourceFlow      MonitorSourceType = "flow" // 接收NetFlow/IPFIX/sFlow
+)
+
+type ExportProtocol string
+
+const (
+	ExportProtocolNetflow9 ExportProtocol = "netflow9"
+	ExportProtocolIPFIX    ExportProtocol = "ipfix"
+)
+
+type MonitorMode string
+
+const (
+	MonitorModeHost   MonitorMode = "host"   // 只统计本机收发的流量
+	MonitorModeRouter MonitorMode = "router" // 网关/NAT路由器，统计内网主机经本机转发的流量
+)
+
+type EvictionPolicy string
+
+const (
+	EvictionPolicyLRU          EvictionPolicy = "lru"
+	EvictionPolicyLeastTraffic EvictionPolicy = "least_traffic"
+)
+
 type FirewallType string
 
 const (
@@ -80,3 +180,28 @@ type DatabaseConfig struct {
 	DSN      string `yaml:"dsn"`       // 数据库连接字符串
 	LogLevel string `yaml:"log_level"` // SQL日志级别: "silent", "error", "warn", "info"
 }
+
+// SnapshotConfig 流量快照持久化配置
+type SnapshotConfig struct {
+	Enabled         bool `yaml:"enabled"`          // 是否定期将流量写入数据库
+	Interval        int  `yaml:"interval"`         // 写入间隔（秒）
+	MinuteRetention int  `yaml:"minute_retention"` // 分钟级记录保留天数，之后合并为小时级
+	HourRetention   int  `yaml:"hour_retention"`   // 小时级记录保留天数，之后合并为天级
+	DayRetention    int  `yaml:"day_retention"`    // 天级记录保留天数
+}
+
+// CaptureConfig 按需抓包配置
+type CaptureConfig struct {
+	Dir           string `yaml:"dir"`            // pcap文件保存目录
+	MaxConcurrent int    `yaml:"max_concurrent"` // 同时进行的抓包任务数上限
+	MaxDuration   int    `yaml:"max_duration"`   // 单次抓包的最长时间（秒）
+	MaxPackets    int    `yaml:"max_packets"`    // 单次抓包的最多包数
+	MaxFileSize   int    `yaml:"max_file_size"`  // 单个pcap文件的大小上限（MB）
+	MaxFiles      int    `yaml:"max_files"`      // 保留的抓包文件数，超出时删除最早的
+}
+
+// QuotaConfig 带宽配额配置，配额规则通过API管理
+type QuotaConfig struct {
+	Enabled  bool `yaml:"enabled"`  // 是否按配额规则累计远程IP的流量并在超额时执行动作
+	Interval int  `yaml:"interval"` // 累计流量和检查配额的间隔（秒）
+}
@@ -46,8 +46,50 @@ func DefaultConfig() *Config {
 		Monitor: MonitorConfig{
 			Interface:      "",
 			ExcludeSubnets: "",
+			LocalSubnets:   "",
+			Mode:           "host",
 			Window:         30,
+			WindowBucket:   1,
 			Timeout:        60 * 60 * 24, // 24小时
+			Source:         "pcap",
+			PollInterval:   5,
+			FlowTCPTimeout: 600,
+			FlowUDPTimeout: 60,
+			TopPorts:       10,
+			MaxTrackedIPs:  100000,
+			EvictionPolicy: "lru",
+			HeavyHitters:   20,
+			HistorySeconds: 3600,
+			DropWarnRatio:  0.01,
+			SampleRate:     1,
+			DNSCacheSize:   10000,
+			Snaplen:        1600,
+			Detection: DetectionConfig{
+				PortScanPorts:    50,
+				PortScanWindow:   60,
+				SynFloodHalfOpen: 100,
+				Cooldown:         300,
+			},
+			Baseline: BaselineConfig{
+				Interval:        60,
+				Alpha:           0.1,
+				Threshold:       3,
+				WarmUp:          10,
+				TopContributors: 5,
+			},
+			Process: ProcessConfig{
+				Interval: 10,
+			},
+			Export: ExportConfig{
+				Protocol:        "ipfix",
+				ActiveTimeout:   60,
+				InactiveTimeout: 15,
+				MaxFlows:        65536,
+			},
+			Collector: CollectorConfig{
+				Listen:     ":2055",
+				SampleRate: 1,
+			},
 		},
 		Firewall: FirewallConfig{
 			Chain: "NETBOUNCER",
@@ -66,5 +108,24 @@ func DefaultConfig() *Config {
 			Database: "netbouncer.db",
 			DSN:      "",
 		},
+		Snapshot: SnapshotConfig{
+			Enabled:         false,
+			Interval:        60,
+			MinuteRetention: 1,
+			HourRetention:   30,
+			DayRetention:    365,
+		},
+		Capture: CaptureConfig{
+			Dir:           "captures",
+			MaxConcurrent: 2,
+			MaxDuration:   300,
+			MaxPackets:    100000,
+			MaxFileSize:   100,
+			MaxFiles:      20,
+		},
+		Quota: QuotaConfig{
+			Enabled:  false,
+			Interval: 10,
+		},
 	}
 }
@@ -0,0 +1,123 @@
+package core
+
+import (
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// captureHealthInterval 健康状况采样周期
+const captureHealthInterval = 10 * time.Second
+
+// CaptureStats 数据源的累计抓包计数
+type CaptureStats struct {
+	PacketsReceived  uint64 // 过滤器接收的包数
+	PacketsDropped   uint64 // 内核因缓冲区满丢弃的包数
+	PacketsIfDropped uint64 // 网卡或驱动丢弃的包数
+}
+
+// CaptureHealth 数据源健康状况，丢包率和处理延迟按最近一个采样周期计算
+type CaptureHealth struct {
+	Source           string    `json:"source"`
+	StatsSupported   bool      `json:"stats_supported"`    // 数据源是否提供抓包计数
+	PacketsReceived  uint64    `json:"packets_received"`   // 累计接收包数
+	PacketsDropped   uint64    `json:"packets_dropped"`    // 累计内核丢包数
+	PacketsIfDropped uint64    `json:"packets_if_dropped"` // 累计网卡丢包数
+	DropRatio        float64   `json:"drop_ratio"`         // 最近周期的丢包率
+	Processed        uint64    `json:"processed"`          // 最近周期处理的包数或轮询次数
+	AvgLatencyMs     float64   `json:"avg_latency_ms"`     // 最近周期的平均处理延迟（毫秒）
+	MaxLatencyMs     float64   `json:"max_latency_ms"`     // 最近周期的最大处理延迟（毫秒）
+	Warning          bool      `json:"warning"`            // 丢包率超过阈值
+	SampledAt        time.Time `json:"sampled_at"`         // 采样时间
+}
+
+// captureHealth 采集处理延迟并定期计算数据源健康状况
+type captureHealth struct {
+	mutex         sync.Mutex
+	dropWarnRatio float64
+
+	// 当前周期的处理延迟，每次采样后清零
+	processed    uint64
+	latencyTotal time.Duration
+	latencyMax   time.Duration
+
+	last   CaptureStats  // 上次采样时的累计计数
+	health CaptureHealth // 最近一次采样结果
+}
+
+// newCaptureHealth 创建健康状况采集器
+func newCaptureHealth(source string, dropWarnRatio float64) *captureHealth {
+	return &captureHealth{
+		dropWarnRatio: dropWarnRatio,
+		health:        CaptureHealth{Source: source},
+	}
+}
+
+// recordLatency 记录一次处理延迟
+func (h *captureHealth) recordLatency(latency time.Duration) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	h.processed++
+	h.latencyTotal += latency
+	h.latencyMax = max(h.latencyMax, latency)
+}
+
+// sample 根据数据源的累计计数计算最近周期的丢包率和处理延迟
+func (h *captureHealth) sample(stats CaptureStats, supported bool, now time.Time) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	health := CaptureHealth{
+		Source:           h.health.Source,
+		StatsSupported:   supported,
+		PacketsReceived:  stats.PacketsReceived,
+		PacketsDropped:   stats.PacketsDropped,
+		PacketsIfDropped: stats.PacketsIfDropped,
+		Processed:        h.processed,
+		MaxLatencyMs:     float64(h.latencyMax) / float64(time.Millisecond),
+		SampledAt:        now,
+	}
+	if h.processed > 0 {
+		health.AvgLatencyMs = float64(h.latencyTotal) / float64(h.processed) / float64(time.Millisecond)
+	}
+
+	// 计数器可能因数据源重启而回绕，此时只使用当前值
+	received, dropped := stats.PacketsReceived, stats.PacketsDropped+stats.PacketsIfDropped
+	if stats.PacketsReceived >= h.last.PacketsReceived && stats.PacketsDropped >= h.last.PacketsDropped && stats.PacketsIfDropped >= h.last.PacketsIfDropped {
+		received -= h.last.PacketsReceived
+		dropped -= h.last.PacketsDropped + h.last.PacketsIfDropped
+	}
+	if received+dropped > 0 {
+		health.DropRatio = float64(dropped) / float64(received+dropped)
+	}
+	health.Warning = supported && health.DropRatio > h.dropWarnRatio
+
+	if health.Warning && !h.health.Warning {
+		slog.Warn("抓包丢包率过高，流量统计可能偏低", "source", health.Source, "drop_ratio", health.DropRatio, "threshold", h.dropWarnRatio)
+	} else if !health.Warning && h.health.Warning {
+		slog.Info("抓包丢包率已恢复正常", "source", health.Source, "drop_ratio", health.DropRatio)
+	}
+
+	h.last = stats
+	h.health = health
+	h.processed, h.latencyTotal, h.latencyMax = 0, 0, 0
+}
+
+// get 返回最近一次采样结果
+func (h *captureHealth) get() CaptureHealth {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	return h.health
+}
+
+// sampleCaptureHealth 读取数据源的抓包计数并更新健康状况
+func (m *Monitor) sampleCaptureHealth() {
+	stats, supported := m.source.CaptureStats()
+	m.health.sample(stats, supported, time.Now())
+}
+
+// GetCaptureHealth 获取最近一次采样的数据源健康状况
+func (m *Monitor) GetCaptureHealth() CaptureHealth {
+	return m.health.get()
+}
@@ -0,0 +1,88 @@
+package core
+
+import (
+	"testing"
+	"time"
+)
+
+func Test_captureHealth_sample(t *testing.T) {
+	tests := []struct {
+		name        string
+		samples     []CaptureStats
+		supported   bool
+		wantRatio   float64
+		wantWarning bool
+	}{
+		{
+			name:      "no_traffic",
+			samples:   []CaptureStats{{}},
+			supported: true,
+		},
+		{
+			name:        "first_sample",
+			samples:     []CaptureStats{{PacketsReceived: 90, PacketsDropped: 10}},
+			supported:   true,
+			wantRatio:   0.1,
+			wantWarning: true,
+		},
+		{
+			name:      "delta_below_threshold",
+			samples:   []CaptureStats{{PacketsReceived: 90, PacketsDropped: 10}, {PacketsReceived: 1090, PacketsDropped: 10}},
+			supported: true,
+		},
+		{
+			name:        "delta_with_if_dropped",
+			samples:     []CaptureStats{{PacketsReceived: 1000}, {PacketsReceived: 1900, PacketsDropped: 50, PacketsIfDropped: 50}},
+			supported:   true,
+			wantRatio:   0.1,
+			wantWarning: true,
+		},
+		{
+			name:        "counter_reset",
+			samples:     []CaptureStats{{PacketsReceived: 1000, PacketsDropped: 100}, {PacketsReceived: 80, PacketsDropped: 20}},
+			supported:   true,
+			wantRatio:   0.2,
+			wantWarning: true,
+		},
+		{
+			name:      "unsupported",
+			samples:   []CaptureStats{{}},
+			supported: false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := newCaptureHealth("test", 0.01)
+			now := time.Unix(1700000000, 0)
+			for _, stats := range tt.samples {
+				h.sample(stats, tt.supported, now)
+				now = now.Add(captureHealthInterval)
+			}
+			got := h.get()
+			if got.DropRatio != tt.wantRatio {
+				t.Errorf("DropRatio = %v, want %v", got.DropRatio, tt.wantRatio)
+			}
+			if got.Warning != tt.wantWarning {
+				t.Errorf("Warning = %v, want %v", got.Warning, tt.wantWarning)
+			}
+		})
+	}
+}
+
+func Test_captureHealth_latency(t *testing.T) {
+	h := newCaptureHealth("test", 0.01)
+	h.recordLatency(time.Millisecond)
+	h.recordLatency(3 * time.Millisecond)
+	h.sample(CaptureStats{}, true, time.Now())
+
+	got := h.get()
+	if got.Processed != 2 || got.AvgLatencyMs != 2 || got.MaxLatencyMs != 3 {
+		t.Errorf("got processed=%d avg=%v max=%v, want 2, 2, 3", got.Processed, got.AvgLatencyMs, got.MaxLatencyMs)
+	}
+
+	// 采样后清零，下一周期重新计算
+	h.sample(CaptureStats{}, true, time.Now())
+	if got := h.get(); got.Processed != 0 || got.MaxLatencyMs != 0 {
+		t.Errorf("latency not reset: processed=%d max=%v", got.Processed, got.MaxLatencyMs)
+	}
+}
@@ -0,0 +1,244 @@
+package core
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/google/gopacket/layers"
+	"github.com/vishvananda/netlink"
+)
+
+// conntrackAcctPath 内核conntrack流量计数开关
+const conntrackAcctPath = "/proc/sys/net/netfilter/nf_conntrack_acct"
+
+// ConntrackSource 通过netlink读取conntrack表中的流量计数，不需要抓包
+type ConntrackSource struct {
+	pollInterval time.Duration
+	enableAcct   bool // nf_conntrack_acct未开启时是否自动开启，该设置对整个主机生效
+	stopChan     chan struct{}
+
+	// 上一次轮询时各连接的累计计数，用于计算增量，仅在轮询协程中访问，首次轮询前为nil
+	lastCounters map[string]conntrackCounters
+}
+
+// conntrackCounters 单个conntrack连接的累计计数
+type conntrackCounters struct {
+	origBytes    uint64
+	origPackets  uint64
+	replyBytes   uint64
+	replyPackets uint64
+}
+
+// newConntrackSource 创建conntrack数据源，enableAcct为true时允许自动开启nf_conntrack_acct
+func newConntrackSource(pollInterval time.Duration, enableAcct bool) *ConntrackSource {
+	if pollInterval <= 0 {
+		pollInterval = 5 * time.Second
+	}
+	return &ConntrackSource{
+		pollInterval: pollInterval,
+		enableAcct:   enableAcct,
+		stopChan:     make(chan struct{}),
+	}
+}
+
+func (c *ConntrackSource) Name() string {
+	return "conntrack"
+}
+
+func (c *ConntrackSource) Start(m *Monitor) error {
+	if err := ensureConntrackAcct(c.enableAcct); err != nil {
+		return err
+	}
+
+	// 先读取一次，确认有权限访问conntrack表
+	if _, err := listConntrackFlows(); err != nil {
+		return fmt.Errorf("读取conntrack表失败: %w", err)
+	}
+
+	go func() {
+		ticker := time.NewTicker(c.pollInterval)
+		defer ticker.Stop()
+
+		c.poll(m)
+		for {
+			select {
+			case <-ticker.C:
+				c.poll(m)
+			case <-c.stopChan:
+				return
+			}
+		}
+	}()
+
+	slog.Info("conntrack数据源已启动", "poll_interval", c.pollInterval)
+	return nil
+}
+
+func (c *ConntrackSource) Stop() {
+	close(c.stopChan)
+}
+
+func (c *ConntrackSource) DebugInfo() map[string]interface{} {
+	return map[string]interface{}{
+		"poll_interval": c.pollInterval.String(),
+	}
+}
+
+// CaptureStats conntrack直接读取内核计数，不存在抓包丢包
+func (c *ConntrackSource) CaptureStats() (CaptureStats, bool) {
+	return CaptureStats{}, false
+}
+
+// ensureConntrackAcct 检查nf_conntrack_acct，未开启时conntrack不记录字节和包计数
+// 该设置对整个主机生效，只有enable为true时才自动开启，否则返回错误
+func ensureConntrackAcct(enable bool) error {
+	data, err := os.ReadFile(conntrackAcctPath)
+	if err != nil {
+		slog.Warn("无法读取nf_conntrack_acct，流量计数可能为0", "path", conntrackAcctPath, "error", err)
+		return nil
+	}
+	if strings.TrimSpace(string(data)) == "1" {
+		return nil
+	}
+	if !enable {
+		return fmt.Errorf("nf_conntrack_acct未开启，conntrack不记录流量计数，请执行 sysctl -w net.netfilter.nf_conntrack_acct=1 或设置 monitor.conntrack_acct 允许自动开启")
+	}
+
+	if err := os.WriteFile(conntrackAcctPath, []byte("1"), 0644); err != nil {
+		return fmt.Errorf("开启nf_conntrack_acct失败: %w", err)
+	}
+	slog.Info("已开启nf_conntrack_acct", "path", conntrackAcctPath)
+	return nil
+}
+
+// listConntrackFlows 读取IPv4和IPv6的conntrack表
+func listConntrackFlows() ([]*netlink.ConntrackFlow, error) {
+	flows, err := netlink.ConntrackTableList(netlink.ConntrackTable, netlink.FAMILY_V4)
+	if err != nil {
+		return nil, err
+	}
+	flowsV6, err := netlink.ConntrackTableList(netlink.ConntrackTable, netlink.FAMILY_V6)
+	if err != nil {
+		return nil, err
+	}
+	return append(flows, flowsV6...), nil
+}
+
+// conntrackFlowKey 使用原始方向的五元组和zone标识一个连接
+func conntrackFlowKey(flow *netlink.ConntrackFlow) string {
+	return fmt.Sprintf("%d/%d/%s:%d/%s:%d", flow.Zone, flow.Forward.Protocol,
+		flow.Forward.SrcIP, flow.Forward.SrcPort, flow.Forward.DstIP, flow.Forward.DstPort)
+}
+
+// poll 读取conntrack表，将计数增量累加到监控器中，并用连接表中的条目数作为连接数
+func (c *ConntrackSource) poll(m *Monitor) {
+	start := time.Now()
+	// 一次轮询的耗时作为处理延迟
+	defer func() { m.health.recordLatency(time.Since(start)) }()
+
+	flows, err := listConntrackFlows()
+	if err != nil {
+		slog.Error("读取conntrack表失败", "error", err)
+		return
+	}
+
+	counters := make(map[string]conntrackCounters, len(flows))
+	counts := make(map[string]flowCounts)
+
+	for _, flow := range flows {
+		remoteIP, localIP, outbound, ok := c.classifyFlow(m, flow)
+		if !ok {
+			continue
+		}
+
+		key := conntrackFlowKey(flow)
+		current := conntrackCounters{
+			origBytes:    flow.Forward.Bytes,
+			origPackets:  flow.Forward.Packets,
+			replyBytes:   flow.Reverse.Bytes,
+			replyPackets: flow.Reverse.Packets,
+		}
+		counters[key] = current
+
+		// 计算与上次轮询的差值，计数变小说明是复用了五元组的新连接
+		last, exists := c.lastCounters[key]
+		isNew := !exists || current.origBytes < last.origBytes || current.replyBytes < last.replyBytes
+		delta := current
+		if !isNew {
+			delta = conntrackCounters{
+				origBytes:    current.origBytes - last.origBytes,
+				origPackets:  current.origPackets - last.origPackets,
+				replyBytes:   current.replyBytes - last.replyBytes,
+				replyPackets: current.replyPackets - last.replyPackets,
+			}
+		}
+
+		count := counts[remoteIP]
+		count.total++
+		switch flow.Forward.Protocol {
+		case uint8(layers.IPProtocolTCP):
+			count.tcp++
+		case uint8(layers.IPProtocolUDP):
+			count.udp++
+		}
+		// 首次轮询时表中已有的连接不计为新建
+		if isNew && c.lastCounters != nil {
+			count.newFlows++
+		}
+		counts[remoteIP] = count
+
+		// 原始方向由本地发起时为发送，由远程发起时为接收
+		sample := trafficSample{
+			remoteIP: remoteIP,
+			localIP:  localIP,
+			protocol: layers.IPProtocol(flow.Forward.Protocol),
+		}
+		if outbound {
+			sample.localPort, sample.remotePort = flow.Forward.SrcPort, flow.Forward.DstPort
+		} else {
+			sample.localPort, sample.remotePort = flow.Forward.DstPort, flow.Forward.SrcPort
+			sample.servicePort = flow.Forward.DstPort
+			if isNew && c.lastCounters != nil {
+				sample.newFlows = 1
+			}
+		}
+		if delta.origPackets > 0 {
+			sample.bytes, sample.packets, sample.isSent = delta.origBytes, delta.origPackets, outbound
+			// conntrack中的ICMP连接基本由回显请求建立，远程发起的ICMP连接原始方向视为回显请求
+			if !outbound && protocolName(sample.protocol) == ProtocolICMP {
+				sample.icmp = icmpEchoRequest
+			}
+			m.updateStats(sample)
+		}
+		if delta.replyPackets > 0 {
+			sample.bytes, sample.packets, sample.isSent = delta.replyBytes, delta.replyPackets, !outbound
+			sample.icmp = icmpOther
+			sample.newFlows = 0
+			m.updateStats(sample)
+		}
+	}
+
+	c.lastCounters = counters
+	m.setFlowCounts(counts)
+}
+
+// classifyFlow 确定conntrack连接的远程IP和本地IP，outbound表示连接由本地发起
+func (c *ConntrackSource) classifyFlow(m *Monitor, flow *netlink.ConntrackFlow) (remoteIP, localIP string, outbound bool, ok bool) {
+	origSrc := flow.Forward.SrcIP.String()
+	origDst := flow.Forward.DstIP.String()
+
+	if remoteIP, localIP, isSent, ok := m.classify(origSrc, origDst); ok {
+		return remoteIP, localIP, isSent, true
+	}
+
+	// 经过SNAT的出站连接，回复方向的目的地址才是本机地址
+	replyDst := flow.Reverse.DstIP.String()
+	if remoteIP, localIP, isSent, ok := m.classify(replyDst, origDst); ok && isSent {
+		return remoteIP, localIP, true, true
+	}
+
+	return "", "", false, false
+}
@@ -0,0 +1,142 @@
+package core
+
+import "sort"
+
+const (
+	sketchDepth = 4    // 哈希函数数量
+	sketchWidth = 2048 // 每行计数器数量
+)
+
+// sketchSeeds 每行使用的哈希种子
+var sketchSeeds = [sketchDepth]uint64{
+	0x9e3779b97f4a7c15,
+	0xc2b2ae3d27d4eb4f,
+	0x165667b19e3779f9,
+	0x27d4eb2f165667c5,
+}
+
+// countMinSketch 固定内存的频率估计，估计值只会偏大不会偏小
+// 不带锁，由所属分片的锁保护
+type countMinSketch struct {
+	counters [sketchDepth][sketchWidth]uint64
+}
+
+// sketchIndex 计算key在某一行中的位置
+func sketchIndex(key string, row int) int {
+	hash := sketchSeeds[row]
+	for i := 0; i < len(key); i++ {
+		hash ^= uint64(key[i])
+		hash *= 1099511628211
+	}
+	hash ^= hash >> 29
+	return int(hash % sketchWidth)
+}
+
+// add 累加并返回累加后的估计值
+func (s *countMinSketch) add(key string, increment uint64) uint64 {
+	var estimate uint64
+	for row := 0; row < sketchDepth; row++ {
+		counter := &s.counters[row][sketchIndex(key, row)]
+		*counter += increment
+		if row == 0 || *counter < estimate {
+			estimate = *counter
+		}
+	}
+	return estimate
+}
+
+// estimate 返回key的估计值
+func (s *countMinSketch) estimate(key string) uint64 {
+	var estimate uint64
+	for row := 0; row < sketchDepth; row++ {
+		counter := s.counters[row][sketchIndex(key, row)]
+		if row == 0 || counter < estimate {
+			estimate = counter
+		}
+	}
+	return estimate
+}
+
+// decay 所有计数减半，使估计值偏向近期流量
+func (s *countMinSketch) decay() {
+	for row := range s.counters {
+		for i := range s.counters[row] {
+			s.counters[row][i] >>= 1
+		}
+	}
+}
+
+// HeavyHitter 流量最大的远程IP，字节数为count-min sketch的估计值
+type HeavyHitter struct {
+	RemoteIP string `json:"remote_ip"`
+	Bytes    uint64 `json:"bytes"`
+	Tracked  bool   `json:"tracked"` // 是否有完整的流量统计，超出跟踪上限时可能为false
+}
+
+// heavyHitters 配合count-min sketch维护流量最大的k个IP
+type heavyHitters struct {
+	limit     int
+	estimates map[string]uint64
+}
+
+// newHeavyHitters 创建heavy hitter候选集
+func newHeavyHitters(limit int) *heavyHitters {
+	return &heavyHitters{
+		limit:     limit,
+		estimates: make(map[string]uint64),
+	}
+}
+
+// offer 用最新的估计值更新候选集，候选集已满时替换估计值最小的IP
+func (h *heavyHitters) offer(ip string, estimate uint64) {
+	if _, exists := h.estimates[ip]; exists || len(h.estimates) < h.limit {
+		h.estimates[ip] = estimate
+		return
+	}
+
+	var minIP string
+	var minEstimate uint64
+	for candidate, value := range h.estimates {
+		if minIP == "" || value < minEstimate {
+			minIP, minEstimate = candidate, value
+		}
+	}
+	if estimate > minEstimate {
+		delete(h.estimates, minIP)
+		h.estimates[ip] = estimate
+	}
+}
+
+// decay 候选集估计值减半，与sketch保持一致
+func (h *heavyHitters) decay() {
+	for ip, value := range h.estimates {
+		if value >>= 1; value == 0 {
+			delete(h.estimates, ip)
+		} else {
+			h.estimates[ip] = value
+		}
+	}
+}
+
+// top 返回估计值最大的n个IP
+func (h *heavyHitters) top(n int) []HeavyHitter {
+	result := make([]HeavyHitter, 0, len(h.estimates))
+	for ip, value := range h.estimates {
+		result = append(result, HeavyHitter{RemoteIP: ip, Bytes: value})
+	}
+	sortHeavyHitters(result)
+	if len(result) > n {
+		result = result[:n]
+	}
+	return result
+}
+
+// sortHeavyHitters 按估计流量从大到小排序
+func sortHeavyHitters(hitters []HeavyHitter) {
+	sort.Slice(hitters, func(i, j int) bool {
+		if hitters[i].Bytes != hitters[j].Bytes {
+			return hitters[i].Bytes > hitters[j].Bytes
+		}
+		return hitters[i].RemoteIP < hitters[j].RemoteIP
+	})
+}
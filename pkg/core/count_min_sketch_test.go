@@ -0,0 +1,79 @@
+package core
+
+import (
+	"fmt"
+	"testing"
+)
+
+func Test_countMinSketch_estimate(t *testing.T) {
+	var sketch countMinSketch
+	for i := 0; i < 10000; i++ {
+		sketch.add(fmt.Sprintf("10.%d.%d.%d", i>>16&0xff, i>>8&0xff, i&0xff), 100)
+	}
+	sketch.add("1.1.1.1", 1000000)
+
+	got := sketch.estimate("1.1.1.1")
+	if got < 1000000 {
+		t.Errorf("estimate() = %v, want >= 1000000", got)
+	}
+	// 误差上限约为总量的 e/width
+	if got > 1000000+2000000*3/sketchWidth {
+		t.Errorf("estimate() = %v, overestimated too much", got)
+	}
+
+	sketch.decay()
+	if got := sketch.estimate("1.1.1.1"); got < 500000 {
+		t.Errorf("estimate() after decay = %v, want >= 500000", got)
+	}
+}
+
+func Test_heavyHitters_offer(t *testing.T) {
+	tests := []struct {
+		name   string
+		limit  int
+		offers []HeavyHitter
+		want   []string
+	}{
+		{
+			name:   "under_limit",
+			limit:  3,
+			offers: []HeavyHitter{{RemoteIP: "a", Bytes: 1}, {RemoteIP: "b", Bytes: 2}},
+			want:   []string{"b", "a"},
+		},
+		{
+			name:   "replace_smallest",
+			limit:  2,
+			offers: []HeavyHitter{{RemoteIP: "a", Bytes: 1}, {RemoteIP: "b", Bytes: 5}, {RemoteIP: "c", Bytes: 3}},
+			want:   []string{"b", "c"},
+		},
+		{
+			name:   "reject_smaller",
+			limit:  2,
+			offers: []HeavyHitter{{RemoteIP: "a", Bytes: 4}, {RemoteIP: "b", Bytes: 5}, {RemoteIP: "c", Bytes: 3}},
+			want:   []string{"b", "a"},
+		},
+		{
+			name:   "update_existing",
+			limit:  2,
+			offers: []HeavyHitter{{RemoteIP: "a", Bytes: 4}, {RemoteIP: "b", Bytes: 5}, {RemoteIP: "a", Bytes: 9}},
+			want:   []string{"a", "b"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := newHeavyHitters(tt.limit)
+			for _, offer := range tt.offers {
+				h.offer(offer.RemoteIP, offer.Bytes)
+			}
+			top := h.top(tt.limit)
+			if len(top) != len(tt.want) {
+				t.Fatalf("top() = %v, want %v", top, tt.want)
+			}
+			for i, ip := range tt.want {
+				if top[i].RemoteIP != ip {
+					t.Errorf("top()[%d] = %v, want %v", i, top[i].RemoteIP, ip)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,166 @@
+package core
+
+import (
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/graydovee/netbouncer/pkg/config"
+)
+
+const (
+	DetectionPortScan = "port_scan" // 端口扫描
+	DetectionSynFlood = "syn_flood" // SYN洪水
+
+	maxDetections = 1000 // 保留的检测事件数量
+)
+
+// Detection 检测到的可疑行为
+type Detection struct {
+	ID        uint64    `json:"id"`        // 递增的事件ID，用于增量拉取
+	Type      string    `json:"type"`      // 检测类型
+	RemoteIP  string    `json:"remote_ip"` // 可疑的远程IP
+	Value     int       `json:"value"`     // 触发时的观测值：不同端口数或半开连接数
+	Threshold int       `json:"threshold"` // 配置的阈值
+	Time      time.Time `json:"time"`
+}
+
+// detector 端口扫描和SYN洪水检测
+type detector struct {
+	portScanPorts    int
+	portScanWindow   time.Duration
+	synFloodHalfOpen int
+	cooldown         time.Duration
+
+	mutex  sync.Mutex
+	nextID uint64
+	events []Detection // 按时间顺序保留最近的事件
+}
+
+// newDetector 根据配置创建检测器，未启用时返回nil
+func newDetector(cfg *config.DetectionConfig) *detector {
+	if !cfg.Enabled {
+		return nil
+	}
+	d := &detector{
+		portScanPorts:    cfg.PortScanPorts,
+		portScanWindow:   time.Duration(cfg.PortScanWindow) * time.Second,
+		synFloodHalfOpen: cfg.SynFloodHalfOpen,
+		cooldown:         time.Duration(cfg.Cooldown) * time.Second,
+	}
+	if d.portScanPorts <= 0 {
+		d.portScanPorts = 50
+	}
+	if d.portScanWindow <= 0 {
+		d.portScanWindow = time.Minute
+	}
+	if d.synFloodHalfOpen <= 0 {
+		d.synFloodHalfOpen = 100
+	}
+	if d.cooldown <= 0 {
+		d.cooldown = 5 * time.Minute
+	}
+	return d
+}
+
+// observePort 记录远程IP新发起连接访问的本地端口，窗口内不同端口数达到阈值时产生端口扫描事件，调用方需持有分片写锁
+func (d *detector) observePort(stats *internalTrafficStats, port uint16, now time.Time) {
+	if stats.scanPorts == nil {
+		stats.scanPorts = make(map[uint16]time.Time)
+	}
+	stats.scanPorts[port] = now
+	if len(stats.scanPorts) < d.portScanPorts {
+		return
+	}
+
+	// 只在达到阈值时清理窗口外的端口，表中最多保留阈值个端口
+	for p, seen := range stats.scanPorts {
+		if now.Sub(seen) > d.portScanWindow {
+			delete(stats.scanPorts, p)
+		}
+	}
+	if count := len(stats.scanPorts); count >= d.portScanPorts {
+		clear(stats.scanPorts)
+		d.raise(stats, DetectionPortScan, count, d.portScanPorts, now)
+	}
+}
+
+// observeHalfOpen 检查远程IP当前未完成握手的连接数，调用方需持有分片写锁
+func (d *detector) observeHalfOpen(stats *internalTrafficStats, count int, now time.Time) {
+	if count >= d.synFloodHalfOpen {
+		d.raise(stats, DetectionSynFlood, count, d.synFloodHalfOpen, now)
+	}
+}
+
+// raise 记录检测事件，同一IP同一类型在冷却时间内只记录一次，调用方需持有分片写锁
+func (d *detector) raise(stats *internalTrafficStats, detectionType string, value, threshold int, now time.Time) {
+	if last, exists := stats.detectedAt[detectionType]; exists && now.Sub(last) < d.cooldown {
+		return
+	}
+	if stats.detectedAt == nil {
+		stats.detectedAt = make(map[string]time.Time)
+	}
+	stats.detectedAt[detectionType] = now
+
+	d.mutex.Lock()
+	d.nextID++
+	d.events = append(d.events, Detection{
+		ID:        d.nextID,
+		Type:      detectionType,
+		RemoteIP:  stats.remoteIP,
+		Value:     value,
+		Threshold: threshold,
+		Time:      now,
+	})
+	if len(d.events) > maxDetections {
+		d.events = d.events[len(d.events)-maxDetections:]
+	}
+	d.mutex.Unlock()
+
+	slog.Warn("检测到可疑行为", "type", detectionType, "ip", stats.remoteIP, "value", value, "threshold", threshold)
+}
+
+// since 返回ID大于since的事件，最新的在前，limit为0时返回全部
+func (d *detector) since(since uint64, limit int) []Detection {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	result := make([]Detection, 0)
+	for i := len(d.events) - 1; i >= 0 && d.events[i].ID > since; i-- {
+		if limit > 0 && len(result) >= limit {
+			break
+		}
+		result = append(result, d.events[i])
+	}
+	return result
+}
+
+// detectSynFloods 按各远程IP当前的半开连接数检测SYN洪水
+func (m *Monitor) detectSynFloods() {
+	if m.detector == nil {
+		return
+	}
+	for _, shard := range m.shards {
+		shard.mutex.Lock()
+		now := time.Now()
+		for remoteIP, count := range shard.flows.halfOpenCounts() {
+			if stats, exists := shard.stats[remoteIP]; exists {
+				m.detector.observeHalfOpen(stats, count, now)
+			}
+		}
+		shard.mutex.Unlock()
+	}
+}
+
+// DetectionEnabled 是否启用了端口扫描和SYN洪水检测
+func (m *Monitor) DetectionEnabled() bool {
+	return m.detector != nil
+}
+
+// GetDetections 获取ID大于since的检测事件，最新的在前，limit为0时返回全部
+func (m *Monitor) GetDetections(since uint64, limit int) []Detection {
+	if m.detector == nil {
+		return []Detection{}
+	}
+	return m.detector.since(since, limit)
+}
@@ -0,0 +1,56 @@
+package core
+
+import (
+	"testing"
+	"time"
+
+	"github.com/graydovee/netbouncer/pkg/config"
+)
+
+func Test_detector_observePort(t *testing.T) {
+	d := newDetector(&config.DetectionConfig{Enabled: true, PortScanPorts: 3, PortScanWindow: 10, Cooldown: 60})
+	stats := &internalTrafficStats{remoteIP: "1.1.1.1"}
+	now := time.Now()
+
+	// 窗口外的端口被清理，不触发
+	d.observePort(stats, 22, now)
+	d.observePort(stats, 23, now.Add(11*time.Second))
+	d.observePort(stats, 24, now.Add(12*time.Second))
+	if got := d.since(0, 0); len(got) != 0 {
+		t.Fatalf("since() = %+v, want none", got)
+	}
+
+	d.observePort(stats, 25, now.Add(13*time.Second))
+	got := d.since(0, 0)
+	if len(got) != 1 || got[0].Type != DetectionPortScan || got[0].Value != 3 || got[0].RemoteIP != "1.1.1.1" {
+		t.Fatalf("since() = %+v, want one port scan with 3 ports", got)
+	}
+
+	// 冷却时间内不重复产生事件
+	for port := uint16(100); port < 110; port++ {
+		d.observePort(stats, port, now.Add(20*time.Second))
+	}
+	if got := d.since(got[0].ID, 0); len(got) != 0 {
+		t.Errorf("since() during cooldown = %+v, want none", got)
+	}
+
+	d.observeHalfOpen(stats, 500, now.Add(20*time.Second))
+	if got := d.since(got[0].ID, 0); len(got) != 1 || got[0].Type != DetectionSynFlood {
+		t.Errorf("since() = %+v, want one syn flood", got)
+	}
+}
+
+func Test_detector_since(t *testing.T) {
+	d := newDetector(&config.DetectionConfig{Enabled: true})
+	now := time.Now()
+	for i := 0; i < maxDetections+5; i++ {
+		d.raise(&internalTrafficStats{remoteIP: "1.1.1.1"}, DetectionSynFlood, i, 1, now)
+	}
+
+	if got := d.since(0, 0); len(got) != maxDetections || got[0].ID != maxDetections+5 {
+		t.Errorf("since(0, 0) returned %d events, newest %d", len(got), got[0].ID)
+	}
+	if got := d.since(maxDetections, 2); len(got) != 2 || got[0].ID != maxDetections+5 || got[1].ID != maxDetections+4 {
+		t.Errorf("since(%d, 2) = %+v", maxDetections, got)
+	}
+}
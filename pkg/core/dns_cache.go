@@ -0,0 +1,118 @@
+package core
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/gopacket/layers"
+)
+
+// dnsNamesPerIP 每个IP最多保留的域名数量
+const dnsNamesPerIP = 8
+
+// dnsEntry 解析到某个IP的域名，最近出现的在前
+type dnsEntry struct {
+	names    []string
+	lastSeen time.Time
+}
+
+// dnsCache 从抓到的DNS响应中被动学习IP到域名的映射，不发起任何查询
+type dnsCache struct {
+	mutex   sync.RWMutex
+	entries map[string]*dnsEntry
+	limit   int // 最多缓存的IP数量
+}
+
+// newDNSCache 创建被动DNS缓存
+func newDNSCache(limit int) *dnsCache {
+	return &dnsCache{
+		entries: make(map[string]*dnsEntry),
+		limit:   max(limit, 1),
+	}
+}
+
+// observe 记录一个DNS响应中全部A/AAAA记录对应的域名
+func (c *dnsCache) observe(dns *layers.DNS, now time.Time) {
+	if !dns.QR || dns.ResponseCode != layers.DNSResponseCodeNoErr {
+		return
+	}
+
+	// 查询的域名比CNAME链末端的CDN域名更有意义，两者都记录
+	var question string
+	if len(dns.Questions) > 0 {
+		question = normalizeDNSName(dns.Questions[0].Name)
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	for _, answer := range dns.Answers {
+		if (answer.Type != layers.DNSTypeA && answer.Type != layers.DNSTypeAAAA) || answer.IP == nil {
+			continue
+		}
+		ip := answer.IP.String()
+		c.add(ip, normalizeDNSName(answer.Name), now)
+		c.add(ip, question, now)
+	}
+}
+
+// add 在IP的域名列表头部加入域名，调用方需持有写锁
+func (c *dnsCache) add(ip, name string, now time.Time) {
+	if name == "" {
+		return
+	}
+
+	entry, exists := c.entries[ip]
+	if !exists {
+		if len(c.entries) >= c.limit {
+			c.evict()
+		}
+		entry = &dnsEntry{}
+		c.entries[ip] = entry
+	}
+	entry.lastSeen = now
+	entry.names = pushRecent(entry.names, name, dnsNamesPerIP)
+}
+
+// evict 抽样淘汰最久没有出现在DNS响应中的IP，调用方需持有写锁
+func (c *dnsCache) evict() {
+	var victimIP string
+	var victim *dnsEntry
+	sampled := 0
+	for ip, entry := range c.entries {
+		if victim == nil || entry.lastSeen.Before(victim.lastSeen) {
+			victimIP, victim = ip, entry
+		}
+		if sampled++; sampled >= evictionSamples {
+			break
+		}
+	}
+	if victim != nil {
+		delete(c.entries, victimIP)
+	}
+}
+
+// lookup 返回解析到IP的域名
+func (c *dnsCache) lookup(ip string) []string {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+
+	entry, exists := c.entries[ip]
+	if !exists {
+		return nil
+	}
+	return append([]string(nil), entry.names...)
+}
+
+// size 返回缓存的IP数量
+func (c *dnsCache) size() int {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	return len(c.entries)
+}
+
+// normalizeDNSName 转为小写并去掉末尾的点
+func normalizeDNSName(name []byte) string {
+	return strings.TrimSuffix(strings.ToLower(string(name)), ".")
+}
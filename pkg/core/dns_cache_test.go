@@ -0,0 +1,109 @@
+package core
+
+import (
+	"fmt"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/google/gopacket/layers"
+)
+
+func dnsAnswer(name string, ip string) layers.DNSResourceRecord {
+	parsed := net.ParseIP(ip)
+	recordType := layers.DNSTypeAAAA
+	if parsed.To4() != nil {
+		recordType = layers.DNSTypeA
+	}
+	return layers.DNSResourceRecord{Name: []byte(name), Type: recordType, Class: layers.DNSClassIN, IP: parsed}
+}
+
+func dnsResponse(question string, answers ...layers.DNSResourceRecord) *layers.DNS {
+	return &layers.DNS{
+		QR:        true,
+		Questions: []layers.DNSQuestion{{Name: []byte(question), Type: layers.DNSTypeA, Class: layers.DNSClassIN}},
+		Answers:   answers,
+	}
+}
+
+func Test_dnsCache_observe(t *testing.T) {
+	now := time.Now()
+	cname := layers.DNSResourceRecord{Name: []byte("www.example.com"), Type: layers.DNSTypeCNAME, CNAME: []byte("edge.cdn.net")}
+
+	tests := []struct {
+		name      string
+		responses []*layers.DNS
+		ip        string
+		want      []string
+	}{
+		{
+			name:      "a_record",
+			responses: []*layers.DNS{dnsResponse("Example.COM.", dnsAnswer("example.com", "93.184.216.34"))},
+			ip:        "93.184.216.34",
+			want:      []string{"example.com"},
+		},
+		{
+			name:      "cname_chain",
+			responses: []*layers.DNS{dnsResponse("www.example.com", cname, dnsAnswer("edge.cdn.net", "2001:db8::1"))},
+			ip:        "2001:db8::1",
+			want:      []string{"www.example.com", "edge.cdn.net"},
+		},
+		{
+			name: "most_recent_first",
+			responses: []*layers.DNS{
+				dnsResponse("a.example.com", dnsAnswer("a.example.com", "1.1.1.1")),
+				dnsResponse("b.example.com", dnsAnswer("b.example.com", "1.1.1.1")),
+				dnsResponse("a.example.com", dnsAnswer("a.example.com", "1.1.1.1")),
+			},
+			ip:   "1.1.1.1",
+			want: []string{"a.example.com", "b.example.com"},
+		},
+		{
+			name:      "query_ignored",
+			responses: []*layers.DNS{{Questions: []layers.DNSQuestion{{Name: []byte("example.com")}}, Answers: []layers.DNSResourceRecord{dnsAnswer("example.com", "1.1.1.1")}}},
+			ip:        "1.1.1.1",
+		},
+		{
+			name:      "nxdomain_ignored",
+			responses: []*layers.DNS{{QR: true, ResponseCode: layers.DNSResponseCodeNXDomain, Answers: []layers.DNSResourceRecord{dnsAnswer("example.com", "1.1.1.1")}}},
+			ip:        "1.1.1.1",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cache := newDNSCache(100)
+			for _, response := range tt.responses {
+				cache.observe(response, now)
+			}
+			if got := cache.lookup(tt.ip); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("lookup(%s) = %v, want %v", tt.ip, got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_dnsCache_limit(t *testing.T) {
+	cache := newDNSCache(10)
+	now := time.Now()
+	for i := 0; i < 100; i++ {
+		name := fmt.Sprintf("host%d.example.com", i)
+		cache.observe(dnsResponse(name, dnsAnswer(name, fmt.Sprintf("10.0.0.%d", i))), now.Add(time.Duration(i)*time.Second))
+	}
+	if cache.size() != 10 {
+		t.Errorf("size = %d, want 10", cache.size())
+	}
+	// 最近写入的IP不会被淘汰
+	if got := cache.lookup("10.0.0.99"); len(got) != 1 {
+		t.Errorf("latest entry evicted: %v", got)
+	}
+
+	names := newDNSCache(10)
+	for i := 0; i < dnsNamesPerIP*2; i++ {
+		name := fmt.Sprintf("alias%d.example.com", i)
+		names.observe(dnsResponse(name, dnsAnswer(name, "10.0.0.1")), now)
+	}
+	if got := names.lookup("10.0.0.1"); len(got) != dnsNamesPerIP {
+		t.Errorf("names per ip = %d, want %d", len(got), dnsNamesPerIP)
+	}
+}
@@ -1,8 +1,10 @@
 package core
 
 import (
+	"errors"
 	"fmt"
 	"log/slog"
+	"net"
 	"os"
 	"os/signal"
 	"syscall"
@@ -11,8 +13,8 @@ import (
 	"github.com/graydovee/netbouncer/pkg/store"
 )
 
-// NewFirewallFromConfig 根据配置创建相应的防火墙实例
-func NewFirewallFromConfig(cfg *config.FirewallConfig) (*Firewall, error) {
+// NewFirewallFromConfig 根据配置创建相应的防火墙实例，router为true时封禁和放行同样作用于转发的流量
+func NewFirewallFromConfig(cfg *config.FirewallConfig, router bool) (*Firewall, error) {
 	var core FirewallCore
 
 	switch config.FirewallType(cfg.Type) {
@@ -28,12 +30,14 @@ func NewFirewallFromConfig(cfg *config.FirewallConfig) (*Firewall, error) {
 		}
 		slog.Info("使用IpSet防火墙", "ipset", cfg.IpSet, "chain", cfg.Chain)
 		core = &IpSetFirewallCore{
-			ipset: cfg.IpSet,
-			chain: cfg.Chain,
+			ipset:  cfg.IpSet,
+			chain:  cfg.Chain,
+			router: router,
 		}
 	case config.FirewallTypeIptables:
 		core = &IptablesFirewallCore{
-			chain: cfg.Chain,
+			chain:  cfg.Chain,
+			router: router,
 		}
 	default:
 		return nil, fmt.Errorf("invalid firewall type: %s", cfg.Type)
@@ -51,13 +55,22 @@ type FirewallCore interface {
 	RevertBan(ipNet string) error
 	Allow(ipNet string) error
 	RevertAllow(ipNet string) error
+	// 将发往ipNet的流量限制在bytesPerSec以内
+	Limit(ipNet string, bytesPerSec uint64) error
+	RevertLimit(ipNet string) error
 
 	// 清理Ip的防火墙规则
 	CleanupIpNetRules(ipNet string) error
 	// 清理防火墙规则
 	CleanupRules() error
+
+	// 是否支持为IPv6地址添加规则
+	SupportsIPv6() bool
 }
 
+// ErrIPv6NotSupported 防火墙只管理IPv4规则，无法处理IPv6地址或网段
+var ErrIPv6NotSupported = errors.New("当前防火墙只支持IPv4，无法对IPv6地址或网段封禁、放行或限速")
+
 // Firewall 提供统一的防火墙接口，通过组合不同的FirewallCore实现不同功能
 type Firewall struct {
 	core FirewallCore
@@ -97,12 +110,19 @@ func (f *Firewall) Init(ipList []store.IpNet) error {
 
 	// 从传入的IP列表中加载所有IP到防火墙规则
 	for _, ipnet := range ipList {
+		if ipnet.Action != store.ActionWatch && f.unsupported(ipnet.IpNet) {
+			slog.Warn("防火墙不支持IPv6，跳过规则", "ip", ipnet.IpNet, "action", ipnet.Action)
+			continue
+		}
 
 		switch ipnet.Action {
 		case store.ActionBan:
 			err = f.core.Ban(ipnet.IpNet)
 		case store.ActionAllow:
 			err = f.core.Allow(ipnet.IpNet)
+		case store.ActionWatch:
+			// 观察的IP不需要防火墙规则
+			continue
 		default:
 			return fmt.Errorf("不支持的防火墙动作: %s", ipnet.Action)
 		}
@@ -115,23 +135,65 @@ func (f *Firewall) Init(ipList []store.IpNet) error {
 	return nil
 }
 
+// CheckIpNet 检查防火墙能否为ipNet添加规则，不支持IPv6的防火墙对IPv6地址或网段返回ErrIPv6NotSupported
+func (f *Firewall) CheckIpNet(ipNet string) error {
+	if !f.core.SupportsIPv6() && isIPv6IpNet(ipNet) {
+		return ErrIPv6NotSupported
+	}
+	return nil
+}
+
+// unsupported 防火墙不支持ipNet的地址族，此时不可能存在它的规则，撤销时直接视为成功
+func (f *Firewall) unsupported(ipNet string) bool {
+	return f.CheckIpNet(ipNet) != nil
+}
+
 func (f *Firewall) Ban(ipNet string) error {
+	if err := f.CheckIpNet(ipNet); err != nil {
+		return err
+	}
 	return f.core.Ban(ipNet)
 }
 
 func (f *Firewall) RevertBan(ipNet string) error {
+	if f.unsupported(ipNet) {
+		return nil
+	}
 	return f.core.RevertBan(ipNet)
 }
 
 func (f *Firewall) Allow(ipNet string) error {
+	if err := f.CheckIpNet(ipNet); err != nil {
+		return err
+	}
 	return f.core.Allow(ipNet)
 }
 
 func (f *Firewall) RevertAllow(ipNet string) error {
+	if f.unsupported(ipNet) {
+		return nil
+	}
 	return f.core.RevertAllow(ipNet)
 }
 
+func (f *Firewall) Limit(ipNet string, bytesPerSec uint64) error {
+	if err := f.CheckIpNet(ipNet); err != nil {
+		return err
+	}
+	return f.core.Limit(ipNet, bytesPerSec)
+}
+
+func (f *Firewall) RevertLimit(ipNet string) error {
+	if f.unsupported(ipNet) {
+		return nil
+	}
+	return f.core.RevertLimit(ipNet)
+}
+
 func (f *Firewall) CleanupIpNet(ipNet string) error {
+	if f.unsupported(ipNet) {
+		return nil
+	}
 	return f.core.CleanupIpNetRules(ipNet)
 }
 
@@ -163,6 +225,14 @@ func (m *MockFirewallCore) RevertAllow(ipNet string) error {
 	return nil
 }
 
+func (m *MockFirewallCore) Limit(ipNet string, bytesPerSec uint64) error {
+	return nil
+}
+
+func (m *MockFirewallCore) RevertLimit(ipNet string) error {
+	return nil
+}
+
 func (m *MockFirewallCore) CleanupIpNetRules(ipNet string) error {
 	// Mock防火墙不需要清理IP规则
 	return nil
@@ -172,3 +242,16 @@ func (m *MockFirewallCore) CleanupRules() error {
 	// Mock防火墙不需要清理规则
 	return nil
 }
+
+func (m *MockFirewallCore) SupportsIPv6() bool {
+	return true
+}
+
+// isIPv6IpNet ipNet是否为IPv6地址或网段
+func isIPv6IpNet(ipNet string) bool {
+	if ip, _, err := net.ParseCIDR(ipNet); err == nil {
+		return ip.To4() == nil
+	}
+	ip := net.ParseIP(ipNet)
+	return ip != nil && ip.To4() == nil
+}
@@ -0,0 +1,41 @@
+package core
+
+import (
+	"errors"
+	"testing"
+)
+
+func Test_Firewall_CheckIpNet(t *testing.T) {
+	ipv4Only := &Firewall{core: &IpSetFirewallCore{}}
+	mock := &Firewall{core: &MockFirewallCore{}}
+
+	tests := []struct {
+		ipNet string
+		ipv6  bool
+	}{
+		{ipNet: "192.0.2.1", ipv6: false},
+		{ipNet: "192.0.2.0/24", ipv6: false},
+		{ipNet: "::ffff:192.0.2.1", ipv6: false},
+		{ipNet: "2001:db8::1", ipv6: true},
+		{ipNet: "2001:db8::/64", ipv6: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.ipNet, func(t *testing.T) {
+			err := ipv4Only.CheckIpNet(tt.ipNet)
+			if tt.ipv6 != errors.Is(err, ErrIPv6NotSupported) {
+				t.Errorf("CheckIpNet() error = %v, want ipv6 %v", err, tt.ipv6)
+			}
+			if err := mock.CheckIpNet(tt.ipNet); err != nil {
+				t.Errorf("mock CheckIpNet() error = %v", err)
+			}
+		})
+	}
+
+	// 不支持的地址族不可能有规则，撤销时不调用防火墙
+	if err := ipv4Only.RevertBan("2001:db8::/64"); err != nil {
+		t.Errorf("RevertBan() error = %v", err)
+	}
+	if err := ipv4Only.Ban("2001:db8::/64"); !errors.Is(err, ErrIPv6NotSupported) {
+		t.Errorf("Ban() error = %v, want ErrIPv6NotSupported", err)
+	}
+}
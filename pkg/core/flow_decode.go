@@ -0,0 +1,557 @@
+package core
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+)
+
+const (
+	netflow5Version = 5
+	sflow5Version   = 5
+
+	maxFlowTemplates = 4096 // 最多缓存的NetFlow v9/IPFIX模板数量，避免伪造的模板耗尽内存
+
+	netflow5HeaderLength = 24
+	netflow5RecordLength = 48
+	netflow9HeaderLength = 20
+	ipfixHeaderLength    = 16
+	flowMinDataSetID     = 256   // 小于该值的集合ID为模板集或保留值
+	ipfixVariableLength  = 65535 // IPFIX变长字段的长度标记
+	ipfixEnterpriseBit   = 0x8000
+
+	tcpFlagSYN = 0x02
+	tcpFlagACK = 0x10
+)
+
+// 采集时额外使用的信息元素ID
+const (
+	ieTCPControlBits         = 6
+	ieSamplingInterval       = 34 // NetFlow v9的SAMPLING_INTERVAL
+	ieSamplerRandomInterval  = 50 // NetFlow v9的FLOW_SAMPLER_RANDOM_INTERVAL
+	ieSamplingPacketInterval = 305
+)
+
+// sFlow v5的抽样格式、流记录格式和包头协议，均为企业号0的标准格式
+const (
+	sflowFlowSample         = 1
+	sflowExpandedFlowSample = 3
+
+	sflowRawPacketHeader = 1
+	sflowSampledIPv4     = 3
+	sflowSampledIPv6     = 4
+
+	sflowHeaderProtocolEthernet = 1
+	sflowHeaderProtocolIPv4     = 11
+	sflowHeaderProtocolIPv6     = 12
+)
+
+var errFlowTruncated = errors.New("truncated flow packet")
+
+// flowRecord 从NetFlow/IPFIX/sFlow中解码出的单向流量，计数已按抽样率放大
+type flowRecord struct {
+	srcIP    net.IP
+	dstIP    net.IP
+	srcPort  uint16
+	dstPort  uint16
+	protocol layers.IPProtocol
+	tcpFlags uint8 // 流中出现过的TCP标志位
+	bytes    uint64
+	packets  uint64
+	rate     uint64 // 计数已按该抽样率放大
+}
+
+// flowTemplateKey 模板按导出设备、Source ID/Observation Domain ID和模板ID区分
+type flowTemplateKey struct {
+	exporter string
+	domain   uint32
+	id       uint16
+}
+
+// flowTemplate NetFlow v9或IPFIX模板
+type flowTemplate struct {
+	fields  []templateField
+	options bool // 选项模板，数据记录描述导出设备本身（如抽样率）而不是流
+}
+
+// minLength 单条数据记录的最小长度，变长字段按1字节计算
+func (t *flowTemplate) minLength() int {
+	length := 0
+	for _, f := range t.fields {
+		if f.length == ipfixVariableLength {
+			length++
+		} else {
+			length += int(f.length)
+		}
+	}
+	return length
+}
+
+// flowSamplerKey 导出设备的抽样率按Source ID/Observation Domain ID区分
+type flowSamplerKey struct {
+	exporter string
+	domain   uint32
+}
+
+// flowDecoder 解码NetFlow v5/v9、IPFIX和sFlow v5，只在接收协程中使用
+type flowDecoder struct {
+	defaultSampleRate uint64
+	templates         map[flowTemplateKey]*flowTemplate
+	samplers          map[flowSamplerKey]uint64 // 选项模板中通告的抽样率
+
+	missingTemplates uint64 // 因尚未收到模板而跳过的数据集数量
+}
+
+// newFlowDecoder 创建解码器，defaultSampleRate用于记录中没有抽样率的NetFlow v9/IPFIX
+func newFlowDecoder(defaultSampleRate int) *flowDecoder {
+	return &flowDecoder{
+		defaultSampleRate: uint64(max(defaultSampleRate, 1)),
+		templates:         make(map[flowTemplateKey]*flowTemplate),
+		samplers:          make(map[flowSamplerKey]uint64),
+	}
+}
+
+// decode 按报文开头的版本号解码，exporter为发送方的地址
+func (d *flowDecoder) decode(exporter string, b []byte) ([]flowRecord, error) {
+	if len(b) < 4 {
+		return nil, errFlowTruncated
+	}
+	switch binary.BigEndian.Uint16(b) {
+	case netflow5Version:
+		return d.decodeNetflow5(b)
+	case netflow9Version:
+		return d.decodeNetflow9(exporter, b)
+	case ipfixVersion:
+		return d.decodeIPFIX(exporter, b)
+	case 0:
+		// sFlow的版本号是32位的
+		if binary.BigEndian.Uint32(b) == sflow5Version {
+			return d.decodeSflow(b)
+		}
+	}
+	return nil, fmt.Errorf("unsupported flow packet version: %d", binary.BigEndian.Uint16(b))
+}
+
+// decodeNetflow5 解码NetFlow v5，记录格式固定，抽样率在报头中
+func (d *flowDecoder) decodeNetflow5(b []byte) ([]flowRecord, error) {
+	if len(b) < netflow5HeaderLength {
+		return nil, errFlowTruncated
+	}
+	count := int(binary.BigEndian.Uint16(b[2:]))
+	if len(b) < netflow5HeaderLength+count*netflow5RecordLength {
+		return nil, errFlowTruncated
+	}
+	// 抽样间隔的高2位为抽样模式
+	rate := uint64(max(binary.BigEndian.Uint16(b[22:])&0x3fff, 1))
+
+	records := make([]flowRecord, 0, count)
+	for i := range count {
+		r := b[netflow5HeaderLength+i*netflow5RecordLength:]
+		records = append(records, flowRecord{
+			srcIP:    net.IP(r[0:4]),
+			dstIP:    net.IP(r[4:8]),
+			packets:  uint64(binary.BigEndian.Uint32(r[16:])) * rate,
+			bytes:    uint64(binary.BigEndian.Uint32(r[20:])) * rate,
+			srcPort:  binary.BigEndian.Uint16(r[32:]),
+			dstPort:  binary.BigEndian.Uint16(r[34:]),
+			tcpFlags: r[37],
+			protocol: layers.IPProtocol(r[38]),
+			rate:     rate,
+		})
+	}
+	return records, nil
+}
+
+// decodeNetflow9 解码NetFlow v9，报头中的计数包括模板记录，不作为解析依据
+func (d *flowDecoder) decodeNetflow9(exporter string, b []byte) ([]flowRecord, error) {
+	if len(b) < netflow9HeaderLength {
+		return nil, errFlowTruncated
+	}
+	domain := binary.BigEndian.Uint32(b[16:])
+	return d.decodeSets(exporter, domain, b[netflow9HeaderLength:], false)
+}
+
+// decodeIPFIX 解码IPFIX，报头中的长度为整个消息的长度
+func (d *flowDecoder) decodeIPFIX(exporter string, b []byte) ([]flowRecord, error) {
+	if len(b) < ipfixHeaderLength {
+		return nil, errFlowTruncated
+	}
+	length := int(binary.BigEndian.Uint16(b[2:]))
+	if length < ipfixHeaderLength || length > len(b) {
+		return nil, errFlowTruncated
+	}
+	domain := binary.BigEndian.Uint32(b[12:])
+	return d.decodeSets(exporter, domain, b[ipfixHeaderLength:length], true)
+}
+
+// decodeSets 依次解码模板集、选项模板集和数据集
+func (d *flowDecoder) decodeSets(exporter string, domain uint32, b []byte, ipfix bool) ([]flowRecord, error) {
+	var records []flowRecord
+	for len(b) >= 4 {
+		setID := binary.BigEndian.Uint16(b)
+		length := int(binary.BigEndian.Uint16(b[2:]))
+		if length < 4 || length > len(b) {
+			return records, errFlowTruncated
+		}
+		body := b[4:length]
+		b = b[length:]
+
+		var err error
+		switch {
+		case !ipfix && setID == 0, ipfix && setID == 2:
+			err = d.decodeTemplates(exporter, domain, body, ipfix)
+		case !ipfix && setID == 1:
+			err = d.decodeNetflow9OptionsTemplates(exporter, domain, body)
+		case ipfix && setID == 3:
+			err = d.decodeIPFIXOptionsTemplates(exporter, domain, body)
+		case setID >= flowMinDataSetID:
+			template, exists := d.templates[flowTemplateKey{exporter: exporter, domain: domain, id: setID}]
+			if !exists {
+				d.missingTemplates++
+				continue
+			}
+			records, err = d.decodeData(exporter, domain, template, body, records)
+		}
+		if err != nil {
+			return records, err
+		}
+	}
+	return records, nil
+}
+
+// readTemplateFields 读取count个字段定义，IPFIX中企业字段的ID保留最高位，后面跟4字节的企业编号
+func readTemplateFields(b []byte, count int, ipfix bool) ([]templateField, []byte, error) {
+	fields := make([]templateField, 0, count)
+	for range count {
+		if len(b) < 4 {
+			return nil, nil, errFlowTruncated
+		}
+		field := templateField{id: binary.BigEndian.Uint16(b), length: binary.BigEndian.Uint16(b[2:])}
+		b = b[4:]
+		if ipfix && field.id&ipfixEnterpriseBit != 0 {
+			if len(b) < 4 {
+				return nil, nil, errFlowTruncated
+			}
+			b = b[4:]
+		}
+		fields = append(fields, field)
+	}
+	return fields, b, nil
+}
+
+// storeTemplate 保存模板，字段为空时删除（IPFIX模板撤回）
+func (d *flowDecoder) storeTemplate(key flowTemplateKey, template *flowTemplate) {
+	if len(template.fields) == 0 {
+		delete(d.templates, key)
+		return
+	}
+	if _, exists := d.templates[key]; !exists && len(d.templates) >= maxFlowTemplates {
+		return
+	}
+	d.templates[key] = template
+}
+
+// decodeTemplates 解码模板集，NetFlow v9和IPFIX的模板记录格式相同
+func (d *flowDecoder) decodeTemplates(exporter string, domain uint32, b []byte, ipfix bool) error {
+	for len(b) >= 4 {
+		id := binary.BigEndian.Uint16(b)
+		count := int(binary.BigEndian.Uint16(b[2:]))
+		if id < flowMinDataSetID {
+			// 模板集末尾的填充
+			return nil
+		}
+		fields, rest, err := readTemplateFields(b[4:], count, ipfix)
+		if err != nil {
+			return err
+		}
+		d.storeTemplate(flowTemplateKey{exporter: exporter, domain: domain, id: id}, &flowTemplate{fields: fields})
+		b = rest
+	}
+	return nil
+}
+
+// decodeNetflow9OptionsTemplates 解码NetFlow v9选项模板，范围字段和选项字段的长度以字节计
+func (d *flowDecoder) decodeNetflow9OptionsTemplates(exporter string, domain uint32, b []byte) error {
+	for len(b) >= 6 {
+		id := binary.BigEndian.Uint16(b)
+		scopeLength := int(binary.BigEndian.Uint16(b[2:]))
+		optionLength := int(binary.BigEndian.Uint16(b[4:]))
+		if id < flowMinDataSetID {
+			return nil
+		}
+		fields, rest, err := readTemplateFields(b[6:], (scopeLength+optionLength)/4, false)
+		if err != nil {
+			return err
+		}
+		d.storeTemplate(flowTemplateKey{exporter: exporter, domain: domain, id: id}, &flowTemplate{fields: fields, options: true})
+		b = rest
+	}
+	return nil
+}
+
+// decodeIPFIXOptionsTemplates 解码IPFIX选项模板，字段数包括范围字段
+func (d *flowDecoder) decodeIPFIXOptionsTemplates(exporter string, domain uint32, b []byte) error {
+	for len(b) >= 6 {
+		id := binary.BigEndian.Uint16(b)
+		count := int(binary.BigEndian.Uint16(b[2:]))
+		if id < flowMinDataSetID {
+			return nil
+		}
+		fields, rest, err := readTemplateFields(b[6:], count, true)
+		if err != nil {
+			return err
+		}
+		d.storeTemplate(flowTemplateKey{exporter: exporter, domain: domain, id: id}, &flowTemplate{fields: fields, options: true})
+		b = rest
+	}
+	return nil
+}
+
+// decodeData 按模板解码数据集，选项数据只用于记录导出设备的抽样率
+func (d *flowDecoder) decodeData(exporter string, domain uint32, template *flowTemplate, b []byte, records []flowRecord) ([]flowRecord, error) {
+	minLength := template.minLength()
+	samplerKey := flowSamplerKey{exporter: exporter, domain: domain}
+	for len(b) >= minLength && minLength > 0 {
+		var r flowRecord
+		var rate uint64
+		for _, f := range template.fields {
+			length := int(f.length)
+			if f.length == ipfixVariableLength {
+				if len(b) < 1 {
+					return records, errFlowTruncated
+				}
+				length, b = int(b[0]), b[1:]
+				if length == 255 {
+					if len(b) < 2 {
+						return records, errFlowTruncated
+					}
+					length, b = int(binary.BigEndian.Uint16(b)), b[2:]
+				}
+			}
+			if len(b) < length {
+				return records, errFlowTruncated
+			}
+			value := b[:length]
+			b = b[length:]
+
+			switch f.id {
+			case ieSourceIPv4Address, ieSourceIPv6Address:
+				r.srcIP = net.IP(value)
+			case ieDestinationIPv4Address, ieDestinationIPv6Address:
+				r.dstIP = net.IP(value)
+			case ieSourceTransportPort:
+				r.srcPort = uint16(readUint(value))
+			case ieDestinationTransportPort:
+				r.dstPort = uint16(readUint(value))
+			case ieProtocolIdentifier:
+				r.protocol = layers.IPProtocol(readUint(value))
+			case ieTCPControlBits:
+				r.tcpFlags = uint8(readUint(value))
+			case ieOctetDeltaCount:
+				r.bytes = readUint(value)
+			case iePacketDeltaCount:
+				r.packets = readUint(value)
+			case ieSamplingInterval, ieSamplerRandomInterval, ieSamplingPacketInterval:
+				rate = readUint(value)
+			}
+		}
+
+		if template.options {
+			if rate > 0 {
+				d.samplers[samplerKey] = rate
+			}
+			continue
+		}
+		if r.srcIP == nil || r.dstIP == nil || r.packets == 0 {
+			continue
+		}
+		if rate == 0 {
+			rate = d.samplers[samplerKey]
+		}
+		if rate == 0 {
+			rate = d.defaultSampleRate
+		}
+		r.bytes *= rate
+		r.packets *= rate
+		r.rate = rate
+		records = append(records, r)
+	}
+	return records, nil
+}
+
+// readUint 读取任意长度（最多8字节）的大端无符号整数，IPFIX允许缩短整数字段的长度
+func readUint(b []byte) uint64 {
+	var v uint64
+	for _, c := range b[max(len(b)-8, 0):] {
+		v = v<<8 | uint64(c)
+	}
+	return v
+}
+
+// decodeSflow 解码sFlow v5，只处理流抽样中的原始包头和IPv4/IPv6记录，计数器抽样被忽略
+func (d *flowDecoder) decodeSflow(b []byte) ([]flowRecord, error) {
+	if len(b) < 8 {
+		return nil, errFlowTruncated
+	}
+	// 报头：版本、代理地址类型、代理地址、子代理ID、序列号、运行时间、抽样数
+	offset := 28
+	switch binary.BigEndian.Uint32(b[4:]) {
+	case 1:
+	case 2:
+		offset = 40
+	default:
+		return nil, fmt.Errorf("invalid sflow agent address type")
+	}
+	if len(b) < offset {
+		return nil, errFlowTruncated
+	}
+	count := int(binary.BigEndian.Uint32(b[offset-4:]))
+	b = b[offset:]
+
+	var records []flowRecord
+	for range count {
+		if len(b) < 8 {
+			return records, errFlowTruncated
+		}
+		format := binary.BigEndian.Uint32(b)
+		length := int(binary.BigEndian.Uint32(b[4:]))
+		if length > len(b)-8 {
+			return records, errFlowTruncated
+		}
+		sample := b[8 : 8+length]
+		b = b[8+length:]
+
+		// 流抽样的固定部分以记录数结尾，扩展格式的接口和来源ID各多占4字节
+		var fixed, rateOffset int
+		switch format {
+		case sflowFlowSample:
+			fixed, rateOffset = 32, 8
+		case sflowExpandedFlowSample:
+			fixed, rateOffset = 44, 12
+		default:
+			continue
+		}
+		if len(sample) < fixed {
+			return records, errFlowTruncated
+		}
+		rate := uint64(max(binary.BigEndian.Uint32(sample[rateOffset:]), 1))
+		var err error
+		records, err = decodeSflowRecords(sample[fixed:], int(binary.BigEndian.Uint32(sample[fixed-4:])), rate, records)
+		if err != nil {
+			return records, err
+		}
+	}
+	return records, nil
+}
+
+// decodeSflowRecords 解码一个流抽样中的流记录，每个记录代表rate个包
+func decodeSflowRecords(b []byte, count int, rate uint64, records []flowRecord) ([]flowRecord, error) {
+	for range count {
+		if len(b) < 8 {
+			return records, errFlowTruncated
+		}
+		format := binary.BigEndian.Uint32(b)
+		length := int(binary.BigEndian.Uint32(b[4:]))
+		if length > len(b)-8 {
+			return records, errFlowTruncated
+		}
+		data := b[8 : 8+length]
+		b = b[8+length:]
+
+		var r flowRecord
+		var ok bool
+		switch format {
+		case sflowRawPacketHeader:
+			r, ok = decodeSflowRawHeader(data)
+		case sflowSampledIPv4:
+			// 长度、协议、源地址、目的地址、源端口、目的端口、TCP标志、TOS
+			if ok = len(data) >= 32; ok {
+				r = flowRecord{
+					bytes:    uint64(binary.BigEndian.Uint32(data)),
+					protocol: layers.IPProtocol(binary.BigEndian.Uint32(data[4:])),
+					srcIP:    net.IP(data[8:12]),
+					dstIP:    net.IP(data[12:16]),
+					srcPort:  uint16(binary.BigEndian.Uint32(data[16:])),
+					dstPort:  uint16(binary.BigEndian.Uint32(data[20:])),
+					tcpFlags: uint8(binary.BigEndian.Uint32(data[24:])),
+				}
+			}
+		case sflowSampledIPv6:
+			if ok = len(data) >= 56; ok {
+				r = flowRecord{
+					bytes:    uint64(binary.BigEndian.Uint32(data)),
+					protocol: layers.IPProtocol(binary.BigEndian.Uint32(data[4:])),
+					srcIP:    net.IP(data[8:24]),
+					dstIP:    net.IP(data[24:40]),
+					srcPort:  uint16(binary.BigEndian.Uint32(data[40:])),
+					dstPort:  uint16(binary.BigEndian.Uint32(data[44:])),
+					tcpFlags: uint8(binary.BigEndian.Uint32(data[48:])),
+				}
+			}
+		}
+		if !ok {
+			continue
+		}
+		r.bytes *= rate
+		r.packets = rate
+		r.rate = rate
+		records = append(records, r)
+	}
+	return records, nil
+}
+
+// decodeSflowRawHeader 用gopacket解析抽样到的包头，字节数取原始帧长度
+func decodeSflowRawHeader(b []byte) (flowRecord, bool) {
+	// 包头协议、帧长度、剥离的字节数、包头长度
+	if len(b) < 16 {
+		return flowRecord{}, false
+	}
+	frameLength := binary.BigEndian.Uint32(b[4:])
+	headerLength := int(binary.BigEndian.Uint32(b[12:]))
+	if headerLength > len(b)-16 {
+		return flowRecord{}, false
+	}
+	header := b[16 : 16+headerLength]
+
+	var first gopacket.LayerType
+	switch binary.BigEndian.Uint32(b) {
+	case sflowHeaderProtocolEthernet:
+		first = layers.LayerTypeEthernet
+	case sflowHeaderProtocolIPv4:
+		first = layers.LayerTypeIPv4
+	case sflowHeaderProtocolIPv6:
+		first = layers.LayerTypeIPv6
+	default:
+		return flowRecord{}, false
+	}
+	packet := gopacket.NewPacket(header, first, gopacket.DecodeOptions{Lazy: true, NoCopy: true})
+
+	r := flowRecord{bytes: uint64(frameLength)}
+	switch ip := packet.NetworkLayer().(type) {
+	case *layers.IPv4:
+		r.srcIP, r.dstIP, r.protocol = ip.SrcIP, ip.DstIP, ip.Protocol
+	case *layers.IPv6:
+		r.srcIP, r.dstIP, r.protocol = ip.SrcIP, ip.DstIP, ip.NextHeader
+	default:
+		return flowRecord{}, false
+	}
+	switch transport := packet.TransportLayer().(type) {
+	case *layers.TCP:
+		r.protocol = layers.IPProtocolTCP
+		r.srcPort, r.dstPort = uint16(transport.SrcPort), uint16(transport.DstPort)
+		if transport.SYN {
+			r.tcpFlags |= tcpFlagSYN
+		}
+		if transport.ACK {
+			r.tcpFlags |= tcpFlagACK
+		}
+	case *layers.UDP:
+		r.protocol = layers.IPProtocolUDP
+		r.srcPort, r.dstPort = uint16(transport.SrcPort), uint16(transport.DstPort)
+	}
+	return r, true
+}
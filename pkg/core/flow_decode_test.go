@@ -0,0 +1,213 @@
+package core
+
+import (
+	"encoding/binary"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+)
+
+// checkFlowRecord 比较解码出的流记录中测试关心的字段
+func checkFlowRecord(t *testing.T, got flowRecord, src, dst string, srcPort, dstPort uint16, protocol layers.IPProtocol, bytes, packets uint64) {
+	t.Helper()
+	if !got.srcIP.Equal(net.ParseIP(src)) || !got.dstIP.Equal(net.ParseIP(dst)) || got.srcPort != srcPort || got.dstPort != dstPort ||
+		got.protocol != protocol || got.bytes != bytes || got.packets != packets {
+		t.Errorf("record = %s:%d -> %s:%d proto %d bytes %d packets %d, want %s:%d -> %s:%d proto %d bytes %d packets %d",
+			got.srcIP, got.srcPort, got.dstIP, got.dstPort, got.protocol, got.bytes, got.packets,
+			src, srcPort, dst, dstPort, protocol, bytes, packets)
+	}
+}
+
+func Test_flowDecoder_exporterRoundTrip(t *testing.T) {
+	for _, version := range []uint16{netflow9Version, ipfixVersion} {
+		now := time.Now()
+		encoder := newFlowEncoder(version, now.Add(-time.Minute))
+		packets := encoder.encode([]exportRecord{
+			{exportKey{layers.IPProtocolTCP, "203.0.113.5", "192.0.2.1", 51000, 443}, exportFlow{bytes: 1500, packets: 3, first: now, last: now}},
+			{exportKey{layers.IPProtocolUDP, "2001:db8::1", "2001:db8::53", 53, 40000}, exportFlow{bytes: 80, packets: 1, first: now, last: now}},
+		}, now)
+
+		decoder := newFlowDecoder(10)
+		var records []flowRecord
+		for _, packet := range packets {
+			decoded, err := decoder.decode("192.0.2.254", packet)
+			if err != nil {
+				t.Fatalf("version %d: decode() error = %v", version, err)
+			}
+			records = append(records, decoded...)
+		}
+		if len(records) != 2 {
+			t.Fatalf("version %d: records = %d, want 2", version, len(records))
+		}
+		// 没有抽样信息时使用默认抽样率
+		checkFlowRecord(t, records[0], "203.0.113.5", "192.0.2.1", 51000, 443, layers.IPProtocolTCP, 15000, 30)
+		checkFlowRecord(t, records[1], "2001:db8::1", "2001:db8::53", 53, 40000, layers.IPProtocolUDP, 800, 10)
+
+		// 模板按导出设备区分，其他设备的数据集因缺少模板被跳过
+		encoder.lastTemplate = now
+		packets = encoder.encode([]exportRecord{
+			{exportKey{layers.IPProtocolTCP, "203.0.113.5", "192.0.2.1", 51000, 443}, exportFlow{bytes: 1, packets: 1, first: now, last: now}},
+		}, now)
+		if records, _ := decoder.decode("192.0.2.253", packets[0]); len(records) != 0 || decoder.missingTemplates != 1 {
+			t.Errorf("version %d: records = %d, missingTemplates = %d, want 0 and 1", version, len(records), decoder.missingTemplates)
+		}
+	}
+}
+
+func Test_flowDecoder_netflow5(t *testing.T) {
+	b := make([]byte, 24+48)
+	binary.BigEndian.PutUint16(b[0:], 5)
+	binary.BigEndian.PutUint16(b[2:], 1)
+	binary.BigEndian.PutUint16(b[22:], 1<<14|100) // 抽样模式1，每100个包抽样1个
+	r := b[24:]
+	copy(r[0:], net.ParseIP("198.51.100.7").To4())
+	copy(r[4:], net.ParseIP("192.0.2.1").To4())
+	binary.BigEndian.PutUint32(r[16:], 2)
+	binary.BigEndian.PutUint32(r[20:], 120)
+	binary.BigEndian.PutUint16(r[32:], 40000)
+	binary.BigEndian.PutUint16(r[34:], 22)
+	r[37] = tcpFlagSYN
+	r[38] = byte(layers.IPProtocolTCP)
+
+	records, err := newFlowDecoder(1).decode("192.0.2.254", b)
+	if err != nil || len(records) != 1 {
+		t.Fatalf("decode() = %d records, error %v", len(records), err)
+	}
+	checkFlowRecord(t, records[0], "198.51.100.7", "192.0.2.1", 40000, 22, layers.IPProtocolTCP, 12000, 200)
+	if records[0].tcpFlags != tcpFlagSYN {
+		t.Errorf("tcpFlags = %#x, want SYN", records[0].tcpFlags)
+	}
+
+	if _, err := newFlowDecoder(1).decode("192.0.2.254", b[:60]); err == nil {
+		t.Error("decode() expected error for truncated packet")
+	}
+}
+
+func Test_flowDecoder_ipfixOptions(t *testing.T) {
+	u16 := func(b []byte, v ...uint16) []byte {
+		for _, x := range v {
+			b = binary.BigEndian.AppendUint16(b, x)
+		}
+		return b
+	}
+	set := func(b []byte, id uint16, body []byte) []byte {
+		return append(u16(b, id, uint16(len(body)+4)), body...)
+	}
+
+	var msg []byte
+	// 选项模板300：范围字段为observationDomainId(149)，选项字段为samplingPacketInterval
+	msg = set(msg, 3, u16(nil, 300, 2, 1, 149, 4, ieSamplingPacketInterval, 4))
+	// 模板400：企业字段和变长字段夹在标准字段之间
+	tmpl := u16(nil, 400, 5, ieSourceIPv4Address, 4, ieDestinationIPv4Address, 4)
+	tmpl = binary.BigEndian.AppendUint32(u16(tmpl, 0x8000|1, 2), 29305)
+	tmpl = u16(tmpl, 82, ipfixVariableLength, iePacketDeltaCount, 4)
+	msg = set(msg, 2, tmpl)
+	msg = set(msg, 300, binary.BigEndian.AppendUint32(binary.BigEndian.AppendUint32(nil, 0), 50))
+	data := append(net.ParseIP("198.51.100.9").To4(), net.ParseIP("192.0.2.1").To4()...)
+	data = append(u16(data, 0xffff), 4, 'e', 't', 'h', '0')
+	data = binary.BigEndian.AppendUint32(data, 3)
+	msg = set(msg, 400, data)
+
+	header := u16(nil, 10, uint16(16+len(msg)))
+	header = binary.BigEndian.AppendUint32(header, uint32(time.Now().Unix()))
+	header = binary.BigEndian.AppendUint32(header, 0)
+	header = binary.BigEndian.AppendUint32(header, 7)
+
+	records, err := newFlowDecoder(1).decode("192.0.2.254", append(header, msg...))
+	if err != nil || len(records) != 1 {
+		t.Fatalf("decode() = %d records, error %v", len(records), err)
+	}
+	// 没有字节数字段，包数按选项模板通告的抽样率放大
+	checkFlowRecord(t, records[0], "198.51.100.9", "192.0.2.1", 0, 0, 0, 0, 150)
+}
+
+func Test_flowDecoder_sflow(t *testing.T) {
+	u32 := func(b []byte, v ...uint32) []byte {
+		for _, x := range v {
+			b = binary.BigEndian.AppendUint32(b, x)
+		}
+		return b
+	}
+
+	// 原始以太网包头：IPv4 TCP SYN
+	eth := &layers.Ethernet{SrcMAC: net.HardwareAddr{0, 1, 2, 3, 4, 5}, DstMAC: net.HardwareAddr{0, 1, 2, 3, 4, 6}, EthernetType: layers.EthernetTypeIPv4}
+	ip := &layers.IPv4{Version: 4, TTL: 64, Protocol: layers.IPProtocolTCP, SrcIP: net.ParseIP("198.51.100.20").To4(), DstIP: net.ParseIP("192.0.2.1").To4()}
+	tcp := &layers.TCP{SrcPort: 50000, DstPort: 443, SYN: true}
+	_ = tcp.SetNetworkLayerForChecksum(ip)
+	buf := gopacket.NewSerializeBuffer()
+	if err := gopacket.SerializeLayers(buf, gopacket.SerializeOptions{FixLengths: true}, eth, ip, tcp); err != nil {
+		t.Fatal(err)
+	}
+	header := buf.Bytes()
+	for len(header)%4 != 0 {
+		header = append(header, 0)
+	}
+	raw := append(u32(nil, 1, 74, 4, uint32(len(buf.Bytes()))), header...)
+
+	// 抽样的IPv6记录
+	sampled := u32(nil, 1200, uint32(layers.IPProtocolUDP))
+	sampled = append(sampled, net.ParseIP("2001:db8::1")...)
+	sampled = append(sampled, net.ParseIP("2001:db8::2")...)
+	sampled = u32(sampled, 53, 33000, 0, 0)
+
+	var records []byte
+	records = append(u32(records, 1, uint32(len(raw))), raw...)
+	records = append(u32(records, 4, uint32(len(sampled))), sampled...)
+	records = append(u32(records, 1001, 4), 0, 0, 0, 0) // 不支持的记录格式被跳过
+
+	// 流抽样：序列号、来源ID、抽样率、抽样池、丢弃数、输入接口、输出接口、记录数
+	sample := append(u32(nil, 1, 3, 256, 1000, 0, 1, 2, 3), records...)
+	counter := u32(nil, 1, 2, 0) // 计数器抽样被忽略
+
+	datagram := u32(nil, 5, 1)
+	datagram = append(datagram, net.ParseIP("192.0.2.254").To4()...)
+	datagram = u32(datagram, 0, 1, 1000, 2)
+	datagram = append(u32(datagram, 2, uint32(len(counter))), counter...)
+	datagram = append(u32(datagram, 1, uint32(len(sample))), sample...)
+
+	got, err := newFlowDecoder(1).decode("192.0.2.254", datagram)
+	if err != nil || len(got) != 2 {
+		t.Fatalf("decode() = %d records, error %v", len(got), err)
+	}
+	checkFlowRecord(t, got[0], "198.51.100.20", "192.0.2.1", 50000, 443, layers.IPProtocolTCP, 74*256, 256)
+	if got[0].tcpFlags != tcpFlagSYN {
+		t.Errorf("tcpFlags = %#x, want SYN", got[0].tcpFlags)
+	}
+	checkFlowRecord(t, got[1], "2001:db8::1", "2001:db8::2", 53, 33000, layers.IPProtocolUDP, 1200*256, 256)
+}
+
+func Test_FlowSource_recordSampled(t *testing.T) {
+	m := &Monitor{
+		shards: newStatsShards(0, 20, func() *flowTable {
+			return newFlowTable(time.Minute, time.Minute)
+		}),
+		localIPs:   map[string]bool{"192.0.2.1": true},
+		sampleRate: 1,
+		dns:        newDNSCache(0),
+		windowSize: 30 * time.Second,
+		bucketSize: time.Second,
+	}
+	f := &FlowSource{}
+	records := []flowRecord{
+		{srcIP: net.ParseIP("198.51.100.7"), dstIP: net.ParseIP("192.0.2.1"), protocol: layers.IPProtocolUDP, bytes: 12000, packets: 200, rate: 100},
+		{srcIP: net.ParseIP("192.0.2.1"), dstIP: net.ParseIP("198.51.100.7"), protocol: layers.IPProtocolUDP, bytes: 60, packets: 1, rate: 1},
+		{srcIP: net.ParseIP("198.51.100.8"), dstIP: net.ParseIP("192.0.2.1"), protocol: layers.IPProtocolUDP, bytes: 60, packets: 1, rate: 1},
+	}
+	for i := range records {
+		if !f.record(m, &records[i]) {
+			t.Fatalf("record(%d) = false", i)
+		}
+	}
+
+	stats := m.GetStats()
+	// 任一流记录按抽样率放大过，该IP的统计就是估算值
+	if got := stats["198.51.100.7"]; got == nil || !got.Sampled || got.SampleRate != 100 {
+		t.Errorf("198.51.100.7 = %+v, want sampled with rate 100", got)
+	}
+	if got := stats["198.51.100.8"]; got == nil || got.Sampled || got.SampleRate != 1 {
+		t.Errorf("198.51.100.8 = %+v, want unsampled with rate 1", got)
+	}
+}
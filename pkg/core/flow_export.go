@@ -0,0 +1,439 @@
+package core
+
+import (
+	"encoding/binary"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/netip"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/google/gopacket/layers"
+	"github.com/graydovee/netbouncer/pkg/config"
+)
+
+const (
+	netflow9Version = 9
+	ipfixVersion    = 10
+
+	exportTemplateIPv4    = 256         // IPv4流记录的模板ID
+	exportTemplateIPv6    = 257         // IPv6流记录的模板ID
+	exportMaxPacketSize   = 1400        // 单个导出报文的最大字节数，避免IP分片
+	exportTemplateRefresh = time.Minute // UDP传输时模板需要定期重发，采集器重启后才能继续解析
+	exportInterval        = time.Second // 检查流是否到期的间隔
+)
+
+// 信息元素ID，NetFlow v9和IPFIX使用相同的编号
+const (
+	ieOctetDeltaCount          = 1
+	iePacketDeltaCount         = 2
+	ieProtocolIdentifier       = 4
+	ieSourceTransportPort      = 7
+	ieSourceIPv4Address        = 8
+	ieDestinationTransportPort = 11
+	ieDestinationIPv4Address   = 12
+	ieFlowEndSysUpTime         = 21 // NetFlow v9的LAST_SWITCHED
+	ieFlowStartSysUpTime       = 22 // NetFlow v9的FIRST_SWITCHED
+	ieSourceIPv6Address        = 27
+	ieDestinationIPv6Address   = 28
+	ieFlowDirection            = 61
+	ieFlowStartMilliseconds    = 152
+	ieFlowEndMilliseconds      = 153
+)
+
+// exportKey 单向流的五元组，与NetFlow一致，同一连接的两个方向分别导出
+type exportKey struct {
+	protocol layers.IPProtocol
+	srcIP    string
+	dstIP    string
+	srcPort  uint16
+	dstPort  uint16
+}
+
+// exportFlow 单向流自上次导出以来的计数
+type exportFlow struct {
+	bytes   uint64
+	packets uint64
+	egress  bool // 由本地发出
+	first   time.Time
+	last    time.Time
+}
+
+// exportRecord 到期待发送的流记录
+type exportRecord struct {
+	exportKey
+	exportFlow
+}
+
+// recordExport 将一次流量计数累加到单向流中，流数量达到limit时丢弃新的流，调用方需持有写锁
+func (s *statsShard) recordExport(sample trafficSample, now time.Time, limit int) {
+	key := exportKey{protocol: sample.protocol, srcIP: sample.remoteIP, dstIP: sample.localIP, srcPort: sample.remotePort, dstPort: sample.localPort}
+	if sample.isSent {
+		key.srcIP, key.dstIP = key.dstIP, key.srcIP
+		key.srcPort, key.dstPort = key.dstPort, key.srcPort
+	}
+	flow, exists := s.exports[key]
+	if !exists {
+		if limit > 0 && len(s.exports) >= limit {
+			s.exportDropped++
+			return
+		}
+		flow = &exportFlow{egress: sample.isSent, first: now}
+		s.exports[key] = flow
+	}
+	flow.bytes += sample.bytes
+	flow.packets += sample.packets
+	flow.last = now
+}
+
+// expireExports 取出持续时间超过active或空闲超过inactive的流，flush为true时取出全部，调用方需持有写锁
+// 活动超时的流同样从表中移除，之后的流量作为新的记录重新计数
+func (s *statsShard) expireExports(now time.Time, active, inactive time.Duration, flush bool) []exportRecord {
+	var expired []exportRecord
+	for key, flow := range s.exports {
+		if !flush && now.Sub(flow.last) < inactive && now.Sub(flow.first) < active {
+			continue
+		}
+		expired = append(expired, exportRecord{exportKey: key, exportFlow: *flow})
+		delete(s.exports, key)
+	}
+	return expired
+}
+
+// flowExporter 将到期的流编码为NetFlow v9或IPFIX报文并通过UDP发送给采集器
+type flowExporter struct {
+	encoder         *flowEncoder
+	collectors      []string
+	conns           []net.Conn
+	activeTimeout   time.Duration
+	inactiveTimeout time.Duration
+	shardLimit      int // 每个分片等待导出的流数量上限
+
+	records atomic.Uint64 // 已导出的流记录数
+	packets atomic.Uint64 // 已发送的报文数
+	errors  atomic.Uint64 // 发送失败的次数
+}
+
+// newFlowExporter 创建流导出器并连接采集器，未启用时返回nil
+func newFlowExporter(cfg *config.ExportConfig) (*flowExporter, error) {
+	if !cfg.Enabled {
+		return nil, nil
+	}
+
+	var version uint16
+	switch config.ExportProtocol(cfg.Protocol) {
+	case "", config.ExportProtocolIPFIX:
+		version = ipfixVersion
+	case config.ExportProtocolNetflow9:
+		version = netflow9Version
+	default:
+		return nil, fmt.Errorf("invalid export protocol: %s", cfg.Protocol)
+	}
+
+	x := &flowExporter{
+		encoder:         newFlowEncoder(version, time.Now()),
+		activeTimeout:   time.Duration(cfg.ActiveTimeout) * time.Second,
+		inactiveTimeout: time.Duration(cfg.InactiveTimeout) * time.Second,
+	}
+	if x.activeTimeout <= 0 {
+		x.activeTimeout = time.Minute
+	}
+	if x.inactiveTimeout <= 0 {
+		x.inactiveTimeout = 15 * time.Second
+	}
+	if cfg.MaxFlows > 0 {
+		x.shardLimit = max((cfg.MaxFlows+statsShardCount-1)/statsShardCount, 1)
+	}
+
+	for collector := range strings.SplitSeq(cfg.Collectors, ",") {
+		collector = strings.TrimSpace(collector)
+		if collector == "" {
+			continue
+		}
+		conn, err := net.Dial("udp", collector)
+		if err != nil {
+			x.close()
+			return nil, fmt.Errorf("连接流采集器失败 %s: %w", collector, err)
+		}
+		x.collectors = append(x.collectors, collector)
+		x.conns = append(x.conns, conn)
+	}
+	if len(x.conns) == 0 {
+		return nil, fmt.Errorf("flow export requires collectors")
+	}
+	slog.Info("导出流记录", "protocol", cfg.Protocol, "collectors", x.collectors)
+	return x, nil
+}
+
+// send 编码流记录并发送给全部采集器
+func (x *flowExporter) send(records []exportRecord, now time.Time) {
+	for _, packet := range x.encoder.encode(records, now) {
+		for i, conn := range x.conns {
+			if _, err := conn.Write(packet); err != nil {
+				x.errors.Add(1)
+				slog.Debug("发送流记录失败", "collector", x.collectors[i], "error", err)
+			}
+		}
+		x.packets.Add(1)
+	}
+	x.records.Add(uint64(len(records)))
+}
+
+// close 关闭与采集器的连接
+func (x *flowExporter) close() {
+	for _, conn := range x.conns {
+		_ = conn.Close()
+	}
+}
+
+// watchExports 定期导出到期的流，停止时导出剩余的全部流
+func (m *Monitor) watchExports() {
+	go func() {
+		ticker := time.NewTicker(exportInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				m.exportFlows(false)
+			case <-m.stopChan:
+				m.exportFlows(true)
+				m.exporter.close()
+				return
+			}
+		}
+	}()
+}
+
+// exportFlows 从各分片取出到期的流并发送
+func (m *Monitor) exportFlows(flush bool) {
+	now := time.Now()
+	var records []exportRecord
+	for _, shard := range m.shards {
+		shard.mutex.Lock()
+		records = append(records, shard.expireExports(now, m.exporter.activeTimeout, m.exporter.inactiveTimeout, flush)...)
+		shard.mutex.Unlock()
+	}
+	if len(records) > 0 {
+		m.exporter.send(records, now)
+	}
+}
+
+// templateField 模板中的一个字段
+type templateField struct {
+	id     uint16
+	length uint16
+}
+
+// flowEncoder 将流记录编码为NetFlow v9或IPFIX报文，只在导出协程中使用
+type flowEncoder struct {
+	version      uint16
+	domainID     uint32    // NetFlow v9的Source ID，IPFIX的Observation Domain ID
+	start        time.Time // 导出器启动时间，NetFlow v9的时间戳是相对它的毫秒数
+	sequence     uint32    // NetFlow v9为已发送的报文数，IPFIX为已发送的数据记录数
+	lastTemplate time.Time // 上次发送模板的时间
+}
+
+// newFlowEncoder 创建编码器
+func newFlowEncoder(version uint16, start time.Time) *flowEncoder {
+	return &flowEncoder{version: version, start: start}
+}
+
+// fields 返回IPv4或IPv6模板的字段，顺序与appendRecord写入的顺序一致
+func (e *flowEncoder) fields(ipv6 bool) []templateField {
+	src, dst, addrLen := uint16(ieSourceIPv4Address), uint16(ieDestinationIPv4Address), uint16(4)
+	if ipv6 {
+		src, dst, addrLen = ieSourceIPv6Address, ieDestinationIPv6Address, 16
+	}
+	fields := []templateField{
+		{src, addrLen},
+		{dst, addrLen},
+		{ieSourceTransportPort, 2},
+		{ieDestinationTransportPort, 2},
+		{ieProtocolIdentifier, 1},
+		{ieFlowDirection, 1},
+		{ieOctetDeltaCount, 8},
+		{iePacketDeltaCount, 8},
+	}
+	if e.version == netflow9Version {
+		return append(fields, templateField{ieFlowStartSysUpTime, 4}, templateField{ieFlowEndSysUpTime, 4})
+	}
+	return append(fields, templateField{ieFlowStartMilliseconds, 8}, templateField{ieFlowEndMilliseconds, 8})
+}
+
+// headerLength 报头长度
+func (e *flowEncoder) headerLength() int {
+	if e.version == netflow9Version {
+		return 20
+	}
+	return 16
+}
+
+// uptime 相对导出器启动时间的毫秒数
+func (e *flowEncoder) uptime(t time.Time) uint32 {
+	return uint32(max(t.Sub(e.start).Milliseconds(), 0))
+}
+
+// exportPacket 正在编码的报文
+type exportPacket struct {
+	buf         []byte
+	records     int // 模板记录和数据记录的总数，NetFlow v9报头需要
+	dataRecords int // 数据记录数，IPFIX序列号按数据记录计数
+}
+
+// addrRecord 解析过地址的流记录
+type addrRecord struct {
+	src, dst netip.Addr
+	*exportRecord
+}
+
+// encode 将流记录编码为若干个不超过exportMaxPacketSize的报文，模板到期时附带在第一个报文中
+func (e *flowEncoder) encode(records []exportRecord, now time.Time) [][]byte {
+	var v4, v6 []addrRecord
+	for i := range records {
+		src, err := netip.ParseAddr(records[i].srcIP)
+		if err != nil {
+			continue
+		}
+		dst, err := netip.ParseAddr(records[i].dstIP)
+		if err != nil {
+			continue
+		}
+		src, dst = src.Unmap(), dst.Unmap()
+		if src.Is4() != dst.Is4() {
+			continue
+		}
+		if src.Is4() {
+			v4 = append(v4, addrRecord{src, dst, &records[i]})
+		} else {
+			v6 = append(v6, addrRecord{src, dst, &records[i]})
+		}
+	}
+	if len(v4) == 0 && len(v6) == 0 {
+		return nil
+	}
+
+	var packets [][]byte
+	packet := e.newPacket()
+	if e.lastTemplate.IsZero() || now.Sub(e.lastTemplate) >= exportTemplateRefresh {
+		e.appendTemplates(packet)
+		e.lastTemplate = now
+	}
+	for _, set := range []struct {
+		templateID uint16
+		records    []addrRecord
+	}{{exportTemplateIPv4, v4}, {exportTemplateIPv6, v6}} {
+		pending := set.records
+		for len(pending) > 0 {
+			n := e.appendRecords(packet, set.templateID, pending)
+			if n == 0 {
+				packets = append(packets, e.finish(packet, now))
+				packet = e.newPacket()
+				continue
+			}
+			pending = pending[n:]
+		}
+	}
+	if packet.records > 0 {
+		packets = append(packets, e.finish(packet, now))
+	}
+	return packets
+}
+
+// newPacket 创建报文，报头在finish时填写
+func (e *flowEncoder) newPacket() *exportPacket {
+	return &exportPacket{buf: make([]byte, e.headerLength(), exportMaxPacketSize)}
+}
+
+// appendTemplates 写入IPv4和IPv6两个模板
+func (e *flowEncoder) appendTemplates(p *exportPacket) {
+	setID := uint16(0)
+	if e.version == ipfixVersion {
+		setID = 2
+	}
+	start := len(p.buf)
+	p.buf = binary.BigEndian.AppendUint16(p.buf, setID)
+	p.buf = binary.BigEndian.AppendUint16(p.buf, 0)
+	for _, templateID := range []uint16{exportTemplateIPv4, exportTemplateIPv6} {
+		fields := e.fields(templateID == exportTemplateIPv6)
+		p.buf = binary.BigEndian.AppendUint16(p.buf, templateID)
+		p.buf = binary.BigEndian.AppendUint16(p.buf, uint16(len(fields)))
+		for _, f := range fields {
+			p.buf = binary.BigEndian.AppendUint16(p.buf, f.id)
+			p.buf = binary.BigEndian.AppendUint16(p.buf, f.length)
+		}
+		p.records++
+	}
+	binary.BigEndian.PutUint16(p.buf[start+2:], uint16(len(p.buf)-start))
+}
+
+// appendRecords 写入一个数据集，返回写入的记录数，报文剩余空间不足一条记录时返回0
+func (e *flowEncoder) appendRecords(p *exportPacket, templateID uint16, records []addrRecord) int {
+	size := 0
+	for _, f := range e.fields(templateID == exportTemplateIPv6) {
+		size += int(f.length)
+	}
+	// 数据集头4字节，末尾最多3字节填充到4字节边界
+	n := min((exportMaxPacketSize-len(p.buf)-4-3)/size, len(records))
+	if n <= 0 {
+		return 0
+	}
+
+	start := len(p.buf)
+	p.buf = binary.BigEndian.AppendUint16(p.buf, templateID)
+	p.buf = binary.BigEndian.AppendUint16(p.buf, 0)
+	for _, r := range records[:n] {
+		p.buf = e.appendRecord(p.buf, r)
+	}
+	for (len(p.buf)-start)%4 != 0 {
+		p.buf = append(p.buf, 0)
+	}
+	binary.BigEndian.PutUint16(p.buf[start+2:], uint16(len(p.buf)-start))
+	p.records += n
+	p.dataRecords += n
+	return n
+}
+
+// appendRecord 按模板字段的顺序写入一条流记录
+func (e *flowEncoder) appendRecord(buf []byte, r addrRecord) []byte {
+	buf = append(buf, r.src.AsSlice()...)
+	buf = append(buf, r.dst.AsSlice()...)
+	buf = binary.BigEndian.AppendUint16(buf, r.srcPort)
+	buf = binary.BigEndian.AppendUint16(buf, r.dstPort)
+	buf = append(buf, byte(r.protocol))
+	direction := byte(0) // 0表示入站，1表示出站
+	if r.egress {
+		direction = 1
+	}
+	buf = append(buf, direction)
+	buf = binary.BigEndian.AppendUint64(buf, r.bytes)
+	buf = binary.BigEndian.AppendUint64(buf, r.packets)
+	if e.version == netflow9Version {
+		buf = binary.BigEndian.AppendUint32(buf, e.uptime(r.first))
+		return binary.BigEndian.AppendUint32(buf, e.uptime(r.last))
+	}
+	buf = binary.BigEndian.AppendUint64(buf, uint64(r.first.UnixMilli()))
+	return binary.BigEndian.AppendUint64(buf, uint64(r.last.UnixMilli()))
+}
+
+// finish 填写报头并更新序列号
+func (e *flowEncoder) finish(p *exportPacket, now time.Time) []byte {
+	b := p.buf
+	binary.BigEndian.PutUint16(b[0:], e.version)
+	if e.version == netflow9Version {
+		binary.BigEndian.PutUint16(b[2:], uint16(p.records))
+		binary.BigEndian.PutUint32(b[4:], e.uptime(now))
+		binary.BigEndian.PutUint32(b[8:], uint32(now.Unix()))
+		binary.BigEndian.PutUint32(b[12:], e.sequence)
+		binary.BigEndian.PutUint32(b[16:], e.domainID)
+		e.sequence++
+		return b
+	}
+	binary.BigEndian.PutUint16(b[2:], uint16(len(b)))
+	binary.BigEndian.PutUint32(b[4:], uint32(now.Unix()))
+	binary.BigEndian.PutUint32(b[8:], e.sequence)
+	binary.BigEndian.PutUint32(b[12:], e.domainID)
+	e.sequence += uint32(p.dataRecords)
+	return b
+}
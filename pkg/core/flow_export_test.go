@@ -0,0 +1,205 @@
+package core
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/google/gopacket/layers"
+	"github.com/graydovee/netbouncer/pkg/config"
+)
+
+// decodedFlow 测试中从报文解析出的流记录
+type decodedFlow struct {
+	src, dst         netip.Addr
+	srcPort, dstPort uint16
+	protocol         uint8
+	egress           bool
+	bytes, packets   uint64
+}
+
+// decodeExport 解析导出报文，templates在多个报文间共享
+func decodeExport(t *testing.T, b []byte, templates map[uint16][]templateField) (version uint16, sequence uint32, flows []decodedFlow) {
+	t.Helper()
+	version = binary.BigEndian.Uint16(b)
+	offset := 16
+	templateSet := uint16(2)
+	if version == netflow9Version {
+		offset, templateSet = 20, 0
+		sequence = binary.BigEndian.Uint32(b[12:])
+	} else {
+		if int(binary.BigEndian.Uint16(b[2:])) != len(b) {
+			t.Fatalf("ipfix length = %d, want %d", binary.BigEndian.Uint16(b[2:]), len(b))
+		}
+		sequence = binary.BigEndian.Uint32(b[8:])
+	}
+
+	for offset < len(b) {
+		setID := binary.BigEndian.Uint16(b[offset:])
+		end := offset + int(binary.BigEndian.Uint16(b[offset+2:]))
+		pos := offset + 4
+		if setID == templateSet {
+			for pos < end {
+				id, count := binary.BigEndian.Uint16(b[pos:]), int(binary.BigEndian.Uint16(b[pos+2:]))
+				pos += 4
+				var fields []templateField
+				for range count {
+					fields = append(fields, templateField{binary.BigEndian.Uint16(b[pos:]), binary.BigEndian.Uint16(b[pos+2:])})
+					pos += 4
+				}
+				templates[id] = fields
+			}
+		} else {
+			fields, exists := templates[setID]
+			if !exists {
+				t.Fatalf("data set %d without template", setID)
+			}
+			size := 0
+			for _, f := range fields {
+				size += int(f.length)
+			}
+			for end-pos >= size {
+				var flow decodedFlow
+				for _, f := range fields {
+					v := b[pos : pos+int(f.length)]
+					switch f.id {
+					case ieSourceIPv4Address, ieSourceIPv6Address:
+						flow.src, _ = netip.AddrFromSlice(v)
+					case ieDestinationIPv4Address, ieDestinationIPv6Address:
+						flow.dst, _ = netip.AddrFromSlice(v)
+					case ieSourceTransportPort:
+						flow.srcPort = binary.BigEndian.Uint16(v)
+					case ieDestinationTransportPort:
+						flow.dstPort = binary.BigEndian.Uint16(v)
+					case ieProtocolIdentifier:
+						flow.protocol = v[0]
+					case ieFlowDirection:
+						flow.egress = v[0] == 1
+					case ieOctetDeltaCount:
+						flow.bytes = binary.BigEndian.Uint64(v)
+					case iePacketDeltaCount:
+						flow.packets = binary.BigEndian.Uint64(v)
+					}
+					pos += int(f.length)
+				}
+				flows = append(flows, flow)
+			}
+		}
+		offset = end
+	}
+	return version, sequence, flows
+}
+
+func Test_statsShard_expireExports(t *testing.T) {
+	shard := newStatsShards(0, 1, func() *flowTable { return newFlowTable(0, 0) })[0]
+	start := time.Now()
+	sample := trafficSample{remoteIP: "203.0.113.5", localIP: "192.0.2.1", protocol: layers.IPProtocolTCP, remotePort: 51000, localPort: 443, bytes: 100, packets: 1}
+
+	shard.recordExport(sample, start, 2)
+	sample.isSent = true
+	shard.recordExport(sample, start, 2)
+	shard.recordExport(sample, start.Add(50*time.Second), 2)
+	// 达到上限后新的流不再记录
+	shard.recordExport(trafficSample{remoteIP: "203.0.113.6", localIP: "192.0.2.1", bytes: 1, packets: 1}, start, 2)
+	if shard.exportDropped != 1 {
+		t.Errorf("exportDropped = %d, want 1", shard.exportDropped)
+	}
+
+	// 入站方向空闲超时，出站方向仍然活动
+	expired := shard.expireExports(start.Add(55*time.Second), time.Minute, 15*time.Second, false)
+	if len(expired) != 1 || expired[0].egress || expired[0].srcIP != "203.0.113.5" || expired[0].dstPort != 443 {
+		t.Fatalf("expireExports() = %+v, want inbound flow", expired)
+	}
+
+	// 出站方向达到活动超时
+	expired = shard.expireExports(start.Add(61*time.Second), time.Minute, 15*time.Second, false)
+	if len(expired) != 1 || !expired[0].egress || expired[0].srcPort != 443 || expired[0].bytes != 200 || expired[0].packets != 2 {
+		t.Fatalf("expireExports() = %+v, want outbound flow with 200 bytes", expired)
+	}
+	if len(shard.exports) != 0 {
+		t.Errorf("exports = %d, want 0", len(shard.exports))
+	}
+}
+
+func Test_flowExporter_send(t *testing.T) {
+	for _, protocol := range []config.ExportProtocol{config.ExportProtocolNetflow9, config.ExportProtocolIPFIX} {
+		t.Run(string(protocol), func(t *testing.T) {
+			listener, err := net.ListenPacket("udp", "127.0.0.1:0")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer listener.Close()
+
+			exporter, err := newFlowExporter(&config.ExportConfig{
+				Enabled:    true,
+				Protocol:   string(protocol),
+				Collectors: listener.LocalAddr().String(),
+			})
+			if err != nil {
+				t.Fatalf("newFlowExporter() error = %v", err)
+			}
+			defer exporter.close()
+
+			now := time.Now()
+			records := []exportRecord{
+				{exportKey{layers.IPProtocolTCP, "203.0.113.5", "192.0.2.1", 51000, 443}, exportFlow{bytes: 1500, packets: 3, first: now, last: now}},
+				{exportKey{layers.IPProtocolUDP, "2001:db8::1", "2001:db8::53", 53, 40000}, exportFlow{bytes: 80, packets: 1, egress: true, first: now, last: now}},
+			}
+			// 足够多的记录需要拆分成多个报文
+			for i := range 100 {
+				records = append(records, exportRecord{
+					exportKey{layers.IPProtocolUDP, fmt.Sprintf("198.51.100.%d", i), "192.0.2.1", 123, 123},
+					exportFlow{bytes: 76, packets: 1, first: now, last: now},
+				})
+			}
+			exporter.send(records, now)
+
+			templates := make(map[uint16][]templateField)
+			var flows []decodedFlow
+			var lastSequence uint32
+			buf := make([]byte, 65535)
+			for i := 0; len(flows) < len(records); i++ {
+				_ = listener.SetReadDeadline(time.Now().Add(2 * time.Second))
+				n, _, err := listener.ReadFrom(buf)
+				if err != nil {
+					t.Fatalf("read packet %d: %v (got %d flows)", i, err, len(flows))
+				}
+				if n > exportMaxPacketSize {
+					t.Errorf("packet size = %d, want <= %d", n, exportMaxPacketSize)
+				}
+				version, sequence, packetFlows := decodeExport(t, buf[:n], templates)
+				if i > 0 && sequence <= lastSequence {
+					t.Errorf("sequence = %d, want > %d", sequence, lastSequence)
+				}
+				if want := map[config.ExportProtocol]uint16{config.ExportProtocolNetflow9: 9, config.ExportProtocolIPFIX: 10}[protocol]; version != want {
+					t.Errorf("version = %d, want %d", version, want)
+				}
+				lastSequence = sequence
+				flows = append(flows, packetFlows...)
+			}
+
+			if len(flows) != len(records) {
+				t.Fatalf("flows = %d, want %d", len(flows), len(records))
+			}
+			want := decodedFlow{netip.MustParseAddr("203.0.113.5"), netip.MustParseAddr("192.0.2.1"), 51000, 443, 6, false, 1500, 3}
+			if flows[0] != want {
+				t.Errorf("flows[0] = %+v, want %+v", flows[0], want)
+			}
+			var v6 *decodedFlow
+			for i := range flows {
+				if flows[i].src.Is6() {
+					v6 = &flows[i]
+				}
+			}
+			if v6 == nil || v6.dst != netip.MustParseAddr("2001:db8::53") || !v6.egress || v6.srcPort != 53 || v6.protocol != 17 {
+				t.Errorf("ipv6 flow = %+v", v6)
+			}
+			if exporter.records.Load() != uint64(len(records)) {
+				t.Errorf("records = %d, want %d", exporter.records.Load(), len(records))
+			}
+		})
+	}
+}
@@ -0,0 +1,191 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/google/gopacket/layers"
+)
+
+// FlowSource 在UDP端口上接收路由器等设备发来的NetFlow v5/v9、IPFIX和sFlow v5，不需要抓包
+type FlowSource struct {
+	listen   []string
+	conns    []net.PacketConn
+	decoder  *flowDecoder // 所有监听端口共用，模板在各端口间共享，只在处理协程中使用
+	packets  chan flowPacket
+	stopChan chan struct{}
+
+	datagrams atomic.Uint64 // 接收的报文数
+	errors    atomic.Uint64 // 解码失败的报文数
+	decoded   atomic.Uint64 // 解码出的流记录数
+	unmatched atomic.Uint64 // 两端都是或都不是本地地址而被忽略的记录数
+}
+
+// flowPacket 从某个导出设备接收的一个报文
+type flowPacket struct {
+	exporter string
+	data     []byte
+	received time.Time
+}
+
+// newFlowSource 创建flow数据源，listen为逗号分隔的UDP监听地址
+func newFlowSource(listen string, defaultSampleRate int) (*FlowSource, error) {
+	f := &FlowSource{
+		decoder:  newFlowDecoder(defaultSampleRate),
+		packets:  make(chan flowPacket, 1024),
+		stopChan: make(chan struct{}),
+	}
+	for addr := range strings.SplitSeq(listen, ",") {
+		if addr = strings.TrimSpace(addr); addr != "" {
+			f.listen = append(f.listen, addr)
+		}
+	}
+	if len(f.listen) == 0 {
+		return nil, fmt.Errorf("flow source requires a listen address")
+	}
+	return f, nil
+}
+
+func (f *FlowSource) Name() string {
+	return "flow"
+}
+
+func (f *FlowSource) Start(m *Monitor) error {
+	for _, addr := range f.listen {
+		conn, err := net.ListenPacket("udp", addr)
+		if err != nil {
+			for _, conn := range f.conns {
+				_ = conn.Close()
+			}
+			return fmt.Errorf("监听流记录端口失败 %s: %w", addr, err)
+		}
+		f.conns = append(f.conns, conn)
+	}
+	for _, conn := range f.conns {
+		go f.receive(conn)
+	}
+
+	// 解码和统计在单个协程中进行，模板和抽样率不需要加锁
+	go func() {
+		for {
+			select {
+			case packet := <-f.packets:
+				f.process(m, packet)
+			case <-f.stopChan:
+				return
+			}
+		}
+	}()
+
+	slog.Info("flow数据源已启动", "listen", f.listen)
+	return nil
+}
+
+func (f *FlowSource) Stop() {
+	close(f.stopChan)
+	for _, conn := range f.conns {
+		_ = conn.Close()
+	}
+}
+
+func (f *FlowSource) DebugInfo() map[string]interface{} {
+	return map[string]interface{}{
+		"flow_listen":            f.listen,
+		"flow_datagrams":         f.datagrams.Load(),
+		"flow_decode_errors":     f.errors.Load(),
+		"flow_records":           f.decoded.Load(),
+		"flow_unmatched_records": f.unmatched.Load(),
+	}
+}
+
+// CaptureStats 流记录由导出设备汇总，不存在本机抓包丢包
+func (f *FlowSource) CaptureStats() (CaptureStats, bool) {
+	return CaptureStats{}, false
+}
+
+// receive 读取一个监听端口上的报文，交给处理协程，连接关闭时退出
+func (f *FlowSource) receive(conn net.PacketConn) {
+	buf := make([]byte, 65535)
+	for {
+		n, addr, err := conn.ReadFrom(buf)
+		if err != nil {
+			if !errors.Is(err, net.ErrClosed) {
+				slog.Error("接收流记录失败", "listen", conn.LocalAddr(), "error", err)
+			}
+			return
+		}
+		f.datagrams.Add(1)
+
+		exporter := addr.String()
+		if udpAddr, ok := addr.(*net.UDPAddr); ok {
+			// 导出设备可能更换源端口，模板按地址区分
+			exporter = udpAddr.IP.String()
+		}
+		select {
+		case f.packets <- flowPacket{exporter: exporter, data: append([]byte(nil), buf[:n]...), received: time.Now()}:
+		case <-f.stopChan:
+			return
+		}
+	}
+}
+
+// process 解码一个报文并将其中的流记录计入监控器
+func (f *FlowSource) process(m *Monitor, packet flowPacket) {
+	records, err := f.decoder.decode(packet.exporter, packet.data)
+	if err != nil {
+		f.errors.Add(1)
+		slog.Debug("解码流记录失败", "exporter", packet.exporter, "error", err)
+	}
+	f.decoded.Add(uint64(len(records)))
+	for i := range records {
+		if !f.record(m, &records[i]) {
+			f.unmatched.Add(1)
+		}
+	}
+	m.health.recordLatency(time.Since(packet.received))
+}
+
+// record 按本地地址确定流记录的远程IP和方向，转换为流量计数
+// 流记录没有连接状态，远程发来SYN的连接计为新建连接，端口较小的一方视为服务端
+func (f *FlowSource) record(m *Monitor, r *flowRecord) bool {
+	remoteIP, localIP, isSent, ok := m.classify(r.srcIP.String(), r.dstIP.String())
+	if !ok {
+		return false
+	}
+
+	sample := trafficSample{
+		remoteIP: remoteIP,
+		localIP:  localIP,
+		protocol: r.protocol,
+		bytes:    r.bytes,
+		packets:  r.packets,
+		rate:     r.rate,
+		isSent:   isSent,
+	}
+	if r.protocol == layers.IPProtocolTCP || r.protocol == layers.IPProtocolUDP {
+		sample.localPort, sample.remotePort = r.dstPort, r.srcPort
+		if isSent {
+			sample.localPort, sample.remotePort = r.srcPort, r.dstPort
+		}
+		if !isSent && r.protocol == layers.IPProtocolTCP && r.tcpFlags&tcpFlagSYN != 0 {
+			switch {
+			case r.tcpFlags&tcpFlagACK == 0:
+				// 只有SYN：sFlow抽样到的握手包，或未完成握手的流
+				sample.newFlows = r.packets
+			case sample.localPort < sample.remotePort:
+				// NetFlow/IPFIX的标志位是整个流的并集，远程访问本地服务端口的流计为一个新建连接
+				sample.newFlows = 1
+			}
+		}
+		if sample.newFlows > 0 || sample.localPort < sample.remotePort {
+			sample.servicePort = sample.localPort
+		}
+	}
+	m.updateStats(sample)
+	return true
+}
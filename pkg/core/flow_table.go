@@ -0,0 +1,265 @@
+package core
+
+import (
+	"time"
+
+	"github.com/google/gopacket/layers"
+)
+
+// tcpFlowState TCP连接跟踪状态
+type tcpFlowState int
+
+const (
+	tcpStateSynSent     tcpFlowState = iota // 收到SYN，握手未完成
+	tcpStateSynReceived                     // 收到SYN+ACK，等待发起方的ACK
+	tcpStateEstablished                     // 握手完成，或从中途捕获到的连接
+	tcpStateClosing                         // 一方已发送FIN
+	tcpStateClosed                          // 双方FIN或RST，不再计入活动连接，短暂保留用于吸收重传
+)
+
+const (
+	tcpSynTimeout     = 30 * time.Second // 半开连接超时
+	tcpClosingTimeout = 60 * time.Second // 单方关闭后的超时
+	tcpClosedTimeout  = 10 * time.Second // 关闭后保留时间，类似TIME_WAIT
+)
+
+// flowKey 以远程/本地方向归一化的五元组，同一连接的双向包映射到同一个key
+type flowKey struct {
+	protocol   layers.IPProtocol
+	remoteIP   string
+	localIP    string
+	remotePort uint16
+	localPort  uint16
+}
+
+// flowEntry 单个连接的跟踪信息
+type flowEntry struct {
+	state     tcpFlowState
+	finLocal  bool // 本地已发送FIN
+	finRemote bool // 远程已发送FIN
+	inbound   bool // 连接由远程发起
+	lastSeen  time.Time
+}
+
+// active 连接是否计入活动连接数
+func (e *flowEntry) active() bool {
+	return e.state != tcpStateClosed
+}
+
+// halfOpen 连接是否由远程发起且握手尚未完成
+func (e *flowEntry) halfOpen() bool {
+	return e.inbound && (e.state == tcpStateSynSent || e.state == tcpStateSynReceived)
+}
+
+// flowChange 一次包处理对活动连接数的影响
+type flowChange struct {
+	activeDelta int  // 活动连接数变化
+	isNew       bool // 是否为新建连接
+	inbound     bool // 连接由远程发起，即远程访问本地服务端口
+}
+
+// flowTable 五元组连接表，按远程IP分组，调用方负责加锁
+type flowTable struct {
+	flows      map[string]map[flowKey]*flowEntry
+	tcpTimeout time.Duration // 已建立TCP连接的空闲超时
+	udpTimeout time.Duration // UDP流的空闲超时
+}
+
+// newFlowTable 创建连接表
+func newFlowTable(tcpTimeout, udpTimeout time.Duration) *flowTable {
+	if tcpTimeout <= 0 {
+		tcpTimeout = 10 * time.Minute
+	}
+	if udpTimeout <= 0 {
+		udpTimeout = time.Minute
+	}
+	return &flowTable{
+		flows:      make(map[string]map[flowKey]*flowEntry),
+		tcpTimeout: tcpTimeout,
+		udpTimeout: udpTimeout,
+	}
+}
+
+// timeout 返回连接当前状态下的空闲超时
+func (ft *flowTable) timeout(key flowKey, entry *flowEntry) time.Duration {
+	if key.protocol != layers.IPProtocolTCP {
+		return ft.udpTimeout
+	}
+	switch entry.state {
+	case tcpStateSynSent, tcpStateSynReceived:
+		return tcpSynTimeout
+	case tcpStateClosing:
+		return tcpClosingTimeout
+	case tcpStateClosed:
+		return tcpClosedTimeout
+	default:
+		return ft.tcpTimeout
+	}
+}
+
+// lookup 查找连接，已超时的连接视为不存在并直接移除
+func (ft *flowTable) lookup(key flowKey, now time.Time) (*flowEntry, flowChange) {
+	remoteFlows := ft.flows[key.remoteIP]
+	entry, exists := remoteFlows[key]
+	if !exists {
+		return nil, flowChange{}
+	}
+	if now.Sub(entry.lastSeen) <= ft.timeout(key, entry) {
+		return entry, flowChange{}
+	}
+
+	var change flowChange
+	if entry.active() {
+		change.activeDelta = -1
+	}
+	ft.delete(key)
+	return nil, change
+}
+
+// insert 插入新连接
+func (ft *flowTable) insert(key flowKey, entry *flowEntry) {
+	remoteFlows, exists := ft.flows[key.remoteIP]
+	if !exists {
+		remoteFlows = make(map[flowKey]*flowEntry)
+		ft.flows[key.remoteIP] = remoteFlows
+	}
+	remoteFlows[key] = entry
+}
+
+// delete 删除连接
+func (ft *flowTable) delete(key flowKey) {
+	remoteFlows := ft.flows[key.remoteIP]
+	delete(remoteFlows, key)
+	if len(remoteFlows) == 0 {
+		delete(ft.flows, key.remoteIP)
+	}
+}
+
+// trackTCP 根据TCP标志位更新连接状态，isSent表示包由本地发出
+func (ft *flowTable) trackTCP(key flowKey, tcp *layers.TCP, isSent bool, now time.Time) flowChange {
+	entry, change := ft.lookup(key, now)
+
+	if entry == nil {
+		switch {
+		case tcp.RST, tcp.FIN:
+			// 未知连接的FIN/RST多为重传或迟到的包，不建立新连接
+			return change
+		case tcp.SYN && !tcp.ACK:
+			ft.insert(key, &flowEntry{state: tcpStateSynSent, inbound: !isSent, lastSeen: now})
+			change.activeDelta++
+			change.isNew = true
+			change.inbound = !isSent
+		default:
+			// 中途捕获的连接（如监控启动前已建立），直接视为已建立，端口较小的一方视为服务端
+			inbound := key.localPort < key.remotePort
+			ft.insert(key, &flowEntry{state: tcpStateEstablished, inbound: inbound, lastSeen: now})
+			change.activeDelta++
+			change.inbound = inbound
+		}
+		return change
+	}
+
+	entry.lastSeen = now
+	wasActive := entry.active()
+
+	switch {
+	case tcp.RST:
+		entry.state = tcpStateClosed
+	case tcp.SYN && !tcp.ACK:
+		// 重传的SYN不计为新连接；已关闭的五元组被复用时重新开始
+		if entry.state == tcpStateClosed || entry.state == tcpStateClosing {
+			*entry = flowEntry{state: tcpStateSynSent, inbound: !isSent, lastSeen: now}
+			change.isNew = true
+		}
+	case tcp.SYN && tcp.ACK:
+		if entry.state == tcpStateSynSent {
+			entry.state = tcpStateSynReceived
+		}
+	case tcp.FIN:
+		if isSent {
+			entry.finLocal = true
+		} else {
+			entry.finRemote = true
+		}
+		if entry.finLocal && entry.finRemote {
+			entry.state = tcpStateClosed
+		} else if entry.state != tcpStateClosed {
+			entry.state = tcpStateClosing
+		}
+	default:
+		if (entry.state == tcpStateSynSent || entry.state == tcpStateSynReceived) && tcp.ACK {
+			entry.state = tcpStateEstablished
+		}
+	}
+
+	if wasActive && !entry.active() {
+		change.activeDelta--
+	} else if !wasActive && entry.active() {
+		change.activeDelta++
+	}
+	change.inbound = entry.inbound
+	return change
+}
+
+// trackUDP 更新UDP流，首个包即建立新流，首包方向决定由哪一方发起
+func (ft *flowTable) trackUDP(key flowKey, isSent bool, now time.Time) flowChange {
+	entry, change := ft.lookup(key, now)
+	if entry != nil {
+		entry.lastSeen = now
+		change.inbound = entry.inbound
+		return change
+	}
+
+	ft.insert(key, &flowEntry{state: tcpStateEstablished, inbound: !isSent, lastSeen: now})
+	change.activeDelta++
+	change.isNew = true
+	change.inbound = !isSent
+	return change
+}
+
+// expire 移除超时的连接，返回其中仍计入活动连接数的key
+func (ft *flowTable) expire(now time.Time) []flowKey {
+	var expired []flowKey
+	for remoteIP, remoteFlows := range ft.flows {
+		for key, entry := range remoteFlows {
+			if now.Sub(entry.lastSeen) <= ft.timeout(key, entry) {
+				continue
+			}
+			if entry.active() {
+				expired = append(expired, key)
+			}
+			delete(remoteFlows, key)
+		}
+		if len(remoteFlows) == 0 {
+			delete(ft.flows, remoteIP)
+		}
+	}
+	return expired
+}
+
+// halfOpenCounts 返回各远程IP发起的未完成握手的连接数
+func (ft *flowTable) halfOpenCounts() map[string]int {
+	counts := make(map[string]int)
+	for remoteIP, remoteFlows := range ft.flows {
+		for _, entry := range remoteFlows {
+			if entry.halfOpen() {
+				counts[remoteIP]++
+			}
+		}
+	}
+	return counts
+}
+
+// removeRemote 移除某个远程IP的全部连接
+func (ft *flowTable) removeRemote(remoteIP string) {
+	delete(ft.flows, remoteIP)
+}
+
+// size 返回连接表中的条目数
+func (ft *flowTable) size() int {
+	total := 0
+	for _, remoteFlows := range ft.flows {
+		total += len(remoteFlows)
+	}
+	return total
+}
@@ -0,0 +1,131 @@
+package core
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/gopacket/layers"
+)
+
+func Test_flowTable_trackTCP(t *testing.T) {
+	key := flowKey{protocol: layers.IPProtocolTCP, remoteIP: "1.1.1.1", localIP: "10.0.0.1", remotePort: 40000, localPort: 443}
+	now := time.Now()
+
+	type step struct {
+		tcp        layers.TCP
+		isSent     bool
+		wantDelta  int
+		wantNew    bool
+		wantActive bool
+	}
+	tests := []struct {
+		name  string
+		steps []step
+	}{
+		{
+			name: "handshake_and_close",
+			steps: []step{
+				{tcp: layers.TCP{SYN: true}, wantDelta: 1, wantNew: true, wantActive: true},
+				{tcp: layers.TCP{SYN: true, ACK: true}, isSent: true, wantActive: true},
+				{tcp: layers.TCP{ACK: true}, wantActive: true},
+				{tcp: layers.TCP{FIN: true, ACK: true}, wantActive: true},
+				{tcp: layers.TCP{FIN: true, ACK: true}, isSent: true, wantDelta: -1},
+				{tcp: layers.TCP{ACK: true}},
+			},
+		},
+		{
+			name: "retransmitted_syn",
+			steps: []step{
+				{tcp: layers.TCP{SYN: true}, wantDelta: 1, wantNew: true, wantActive: true},
+				{tcp: layers.TCP{SYN: true}, wantActive: true},
+				{tcp: layers.TCP{SYN: true}, wantActive: true},
+			},
+		},
+		{
+			name: "mid_stream_pickup_and_rst",
+			steps: []step{
+				{tcp: layers.TCP{ACK: true, PSH: true}, wantDelta: 1, wantActive: true},
+				{tcp: layers.TCP{RST: true}, isSent: true, wantDelta: -1},
+				{tcp: layers.TCP{RST: true}, isSent: true},
+			},
+		},
+		{
+			name: "stray_fin_ignored",
+			steps: []step{
+				{tcp: layers.TCP{FIN: true, ACK: true}},
+				{tcp: layers.TCP{RST: true}},
+			},
+		},
+		{
+			name: "port_reuse_after_close",
+			steps: []step{
+				{tcp: layers.TCP{SYN: true}, wantDelta: 1, wantNew: true, wantActive: true},
+				{tcp: layers.TCP{RST: true}, wantDelta: -1},
+				{tcp: layers.TCP{SYN: true}, wantDelta: 1, wantNew: true, wantActive: true},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ft := newFlowTable(time.Minute, time.Minute)
+			for i, s := range tt.steps {
+				change := ft.trackTCP(key, &s.tcp, s.isSent, now)
+				if change.activeDelta != s.wantDelta || change.isNew != s.wantNew {
+					t.Errorf("step %d: trackTCP() = %+v, want delta %d new %v", i, change, s.wantDelta, s.wantNew)
+				}
+				entry := ft.flows[key.remoteIP][key]
+				if active := entry != nil && entry.active(); active != s.wantActive {
+					t.Errorf("step %d: active = %v, want %v", i, active, s.wantActive)
+				}
+			}
+		})
+	}
+}
+
+func Test_flowTable_expire(t *testing.T) {
+	ft := newFlowTable(time.Minute, 30*time.Second)
+	now := time.Now()
+
+	tcpKey := flowKey{protocol: layers.IPProtocolTCP, remoteIP: "1.1.1.1", localIP: "10.0.0.1", remotePort: 40000, localPort: 22}
+	udpKey := flowKey{protocol: layers.IPProtocolUDP, remoteIP: "2.2.2.2", localIP: "10.0.0.1", remotePort: 5353, localPort: 53}
+
+	ft.trackTCP(tcpKey, &layers.TCP{ACK: true}, false, now)
+	if change := ft.trackUDP(udpKey, false, now); change.activeDelta != 1 || !change.isNew {
+		t.Fatalf("trackUDP() = %+v, want new active flow", change)
+	}
+	if change := ft.trackUDP(udpKey, true, now.Add(10*time.Second)); change.activeDelta != 0 || change.isNew {
+		t.Fatalf("trackUDP() = %+v, want existing flow", change)
+	}
+
+	if expired := ft.expire(now.Add(45 * time.Second)); len(expired) != 1 || expired[0] != udpKey {
+		t.Errorf("expire() = %v, want [%v]", expired, udpKey)
+	}
+	if expired := ft.expire(now.Add(2 * time.Minute)); len(expired) != 1 || expired[0] != tcpKey {
+		t.Errorf("expire() = %v, want [%v]", expired, tcpKey)
+	}
+	if ft.size() != 0 {
+		t.Errorf("size() = %d, want 0", ft.size())
+	}
+}
+
+func Test_flowTable_halfOpenCounts(t *testing.T) {
+	ft := newFlowTable(time.Minute, time.Minute)
+	now := time.Now()
+	key := func(remoteIP string, remotePort uint16) flowKey {
+		return flowKey{protocol: layers.IPProtocolTCP, remoteIP: remoteIP, localIP: "10.0.0.1", remotePort: remotePort, localPort: 80}
+	}
+
+	// 1.1.1.1的两个SYN只收到SYN+ACK，第三个完成握手
+	for port := uint16(40000); port < 40003; port++ {
+		ft.trackTCP(key("1.1.1.1", port), &layers.TCP{SYN: true}, false, now)
+		ft.trackTCP(key("1.1.1.1", port), &layers.TCP{SYN: true, ACK: true}, true, now)
+	}
+	ft.trackTCP(key("1.1.1.1", 40002), &layers.TCP{ACK: true}, false, now)
+	// 本地发起的未完成握手不计入
+	ft.trackTCP(flowKey{protocol: layers.IPProtocolTCP, remoteIP: "2.2.2.2", localIP: "10.0.0.1", remotePort: 443, localPort: 50000}, &layers.TCP{SYN: true}, true, now)
+
+	counts := ft.halfOpenCounts()
+	if len(counts) != 1 || counts["1.1.1.1"] != 2 {
+		t.Errorf("halfOpenCounts() = %v, want map[1.1.1.1:2]", counts)
+	}
+}
@@ -0,0 +1,71 @@
+package core
+
+import (
+	"math"
+	"math/bits"
+)
+
+const (
+	hllPrecision = 10                // 用哈希高位选择寄存器的位数
+	hllRegisters = 1 << hllPrecision // 寄存器数量，标准误差约3%
+)
+
+// hyperLogLog 固定内存的基数估计，用于统计不同远程IP数
+// 不带锁，由所属分片的锁保护
+type hyperLogLog struct {
+	registers [hllRegisters]uint8
+}
+
+// hllHash 计算key的64位哈希
+func hllHash(key string) uint64 {
+	hash := uint64(14695981039346656037)
+	for i := 0; i < len(key); i++ {
+		hash ^= uint64(key[i])
+		hash *= 1099511628211
+	}
+	// 打散低位，FNV的高位分布不够均匀
+	hash ^= hash >> 33
+	hash *= 0xff51afd7ed558ccd
+	hash ^= hash >> 33
+	hash *= 0xc4ceb9fe1a85ec53
+	hash ^= hash >> 33
+	return hash
+}
+
+// add 记录一个key
+func (h *hyperLogLog) add(key string) {
+	hash := hllHash(key)
+	index := hash >> (64 - hllPrecision)
+	rank := uint8(bits.LeadingZeros64(hash<<hllPrecision|1<<(hllPrecision-1))) + 1
+	if rank > h.registers[index] {
+		h.registers[index] = rank
+	}
+}
+
+// merge 合并另一个估计器，结果等同于两者记录过的key的并集
+func (h *hyperLogLog) merge(other *hyperLogLog) {
+	for i, rank := range other.registers {
+		if rank > h.registers[i] {
+			h.registers[i] = rank
+		}
+	}
+}
+
+// estimate 返回记录过的不同key数量的估计值
+func (h *hyperLogLog) estimate() uint64 {
+	const m = float64(hllRegisters)
+	var sum float64
+	var zeros int
+	for _, rank := range h.registers {
+		sum += math.Ldexp(1, -int(rank))
+		if rank == 0 {
+			zeros++
+		}
+	}
+	estimate := 0.7213 / (1 + 1.079/m) * m * m / sum
+	// 基数较小时用线性计数修正
+	if estimate <= 2.5*m && zeros > 0 {
+		estimate = m * math.Log(m/float64(zeros))
+	}
+	return uint64(math.Round(estimate))
+}
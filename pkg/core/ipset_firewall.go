@@ -19,6 +19,8 @@ type IpSetFirewallCore struct {
 	banIpSet   string
 	allowIpSet string
 	ipt        *iptables.IPTables
+	router     bool // 路由模式下同时拦截转发的流量
+	limiter    rateLimiter
 }
 
 func (i *IpSetFirewallCore) InitRules() error {
@@ -109,21 +111,11 @@ func (i *IpSetFirewallCore) setupIptables() error {
 		_ = ipt.NewChain("filter", i.chain)
 	}
 
-	// 检查 INPUT 链是否已经包含对自定义链的引用
-	rules, err := ipt.List("filter", "INPUT")
-	if err != nil {
+	// 在 INPUT 链（路由模式下还有 FORWARD 链）的第1位插入跳转到自定义链的规则
+	if err := hookBanChain(ipt, i.chain, i.router); err != nil {
 		return err
 	}
 
-	// 查找是否已存在指向自定义链的规则
-	ruleExists := slices.Contains(rules, "-j "+i.chain)
-
-	// 只有在规则不存在时才插入
-	if !ruleExists {
-		slog.Info("初始化自定义链", "cmd", "iptables -I INPUT 1 -j "+i.chain)
-		_ = ipt.Insert("filter", "INPUT", 1, "-j", i.chain)
-	}
-
 	// 添加允许IP的规则到自定义链（优先级最高）
 	// iptables -A <chain> -m set --match-set <allow_ipset> src -j ACCEPT
 	slog.Info("添加允许ipset规则到iptables", "cmd", "iptables -A "+i.chain+" -m set --match-set "+i.allowIpSet+" src -j ACCEPT")
@@ -140,7 +132,7 @@ func (i *IpSetFirewallCore) setupIptables() error {
 		return fmt.Errorf("添加禁止ipset规则到iptables失败: %w", err)
 	}
 
-	return nil
+	return i.limiter.init(ipt, i.chain)
 }
 
 func (i *IpSetFirewallCore) Ban(ipOrCidr string) error {
@@ -159,6 +151,19 @@ func (i *IpSetFirewallCore) RevertAllow(ipOrCidr string) error {
 	return i.removeFromAllowRules(ipOrCidr)
 }
 
+func (i *IpSetFirewallCore) Limit(ipOrCidr string, bytesPerSec uint64) error {
+	return i.limiter.limit(ipOrCidr, bytesPerSec)
+}
+
+func (i *IpSetFirewallCore) RevertLimit(ipOrCidr string) error {
+	return i.limiter.revert(ipOrCidr)
+}
+
+// SupportsIPv6 ipset按IPv4地址族创建，规则只添加到iptables
+func (i *IpSetFirewallCore) SupportsIPv6() bool {
+	return false
+}
+
 func (i *IpSetFirewallCore) CleanupIpNetRules(ipOrCidr string) error {
 	// 先尝试从禁止ipset中删除
 
@@ -331,14 +336,8 @@ func (i *IpSetFirewallCore) CleanupRules() error {
 		i.ipt = ipt
 	}
 
-	// 从INPUT链移除所有指向自定义链的规则
-	for {
-		err := i.ipt.Delete("filter", "INPUT", "-j", i.chain)
-		if err != nil {
-			break
-		}
-		slog.Info("清除自定义链的规则", "cmd", "iptables -D INPUT -j "+i.chain)
-	}
+	// 从INPUT和FORWARD链移除所有指向自定义链的规则
+	unhookBanChain(i.ipt, i.chain)
 
 	// 清空自定义链中的所有规则
 	slog.Info("清空自定义链中的所有规则", "cmd", "iptables -F "+i.chain)
@@ -348,6 +347,8 @@ func (i *IpSetFirewallCore) CleanupRules() error {
 	slog.Info("删除自定义链", "cmd", "iptables -X "+i.chain)
 	_ = i.ipt.DeleteChain("filter", i.chain)
 
+	i.limiter.cleanup(i.ipt, i.chain)
+
 	// 清空禁止ipset
 	slog.Info("清空禁止ipset", "ipset", i.banIpSet, "cmd", "ipset flush "+i.banIpSet)
 	err := netlink.IpsetFlush(i.banIpSet)
@@ -12,8 +12,10 @@ import (
 
 // IptablesFirewallCore 实现iptables防火墙的核心操作
 type IptablesFirewallCore struct {
-	ipt   *iptables.IPTables
-	chain string
+	ipt     *iptables.IPTables
+	chain   string
+	router  bool // 路由模式下同时拦截转发的流量
+	limiter rateLimiter
 }
 
 func (i *IptablesFirewallCore) InitRules() error {
@@ -40,24 +42,12 @@ func (i *IptablesFirewallCore) InitRules() error {
 		_ = i.ipt.NewChain("filter", i.chain)
 	}
 
-	// 检查 INPUT 链是否已经包含对自定义链的引用
-	// iptables -L INPUT 列出 INPUT 链的所有规则
-	rules, err := i.ipt.List("filter", "INPUT")
-	if err != nil {
+	// 在 INPUT 链（路由模式下还有 FORWARD 链）的第1位插入跳转到自定义链的规则
+	if err := hookBanChain(i.ipt, i.chain, i.router); err != nil {
 		return err
 	}
 
-	// 查找是否已存在指向自定义链的规则
-	ruleExists := slices.Contains(rules, "-j "+i.chain)
-
-	// 只有在规则不存在时才插入
-	if !ruleExists {
-		// iptables -I INPUT 1 -j <chain> 在 INPUT 链的第1位插入规则，跳转到自定义链
-		slog.Info("初始化自定义链", "cmd", "iptables -I INPUT 1 -j "+i.chain)
-		_ = i.ipt.Insert("filter", "INPUT", 1, "-j", i.chain)
-	}
-
-	return nil
+	return i.limiter.init(i.ipt, i.chain)
 }
 
 func (i *IptablesFirewallCore) Ban(ipNet string) error {
@@ -76,6 +66,19 @@ func (i *IptablesFirewallCore) RevertAllow(ipNet string) error {
 	return i.removeFromAllowRules(ipNet)
 }
 
+func (i *IptablesFirewallCore) Limit(ipNet string, bytesPerSec uint64) error {
+	return i.limiter.limit(ipNet, bytesPerSec)
+}
+
+func (i *IptablesFirewallCore) RevertLimit(ipNet string) error {
+	return i.limiter.revert(ipNet)
+}
+
+// SupportsIPv6 规则只通过iptables添加，不管理ip6tables
+func (i *IptablesFirewallCore) SupportsIPv6() bool {
+	return false
+}
+
 func (i *IptablesFirewallCore) CleanupIpNetRules(ipNet string) error {
 	// 先尝试删除禁止规则
 	var errs []error
@@ -156,22 +159,54 @@ func (i *IptablesFirewallCore) CleanupRules() error {
 	slog.Info("清空自定义链中的所有规则", "cmd", "iptables -F "+i.chain)
 	_ = i.ipt.ClearChain("filter", i.chain)
 
-	// 从INPUT链移除所有指向自定义链的规则
-	// 使用循环删除，直到没有更多匹配的规则
-	for {
-		// iptables -D INPUT -j <chain> 从 INPUT 链中删除跳转到自定义链的规则
-		// 尝试删除规则，如果删除失败说明没有更多匹配的规则
-		err := i.ipt.Delete("filter", "INPUT", "-j", i.chain)
-		if err != nil {
-			// 没有更多匹配的规则，退出循环
-			break
-		}
-		slog.Info("清除自定义链的规则", "cmd", "iptables -D INPUT -j "+i.chain)
-	}
+	// 从INPUT和FORWARD链移除所有指向自定义链的规则
+	unhookBanChain(i.ipt, i.chain)
 
 	// 删除自定义链
 	// iptables -X <chain> 删除自定义链（链必须为空）
 	slog.Info("删除自定义链", "cmd", "iptables -X "+i.chain)
 	_ = i.ipt.DeleteChain("filter", i.chain)
+
+	i.limiter.cleanup(i.ipt, i.chain)
 	return nil
 }
+
+// banHookChains 挂载封禁链的内置链，路由模式下同时挂到FORWARD链，拦截外部IP与内网主机之间转发的流量
+func banHookChains(router bool) []string {
+	if router {
+		return []string{"INPUT", "FORWARD"}
+	}
+	return []string{"INPUT"}
+}
+
+// hookBanChain 将封禁链挂到INPUT链，路由模式下还挂到FORWARD链，已挂载时跳过
+func hookBanChain(ipt *iptables.IPTables, chain string, router bool) error {
+	for _, hook := range banHookChains(router) {
+		rules, err := ipt.List("filter", hook)
+		if err != nil {
+			return err
+		}
+		if slices.Contains(rules, "-A "+hook+" -j "+chain) {
+			continue
+		}
+		// iptables -I <hook> 1 -j <chain> 在内置链的第1位插入规则，跳转到自定义链
+		slog.Info("初始化自定义链", "cmd", "iptables -I "+hook+" 1 -j "+chain)
+		if err := ipt.Insert("filter", hook, 1, "-j", chain); err != nil {
+			return fmt.Errorf("挂载自定义链失败: %w", err)
+		}
+	}
+	return nil
+}
+
+// unhookBanChain 从INPUT和FORWARD链移除所有指向封禁链的规则，不论当前是否为路由模式，避免切换模式后残留
+func unhookBanChain(ipt *iptables.IPTables, chain string) {
+	for _, hook := range banHookChains(true) {
+		// 使用循环删除，删除失败说明没有更多匹配的规则
+		for {
+			if err := ipt.Delete("filter", hook, "-j", chain); err != nil {
+				break
+			}
+			slog.Info("清除自定义链的规则", "cmd", "iptables -D "+hook+" -j "+chain)
+		}
+	}
+}
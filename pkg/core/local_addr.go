@@ -0,0 +1,66 @@
+package core
+
+import (
+	"log/slog"
+
+	"github.com/vishvananda/netlink"
+)
+
+// watchLocalAddrs 订阅netlink地址变更，DHCP续约、VIP漂移或新建网桥时更新本地地址
+// 订阅失败时只记录警告，继续使用启动时获取的地址
+// 订阅时同时列出现有地址，创建监控器到启动之间新增的地址也能被加入
+func (m *Monitor) watchLocalAddrs() {
+	updates := make(chan netlink.AddrUpdate)
+	done := make(chan struct{})
+	err := netlink.AddrSubscribeWithOptions(updates, done, netlink.AddrSubscribeOptions{
+		ListExisting: true,
+		ErrorCallback: func(err error) {
+			slog.Warn("地址变更订阅出错", "error", err)
+		},
+	})
+	if err != nil {
+		slog.Warn("订阅地址变更失败，本地地址将不会自动更新", "error", err)
+		return
+	}
+
+	go func() {
+		defer close(done)
+		for {
+			select {
+			case update, ok := <-updates:
+				if !ok {
+					return
+				}
+				m.applyAddrUpdate(update)
+			case <-m.stopChan:
+				return
+			}
+		}
+	}()
+}
+
+// applyAddrUpdate 应用单个地址变更
+func (m *Monitor) applyAddrUpdate(update netlink.AddrUpdate) {
+	ip := update.LinkAddress.IP
+	if ip == nil || ip.IsLoopback() {
+		return
+	}
+
+	if update.NewAddr {
+		m.localMutex.Lock()
+		added := !m.localIPs[ip.String()]
+		m.localIPs[ip.String()] = true
+		m.localMutex.Unlock()
+		if added {
+			slog.Info("新增本地地址", "ip", ip, "link_index", update.LinkIndex)
+		}
+		return
+	}
+
+	// 同一地址可能配置在多个网卡上，删除时重新获取全部地址
+	if err := m.getLocalIPs(); err != nil {
+		slog.Error("重新获取本地地址失败", "error", err)
+		return
+	}
+	slog.Info("移除本地地址", "ip", ip, "link_index", update.LinkIndex)
+}
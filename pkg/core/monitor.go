@@ -3,30 +3,57 @@ package core
 import (
 	"fmt"
 	"log/slog"
+	"maps"
 	"net"
+	"slices"
 	"strings"
 	"sync"
 	"time"
 
 	"github.com/google/gopacket"
 	"github.com/google/gopacket/layers"
-	"github.com/google/gopacket/pcap"
 	"github.com/graydovee/netbouncer/pkg/config"
 )
 
 // TrafficStats 流量统计信息（对外暴露）
 type TrafficStats struct {
-	RemoteIP        string    `json:"remote_ip"`
-	LocalIP         string    `json:"local_ip"`
-	BytesSent       uint64    `json:"bytes_sent"`         // 总发送字节数
-	BytesRecv       uint64    `json:"bytes_recv"`         // 总接收字节数
-	PacketsSent     uint64    `json:"packets_sent"`       // 总发送包数
-	PacketsRecv     uint64    `json:"packets_recv"`       // 总接收包数
-	BytesSentPerSec float64   `json:"bytes_sent_per_sec"` // 每秒发送字节数
-	BytesRecvPerSec float64   `json:"bytes_recv_per_sec"` // 每秒接收字节数
-	LastSeen        time.Time `json:"last_seen"`          // 最后活动时间
-	FirstSeen       time.Time `json:"first_seen"`         // 首次发现时间
-	Connections     int       `json:"connections"`        // 连接数
+	RemoteIP        string         `json:"remote_ip"`
+	LocalIP         string         `json:"local_ip"`           // 最近通信的本地IP
+	LocalIPs        []string       `json:"local_ips"`          // 通信过的全部本地IP
+	BytesSent       uint64         `json:"bytes_sent"`         // 总发送字节数
+	BytesRecv       uint64         `json:"bytes_recv"`         // 总接收字节数
+	PacketsSent     uint64         `json:"packets_sent"`       // 总发送包数
+	PacketsRecv     uint64         `json:"packets_recv"`       // 总接收包数
+	BytesSentPerSec float64        `json:"bytes_sent_per_sec"` // 每秒发送字节数
+	BytesRecvPerSec float64        `json:"bytes_recv_per_sec"` // 每秒接收字节数
+	LastSeen        time.Time      `json:"last_seen"`          // 最后活动时间
+	FirstSeen       time.Time      `json:"first_seen"`         // 首次发现时间
+	Connections     int            `json:"connections"`        // 连接数
+	TCPFlows        int            `json:"tcp_flows"`          // 活动TCP连接数
+	UDPFlows        int            `json:"udp_flows"`          // 活动UDP流数
+	NewFlowsPerSec  float64        `json:"new_flows_per_sec"`  // 每秒新建连接数
+	DomainNames     []string       `json:"domain_names"`       // 从DNS响应中学习到的解析到该IP的域名
+	TLSServerNames  []string       `json:"tls_server_names"`   // 该IP发起TLS连接时的SNI，最近的在前
+	HTTPHosts       []string       `json:"http_hosts"`         // 该IP发起明文HTTP请求时的Host，最近的在前
+	JA3             []string       `json:"ja3"`                // 该IP的TLS客户端JA3指纹，最近的在前
+	JA4             []string       `json:"ja4"`                // 该IP的TLS客户端JA4指纹，最近的在前
+	Sampled         bool           `json:"sampled"`            // 统计是否来自抽样估算
+	SampleRate      int            `json:"sample_rate"`        // 抽样率，每N个包处理1个，计数已按N放大
+	Processes       []ProcessStats `json:"processes"`          // 与该IP通信的本地进程，流量最多的在前，未启用进程归属时为空
+
+	ICMPPackets      uint64 `json:"icmp_packets"`       // ICMP/ICMPv6总包数
+	ICMPEchoRequests uint64 `json:"icmp_echo_requests"` // 远程发来的ICMP回显请求数
+	ICMPUnreachables uint64 `json:"icmp_unreachables"`  // 远程发来的ICMP不可达消息数
+	OtherPackets     uint64 `json:"other_packets"`      // TCP/UDP/ICMP以外协议（如GRE、ESP）的总包数
+	OtherBytes       uint64 `json:"other_bytes"`        // TCP/UDP/ICMP以外协议的总字节数
+}
+
+// TrafficDetail 单个远程IP的流量详情
+type TrafficDetail struct {
+	TrafficStats
+	Locals    []LocalStats    `json:"locals"`    // 按本地IP划分
+	Protocols []ProtocolStats `json:"protocols"` // 按协议划分
+	TopPorts  []PortStats     `json:"top_ports"` // 访问最多的本地服务端口
 }
 
 // GetTotalBytes 获取总字节数
@@ -39,78 +66,199 @@ func (ts *TrafficStats) GetTotalPackets() uint64 {
 	return ts.PacketsSent + ts.PacketsRecv
 }
 
+// MonitorSource 定义流量数据源接口
+type MonitorSource interface {
+	// Name 返回数据源名称
+	Name() string
+	// Start 开始采集，采集到的流量写入监控器
+	Start(m *Monitor) error
+	// Stop 停止采集
+	Stop()
+	// DebugInfo 返回数据源的调试信息
+	DebugInfo() map[string]interface{}
+	// CaptureStats 返回累计的抓包计数，数据源不提供计数时返回false
+	CaptureStats() (CaptureStats, bool)
+}
+
 // Monitor 网络流量监控器
 type Monitor struct {
-	stats     map[string]*internalTrafficStats
-	mutex     sync.RWMutex
-	handle    *pcap.Handle
-	localIPs  map[string]bool
+	shards    []*statsShard // 按远程IP分片的统计和连接表
+	source    MonitorSource
+	localIPs  map[string]bool // 本机网卡上的地址，由localMutex保护，地址变更时更新
 	isRunning bool
 	stopChan  chan bool
-	device    string
+	topPorts  int // 详情中返回的端口数量
+
+	maxTrackedIPs    int                   // 最多跟踪的远程IP数量，0表示不限制
+	evictionPolicy   config.EvictionPolicy // 超出上限时的淘汰策略
+	heavyHitterCount int                   // 流量排行返回的IP数量
+	historySeconds   int                   // 秒级历史速率保留的点数
+	sampleRate       int                   // 抓包抽样率，每N个包处理1个
+	health           *captureHealth        // 数据源丢包率和处理延迟
+	dns              *dnsCache             // 被动DNS缓存
+	inspect          bool                  // 是否从包内容中提取SNI、TLS指纹和Host
+	fingerprints     *fingerprintTable     // 全局TLS客户端指纹统计
+	detector         *detector             // 端口扫描和SYN洪水检测，未启用时为nil
+	baseline         *baselineTracker      // 按本地端口的流量基线，未启用时为nil
+	processes        *processTable         // 本地socket所属的进程，未启用时为nil
+	exporter         *flowExporter         // NetFlow v9/IPFIX流导出，未启用时为nil
 
 	windowSize        time.Duration // 滑动窗口大小（如30秒）
+	bucketSize        time.Duration // 滑动窗口时间桶长度
 	connectionTimeout time.Duration // 连接超时时间
 	excludeSubnets    []*net.IPNet
+	localSubnets      []*net.IPNet // 额外视为本地的子网，路由模式下包括内网子网
+	mode              config.MonitorMode
+	localMutex        sync.RWMutex
 }
 
 // NewMonitor 创建新的监控器
 func NewMonitor(cfg *config.MonitorConfig) (*Monitor, error) {
-	device := cfg.Interface
 	windowSize := time.Duration(cfg.Window) * time.Second
+	bucketSize := time.Duration(cfg.WindowBucket) * time.Second
 	connectionTimeout := time.Duration(cfg.Timeout) * time.Second
+	flowTCPTimeout := time.Duration(cfg.FlowTCPTimeout) * time.Second
+	flowUDPTimeout := time.Duration(cfg.FlowUDPTimeout) * time.Second
 
-	var excludedSubnets []*net.IPNet
-	if cfg.ExcludeSubnets != "" {
-		excludedSubnetStrs := strings.SplitSeq(cfg.ExcludeSubnets, ",")
-		for subnetStr := range excludedSubnetStrs {
-			subnetStr = strings.TrimSpace(subnetStr)
-			if subnetStr == "" {
-				continue
-			}
-			_, ipNet, err := net.ParseCIDR(subnetStr)
-			if err != nil {
-				return nil, fmt.Errorf("解析排除的子网失败 %s: %w", subnetStr, err)
-			}
-			excludedSubnets = append(excludedSubnets, ipNet)
-			slog.Info("排除网段", "subnet", ipNet)
-		}
+	excludedSubnets, err := parseSubnets(cfg.ExcludeSubnets)
+	if err != nil {
+		return nil, fmt.Errorf("解析排除的子网失败 %w", err)
 	}
-
-	if device == "" {
-		// 自动选择默认网络接口
-		devices, err := pcap.FindAllDevs()
+	for _, subnet := range excludedSubnets {
+		slog.Info("排除网段", "subnet", subnet)
+	}
+	localSubnets, err := parseSubnets(cfg.LocalSubnets)
+	if err != nil {
+		return nil, fmt.Errorf("解析本地子网失败 %w", err)
+	}
+	mode := config.MonitorMode(cfg.Mode)
+	switch mode {
+	case "":
+		mode = config.MonitorModeHost
+	case config.MonitorModeHost:
+	case config.MonitorModeRouter:
+		// 路由模式下内网子网视为本地，转发的流量按内网主机与外部对端统计
+		internalSubnets, err := parseSubnets(cfg.InternalSubnets)
 		if err != nil {
-			return nil, fmt.Errorf("failed to find devices: %v", err)
+			return nil, fmt.Errorf("解析内网子网失败 %w", err)
 		}
-
-		for _, dev := range devices {
-			if len(dev.Addresses) > 0 && dev.Name != "lo" {
-				device = dev.Name
-				break
-			}
+		if len(internalSubnets) == 0 {
+			return nil, fmt.Errorf("router mode requires internal_subnets")
 		}
+		localSubnets = append(localSubnets, internalSubnets...)
+	default:
+		return nil, fmt.Errorf("invalid monitor mode: %s", cfg.Mode)
+	}
+	for _, subnet := range localSubnets {
+		slog.Info("视为本地的网段", "subnet", subnet)
+	}
 
-		if device == "" {
-			return nil, fmt.Errorf("no suitable network device found")
+	var source MonitorSource
+	switch config.MonitorSourceType(cfg.Source) {
+	case "", config.MonitorSourcePcap:
+		snaplen := cfg.Snaplen
+		if snaplen <= 0 {
+			snaplen = 1600
+		}
+		if cfg.InspectPayload && snaplen < inspectSnaplen {
+			// ClientHello和HTTP请求头可能超过默认的捕获长度，网卡合并分段后更长
+			slog.Info("已启用包内容解析，增大捕获长度", "snaplen", inspectSnaplen)
+			snaplen = inspectSnaplen
+		}
+		pcapSource, err := newPcapSource(cfg.Interface, snaplen)
+		if err != nil {
+			return nil, err
 		}
+		source = pcapSource
+	case config.MonitorSourceConntrack:
+		source = newConntrackSource(time.Duration(cfg.PollInterval)*time.Second, cfg.ConntrackAcct)
+	case config.MonitorSourceFlow:
+		flowSource, err := newFlowSource(cfg.Collector.Listen, cfg.Collector.SampleRate)
+		if err != nil {
+			return nil, err
+		}
+		source = flowSource
+	default:
+		return nil, fmt.Errorf("invalid monitor source: %s", cfg.Source)
 	}
+	slog.Info("使用流量数据源", "source", source.Name(), "mode", mode)
 
 	if windowSize <= 0 {
 		windowSize = 30 * time.Second // 默认30秒
 	}
+	if bucketSize <= 0 {
+		bucketSize = time.Second // 默认1秒
+	}
 	if connectionTimeout <= 0 {
 		connectionTimeout = 24 * time.Hour // 默认24小时
 	}
+	topPorts := cfg.TopPorts
+	if topPorts <= 0 {
+		topPorts = 10
+	}
+
+	evictionPolicy := config.EvictionPolicy(cfg.EvictionPolicy)
+	switch evictionPolicy {
+	case "":
+		evictionPolicy = config.EvictionPolicyLRU
+	case config.EvictionPolicyLRU, config.EvictionPolicyLeastTraffic:
+	default:
+		return nil, fmt.Errorf("invalid eviction policy: %s", cfg.EvictionPolicy)
+	}
+	maxTrackedIPs := max(cfg.MaxTrackedIPs, 0)
+	heavyHitterCount := cfg.HeavyHitters
+	if heavyHitterCount <= 0 {
+		heavyHitterCount = 20
+	}
+	historySeconds := min(cfg.HistorySeconds, 3600)
+	if historySeconds <= 0 {
+		historySeconds = 3600
+	}
+	sampleRate := max(cfg.SampleRate, 1)
+	if sampleRate > 1 && source.Name() != string(config.MonitorSourcePcap) {
+		// conntrack直接读取内核计数，没有逐包处理的开销
+		slog.Warn("抽样只对pcap数据源生效，已忽略", "source", source.Name(), "sample_rate", sampleRate)
+		sampleRate = 1
+	}
+	detect := newDetector(&cfg.Detection)
+	if detect != nil && (source.Name() != string(config.MonitorSourcePcap) || sampleRate > 1) {
+		// 检测依赖逐包的连接跟踪，conntrack和抽样都无法看到完整的握手过程
+		slog.Warn("端口扫描和SYN洪水检测需要不抽样的pcap数据源，已关闭", "source", source.Name(), "sample_rate", sampleRate)
+		detect = nil
+	}
+
+	exporter, err := newFlowExporter(&cfg.Export)
+	if err != nil {
+		return nil, err
+	}
 
 	monitor := &Monitor{
-		stats:             make(map[string]*internalTrafficStats),
+		shards: newStatsShards(maxTrackedIPs, heavyHitterCount, func() *flowTable {
+			return newFlowTable(flowTCPTimeout, flowUDPTimeout)
+		}),
 		localIPs:          make(map[string]bool),
 		stopChan:          make(chan bool),
-		device:            device,
+		source:            source,
+		topPorts:          topPorts,
+		maxTrackedIPs:     maxTrackedIPs,
+		evictionPolicy:    evictionPolicy,
+		heavyHitterCount:  heavyHitterCount,
+		historySeconds:    historySeconds,
+		sampleRate:        sampleRate,
+		dns:               newDNSCache(cfg.DNSCacheSize),
+		inspect:           cfg.InspectPayload,
+		fingerprints:      newFingerprintTable(),
+		detector:          detect,
+		baseline:          newBaselineTracker(&cfg.Baseline),
+		processes:         newProcessTable(&cfg.Process),
+		exporter:          exporter,
+		health:            newCaptureHealth(source.Name(), cfg.DropWarnRatio),
 		windowSize:        windowSize,
+		bucketSize:        bucketSize,
 		connectionTimeout: connectionTimeout,
 		excludeSubnets:    excludedSubnets,
+		localSubnets:      localSubnets,
+		mode:              mode,
 	}
 
 	// 获取本地IP地址
@@ -137,34 +285,64 @@ func (m *Monitor) SetConnectionTimeout(timeout time.Duration) {
 	m.connectionTimeout = timeout
 }
 
-// getLocalIPs 获取本地IP地址列表
+// getLocalIPs 获取本地IP地址列表，替换现有的本地地址
 func (m *Monitor) getLocalIPs() error {
 	addrs, err := net.InterfaceAddrs()
 	if err != nil {
 		return err
 	}
 
+	localIPs := make(map[string]bool)
 	for _, addr := range addrs {
 		if ipnet, ok := addr.(*net.IPNet); ok && !ipnet.IP.IsLoopback() {
 			if ipnet.IP.To4() != nil || ipnet.IP.To16() != nil {
-				m.localIPs[ipnet.IP.String()] = true
+				localIPs[ipnet.IP.String()] = true
 			}
 		}
 	}
 
+	m.localMutex.Lock()
+	m.localIPs = localIPs
+	m.localMutex.Unlock()
 	return nil
 }
 
+// isLocal 判断IP是否为本地地址
+func (m *Monitor) isLocal(ip string) bool {
+	m.localMutex.RLock()
+	local := m.localIPs[ip]
+	m.localMutex.RUnlock()
+	return local || ipInSubnets(ip, m.localSubnets)
+}
+
 // StartCleanupRoutine 启动定期清理协程
 func (m *Monitor) StartCleanupRoutine() {
 	go func() {
 		ticker := time.NewTicker(time.Minute)
 		defer ticker.Stop()
+		flowTicker := time.NewTicker(5 * time.Second)
+		defer flowTicker.Stop()
+		healthTicker := time.NewTicker(captureHealthInterval)
+		defer healthTicker.Stop()
+		var baselineTick <-chan time.Time
+		if m.baseline != nil {
+			baselineTicker := time.NewTicker(m.baseline.interval)
+			defer baselineTicker.Stop()
+			baselineTick = baselineTicker.C
+		}
 
 		for {
 			select {
 			case <-ticker.C:
 				m.cleanupInactiveConnections()
+				m.decayHeavyHitters()
+			case <-flowTicker.C:
+				m.expireFlows()
+				m.detectSynFloods()
+			case <-healthTicker.C:
+				m.sampleCaptureHealth()
+			case <-baselineTick:
+				m.evaluateBaselines()
 			case <-m.stopChan:
 				return
 			}
@@ -178,28 +356,25 @@ func (m *Monitor) Start() error {
 		return fmt.Errorf("monitor is already running")
 	}
 
-	// 打开网络接口进行捕获
-	handle, err := pcap.OpenLive(m.device, 1600, true, pcap.BlockForever)
-	if err != nil {
-		return fmt.Errorf("failed to open device %s: %v", m.device, err)
+	if err := m.source.Start(m); err != nil {
+		return err
 	}
-
-	m.handle = handle
 	m.isRunning = true
 
-	// 设置过滤器，只捕获TCP和UDP包
-	err = m.handle.SetBPFFilter("tcp or udp")
-	if err != nil {
-		return fmt.Errorf("failed to set BPF filter: %v", err)
-	}
-
-	// 启动包捕获协程
-	go m.capturePackets()
-
 	// 启动清理协程
 	m.StartCleanupRoutine()
+	// 跟随本机地址变更
+	m.watchLocalAddrs()
+	// 跟随本地进程的socket变化
+	if m.processes != nil {
+		m.watchProcesses()
+	}
+	// 导出到期的流
+	if m.exporter != nil {
+		m.watchExports()
+	}
 
-	slog.Info("Network monitor started on device", "device", m.device)
+	slog.Info("Network monitor started", "source", m.source.Name())
 	return nil
 }
 
@@ -211,29 +386,23 @@ func (m *Monitor) Stop() {
 
 	m.isRunning = false
 	close(m.stopChan)
-
-	if m.handle != nil {
-		m.handle.Close()
-	}
+	m.source.Stop()
 
 	slog.Info("Network monitor stopped")
 }
 
-// capturePackets 捕获网络包
-func (m *Monitor) capturePackets() {
-	packetSource := gopacket.NewPacketSource(m.handle, m.handle.LinkType())
-
-	for {
-		select {
-		case <-m.stopChan:
-			return
-		case packet := <-packetSource.Packets():
-			if packet == nil {
-				continue
-			}
-			m.processPacket(packet)
-		}
+// classify 根据源和目的地址确定远程IP、本地IP和流量方向
+// 本地到本地或远程到远程的流量返回ok=false
+func (m *Monitor) classify(srcIP, dstIP string) (remoteIP, localIP string, isSent bool, ok bool) {
+	srcLocal, dstLocal := m.isLocal(srcIP), m.isLocal(dstIP)
+	if srcLocal && !dstLocal {
+		// 本地发送到远程
+		return dstIP, srcIP, true, true
+	} else if !srcLocal && dstLocal {
+		// 远程发送到本地
+		return srcIP, dstIP, false, true
 	}
+	return "", "", false, false
 }
 
 // processPacket 处理单个网络包
@@ -250,19 +419,20 @@ func (m *Monitor) processPacket(packet gopacket.Packet) {
 
 	var srcIP, dstIP string
 	var length uint64
-	var isTCP bool
-	var tcpLayer *layers.TCP
+	var ipProtocol layers.IPProtocol
 
 	// 处理IPv4
 	if ipv4, ok := ipLayer.(*layers.IPv4); ok {
 		srcIP = ipv4.SrcIP.String()
 		dstIP = ipv4.DstIP.String()
+		ipProtocol = ipv4.Protocol
 		// 使用整个数据包的长度，而不是IP层的长度
 		length = uint64(len(packet.Data()))
 	} else if ipv6, ok := ipLayer.(*layers.IPv6); ok {
 		// 处理IPv6
 		srcIP = ipv6.SrcIP.String()
 		dstIP = ipv6.DstIP.String()
+		ipProtocol = ipv6.NextHeader
 		// 使用整个数据包的长度
 		length = uint64(len(packet.Data()))
 	} else {
@@ -275,145 +445,465 @@ func (m *Monitor) processPacket(packet gopacket.Packet) {
 		return
 	}
 
-	// 检查是否为TCP包
-	tcp := packet.Layer(layers.LayerTypeTCP)
-	if tcp != nil {
-		isTCP = true
-		tcpLayer, _ = tcp.(*layers.TCP)
+	// 从DNS响应中学习IP对应的域名，gopacket按53端口解码DNS层
+	if dns, ok := packet.Layer(layers.LayerTypeDNS).(*layers.DNS); ok {
+		m.dns.observe(dns, time.Now())
 	}
 
-	// 确定远程IP和流量方向
-	var remoteIP, localIP string
-	var isSent bool
-
-	if m.localIPs[srcIP] && !m.localIPs[dstIP] {
-		// 本地发送到远程
-		remoteIP = dstIP
-		localIP = srcIP
-		isSent = true
-	} else if !m.localIPs[srcIP] && m.localIPs[dstIP] {
-		// 远程发送到本地
-		remoteIP = srcIP
-		localIP = dstIP
-		isSent = false
-	} else {
-		// 跳过本地到本地或远程到远程的包
+	// 确定远程IP和流量方向，跳过本地到本地或远程到远程的包
+	remoteIP, localIP, isSent, ok := m.classify(srcIP, dstIP)
+	if !ok {
 		return
 	}
 
-	// 统计TCP连接数
-	if isTCP && tcpLayer != nil {
-		m.mutex.Lock()
-		stats, exists := m.stats[remoteIP]
-		if !exists {
-			stats = &internalTrafficStats{
-				remoteIP:   remoteIP,
-				localIP:    localIP,
-				firstSeen:  time.Now(),
-				lastSeen:   time.Now(),
-				sentWindow: newTrafficWindow(m.windowSize),
-				recvWindow: newTrafficWindow(m.windowSize),
+	// 抽样时每个包代表N个包，计数按抽样率放大
+	scale := uint64(m.sampleRate)
+	sample := trafficSample{
+		remoteIP: remoteIP,
+		localIP:  localIP,
+		protocol: ipProtocol, // GRE、ESP等其他协议按IP头中的协议号归入other
+		bytes:    length * scale,
+		packets:  scale,
+		isSent:   isSent,
+	}
+
+	shard := m.shard(remoteIP)
+	shard.mutex.Lock()
+	defer shard.mutex.Unlock()
+
+	now := time.Now()
+	shard.observe(remoteIP, sample.bytes)
+	stats := m.getOrCreateStats(shard, remoteIP, localIP, now)
+
+	// 跟踪五元组连接状态，远程发起的连接记录其访问的本地服务端口
+	if tcp, ok := packet.Layer(layers.LayerTypeTCP).(*layers.TCP); ok {
+		sample.protocol = layers.IPProtocolTCP
+		key := newFlowKey(sample.protocol, remoteIP, localIP, uint16(tcp.SrcPort), uint16(tcp.DstPort), isSent)
+		change := shard.flows.trackTCP(key, tcp, isSent, now)
+		m.applyFlowChange(stats, key.protocol, change, now)
+		sample.localPort, sample.remotePort = key.localPort, key.remotePort
+		if change.inbound {
+			sample.servicePort = key.localPort
+		}
+		if change.isNew && change.inbound {
+			sample.newFlows = uint64(m.sampleRate)
+			if m.detector != nil {
+				m.detector.observePort(stats, key.localPort, now)
 			}
-			m.stats[remoteIP] = stats
 		}
-
-		// 改进的TCP连接数统计逻辑
-		// 只统计SYN包（新连接开始）
-		if tcpLayer.SYN && !tcpLayer.ACK {
-			stats.connections++
+		// 记录远程IP作为客户端访问的主机名
+		if m.inspect && !isSent && len(tcp.Payload) > 0 {
+			m.inspectPayload(stats, tcp.Payload, now)
 		}
-		// 统计FIN或RST包（连接结束）
-		if (tcpLayer.FIN || tcpLayer.RST) && stats.connections > 0 {
-			stats.connections--
+	} else if udp, ok := packet.Layer(layers.LayerTypeUDP).(*layers.UDP); ok {
+		sample.protocol = layers.IPProtocolUDP
+		key := newFlowKey(sample.protocol, remoteIP, localIP, uint16(udp.SrcPort), uint16(udp.DstPort), isSent)
+		change := shard.flows.trackUDP(key, isSent, now)
+		m.applyFlowChange(stats, key.protocol, change, now)
+		sample.localPort, sample.remotePort = key.localPort, key.remotePort
+		if change.inbound {
+			sample.servicePort = key.localPort
+		}
+		if change.isNew && change.inbound {
+			sample.newFlows = uint64(m.sampleRate)
+			if m.detector != nil {
+				m.detector.observePort(stats, key.localPort, now)
+			}
 		}
-		m.mutex.Unlock()
+	} else if icmp, ok := packet.Layer(layers.LayerTypeICMPv4).(*layers.ICMPv4); ok {
+		sample.protocol = layers.IPProtocolICMPv4
+		sample.icmp = icmpv4Message(icmp)
+	} else if icmp, ok := packet.Layer(layers.LayerTypeICMPv6).(*layers.ICMPv6); ok {
+		sample.protocol = layers.IPProtocolICMPv6
+		sample.icmp = icmpv6Message(icmp)
 	}
 
 	// 更新统计信息
-	m.updateStats(remoteIP, localIP, length, isSent)
+	m.applySample(stats, sample, now)
+	if m.baseline != nil {
+		shard.recordService(sample)
+	}
+	if m.exporter != nil {
+		shard.recordExport(sample, now, m.exporter.shardLimit)
+	}
 }
 
-// updateStats 更新流量统计
-func (m *Monitor) updateStats(remoteIP string, localIP string, bytes uint64, isSent bool) {
-	m.mutex.Lock()
-	defer m.mutex.Unlock()
+// shard 返回远程IP所在的统计分片
+func (m *Monitor) shard(remoteIP string) *statsShard {
+	return m.shards[shardIndex(remoteIP)]
+}
 
-	now := time.Now()
-	stats, exists := m.stats[remoteIP]
+// getOrCreateStats 获取远程IP的统计信息，不存在时创建，分片已满时先淘汰一个远程IP，调用方需持有分片写锁
+// 调用前需先用shard.observe记录流量，成为流量排行候选的IP分配秒级历史
+func (m *Monitor) getOrCreateStats(shard *statsShard, remoteIP string, localIP string, now time.Time) *internalTrafficStats {
+	stats, exists := shard.stats[remoteIP]
 	if !exists {
+		if shard.full() {
+			shard.evict(m.evictionPolicy)
+		}
 		stats = &internalTrafficStats{
 			remoteIP:   remoteIP,
 			localIP:    localIP,
 			firstSeen:  now,
 			lastSeen:   now,
-			sentWindow: newTrafficWindow(m.windowSize),
-			recvWindow: newTrafficWindow(m.windowSize),
+			sentWindow: newTrafficWindow(m.windowSize, m.bucketSize),
+			recvWindow: newTrafficWindow(m.windowSize, m.bucketSize),
+			flowWindow: newTrafficWindow(m.windowSize, m.bucketSize),
+			locals:     make(map[string]*localTrafficStats),
+			protocols:  make(map[string]*trafficCounters),
+			ports:      newPortCounters(max(m.topPorts*4, 64)),
+			history:    newRateHistory(),
 		}
-		m.stats[remoteIP] = stats
+		shard.stats[remoteIP] = stats
+	}
+	// 大量只发少量包的IP（如伪造源地址）不会成为候选，不占用秒级历史的内存
+	if _, heavy := shard.heavy.estimates[remoteIP]; heavy {
+		shard.enableSecondsHistory(stats, m.historySeconds)
+	}
+	return stats
+}
+
+// trafficSample 一次流量计数，来自单个包或数据源汇总的增量
+type trafficSample struct {
+	remoteIP    string
+	localIP     string
+	protocol    layers.IPProtocol
+	servicePort uint16      // 远程发起连接时访问的本地端口，0表示不计入端口统计
+	localPort   uint16      // TCP/UDP的本地端口，用于归属到本地进程
+	remotePort  uint16      // TCP/UDP的远程端口，用于导出流记录
+	icmp        icmpMessage // ICMP消息类型，只有数据源能解析ICMP头时才有值
+	newFlows    uint64      // 本次计数中远程新发起的连接数，用于端口基线
+	bytes       uint64
+	packets     uint64
+	rate        uint64 // 流记录的抽样率，计数已按该值放大，0表示未抽样
+	isSent      bool
+}
+
+// updateStats 更新流量统计
+func (m *Monitor) updateStats(sample trafficSample) {
+	shard := m.shard(sample.remoteIP)
+	shard.mutex.Lock()
+	defer shard.mutex.Unlock()
+
+	now := time.Now()
+	shard.observe(sample.remoteIP, sample.bytes)
+	m.applySample(m.getOrCreateStats(shard, sample.remoteIP, sample.localIP, now), sample, now)
+	if m.baseline != nil {
+		shard.recordService(sample)
 	}
+	if m.exporter != nil {
+		shard.recordExport(sample, now, m.exporter.shardLimit)
+	}
+}
+
+// applySample 将一次流量计数累加到远程IP的统计中，调用方需持有分片写锁
+func (m *Monitor) applySample(stats *internalTrafficStats, sample trafficSample, now time.Time) {
+	stats.sampleRate = max(stats.sampleRate, sample.rate)
 
 	// 更新总流量
-	if isSent {
-		stats.bytesSent += bytes
-		stats.packetsSent++
-		stats.sentWindow.addPoint(bytes)
+	if sample.isSent {
+		stats.bytesSent += sample.bytes
+		stats.packetsSent += sample.packets
+		stats.sentWindow.add(now, sample.bytes)
 	} else {
-		stats.bytesRecv += bytes
-		stats.packetsRecv++
-		stats.recvWindow.addPoint(bytes)
+		stats.bytesRecv += sample.bytes
+		stats.packetsRecv += sample.packets
+		stats.recvWindow.add(now, sample.bytes)
+	}
+	stats.history.add(now, sample.bytes, sample.isSent)
+
+	// 按本地IP统计，远程IP可能与多个本地地址通信
+	local, exists := stats.locals[sample.localIP]
+	if !exists {
+		local = &localTrafficStats{firstSeen: now}
+		stats.locals[sample.localIP] = local
+	}
+	local.add(sample.bytes, sample.packets, sample.isSent)
+	local.lastSeen = now
+	stats.localIP = sample.localIP
+
+	// 按协议和本地服务端口统计
+	protocol := protocolName(sample.protocol)
+	counter, exists := stats.protocols[protocol]
+	if !exists {
+		counter = &trafficCounters{}
+		stats.protocols[protocol] = counter
+	}
+	counter.add(sample.bytes, sample.packets, sample.isSent)
+	if sample.servicePort != 0 {
+		stats.ports.add(portKey{protocol: protocol, port: sample.servicePort}, sample.bytes, sample.packets, sample.isSent)
+	}
+	if m.processes != nil && sample.localPort != 0 {
+		m.recordProcess(stats, sample, now)
+	}
+
+	// 远程发来的ICMP回显请求和不可达消息，用于发现ping洪水和扫描
+	if !sample.isSent {
+		switch sample.icmp {
+		case icmpEchoRequest:
+			stats.icmpEchoRequests += sample.packets
+		case icmpUnreachable:
+			stats.icmpUnreachables += sample.packets
+		}
 	}
 
 	stats.lastSeen = now
 }
 
+// newFlowKey 根据包的源端口和目的端口构造按远程/本地归一化的五元组
+func newFlowKey(protocol layers.IPProtocol, remoteIP, localIP string, srcPort, dstPort uint16, isSent bool) flowKey {
+	key := flowKey{protocol: protocol, remoteIP: remoteIP, localIP: localIP}
+	if isSent {
+		key.localPort, key.remotePort = srcPort, dstPort
+	} else {
+		key.remotePort, key.localPort = srcPort, dstPort
+	}
+	return key
+}
+
+// applyFlowChange 将连接表的变化应用到远程IP的统计中，调用方需持有分片写锁
+func (m *Monitor) applyFlowChange(stats *internalTrafficStats, protocol layers.IPProtocol, change flowChange, now time.Time) {
+	if change.isNew {
+		// 抽样时只有被抽中的SYN计为新建，按抽样率放大
+		stats.flowWindow.add(now, uint64(m.sampleRate))
+	}
+	if change.activeDelta == 0 {
+		return
+	}
+
+	switch protocol {
+	case layers.IPProtocolTCP:
+		stats.tcpFlows = max(stats.tcpFlows+change.activeDelta, 0)
+	case layers.IPProtocolUDP:
+		stats.udpFlows = max(stats.udpFlows+change.activeDelta, 0)
+	}
+	stats.connections = stats.tcpFlows + stats.udpFlows
+}
+
+// expireFlows 清理超时的连接并更新活动连接数
+func (m *Monitor) expireFlows() {
+	for _, shard := range m.shards {
+		shard.mutex.Lock()
+		now := time.Now()
+		for _, key := range shard.flows.expire(now) {
+			if stats, exists := shard.stats[key.remoteIP]; exists {
+				m.applyFlowChange(stats, key.protocol, flowChange{activeDelta: -1}, now)
+			}
+		}
+		shard.mutex.Unlock()
+	}
+}
+
+// flowCounts 数据源直接提供的某个远程IP的连接数
+type flowCounts struct {
+	total    int // 全部协议的连接数
+	tcp      int // TCP连接数
+	udp      int // UDP流数
+	newFlows int // 本次统计周期内新建的连接数
+}
+
+// setFlowCounts 使用数据源提供的准确连接数覆盖各远程IP的连接数
+func (m *Monitor) setFlowCounts(counts map[string]flowCounts) {
+	now := time.Now()
+	for _, shard := range m.shards {
+		shard.mutex.Lock()
+		for ip, stats := range shard.stats {
+			count := counts[ip]
+			stats.connections = count.total
+			stats.tcpFlows = count.tcp
+			stats.udpFlows = count.udp
+			if count.newFlows > 0 {
+				stats.flowWindow.add(now, uint64(count.newFlows))
+			}
+		}
+		shard.mutex.Unlock()
+	}
+}
+
 // cleanupInactiveConnections 清理长时间未活动的连接
 func (m *Monitor) cleanupInactiveConnections() {
-	m.mutex.Lock()
-	defer m.mutex.Unlock()
-
 	now := time.Now()
-	for ip, stats := range m.stats {
-		if now.Sub(stats.lastSeen) > m.connectionTimeout {
-			delete(m.stats, ip)
+	for _, shard := range m.shards {
+		shard.mutex.Lock()
+		for ip, stats := range shard.stats {
+			if now.Sub(stats.lastSeen) > m.connectionTimeout {
+				shard.remove(ip, stats)
+			}
 		}
+		shard.mutex.Unlock()
 	}
 }
 
+// decayHeavyHitters 定期衰减流量估计，使流量排行反映近期流量
+func (m *Monitor) decayHeavyHitters() {
+	for _, shard := range m.shards {
+		shard.mutex.Lock()
+		shard.decay()
+		shard.mutex.Unlock()
+	}
+}
+
+// GetHeavyHitters 获取流量最大的远程IP，包括因超出跟踪上限未被跟踪的IP
+func (m *Monitor) GetHeavyHitters() []HeavyHitter {
+	var result []HeavyHitter
+	for _, shard := range m.shards {
+		shard.mutex.RLock()
+		for _, hitter := range shard.heavy.top(m.heavyHitterCount) {
+			if ipInSubnets(hitter.RemoteIP, m.excludeSubnets) {
+				continue
+			}
+			_, hitter.Tracked = shard.stats[hitter.RemoteIP]
+			result = append(result, hitter)
+		}
+		shard.mutex.RUnlock()
+	}
+
+	sortHeavyHitters(result)
+	if len(result) > m.heavyHitterCount {
+		result = result[:m.heavyHitterCount]
+	}
+	return result
+}
+
 // GetAllStats 获取所有IP的流量统计
 func (m *Monitor) GetAllStats() map[string]*TrafficStats {
-	m.mutex.RLock()
-	defer m.mutex.RUnlock()
+	return m.collectStats(nil)
+}
+
+// GetStats 获取过滤后的IP流量统计
+func (m *Monitor) GetStats() map[string]*TrafficStats {
+	return m.collectStats(m.excludeSubnets)
+}
 
+// collectStats 复制所有分片中的统计，跳过排除子网中的IP
+func (m *Monitor) collectStats(excludeSubnets []*net.IPNet) map[string]*TrafficStats {
+	now := time.Now()
 	// 创建副本以避免并发访问问题
 	result := make(map[string]*TrafficStats)
-	for ip, stats := range m.stats {
-		result[ip] = stats.toTrafficStats()
+	for _, shard := range m.shards {
+		shard.mutex.RLock()
+		for ip, stats := range shard.stats {
+			// 检查IP是否在排除的子网中
+			if ipInSubnets(ip, excludeSubnets) {
+				continue
+			}
+			result[ip] = m.annotate(stats.toTrafficStats(now))
+		}
+		shard.mutex.RUnlock()
 	}
 
 	return result
 }
 
-// GetStats 获取过滤后的IP流量统计
-func (m *Monitor) GetStats() map[string]*TrafficStats {
-	m.mutex.RLock()
-	defer m.mutex.RUnlock()
+// GetDetail 获取单个远程IP的流量详情，包括协议划分和访问最多的本地端口
+func (m *Monitor) GetDetail(remoteIP string) (*TrafficDetail, bool) {
+	shard := m.shard(remoteIP)
+	shard.mutex.RLock()
+	defer shard.mutex.RUnlock()
 
-	result := make(map[string]*TrafficStats)
+	stats, exists := shard.stats[remoteIP]
+	if !exists {
+		return nil, false
+	}
 
-	for ip, stats := range m.stats {
-		// 检查IP是否在排除的子网中
-		if isIPExcluded(ip, m.excludeSubnets) {
-			continue
+	return &TrafficDetail{
+		TrafficStats: *m.annotate(stats.toTrafficStats(time.Now())),
+		Locals:       localStats(stats.locals),
+		Protocols:    protocolStats(stats.protocols),
+		TopPorts:     stats.ports.top(m.topPorts),
+	}, true
+}
+
+// GetHistory 获取单个远程IP的秒级和分钟级历史速率
+// 尚未分配秒级历史的IP从本次查询开始记录
+func (m *Monitor) GetHistory(remoteIP string) (*RateHistory, bool) {
+	shard := m.shard(remoteIP)
+	shard.mutex.Lock()
+	defer shard.mutex.Unlock()
+
+	stats, exists := shard.stats[remoteIP]
+	if !exists {
+		return nil, false
+	}
+	shard.enableSecondsHistory(stats, m.historySeconds)
+
+	now := time.Now()
+	return &RateHistory{
+		RemoteIP: remoteIP,
+		Seconds:  stats.history.secondsSeries(now),
+		Minutes:  stats.history.minutes.series(now),
+	}, true
+}
+
+// CollectDeltas 返回各远程IP自上次调用以来新增的流量，用于定期持久化
+func (m *Monitor) CollectDeltas() []TrafficDelta {
+	return m.collectDeltas(func(stats *internalTrafficStats) *trafficCounters { return &stats.flushed })
+}
+
+// CollectQuotaDeltas 返回各远程IP自上次调用以来新增的流量，用于累计带宽配额，与CollectDeltas互不影响
+func (m *Monitor) CollectQuotaDeltas() []TrafficDelta {
+	return m.collectDeltas(func(stats *internalTrafficStats) *trafficCounters { return &stats.quotaCounted })
+}
+
+// collectDeltas 计算各远程IP的累计流量与cursor记录的差值，并将cursor更新为当前累计流量
+func (m *Monitor) collectDeltas(cursor func(stats *internalTrafficStats) *trafficCounters) []TrafficDelta {
+	var result []TrafficDelta
+	for _, shard := range m.shards {
+		shard.mutex.Lock()
+		for ip, stats := range shard.stats {
+			if ipInSubnets(ip, m.excludeSubnets) {
+				continue
+			}
+			last := cursor(stats)
+			delta := TrafficDelta{
+				RemoteIP:    ip,
+				BytesSent:   stats.bytesSent - last.bytesSent,
+				BytesRecv:   stats.bytesRecv - last.bytesRecv,
+				PacketsSent: stats.packetsSent - last.packetsSent,
+				PacketsRecv: stats.packetsRecv - last.packetsRecv,
+			}
+			if delta.PacketsSent == 0 && delta.PacketsRecv == 0 {
+				continue
+			}
+			*last = trafficCounters{
+				bytesSent:   stats.bytesSent,
+				bytesRecv:   stats.bytesRecv,
+				packetsSent: stats.packetsSent,
+				packetsRecv: stats.packetsRecv,
+			}
+			result = append(result, delta)
 		}
-		result[ip] = stats.toTrafficStats()
+		shard.mutex.Unlock()
 	}
+	return result
+}
 
+// GetStatsByLocal 获取与指定本地IP通信的全部远程IP，只包含该本地IP上的流量
+func (m *Monitor) GetStatsByLocal(localIP string) []*PeerStats {
+	var result []*PeerStats
+	for _, shard := range m.shards {
+		shard.mutex.RLock()
+		for ip, stats := range shard.stats {
+			if ipInSubnets(ip, m.excludeSubnets) {
+				continue
+			}
+			local, exists := stats.locals[localIP]
+			if !exists {
+				continue
+			}
+			result = append(result, &PeerStats{
+				RemoteIP:   ip,
+				LocalStats: local.toLocalStats(localIP),
+			})
+		}
+		shard.mutex.RUnlock()
+	}
 	return result
 }
 
-// isIPExcluded 检查IP是否在排除的子网中
-func isIPExcluded(ipStr string, excludedSubnets []*net.IPNet) bool {
-	if len(excludedSubnets) == 0 {
+// ipInSubnets 检查IP是否在给定的子网中
+func ipInSubnets(ipStr string, subnets []*net.IPNet) bool {
+	if len(subnets) == 0 {
 		return false
 	}
 
@@ -422,7 +912,7 @@ func isIPExcluded(ipStr string, excludedSubnets []*net.IPNet) bool {
 		return false
 	}
 
-	for _, subnet := range excludedSubnets {
+	for _, subnet := range subnets {
 		if subnet.Contains(ip) {
 			return true
 		}
@@ -430,37 +920,100 @@ func isIPExcluded(ipStr string, excludedSubnets []*net.IPNet) bool {
 	return false
 }
 
+// parseSubnets 解析逗号分隔的子网列表
+func parseSubnets(subnets string) ([]*net.IPNet, error) {
+	var result []*net.IPNet
+	for subnetStr := range strings.SplitSeq(subnets, ",") {
+		subnetStr = strings.TrimSpace(subnetStr)
+		if subnetStr == "" {
+			continue
+		}
+		_, ipNet, err := net.ParseCIDR(subnetStr)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", subnetStr, err)
+		}
+		result = append(result, ipNet)
+	}
+	return result, nil
+}
+
 // ClearStats 清空统计信息
 func (m *Monitor) ClearStats() {
-	m.mutex.Lock()
-	defer m.mutex.Unlock()
-
-	m.stats = make(map[string]*internalTrafficStats)
+	for _, shard := range m.shards {
+		shard.mutex.Lock()
+		shard.stats = make(map[string]*internalTrafficStats)
+		shard.histories = make(map[string]struct{})
+		shard.flows = newFlowTable(shard.flows.tcpTimeout, shard.flows.udpTimeout)
+		shard.sketch = &countMinSketch{}
+		shard.heavy = newHeavyHitters(shard.heavy.limit)
+		shard.services = make(map[portKey]*serviceWindow)
+		shard.mutex.Unlock()
+	}
 }
 
 // GetDebugInfo 获取调试信息
 func (m *Monitor) GetDebugInfo() map[string]interface{} {
-	m.mutex.RLock()
-	defer m.mutex.RUnlock()
-
 	debugInfo := make(map[string]interface{})
-	debugInfo["total_connections"] = len(m.stats)
-	debugInfo["local_ips"] = m.localIPs
-	debugInfo["device"] = m.device
+	m.localMutex.RLock()
+	debugInfo["local_ips"] = maps.Clone(m.localIPs)
+	m.localMutex.RUnlock()
+	debugInfo["local_subnets"] = m.localSubnets
+	debugInfo["mode"] = string(m.mode)
+	debugInfo["source"] = m.source.Name()
+	for k, v := range m.source.DebugInfo() {
+		debugInfo[k] = v
+	}
+	debugInfo["capture_health"] = m.health.get()
 	debugInfo["is_running"] = m.isRunning
 	debugInfo["window_size"] = m.windowSize.String()
+	debugInfo["window_bucket"] = m.bucketSize.String()
 	debugInfo["connection_timeout"] = m.connectionTimeout.String()
+	debugInfo["max_tracked_ips"] = m.maxTrackedIPs
+	debugInfo["eviction_policy"] = string(m.evictionPolicy)
+	debugInfo["sample_rate"] = m.sampleRate
+	debugInfo["dns_cache_entries"] = m.dns.size()
+	debugInfo["inspect_payload"] = m.inspect
+	m.fingerprints.mutex.Lock()
+	debugInfo["tls_fingerprints"] = len(m.fingerprints.entries)
+	debugInfo["fingerprint_observations_dropped"] = m.fingerprints.dropped
+	m.fingerprints.mutex.Unlock()
+	debugInfo["detection_enabled"] = m.detector != nil
+	debugInfo["baseline_enabled"] = m.baseline != nil
+	debugInfo["process_enabled"] = m.processes != nil
+	debugInfo["export_enabled"] = m.exporter != nil
+	if m.exporter != nil {
+		debugInfo["export_collectors"] = m.exporter.collectors
+		debugInfo["export_records"] = m.exporter.records.Load()
+		debugInfo["export_packets"] = m.exporter.packets.Load()
+		debugInfo["export_errors"] = m.exporter.errors.Load()
+	}
 
 	// 统计总流量
+	var totalConnections, trackedFlows int
+	var evictedIPs, exportDropped uint64
 	var totalBytesSent, totalBytesRecv uint64
 	var totalPacketsSent, totalPacketsRecv uint64
-	for _, stats := range m.stats {
-		totalBytesSent += stats.bytesSent
-		totalBytesRecv += stats.bytesRecv
-		totalPacketsSent += stats.packetsSent
-		totalPacketsRecv += stats.packetsRecv
+	for _, shard := range m.shards {
+		shard.mutex.RLock()
+		totalConnections += len(shard.stats)
+		trackedFlows += shard.flows.size()
+		evictedIPs += shard.evictions
+		exportDropped += shard.exportDropped
+		for _, stats := range shard.stats {
+			totalBytesSent += stats.bytesSent
+			totalBytesRecv += stats.bytesRecv
+			totalPacketsSent += stats.packetsSent
+			totalPacketsRecv += stats.packetsRecv
+		}
+		shard.mutex.RUnlock()
 	}
 
+	debugInfo["total_connections"] = totalConnections
+	debugInfo["tracked_flows"] = trackedFlows
+	debugInfo["evicted_ips"] = evictedIPs
+	if m.exporter != nil {
+		debugInfo["export_dropped"] = exportDropped
+	}
 	debugInfo["total_bytes_sent"] = totalBytesSent
 	debugInfo["total_bytes_recv"] = totalBytesRecv
 	debugInfo["total_packets_sent"] = totalPacketsSent
@@ -470,113 +1023,86 @@ func (m *Monitor) GetDebugInfo() map[string]interface{} {
 	return debugInfo
 }
 
-// trafficWindow 流量滑动窗口
-type trafficWindow struct {
-	windowSize time.Duration // 窗口大小（如30秒）
-	points     []windowPoint // 窗口数据点
-	mutex      sync.Mutex    // 新增互斥锁，保证线程安全
-}
-
-// windowPoint 窗口数据点
-type windowPoint struct {
-	timestamp time.Time
-	increment uint64 // 这次增加的字节数
-}
-
-// newTrafficWindow 创建新的流量滑动窗口
-func newTrafficWindow(windowSize time.Duration) *trafficWindow {
-	if windowSize <= 0 {
-		windowSize = 30 * time.Second
-	}
-	return &trafficWindow{
-		windowSize: windowSize,
-		points:     make([]windowPoint, 0, 30),
-	}
+// internalTrafficStats 内部使用的流量统计信息
+type internalTrafficStats struct {
+	remoteIP         string
+	localIP          string
+	bytesSent        uint64
+	bytesRecv        uint64
+	packetsSent      uint64
+	packetsRecv      uint64
+	lastSeen         time.Time
+	firstSeen        time.Time
+	connections      int
+	tcpFlows         int
+	udpFlows         int
+	icmpEchoRequests uint64
+	icmpUnreachables uint64
+	sampleRate       uint64                              // 计入的流记录中最大的抽样率，pcap抽样由监控器统一记录
+	sentWindow       *trafficWindow                      // 发送流量滑动窗口
+	recvWindow       *trafficWindow                      // 接收流量滑动窗口
+	flowWindow       *trafficWindow                      // 新建连接滑动窗口
+	locals           map[string]*localTrafficStats       // 按本地IP统计
+	protocols        map[string]*trafficCounters         // 按协议统计
+	ports            *portCounters                       // 按本地服务端口统计
+	history          *rateHistory                        // 秒级和分钟级历史速率
+	flushed          trafficCounters                     // 上次导出快照时的累计流量
+	quotaCounted     trafficCounters                     // 上次计入带宽配额时的累计流量
+	tlsServerNames   []string                            // 最近的TLS SNI
+	httpHosts        []string                            // 最近的HTTP Host
+	ja3              []string                            // 最近的JA3指纹
+	ja4              []string                            // 最近的JA4指纹
+	scanPorts        map[uint16]time.Time                // 端口扫描检测：新连接访问的本地端口及时间
+	detectedAt       map[string]time.Time                // 各检测类型最近一次产生事件的时间
+	processes        map[processKey]*processTrafficStats // 按本地进程统计，启用进程归属时才有
 }
 
-// addPoint 添加数据点
-func (tw *trafficWindow) addPoint(increment uint64) {
-	tw.mutex.Lock()
-	defer tw.mutex.Unlock()
-	now := time.Now()
-
-	// 移除过期的数据点
-	tw.cleanup(now)
-	// 添加新数据点
-	tw.points = append(tw.points, windowPoint{
-		timestamp: now,
-		increment: increment,
-	})
-}
-
-// cleanup 清理过期的数据点
-func (tw *trafficWindow) cleanup(now time.Time) {
-	cutoff := now.Add(-tw.windowSize)
-	validStart := len(tw.points)
-	for i, point := range tw.points {
-		if point.timestamp.After(cutoff) {
-			validStart = i
-			break
-		}
-	}
-	tw.points = tw.points[validStart:]
+// annotate 补充统计之外的信息：解析到该IP的域名和抽样率
+// flow数据源的抽样率来自各条流记录，pcap数据源使用配置的抽样率
+func (m *Monitor) annotate(stats *TrafficStats) *TrafficStats {
+	stats.DomainNames = m.dns.lookup(stats.RemoteIP)
+	stats.SampleRate = max(stats.SampleRate, m.sampleRate)
+	stats.Sampled = stats.SampleRate > 1
+	return stats
 }
 
-// getRate 计算当前速率（字节/秒）
-func (tw *trafficWindow) getRate() float64 {
-	tw.mutex.Lock()
-	defer tw.mutex.Unlock()
-
-	now := time.Now()
-	tw.cleanup(now)
-
-	if len(tw.points) == 0 {
-		return 0
+// toTrafficStats 将内部统计转换为对外暴露的统计
+func (its *internalTrafficStats) toTrafficStats(now time.Time) *TrafficStats {
+	var icmp, other trafficCounters
+	if counter, exists := its.protocols[ProtocolICMP]; exists {
+		icmp = *counter
 	}
-
-	// 计算窗口内的总增量字节数
-	var totalIncrement uint64
-	for _, point := range tw.points {
-		totalIncrement += point.increment
+	if counter, exists := its.protocols[ProtocolOther]; exists {
+		other = *counter
 	}
 
-	// 计算时间窗口大小
-	windowDuration := now.Sub(tw.points[0].timestamp).Seconds()
-	if windowDuration <= 0 {
-		return 0
-	}
-
-	return float64(totalIncrement) / windowDuration
-}
-
-// internalTrafficStats 内部使用的流量统计信息
-type internalTrafficStats struct {
-	remoteIP    string
-	localIP     string
-	bytesSent   uint64
-	bytesRecv   uint64
-	packetsSent uint64
-	packetsRecv uint64
-	lastSeen    time.Time
-	firstSeen   time.Time
-	connections int
-	sentWindow  *trafficWindow // 发送流量滑动窗口
-	recvWindow  *trafficWindow // 接收流量滑动窗口
-}
-
-// toTrafficStats 将内部统计转换为对外暴露的统计
-func (its *internalTrafficStats) toTrafficStats() *TrafficStats {
 	return &TrafficStats{
 		RemoteIP:        its.remoteIP,
 		LocalIP:         its.localIP,
+		LocalIPs:        slices.Sorted(maps.Keys(its.locals)),
 		BytesSent:       its.bytesSent,
 		BytesRecv:       its.bytesRecv,
 		PacketsSent:     its.packetsSent,
 		PacketsRecv:     its.packetsRecv,
-		BytesSentPerSec: its.sentWindow.getRate(),
-		BytesRecvPerSec: its.recvWindow.getRate(),
+		BytesSentPerSec: its.sentWindow.rate(now),
+		BytesRecvPerSec: its.recvWindow.rate(now),
 		LastSeen:        its.lastSeen,
 		FirstSeen:       its.firstSeen,
 		Connections:     its.connections,
+		TCPFlows:        its.tcpFlows,
+		UDPFlows:        its.udpFlows,
+		NewFlowsPerSec:  its.flowWindow.rate(now),
+		SampleRate:      int(its.sampleRate),
+
+		ICMPPackets:      icmp.packetsSent + icmp.packetsRecv,
+		ICMPEchoRequests: its.icmpEchoRequests,
+		ICMPUnreachables: its.icmpUnreachables,
+		OtherPackets:     other.packetsSent + other.packetsRecv,
+		OtherBytes:       other.total(),
+		TLSServerNames:   slices.Clone(its.tlsServerNames),
+		HTTPHosts:        slices.Clone(its.httpHosts),
+		JA3:              slices.Clone(its.ja3),
+		JA4:              slices.Clone(its.ja4),
+		Processes:        its.processStats(),
 	}
 }
@@ -0,0 +1,417 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/pcapgo"
+	"github.com/graydovee/netbouncer/pkg/config"
+)
+
+const (
+	CaptureRunning   = "running"   // 正在抓包
+	CaptureCompleted = "completed" // 已完成，可以下载
+	CaptureFailed    = "failed"    // 抓包失败
+
+	captureSnaplen    = 65535             // 按需抓包保留完整的包
+	captureTimeLayout = "20060102-150405" // 抓包文件名中的时间格式
+)
+
+var (
+	// ErrCaptureNotFound 抓包任务不存在
+	ErrCaptureNotFound = errors.New("抓包任务不存在")
+	// ErrCaptureBusy 同时进行的抓包任务已达上限
+	ErrCaptureBusy = errors.New("同时进行的抓包任务已达上限")
+
+	// errCaptureTimeout 读取超时，没有新的包，用于定期检查是否应当结束
+	errCaptureTimeout = errors.New("capture read timeout")
+)
+
+// captureHandle 抓包句柄，pcap.Handle的子集，便于替换
+type captureHandle interface {
+	ReadPacketData() ([]byte, gopacket.CaptureInfo, error)
+	LinkType() layers.LinkType
+	Close()
+}
+
+// PacketCapture 按需抓包任务
+type PacketCapture struct {
+	ID          uint64    `json:"id"`
+	Target      string    `json:"target"`       // 抓包的IP或CIDR
+	Filter      string    `json:"filter"`       // 使用的BPF过滤器
+	Duration    int       `json:"duration"`     // 最长抓包时间（秒）
+	PacketLimit int       `json:"packet_limit"` // 最多抓取的包数
+	Packets     int       `json:"packets"`      // 已抓取的包数
+	FileSize    int64     `json:"file_size"`    // pcap文件大小（字节）
+	Truncated   bool      `json:"truncated"`    // 是否因达到文件大小上限而提前结束
+	Status      string    `json:"status"`
+	Error       string    `json:"error"`
+	StartedAt   time.Time `json:"started_at"`
+	FinishedAt  time.Time `json:"finished_at"`
+}
+
+// captureJob 抓包任务及其文件
+type captureJob struct {
+	PacketCapture
+	path string
+	stop chan struct{} // 关闭时提前结束抓包
+	done chan struct{} // 抓包结束后关闭
+}
+
+// Capturer 针对单个IP或网段的按需抓包，每个任务打开独立的抓包句柄并写入pcap文件
+type Capturer struct {
+	device        string
+	dir           string
+	maxConcurrent int
+	maxDuration   time.Duration
+	maxPackets    int
+	maxFileSize   int64
+	maxFiles      int
+	open          func(device, filter string, snaplen int) (captureHandle, error)
+
+	mutex   sync.Mutex
+	nextID  uint64
+	jobs    []*captureJob // 按创建顺序
+	opening int           // 已占用并发名额、正在打开句柄的任务数
+}
+
+// NewCapturer 创建按需抓包管理器，device为空时自动选择网络接口
+// 抓包目录在第一次开始抓包时创建，目录中已有的抓包文件作为已完成的任务重新列出
+func NewCapturer(cfg *config.CaptureConfig, device string) *Capturer {
+	dir := cfg.Dir
+	if dir == "" {
+		dir = "captures"
+	}
+
+	c := &Capturer{
+		device:        device,
+		dir:           dir,
+		maxConcurrent: max(cfg.MaxConcurrent, 1),
+		maxDuration:   time.Duration(cfg.MaxDuration) * time.Second,
+		maxPackets:    cfg.MaxPackets,
+		maxFileSize:   int64(cfg.MaxFileSize) << 20,
+		maxFiles:      max(cfg.MaxFiles, 1),
+		open:          openCaptureHandle,
+	}
+	if c.maxDuration <= 0 {
+		c.maxDuration = 5 * time.Minute
+	}
+	if c.maxPackets <= 0 {
+		c.maxPackets = 100000
+	}
+	if c.maxFileSize <= 0 {
+		c.maxFileSize = 100 << 20
+	}
+	c.loadExisting()
+	return c
+}
+
+// loadExisting 将抓包目录中上次运行留下的pcap文件按修改时间加入任务列表，超出保留数量的最早文件被删除
+func (c *Capturer) loadExisting() {
+	entries, err := os.ReadDir(c.dir)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			slog.Warn("读取抓包目录失败", "dir", c.dir, "error", err)
+		}
+		return
+	}
+
+	var jobs []*captureJob
+	for _, entry := range entries {
+		name := entry.Name()
+		if !entry.Type().IsRegular() || !strings.HasPrefix(name, "capture-") || !strings.HasSuffix(name, ".pcap") {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		done := make(chan struct{})
+		close(done)
+		jobs = append(jobs, &captureJob{
+			PacketCapture: PacketCapture{
+				Target:     captureTargetFromName(name),
+				FileSize:   info.Size(),
+				Status:     CaptureCompleted,
+				StartedAt:  info.ModTime(),
+				FinishedAt: info.ModTime(),
+			},
+			path: filepath.Join(c.dir, name),
+			stop: make(chan struct{}),
+			done: done,
+		})
+	}
+	slices.SortFunc(jobs, func(a, b *captureJob) int {
+		return a.FinishedAt.Compare(b.FinishedAt)
+	})
+	for _, job := range jobs {
+		c.nextID++
+		job.ID = c.nextID
+	}
+	c.jobs = jobs
+	c.removeOldJobs()
+	if len(c.jobs) > 0 {
+		slog.Info("已加载抓包目录中的文件", "dir", c.dir, "files", len(c.jobs))
+	}
+}
+
+// captureFileName 抓包文件名，包含开始时间，避免重启后与旧文件重名
+func captureFileName(id uint64, target string, startedAt time.Time) string {
+	return fmt.Sprintf("capture-%s-%d-%s.pcap", startedAt.Format(captureTimeLayout), id,
+		strings.NewReplacer("/", "_", ":", "_").Replace(target))
+}
+
+// captureTargetFromName 从抓包文件名中取出目标，文件名中的"/"和":"已被替换为"_"
+func captureTargetFromName(name string) string {
+	name = strings.TrimSuffix(strings.TrimPrefix(name, "capture-"), ".pcap")
+	// capture-<日期>-<时间>-<ID>-<目标>.pcap
+	if parts := strings.SplitN(name, "-", 4); len(parts) == 4 {
+		return parts[3]
+	}
+	return name
+}
+
+// captureFilter 根据IP或CIDR生成BPF过滤器
+func captureFilter(target string) (string, error) {
+	if ip := net.ParseIP(target); ip != nil {
+		return "host " + ip.String(), nil
+	}
+	if _, ipNet, err := net.ParseCIDR(target); err == nil {
+		return "net " + ipNet.String(), nil
+	}
+	return "", fmt.Errorf("无效的IP地址或CIDR格式: %s", target)
+}
+
+// Start 开始抓包，duration和packetLimit超过上限或不大于0时使用上限
+func (c *Capturer) Start(target string, duration time.Duration, packetLimit int) (PacketCapture, error) {
+	filter, err := captureFilter(target)
+	if err != nil {
+		return PacketCapture{}, err
+	}
+	if duration <= 0 || duration > c.maxDuration {
+		duration = c.maxDuration
+	}
+	if packetLimit <= 0 || packetLimit > c.maxPackets {
+		packetLimit = c.maxPackets
+	}
+
+	c.mutex.Lock()
+	running := c.opening
+	for _, job := range c.jobs {
+		if job.Status == CaptureRunning {
+			running++
+		}
+	}
+	if running >= c.maxConcurrent {
+		c.mutex.Unlock()
+		return PacketCapture{}, ErrCaptureBusy
+	}
+	c.opening++
+	c.mutex.Unlock()
+
+	// 打开句柄可能较慢，不持有锁，避免阻塞查询和停止其他任务
+	handle, err := c.openHandle(filter)
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.opening--
+	if err != nil {
+		return PacketCapture{}, err
+	}
+
+	c.nextID++
+	now := time.Now()
+	job := &captureJob{
+		PacketCapture: PacketCapture{
+			ID:          c.nextID,
+			Target:      target,
+			Filter:      filter,
+			Duration:    int(duration / time.Second),
+			PacketLimit: packetLimit,
+			Status:      CaptureRunning,
+			StartedAt:   now,
+		},
+		path: filepath.Join(c.dir, captureFileName(c.nextID, target, now)),
+		stop: make(chan struct{}),
+		done: make(chan struct{}),
+	}
+	c.jobs = append(c.jobs, job)
+	c.removeOldJobs()
+
+	go c.run(job, handle, duration)
+
+	slog.Info("开始抓包", "id", job.ID, "filter", filter, "duration", duration, "packet_limit", packetLimit)
+	return job.PacketCapture, nil
+}
+
+// openHandle 创建抓包目录并打开抓包句柄
+func (c *Capturer) openHandle(filter string) (captureHandle, error) {
+	if err := os.MkdirAll(c.dir, 0o750); err != nil {
+		return nil, fmt.Errorf("创建抓包目录失败: %w", err)
+	}
+	handle, err := c.open(c.device, filter, captureSnaplen)
+	if err != nil {
+		return nil, fmt.Errorf("打开抓包句柄失败: %w", err)
+	}
+	return handle, nil
+}
+
+// run 读取包并写入pcap文件，直到超时、达到包数或文件大小上限、或被停止
+func (c *Capturer) run(job *captureJob, handle captureHandle, duration time.Duration) {
+	defer close(job.done)
+	defer handle.Close()
+
+	packets, size, truncated, err := c.write(job, handle, time.Now().Add(duration))
+
+	c.mutex.Lock()
+	job.Packets, job.FileSize, job.Truncated = packets, size, truncated
+	job.FinishedAt = time.Now()
+	if err != nil {
+		job.Status = CaptureFailed
+		job.Error = err.Error()
+		os.Remove(job.path)
+	} else {
+		job.Status = CaptureCompleted
+	}
+	c.mutex.Unlock()
+
+	if err != nil {
+		slog.Error("抓包失败", "id", job.ID, "error", err)
+		return
+	}
+	slog.Info("抓包完成", "id", job.ID, "packets", packets, "size", size, "truncated", truncated)
+}
+
+// write 将包写入任务的pcap文件，返回包数、文件大小和是否因大小上限提前结束
+func (c *Capturer) write(job *captureJob, handle captureHandle, deadline time.Time) (int, int64, bool, error) {
+	// 不覆盖已有的文件
+	file, err := os.OpenFile(job.path, os.O_CREATE|os.O_WRONLY|os.O_EXCL, 0o640)
+	if err != nil {
+		return 0, 0, false, fmt.Errorf("创建抓包文件失败: %w", err)
+	}
+	defer file.Close()
+
+	writer := pcapgo.NewWriter(file)
+	if err := writer.WriteFileHeader(captureSnaplen, handle.LinkType()); err != nil {
+		return 0, 0, false, fmt.Errorf("写入抓包文件失败: %w", err)
+	}
+
+	// pcap文件头24字节，每个包另有16字节的记录头
+	size := int64(24)
+	packets := 0
+	for packets < job.PacketLimit && time.Now().Before(deadline) {
+		select {
+		case <-job.stop:
+			return packets, size, false, nil
+		default:
+		}
+
+		data, ci, err := handle.ReadPacketData()
+		if errors.Is(err, errCaptureTimeout) {
+			continue
+		}
+		if err != nil {
+			return packets, size, false, fmt.Errorf("读取数据包失败: %w", err)
+		}
+		if size+16+int64(len(data)) > c.maxFileSize {
+			return packets, size, true, nil
+		}
+		if err := writer.WritePacket(ci, data); err != nil {
+			return packets, size, false, fmt.Errorf("写入抓包文件失败: %w", err)
+		}
+		size += 16 + int64(len(data))
+		packets++
+
+		if packets%100 == 0 {
+			c.mutex.Lock()
+			job.Packets, job.FileSize = packets, size
+			c.mutex.Unlock()
+		}
+	}
+	return packets, size, false, nil
+}
+
+// removeOldJobs 超出保留数量时删除最早的已结束任务及其文件，调用方需持有锁
+func (c *Capturer) removeOldJobs() {
+	for len(c.jobs) > c.maxFiles {
+		index := -1
+		for i, job := range c.jobs {
+			if job.Status != CaptureRunning {
+				index = i
+				break
+			}
+		}
+		if index < 0 {
+			return
+		}
+		os.Remove(c.jobs[index].path)
+		c.jobs = append(c.jobs[:index], c.jobs[index+1:]...)
+	}
+}
+
+// List 返回全部抓包任务，最新的在前
+func (c *Capturer) List() []PacketCapture {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	result := make([]PacketCapture, 0, len(c.jobs))
+	for i := len(c.jobs) - 1; i >= 0; i-- {
+		result = append(result, c.jobs[i].PacketCapture)
+	}
+	return result
+}
+
+// find 查找抓包任务，调用方需持有锁
+func (c *Capturer) find(id uint64) (int, *captureJob) {
+	for i, job := range c.jobs {
+		if job.ID == id {
+			return i, job
+		}
+	}
+	return -1, nil
+}
+
+// File 返回已完成的抓包任务的文件路径
+func (c *Capturer) File(id uint64) (string, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	_, job := c.find(id)
+	if job == nil {
+		return "", ErrCaptureNotFound
+	}
+	if job.Status != CaptureCompleted {
+		return "", fmt.Errorf("抓包任务未完成: %s", job.Status)
+	}
+	return job.path, nil
+}
+
+// Delete 删除抓包任务及其文件，正在进行的任务先停止
+func (c *Capturer) Delete(id uint64) error {
+	c.mutex.Lock()
+	index, job := c.find(id)
+	if job == nil {
+		c.mutex.Unlock()
+		return ErrCaptureNotFound
+	}
+	c.jobs = append(c.jobs[:index], c.jobs[index+1:]...)
+	close(job.stop)
+	c.mutex.Unlock()
+
+	// 写入协程在下一次读取超时或读到包时退出，之后再删除文件
+	go func() {
+		<-job.done
+		os.Remove(job.path)
+	}()
+	return nil
+}
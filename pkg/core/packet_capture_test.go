@@ -0,0 +1,256 @@
+package core
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/pcapgo"
+	"github.com/graydovee/netbouncer/pkg/config"
+)
+
+// fakeCaptureHandle 每次读取返回一个固定长度的包，block为true时一直返回读取超时
+type fakeCaptureHandle struct {
+	size  int
+	block bool
+}
+
+func (h *fakeCaptureHandle) ReadPacketData() ([]byte, gopacket.CaptureInfo, error) {
+	if h.block {
+		time.Sleep(time.Millisecond)
+		return nil, gopacket.CaptureInfo{}, errCaptureTimeout
+	}
+	ci := gopacket.CaptureInfo{Timestamp: time.Now(), CaptureLength: h.size, Length: h.size}
+	return make([]byte, h.size), ci, nil
+}
+
+func (h *fakeCaptureHandle) LinkType() layers.LinkType { return layers.LinkTypeEthernet }
+
+func (h *fakeCaptureHandle) Close() {}
+
+func newTestCapturer(t *testing.T, cfg config.CaptureConfig, handle *fakeCaptureHandle) *Capturer {
+	t.Helper()
+	cfg.Dir = t.TempDir()
+	c := NewCapturer(&cfg, "eth0")
+	c.open = func(device, filter string, snaplen int) (captureHandle, error) {
+		return handle, nil
+	}
+	return c
+}
+
+// waitCapture 等待抓包任务结束
+func waitCapture(t *testing.T, c *Capturer, id uint64) PacketCapture {
+	t.Helper()
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(5 * time.Millisecond) {
+		for _, capture := range c.List() {
+			if capture.ID == id && capture.Status != CaptureRunning {
+				return capture
+			}
+		}
+	}
+	t.Fatalf("capture %d did not finish", id)
+	return PacketCapture{}
+}
+
+func Test_Capturer_limits(t *testing.T) {
+	tests := []struct {
+		name          string
+		packetLimit   int
+		maxFileSizeMB int
+		packetSize    int
+		wantPackets   int
+		wantTruncated bool
+	}{
+		{name: "packet_limit", packetLimit: 10, maxFileSizeMB: 1, packetSize: 100, wantPackets: 10},
+		// 1MB上限，每个包1000字节加16字节记录头
+		{name: "file_size", packetLimit: 5000, maxFileSizeMB: 1, packetSize: 1000, wantPackets: (1<<20 - 24) / 1016, wantTruncated: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newTestCapturer(t, config.CaptureConfig{MaxFileSize: tt.maxFileSizeMB, MaxFiles: 5}, &fakeCaptureHandle{size: tt.packetSize})
+			started, err := c.Start("192.0.2.1", time.Minute, tt.packetLimit)
+			if err != nil {
+				t.Fatalf("Start() error = %v", err)
+			}
+			if started.Filter != "host 192.0.2.1" {
+				t.Errorf("Filter = %q", started.Filter)
+			}
+
+			capture := waitCapture(t, c, started.ID)
+			if capture.Status != CaptureCompleted || capture.Packets != tt.wantPackets || capture.Truncated != tt.wantTruncated {
+				t.Fatalf("capture = %+v, want %d packets truncated %v", capture, tt.wantPackets, tt.wantTruncated)
+			}
+
+			path, err := c.File(started.ID)
+			if err != nil {
+				t.Fatalf("File() error = %v", err)
+			}
+			file, err := os.Open(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer file.Close()
+			if info, _ := file.Stat(); info.Size() != capture.FileSize {
+				t.Errorf("file size = %d, want %d", info.Size(), capture.FileSize)
+			}
+			reader, err := pcapgo.NewReader(file)
+			if err != nil {
+				t.Fatalf("pcapgo.NewReader() error = %v", err)
+			}
+			packets := 0
+			for {
+				if _, _, err := reader.ReadPacketData(); err != nil {
+					break
+				}
+				packets++
+			}
+			if packets != tt.wantPackets {
+				t.Errorf("pcap file has %d packets, want %d", packets, tt.wantPackets)
+			}
+		})
+	}
+}
+
+func Test_Capturer_concurrency(t *testing.T) {
+	c := newTestCapturer(t, config.CaptureConfig{MaxConcurrent: 1, MaxFiles: 5}, &fakeCaptureHandle{block: true})
+
+	first, err := c.Start("2001:db8::/64", time.Minute, 0)
+	if err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+	if _, err := c.Start("192.0.2.1", time.Minute, 0); !errors.Is(err, ErrCaptureBusy) {
+		t.Errorf("second Start() error = %v, want ErrCaptureBusy", err)
+	}
+	if _, err := c.File(first.ID); err == nil {
+		t.Error("File() of running capture should fail")
+	}
+
+	if err := c.Delete(first.ID); err != nil {
+		t.Fatalf("Delete() error = %v", err)
+	}
+	if _, err := c.File(first.ID); !errors.Is(err, ErrCaptureNotFound) {
+		t.Errorf("File() after Delete() error = %v, want ErrCaptureNotFound", err)
+	}
+	if _, err := c.Start("not-an-ip", time.Minute, 0); err == nil {
+		t.Error("Start() with invalid target should fail")
+	}
+}
+
+func Test_Capturer_openWithoutLock(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "captures")
+	c := NewCapturer(&config.CaptureConfig{Dir: dir, MaxConcurrent: 1, MaxFiles: 5}, "eth0")
+	if _, err := os.Stat(dir); !os.IsNotExist(err) {
+		t.Fatalf("capture dir created before first capture, Stat() error = %v", err)
+	}
+
+	opening := make(chan struct{})
+	release := make(chan struct{})
+	c.open = func(device, filter string, snaplen int) (captureHandle, error) {
+		close(opening)
+		<-release
+		return &fakeCaptureHandle{block: true}, nil
+	}
+
+	started := make(chan error, 1)
+	go func() {
+		_, err := c.Start("192.0.2.1", time.Minute, 0)
+		started <- err
+	}()
+	<-opening
+
+	// 打开句柄期间可以查询，并发名额已被占用
+	if got := c.List(); len(got) != 0 {
+		t.Errorf("List() while opening = %+v, want empty", got)
+	}
+	if _, err := c.Start("192.0.2.2", time.Minute, 0); !errors.Is(err, ErrCaptureBusy) {
+		t.Errorf("Start() while opening error = %v, want ErrCaptureBusy", err)
+	}
+
+	close(release)
+	if err := <-started; err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+	if _, err := os.Stat(dir); err != nil {
+		t.Errorf("capture dir not created: %v", err)
+	}
+	for _, capture := range c.List() {
+		if err := c.Delete(capture.ID); err != nil {
+			t.Errorf("Delete() error = %v", err)
+		}
+	}
+}
+
+func Test_Capturer_loadExisting(t *testing.T) {
+	dir := t.TempDir()
+	base := time.Now().Add(-time.Hour)
+	names := []string{
+		"capture-20260101-100000-1-10.0.0.1.pcap",
+		"capture-20260101-100100-2-10.0.0.0_24.pcap",
+		"capture-20260101-100200-3-2001_db8__1.pcap",
+	}
+	for i, name := range names {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, make([]byte, 24+i), 0o640); err != nil {
+			t.Fatal(err)
+		}
+		modTime := base.Add(time.Duration(i) * time.Minute)
+		if err := os.Chtimes(path, modTime, modTime); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.WriteFile(filepath.Join(dir, "notes.txt"), nil, 0o640); err != nil {
+		t.Fatal(err)
+	}
+
+	c := NewCapturer(&config.CaptureConfig{Dir: dir, MaxFiles: 2}, "eth0")
+	c.open = func(device, filter string, snaplen int) (captureHandle, error) {
+		return &fakeCaptureHandle{size: 60}, nil
+	}
+
+	// 超出保留数量的最早文件被删除
+	if _, err := os.Stat(filepath.Join(dir, names[0])); !os.IsNotExist(err) {
+		t.Errorf("oldest file should be removed, stat error = %v", err)
+	}
+	list := c.List()
+	if len(list) != 2 {
+		t.Fatalf("List() = %d jobs, want 2", len(list))
+	}
+	if list[0].ID != 3 || list[0].Target != "2001_db8__1" || list[0].FileSize != 26 || list[0].Status != CaptureCompleted {
+		t.Errorf("List()[0] = %+v", list[0])
+	}
+	if list[1].ID != 2 || list[1].Target != "10.0.0.0_24" {
+		t.Errorf("List()[1] = %+v", list[1])
+	}
+	path, err := c.File(2)
+	if err != nil || path != filepath.Join(dir, names[1]) {
+		t.Errorf("File(2) = %q, %v", path, err)
+	}
+
+	// 新任务的ID接着已有文件编号，文件名带开始时间
+	capture, err := c.Start("10.0.0.1", 0, 5)
+	if err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+	if capture.ID != 4 {
+		t.Errorf("Start() id = %d, want 4", capture.ID)
+	}
+	capture = waitCapture(t, c, capture.ID)
+	if capture.Status != CaptureCompleted {
+		t.Fatalf("capture = %+v", capture)
+	}
+	path, err = c.File(capture.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := captureFileName(capture.ID, "10.0.0.1", capture.StartedAt)
+	if filepath.Base(path) != want {
+		t.Errorf("file name = %q, want %q", filepath.Base(path), want)
+	}
+	if _, err := os.Stat(filepath.Join(dir, names[1])); !os.IsNotExist(err) {
+		t.Errorf("file beyond max_files should be removed, stat error = %v", err)
+	}
+}
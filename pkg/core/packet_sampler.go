@@ -0,0 +1,33 @@
+package core
+
+import "github.com/google/gopacket"
+
+// packetSampler 在解码之前按1/N抽样，未被抽中的包不复制也不解码
+type packetSampler struct {
+	source gopacket.ZeroCopyPacketDataSource
+	rate   uint64
+	count  uint64
+}
+
+// newPacketSampler 创建抽样数据源，rate为1时保留全部包
+func newPacketSampler(source gopacket.ZeroCopyPacketDataSource, rate int) *packetSampler {
+	return &packetSampler{
+		source: source,
+		rate:   uint64(max(rate, 1)),
+	}
+}
+
+// ReadPacketData 返回下一个被抽中的包，零拷贝读取的数据在下次读取前复制出来
+func (s *packetSampler) ReadPacketData() ([]byte, gopacket.CaptureInfo, error) {
+	for {
+		data, ci, err := s.source.ZeroCopyReadPacketData()
+		if err != nil {
+			return nil, ci, err
+		}
+		s.count++
+		if s.count%s.rate != 0 {
+			continue
+		}
+		return append([]byte(nil), data...), ci, nil
+	}
+}
@@ -0,0 +1,63 @@
+package core
+
+import (
+	"io"
+	"testing"
+
+	"github.com/google/gopacket"
+)
+
+// fakeZeroCopySource 依次返回编号为1..n的包，复用同一个缓冲区
+type fakeZeroCopySource struct {
+	n      int
+	next   int
+	buffer [1]byte
+}
+
+func (f *fakeZeroCopySource) ZeroCopyReadPacketData() ([]byte, gopacket.CaptureInfo, error) {
+	if f.next >= f.n {
+		return nil, gopacket.CaptureInfo{}, io.EOF
+	}
+	f.next++
+	f.buffer[0] = byte(f.next)
+	return f.buffer[:], gopacket.CaptureInfo{Length: f.next}, nil
+}
+
+func Test_packetSampler(t *testing.T) {
+	tests := []struct {
+		name string
+		rate int
+		n    int
+		want []byte
+	}{
+		{name: "no_sampling", rate: 1, n: 3, want: []byte{1, 2, 3}},
+		{name: "invalid_rate", rate: 0, n: 2, want: []byte{1, 2}},
+		{name: "one_in_three", rate: 3, n: 10, want: []byte{3, 6, 9}},
+		{name: "fewer_than_rate", rate: 5, n: 4, want: nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sampler := newPacketSampler(&fakeZeroCopySource{n: tt.n}, tt.rate)
+			var kept [][]byte
+			for {
+				data, _, err := sampler.ReadPacketData()
+				if err == io.EOF {
+					break
+				}
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				kept = append(kept, data)
+			}
+
+			// 返回的数据必须是副本，不受后续读取覆盖缓冲区的影响
+			var got []byte
+			for _, data := range kept {
+				got = append(got, data[0])
+			}
+			if string(got) != string(tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,261 @@
+package core
+
+import (
+	"bytes"
+	"encoding/binary"
+	"strings"
+	"time"
+)
+
+const (
+	recentValuesPerIP = 8     // 每个远程IP保留的最近SNI/Host数量
+	inspectSnaplen    = 65535 // 解析包内容时的最小捕获长度
+)
+
+// httpMethods 明文HTTP请求行的方法前缀
+var httpMethods = [][]byte{
+	[]byte("GET "), []byte("POST "), []byte("HEAD "), []byte("PUT "),
+	[]byte("DELETE "), []byte("OPTIONS "), []byte("PATCH "), []byte("CONNECT "),
+}
+
+// pushRecent 将值放到列表头部，去重并限制长度
+func pushRecent(values []string, value string, limit int) []string {
+	result := make([]string, 0, min(len(values)+1, limit))
+	result = append(result, value)
+	for _, existing := range values {
+		if existing != value && len(result) < limit {
+			result = append(result, existing)
+		}
+	}
+	return result
+}
+
+// inspectPayload 从远程IP发出的TCP载荷中提取SNI、TLS指纹或HTTP Host，调用方需持有分片写锁
+func (m *Monitor) inspectPayload(stats *internalTrafficStats, payload []byte, now time.Time) {
+	if hello, ok := parseClientHello(payload); ok {
+		if hello.serverName != "" {
+			stats.tlsServerNames = pushRecent(stats.tlsServerNames, hello.serverName, recentValuesPerIP)
+		}
+		// 扩展不完整时算出的指纹是错误的，会被计入全局统计并可能命中规则
+		if !hello.truncated {
+			m.recordFingerprint(stats, hello, now)
+		}
+	} else if host, ok := parseHTTPHost(payload); ok {
+		stats.httpHosts = pushRecent(stats.httpHosts, host, recentValuesPerIP)
+	}
+}
+
+// parseHTTPHost 从明文HTTP请求中提取Host头，请求头被截断时只解析已有的部分
+func parseHTTPHost(payload []byte) (string, bool) {
+	isRequest := false
+	for _, method := range httpMethods {
+		if bytes.HasPrefix(payload, method) {
+			isRequest = true
+			break
+		}
+	}
+	if !isRequest {
+		return "", false
+	}
+
+	// 跳过请求行，逐行查找Host头，遇到空行说明请求头结束
+	lines := bytes.Split(payload, []byte("\r\n"))
+	for _, line := range lines[1:] {
+		if len(line) == 0 {
+			break
+		}
+		name, value, found := bytes.Cut(line, []byte(":"))
+		if !found || !strings.EqualFold(string(name), "host") {
+			continue
+		}
+		host := strings.ToLower(strings.TrimSpace(string(value)))
+		if host == "" {
+			return "", false
+		}
+		return host, true
+	}
+	return "", false
+}
+
+// TLS扩展类型
+const (
+	tlsExtServerName          = 0x0000
+	tlsExtSupportedGroups     = 0x000a
+	tlsExtECPointFormats      = 0x000b
+	tlsExtSignatureAlgorithms = 0x000d
+	tlsExtALPN                = 0x0010
+	tlsExtSupportedVersions   = 0x002b
+)
+
+// clientHello ClientHello中用到的字段，列表均保持报文中的原始顺序
+type clientHello struct {
+	version             uint16 // ClientHello中的版本字段
+	cipherSuites        []uint16
+	extensions          []uint16
+	supportedGroups     []uint16
+	pointFormats        []uint8
+	signatureAlgorithms []uint16
+	supportedVersions   []uint16
+	alpn                string // 第一个ALPN协议
+	hasServerName       bool
+	serverName          string
+	truncated           bool // 扩展超出了捕获的载荷，只解析了前面的部分
+}
+
+// parseClientHello 解析TLS记录中的ClientHello
+// 只解析单个TCP段中的数据，位于后续分段的扩展会被忽略并标记为truncated
+func parseClientHello(payload []byte) (*clientHello, bool) {
+	// TLS记录头：类型(1) 版本(2) 长度(2)，握手类型(1) 长度(3)
+	if len(payload) < 9 || payload[0] != 0x16 || payload[1] != 0x03 || payload[5] != 0x01 {
+		return nil, false
+	}
+	r := tlsReader(payload[9:])
+	hello := &clientHello{}
+
+	// 客户端版本(2) 随机数(32)
+	if len(r) < 2 {
+		return nil, false
+	}
+	hello.version = binary.BigEndian.Uint16(r)
+	if !r.skip(34) {
+		return nil, false
+	}
+	// 会话ID、密码套件、压缩方法
+	if _, ok := r.vector(1); !ok {
+		return nil, false
+	}
+	ciphers, ok := r.vector(2)
+	if !ok {
+		return nil, false
+	}
+	hello.cipherSuites = readUint16s(ciphers)
+	if _, ok := r.vector(1); !ok {
+		return nil, false
+	}
+
+	extensions, ok := r.vector(2)
+	if !ok {
+		// 没有扩展或扩展被截断，尽量解析已有的部分用于提取SNI
+		hello.truncated = len(r) > 0
+		extensions = r.rest()
+	}
+	for len(extensions) >= 4 {
+		extType := binary.BigEndian.Uint16(extensions)
+		extLen := int(binary.BigEndian.Uint16(extensions[2:]))
+		extensions = extensions[4:]
+		if extLen > len(extensions) {
+			hello.truncated = true
+			break
+		}
+		data := tlsReader(extensions[:extLen])
+		extensions = extensions[extLen:]
+		hello.extensions = append(hello.extensions, extType)
+
+		switch extType {
+		case tlsExtServerName:
+			hello.hasServerName = true
+			hello.serverName = parseServerNameExtension(data)
+		case tlsExtSupportedGroups:
+			if list, ok := data.vector(2); ok {
+				hello.supportedGroups = readUint16s(list)
+			}
+		case tlsExtECPointFormats:
+			if list, ok := data.vector(1); ok {
+				hello.pointFormats = list
+			}
+		case tlsExtSignatureAlgorithms:
+			if list, ok := data.vector(2); ok {
+				hello.signatureAlgorithms = readUint16s(list)
+			}
+		case tlsExtALPN:
+			if list, ok := data.vector(2); ok {
+				list := tlsReader(list)
+				if protocol, ok := list.vector(1); ok {
+					hello.alpn = string(protocol)
+				}
+			}
+		case tlsExtSupportedVersions:
+			if list, ok := data.vector(1); ok {
+				hello.supportedVersions = readUint16s(list)
+			}
+		}
+	}
+	return hello, true
+}
+
+// readUint16s 将字节序列按大端解析为uint16列表
+func readUint16s(data []byte) []uint16 {
+	values := make([]uint16, 0, len(data)/2)
+	for i := 0; i+1 < len(data); i += 2 {
+		values = append(values, binary.BigEndian.Uint16(data[i:]))
+	}
+	return values
+}
+
+// parseServerNameExtension 解析server_name扩展，返回第一个host_name
+func parseServerNameExtension(data []byte) string {
+	r := tlsReader(data)
+	list, ok := r.vector(2)
+	if !ok {
+		return ""
+	}
+	r = tlsReader(list)
+	for len(r) >= 3 {
+		nameType, _ := r.uint8()
+		name, ok := r.vector(2)
+		if !ok {
+			return ""
+		}
+		if nameType == 0 {
+			return strings.ToLower(strings.TrimSuffix(string(name), "."))
+		}
+	}
+	return ""
+}
+
+// tlsReader 按TLS编码规则顺序读取字段
+type tlsReader []byte
+
+// skip 跳过n个字节
+func (r *tlsReader) skip(n int) bool {
+	if len(*r) < n {
+		return false
+	}
+	*r = (*r)[n:]
+	return true
+}
+
+// uint8 读取1个字节
+func (r *tlsReader) uint8() (uint8, bool) {
+	if len(*r) < 1 {
+		return 0, false
+	}
+	value := (*r)[0]
+	*r = (*r)[1:]
+	return value, true
+}
+
+// vector 读取长度前缀为lengthSize字节的变长字段
+func (r *tlsReader) vector(lengthSize int) ([]byte, bool) {
+	if len(*r) < lengthSize {
+		return nil, false
+	}
+	length := 0
+	for _, b := range (*r)[:lengthSize] {
+		length = length<<8 | int(b)
+	}
+	if len(*r) < lengthSize+length {
+		return nil, false
+	}
+	value := (*r)[lengthSize : lengthSize+length]
+	*r = (*r)[lengthSize+length:]
+	return value, true
+}
+
+// rest 返回剩余的全部数据，跳过长度前缀
+func (r *tlsReader) rest() []byte {
+	if len(*r) < 2 {
+		return nil
+	}
+	return (*r)[2:]
+}
@@ -0,0 +1,82 @@
+package core
+
+import (
+	"crypto/tls"
+	"io"
+	"net"
+	"testing"
+)
+
+// captureClientHello 用crypto/tls生成一个真实的ClientHello记录
+func captureClientHello(t *testing.T, serverName string) []byte {
+	t.Helper()
+	client, server := net.Pipe()
+	defer server.Close()
+
+	go func() {
+		conn := tls.Client(client, &tls.Config{ServerName: serverName, InsecureSkipVerify: true})
+		conn.Handshake()
+		client.Close()
+	}()
+
+	header := make([]byte, 5)
+	if _, err := io.ReadFull(server, header); err != nil {
+		t.Fatalf("read record header: %v", err)
+	}
+	body := make([]byte, int(header[3])<<8|int(header[4]))
+	if _, err := io.ReadFull(server, body); err != nil {
+		t.Fatalf("read record body: %v", err)
+	}
+	return append(header, body...)
+}
+
+func Test_parseClientHello_serverName(t *testing.T) {
+	hello := captureClientHello(t, "API.Example.com")
+
+	tests := []struct {
+		name    string
+		payload []byte
+		want    string
+		wantOk  bool
+	}{
+		{name: "client_hello", payload: hello, want: "api.example.com", wantOk: true},
+		{name: "truncated_header", payload: hello[:20]},
+		{name: "http", payload: []byte("GET / HTTP/1.1\r\nHost: example.com\r\n\r\n")},
+		{name: "empty"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hello, ok := parseClientHello(tt.payload)
+			var got string
+			if ok {
+				got = hello.serverName
+			}
+			if got != tt.want || ok != tt.wantOk {
+				t.Errorf("parseClientHello() serverName = %q, %v, want %q, %v", got, ok, tt.want, tt.wantOk)
+			}
+		})
+	}
+}
+
+func Test_parseHTTPHost(t *testing.T) {
+	tests := []struct {
+		name    string
+		payload string
+		want    string
+		wantOk  bool
+	}{
+		{name: "get", payload: "GET /index.html HTTP/1.1\r\nUser-Agent: curl\r\nHost: Example.com:8080\r\n\r\n", want: "example.com:8080", wantOk: true},
+		{name: "lowercase_header", payload: "POST /api HTTP/1.1\r\nhost: api.example.com\r\nContent-Length: 0\r\n\r\n", want: "api.example.com", wantOk: true},
+		{name: "truncated_before_host", payload: "GET / HTTP/1.1\r\nUser-Agent: cu"},
+		{name: "host_in_body", payload: "GET / HTTP/1.1\r\n\r\nHost: example.com\r\n"},
+		{name: "response", payload: "HTTP/1.1 200 OK\r\nHost: example.com\r\n\r\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := parseHTTPHost([]byte(tt.payload))
+			if got != tt.want || ok != tt.wantOk {
+				t.Errorf("parseHTTPHost() = %q, %v, want %q, %v", got, ok, tt.want, tt.wantOk)
+			}
+		})
+	}
+}
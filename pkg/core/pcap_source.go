@@ -0,0 +1,162 @@
+//go:build !nopcap
+
+package core
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/pcap"
+)
+
+// PcapSource 基于libpcap抓包的流量数据源
+type PcapSource struct {
+	device   string
+	snaplen  int
+	handle   *pcap.Handle
+	stopChan chan struct{}
+}
+
+// newPcapSource 创建pcap数据源，未指定网络接口时自动选择
+func newPcapSource(device string, snaplen int) (*PcapSource, error) {
+	if device == "" {
+		var err error
+		if device, err = defaultDevice(); err != nil {
+			return nil, err
+		}
+	}
+
+	return &PcapSource{
+		device:   device,
+		snaplen:  snaplen,
+		stopChan: make(chan struct{}),
+	}, nil
+}
+
+// defaultDevice 自动选择默认网络接口
+func defaultDevice() (string, error) {
+	devices, err := pcap.FindAllDevs()
+	if err != nil {
+		return "", fmt.Errorf("failed to find devices: %v", err)
+	}
+
+	for _, dev := range devices {
+		if len(dev.Addresses) > 0 && dev.Name != "lo" {
+			return dev.Name, nil
+		}
+	}
+	return "", fmt.Errorf("no suitable network device found")
+}
+
+func (p *PcapSource) Name() string {
+	return "pcap"
+}
+
+func (p *PcapSource) Start(m *Monitor) error {
+	// 打开网络接口进行捕获
+	handle, err := pcap.OpenLive(p.device, int32(p.snaplen), true, pcap.BlockForever)
+	if err != nil {
+		return fmt.Errorf("failed to open device %s: %v", p.device, err)
+	}
+
+	// 设置过滤器，捕获全部IPv4/IPv6包，TCP/UDP以外的协议分别计入ICMP和其他协议
+	err = handle.SetBPFFilter("ip or ip6")
+	if err != nil {
+		handle.Close()
+		return fmt.Errorf("failed to set BPF filter: %v", err)
+	}
+	p.handle = handle
+
+	// 启动包捕获协程
+	go p.capturePackets(m)
+
+	slog.Info("pcap数据源已启动", "device", p.device, "sample_rate", m.sampleRate)
+	return nil
+}
+
+func (p *PcapSource) Stop() {
+	close(p.stopChan)
+	if p.handle != nil {
+		p.handle.Close()
+	}
+}
+
+func (p *PcapSource) DebugInfo() map[string]interface{} {
+	return map[string]interface{}{
+		"device":  p.device,
+		"snaplen": p.snaplen,
+	}
+}
+
+func (p *PcapSource) CaptureStats() (CaptureStats, bool) {
+	if p.handle == nil {
+		return CaptureStats{}, false
+	}
+	stats, err := p.handle.Stats()
+	if err != nil {
+		slog.Debug("读取pcap统计失败", "error", err)
+		return CaptureStats{}, false
+	}
+	return CaptureStats{
+		PacketsReceived:  uint64(stats.PacketsReceived),
+		PacketsDropped:   uint64(stats.PacketsDropped),
+		PacketsIfDropped: uint64(stats.PacketsIfDropped),
+	}, true
+}
+
+// capturePackets 捕获网络包
+func (p *PcapSource) capturePackets(m *Monitor) {
+	// 抽样在解码之前进行，未被抽中的包不产生解码开销
+	packetSource := gopacket.NewPacketSource(newPacketSampler(p.handle, m.sampleRate), p.handle.LinkType())
+
+	for {
+		select {
+		case <-p.stopChan:
+			return
+		case packet := <-packetSource.Packets():
+			if packet == nil {
+				continue
+			}
+			m.processPacket(packet)
+			// 从内核捕获到处理完成的延迟，持续增大说明处理跟不上
+			m.health.recordLatency(time.Since(packet.Metadata().Timestamp))
+		}
+	}
+}
+
+// pcapCaptureHandle 按需抓包使用的pcap句柄，读取超时转换为errCaptureTimeout
+type pcapCaptureHandle struct {
+	*pcap.Handle
+}
+
+func (h pcapCaptureHandle) ReadPacketData() ([]byte, gopacket.CaptureInfo, error) {
+	data, ci, err := h.Handle.ReadPacketData()
+	if errors.Is(err, pcap.NextErrorTimeoutExpired) {
+		return nil, ci, errCaptureTimeout
+	}
+	return data, ci, err
+}
+
+// openCaptureHandle 打开带BPF过滤器的独立抓包句柄，与监控使用的句柄互不影响
+func openCaptureHandle(device, filter string, snaplen int) (captureHandle, error) {
+	if device == "" {
+		var err error
+		if device, err = defaultDevice(); err != nil {
+			return nil, err
+		}
+	}
+
+	// 读取超时用于定期检查抓包是否应当结束
+	handle, err := pcap.OpenLive(device, int32(snaplen), false, time.Second)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open device %s: %v", device, err)
+	}
+	if err := handle.SetBPFFilter(filter); err != nil {
+		handle.Close()
+		return nil, fmt.Errorf("failed to set BPF filter: %v", err)
+	}
+	return pcapCaptureHandle{handle}, nil
+}
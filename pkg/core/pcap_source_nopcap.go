@@ -0,0 +1,34 @@
+//go:build nopcap
+
+package core
+
+import "fmt"
+
+// PcapSource 未启用pcap支持时的占位实现，使用 -tags nopcap 编译时不依赖libpcap
+type PcapSource struct{}
+
+func newPcapSource(device string, snaplen int) (*PcapSource, error) {
+	return nil, fmt.Errorf("当前版本编译时未启用pcap支持，请使用conntrack数据源")
+}
+
+func (p *PcapSource) Name() string {
+	return "pcap"
+}
+
+func (p *PcapSource) Start(m *Monitor) error {
+	return fmt.Errorf("当前版本编译时未启用pcap支持")
+}
+
+func (p *PcapSource) Stop() {}
+
+func (p *PcapSource) DebugInfo() map[string]interface{} {
+	return map[string]interface{}{}
+}
+
+func (p *PcapSource) CaptureStats() (CaptureStats, bool) {
+	return CaptureStats{}, false
+}
+
+func openCaptureHandle(device, filter string, snaplen int) (captureHandle, error) {
+	return nil, fmt.Errorf("当前版本编译时未启用pcap支持，无法抓包")
+}
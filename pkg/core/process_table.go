@@ -0,0 +1,413 @@
+package core
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/google/gopacket/layers"
+	"github.com/graydovee/netbouncer/pkg/config"
+)
+
+// maxProcessesPerIP 每个远程IP最多记录的本地进程数，超出后新的进程不再计入
+const maxProcessesPerIP = 16
+
+// ProcessInfo 本地端口所属的进程
+type ProcessInfo struct {
+	PID         int    `json:"pid"`          // 进程ID，多个进程共享同一socket时为最小的PID
+	Name        string `json:"name"`         // 进程名（/proc/<pid>/comm）
+	Cgroup      string `json:"cgroup"`       // 进程所在的cgroup路径
+	ContainerID string `json:"container_id"` // 从cgroup路径中识别的容器ID，不在容器中时为空
+}
+
+// ProcessStats 远程IP与某个本地进程之间的流量
+type ProcessStats struct {
+	ProcessInfo
+	BytesSent   uint64    `json:"bytes_sent"`
+	BytesRecv   uint64    `json:"bytes_recv"`
+	PacketsSent uint64    `json:"packets_sent"`
+	PacketsRecv uint64    `json:"packets_recv"`
+	LastSeen    time.Time `json:"last_seen"`
+}
+
+// processKey 按进程名和cgroup区分进程，同一服务的多个工作进程合并统计
+type processKey struct {
+	name   string
+	cgroup string
+}
+
+// processTrafficStats 远程IP与某个本地进程之间的流量，由所在分片的锁保护
+type processTrafficStats struct {
+	trafficCounters
+	info     ProcessInfo
+	lastSeen time.Time
+}
+
+// socketKey 本地socket的协议、地址和端口，监听在通配地址上的socket地址为空
+type socketKey struct {
+	protocol layers.IPProtocol
+	ip       string
+	port     uint16
+}
+
+// procSocket /proc/net/{tcp,udp}* 中的一行
+type procSocket struct {
+	key   socketKey
+	inode uint64
+}
+
+// processTable 定期扫描/proc，记录本地socket所属的进程
+type processTable struct {
+	procRoot string
+	interval time.Duration
+	sockets  atomic.Pointer[map[socketKey]*ProcessInfo] // 每次扫描整体替换，查找时不需要加锁
+}
+
+// newProcessTable 创建进程归属表，未启用时返回nil
+func newProcessTable(cfg *config.ProcessConfig) *processTable {
+	if !cfg.Enabled {
+		return nil
+	}
+	interval := time.Duration(cfg.Interval) * time.Second
+	if interval <= 0 {
+		interval = 10 * time.Second
+	}
+	t := &processTable{procRoot: "/proc", interval: interval}
+	t.sockets.Store(&map[socketKey]*ProcessInfo{})
+	return t
+}
+
+// lookup 查找本地地址和端口所属的进程，先精确匹配地址，wildcard为true时再匹配本机监听在通配地址上的socket
+func (t *processTable) lookup(protocol layers.IPProtocol, localIP string, port uint16, wildcard bool) *ProcessInfo {
+	sockets := *t.sockets.Load()
+	if info, exists := sockets[socketKey{protocol: protocol, ip: localIP, port: port}]; exists {
+		return info
+	}
+	if !wildcard {
+		return nil
+	}
+	return sockets[socketKey{protocol: protocol, port: port}]
+}
+
+// procNetFiles 各网络命名空间中记录socket的文件
+var procNetFiles = []struct {
+	name     string
+	protocol layers.IPProtocol
+}{
+	{"tcp", layers.IPProtocolTCP},
+	{"tcp6", layers.IPProtocolTCP},
+	{"udp", layers.IPProtocolUDP},
+	{"udp6", layers.IPProtocolUDP},
+}
+
+// refresh 重新扫描各进程的文件描述符和各网络命名空间的socket，替换现有的映射
+func (t *processTable) refresh() error {
+	owners, namespaces, err := t.scanProcesses()
+	if err != nil {
+		return err
+	}
+
+	processes := make(map[int]*ProcessInfo)
+	sockets := make(map[socketKey]*ProcessInfo)
+	// 本机网络命名空间中监听在通配地址上的socket匹配本机的全部地址
+	if err := t.addNamespace(sockets, filepath.Join(t.procRoot, "net"), true, owners, processes); err != nil {
+		return err
+	}
+	// 容器通常有独立的网络命名空间，其中的socket只能通过该命名空间内的进程读取
+	hostNS, _ := os.Readlink(filepath.Join(t.procRoot, "self", "ns", "net"))
+	for ns, pid := range namespaces {
+		if ns == hostNS {
+			continue
+		}
+		dir := filepath.Join(t.procRoot, strconv.Itoa(pid), "net")
+		if err := t.addNamespace(sockets, dir, false, owners, processes); err != nil {
+			slog.Debug("读取网络命名空间的socket失败", "netns", ns, "pid", pid, "error", err)
+		}
+	}
+	t.sockets.Store(&sockets)
+	return nil
+}
+
+// scanProcesses 遍历各进程，返回socket inode所属的进程（多个进程共享时取最小的PID），以及各网络命名空间中的一个进程
+func (t *processTable) scanProcesses() (map[uint64]int, map[string]int, error) {
+	entries, err := os.ReadDir(t.procRoot)
+	if err != nil {
+		return nil, nil, fmt.Errorf("读取%s失败: %w", t.procRoot, err)
+	}
+
+	owners := make(map[uint64]int)
+	namespaces := make(map[string]int)
+	for _, entry := range entries {
+		pid, err := strconv.Atoi(entry.Name())
+		if err != nil {
+			continue
+		}
+		dir := filepath.Join(t.procRoot, entry.Name())
+		if ns, err := os.Readlink(filepath.Join(dir, "ns", "net")); err == nil {
+			if owner, exists := namespaces[ns]; !exists || pid < owner {
+				namespaces[ns] = pid
+			}
+		}
+
+		fdDir := filepath.Join(dir, "fd")
+		fds, err := os.ReadDir(fdDir)
+		if err != nil {
+			// 进程已退出或没有权限
+			continue
+		}
+		for _, fd := range fds {
+			link, err := os.Readlink(filepath.Join(fdDir, fd.Name()))
+			if err != nil || !strings.HasPrefix(link, "socket:[") {
+				continue
+			}
+			inode, err := strconv.ParseUint(strings.TrimSuffix(strings.TrimPrefix(link, "socket:["), "]"), 10, 64)
+			if err != nil {
+				continue
+			}
+			if owner, exists := owners[inode]; !exists || pid < owner {
+				owners[inode] = pid
+			}
+		}
+	}
+	return owners, namespaces, nil
+}
+
+// addNamespace 读取一个网络命名空间中的socket并记录所属的进程，已有的记录不覆盖
+// 非本机的命名空间中监听在通配地址上的socket展开为该命名空间中出现过的地址
+func (t *processTable) addNamespace(sockets map[socketKey]*ProcessInfo, dir string, host bool, owners map[uint64]int, processes map[int]*ProcessInfo) error {
+	var wildcards []procSocket
+	addrs := make(map[string]bool)
+	found := false
+	for _, file := range procNetFiles {
+		f, err := os.Open(filepath.Join(dir, file.name))
+		if err != nil {
+			// 未启用IPv6时没有tcp6和udp6
+			continue
+		}
+		found = true
+		parsed, err := parseProcNet(f, file.protocol)
+		f.Close()
+		if err != nil {
+			return fmt.Errorf("解析%s失败: %w", filepath.Join(dir, file.name), err)
+		}
+		for _, socket := range parsed {
+			if socket.key.ip != "" && !host && !net.ParseIP(socket.key.ip).IsLoopback() {
+				addrs[socket.key.ip] = true
+			}
+			if _, exists := owners[socket.inode]; !exists {
+				continue
+			}
+			if socket.key.ip == "" && !host {
+				wildcards = append(wildcards, socket)
+				continue
+			}
+			if _, exists := sockets[socket.key]; !exists {
+				sockets[socket.key] = t.process(owners[socket.inode], processes)
+			}
+		}
+	}
+	if !found {
+		return fmt.Errorf("%s中没有socket信息", dir)
+	}
+
+	for _, socket := range wildcards {
+		for addr := range addrs {
+			key := socket.key
+			key.ip = addr
+			if _, exists := sockets[key]; !exists {
+				sockets[key] = t.process(owners[socket.inode], processes)
+			}
+		}
+	}
+	return nil
+}
+
+// process 返回进程信息，同一次扫描中每个进程只读取一次
+func (t *processTable) process(pid int, processes map[int]*ProcessInfo) *ProcessInfo {
+	info, exists := processes[pid]
+	if !exists {
+		info = t.processInfo(pid)
+		processes[pid] = info
+	}
+	return info
+}
+
+// processInfo 读取进程名和cgroup
+func (t *processTable) processInfo(pid int) *ProcessInfo {
+	info := &ProcessInfo{PID: pid}
+	dir := filepath.Join(t.procRoot, strconv.Itoa(pid))
+	if comm, err := os.ReadFile(filepath.Join(dir, "comm")); err == nil {
+		info.Name = strings.TrimSpace(string(comm))
+	}
+	if cgroup, err := os.ReadFile(filepath.Join(dir, "cgroup")); err == nil {
+		info.Cgroup = parseCgroup(string(cgroup))
+		info.ContainerID = containerID(info.Cgroup)
+	}
+	return info
+}
+
+// parseProcNet 解析/proc/net/{tcp,udp}*，跳过inode为0的socket（如TIME_WAIT）
+func parseProcNet(r io.Reader, protocol layers.IPProtocol) ([]procSocket, error) {
+	var result []procSocket
+	scanner := bufio.NewScanner(r)
+	// 跳过表头
+	scanner.Scan()
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 10 {
+			continue
+		}
+		ip, port, err := parseProcNetAddr(fields[1])
+		if err != nil {
+			return nil, err
+		}
+		inode, err := strconv.ParseUint(fields[9], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("无效的inode: %s", fields[9])
+		}
+		if inode == 0 {
+			continue
+		}
+		key := socketKey{protocol: protocol, port: port}
+		if !ip.IsUnspecified() {
+			key.ip = ip.String()
+		}
+		result = append(result, procSocket{key: key, inode: inode})
+	}
+	return result, scanner.Err()
+}
+
+// parseProcNetAddr 解析 "0100007F:0050" 格式的地址，地址按32位字以主机字节序保存
+func parseProcNetAddr(s string) (net.IP, uint16, error) {
+	addr, portHex, ok := strings.Cut(s, ":")
+	if !ok {
+		return nil, 0, fmt.Errorf("无效的socket地址: %s", s)
+	}
+	raw, err := hex.DecodeString(addr)
+	if err != nil || (len(raw) != net.IPv4len && len(raw) != net.IPv6len) {
+		return nil, 0, fmt.Errorf("无效的socket地址: %s", s)
+	}
+	port, err := strconv.ParseUint(portHex, 16, 16)
+	if err != nil {
+		return nil, 0, fmt.Errorf("无效的socket端口: %s", s)
+	}
+
+	ip := make(net.IP, len(raw))
+	for i := 0; i < len(raw); i += 4 {
+		binary.BigEndian.PutUint32(ip[i:], binary.NativeEndian.Uint32(raw[i:]))
+	}
+	return ip, uint16(port), nil
+}
+
+// parseCgroup 从/proc/<pid>/cgroup中取cgroup路径，优先使用cgroup v2的统一层级
+func parseCgroup(content string) string {
+	var fallback string
+	for _, line := range strings.Split(strings.TrimSpace(content), "\n") {
+		parts := strings.SplitN(line, ":", 3)
+		if len(parts) != 3 {
+			continue
+		}
+		if parts[0] == "0" && parts[1] == "" {
+			return parts[2]
+		}
+		if fallback == "" || parts[1] == "name=systemd" {
+			fallback = parts[2]
+		}
+	}
+	return fallback
+}
+
+// containerIDRex docker、containerd、cri-o和podman在cgroup路径中使用64位十六进制的容器ID
+var containerIDRex = regexp.MustCompile(`[0-9a-f]{64}`)
+
+// containerID 从cgroup路径中识别容器ID
+func containerID(cgroup string) string {
+	matches := containerIDRex.FindAllString(cgroup, -1)
+	if len(matches) == 0 {
+		return ""
+	}
+	return matches[len(matches)-1]
+}
+
+// watchProcesses 立即扫描一次/proc，之后定期重新扫描
+func (m *Monitor) watchProcesses() {
+	if err := m.processes.refresh(); err != nil {
+		slog.Warn("扫描本地进程失败", "error", err)
+	}
+
+	go func() {
+		ticker := time.NewTicker(m.processes.interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				if err := m.processes.refresh(); err != nil {
+					slog.Warn("扫描本地进程失败", "error", err)
+				}
+			case <-m.stopChan:
+				return
+			}
+		}
+	}()
+}
+
+// recordProcess 将一次流量计入本地端口所属的进程，调用方需持有分片写锁
+func (m *Monitor) recordProcess(stats *internalTrafficStats, sample trafficSample, now time.Time) {
+	// 通配地址只匹配本机网卡上的地址，路由模式下经本机转发的流量不属于本机进程
+	m.localMutex.RLock()
+	hostAddr := m.localIPs[sample.localIP]
+	m.localMutex.RUnlock()
+	info := m.processes.lookup(sample.protocol, sample.localIP, sample.localPort, hostAddr)
+	if info == nil {
+		return
+	}
+	key := processKey{name: info.Name, cgroup: info.Cgroup}
+	process, exists := stats.processes[key]
+	if !exists {
+		if stats.processes == nil {
+			stats.processes = make(map[processKey]*processTrafficStats)
+		}
+		if len(stats.processes) >= maxProcessesPerIP {
+			return
+		}
+		process = &processTrafficStats{}
+		stats.processes[key] = process
+	}
+	process.info = *info
+	process.add(sample.bytes, sample.packets, sample.isSent)
+	process.lastSeen = now
+}
+
+// processStats 按流量从大到小返回远程IP的进程统计
+func (its *internalTrafficStats) processStats() []ProcessStats {
+	if len(its.processes) == 0 {
+		return nil
+	}
+	result := make([]ProcessStats, 0, len(its.processes))
+	for _, process := range its.processes {
+		result = append(result, ProcessStats{
+			ProcessInfo: process.info,
+			BytesSent:   process.bytesSent,
+			BytesRecv:   process.bytesRecv,
+			PacketsSent: process.packetsSent,
+			PacketsRecv: process.packetsRecv,
+			LastSeen:    process.lastSeen,
+		})
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].BytesSent+result[i].BytesRecv > result[j].BytesSent+result[j].BytesRecv
+	})
+	return result
+}
@@ -0,0 +1,183 @@
+package core
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/gopacket/layers"
+)
+
+// procNetAddr 按/proc/net中的格式（32位字以主机字节序）编码地址和端口
+func procNetAddr(ip string, port uint16) string {
+	addr := net.ParseIP(ip)
+	if v4 := addr.To4(); v4 != nil {
+		addr = v4
+	}
+	raw := make([]byte, len(addr))
+	for i := 0; i < len(addr); i += 4 {
+		binary.NativeEndian.PutUint32(raw[i:], binary.BigEndian.Uint32(addr[i:]))
+	}
+	return fmt.Sprintf("%s:%04X", strings.ToUpper(hex.EncodeToString(raw)), port)
+}
+
+// procNetTable 构造/proc/net/{tcp,udp}*的内容
+func procNetTable(lines ...string) string {
+	content := "  sl  local_address rem_address   st tx_queue rx_queue tr tm->when retrnsmt   uid  timeout inode\n"
+	for i, line := range lines {
+		content += fmt.Sprintf("%4d: %s\n", i, line)
+	}
+	return content
+}
+
+func procNetLine(local string, inode uint64) string {
+	return fmt.Sprintf("%s 00000000:0000 0A 00000000:00000000 00:00000000 00000000     0        0 %d 1 0000000000000000 100 0 0 10 0", local, inode)
+}
+
+func writeFile(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func symlink(t *testing.T, target, path string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(target, path); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func Test_parseProcNetAddr(t *testing.T) {
+	tests := []struct {
+		ip   string
+		port uint16
+		want string
+	}{
+		{"127.0.0.1", 80, "127.0.0.1"},
+		{"0.0.0.0", 53, "0.0.0.0"},
+		{"2001:db8::1", 443, "2001:db8::1"},
+		{"::ffff:10.0.0.5", 8080, "10.0.0.5"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.ip, func(t *testing.T) {
+			ip, port, err := parseProcNetAddr(procNetAddr(tt.ip, tt.port))
+			if err != nil {
+				t.Fatalf("parseProcNetAddr() error = %v", err)
+			}
+			if ip.String() != tt.want || port != tt.port {
+				t.Errorf("parseProcNetAddr() = %s, %d, want %s, %d", ip, port, tt.want, tt.port)
+			}
+		})
+	}
+
+	if _, _, err := parseProcNetAddr("zz:0050"); err == nil {
+		t.Error("parseProcNetAddr() expected error for invalid address")
+	}
+}
+
+func Test_containerID(t *testing.T) {
+	id := strings.Repeat("ab12", 16)
+	tests := []struct {
+		cgroup string
+		want   string
+	}{
+		{"/system.slice/nginx.service", ""},
+		{"/system.slice/docker-" + id + ".scope", id},
+		{"/kubepods.slice/kubepods-burstable.slice/cri-containerd-" + id + ".scope", id},
+		{"/docker/" + id, id},
+	}
+	for _, tt := range tests {
+		if got := containerID(tt.cgroup); got != tt.want {
+			t.Errorf("containerID(%q) = %q, want %q", tt.cgroup, got, tt.want)
+		}
+	}
+
+	cgroup := parseCgroup("12:cpu,cpuacct:/docker/" + id + "\n1:name=systemd:/docker/" + id + "\n0::/system.slice/docker-" + id + ".scope\n")
+	if cgroup != "/system.slice/docker-"+id+".scope" {
+		t.Errorf("parseCgroup() = %q, want cgroup v2 path", cgroup)
+	}
+}
+
+func Test_processTable_refresh(t *testing.T) {
+	root := t.TempDir()
+	id := strings.Repeat("0f", 32)
+
+	// 本机命名空间：nginx的两个进程共享监听80端口的socket，另有一个已建立的出站连接
+	writeFile(t, filepath.Join(root, "net", "tcp"), procNetTable(
+		procNetLine(procNetAddr("0.0.0.0", 80), 100),
+		procNetLine(procNetAddr("192.0.2.1", 40000), 101),
+	))
+	writeFile(t, filepath.Join(root, "net", "udp"), procNetTable())
+	symlink(t, "net:[1]", filepath.Join(root, "self", "ns", "net"))
+	for _, pid := range []string{"20", "10"} {
+		writeFile(t, filepath.Join(root, pid, "comm"), "nginx\n")
+		writeFile(t, filepath.Join(root, pid, "cgroup"), "0::/system.slice/nginx.service\n")
+		symlink(t, "net:[1]", filepath.Join(root, pid, "ns", "net"))
+		symlink(t, "socket:[100]", filepath.Join(root, pid, "fd", "3"))
+	}
+	symlink(t, "socket:[101]", filepath.Join(root, "10", "fd", "4"))
+	symlink(t, "/dev/null", filepath.Join(root, "10", "fd", "0"))
+
+	// 容器命名空间：监听在通配地址上的UDP端口展开为容器的地址
+	writeFile(t, filepath.Join(root, "30", "comm"), "coredns\n")
+	writeFile(t, filepath.Join(root, "30", "cgroup"), "0::/system.slice/docker-"+id+".scope\n")
+	symlink(t, "net:[2]", filepath.Join(root, "30", "ns", "net"))
+	symlink(t, "socket:[200]", filepath.Join(root, "30", "fd", "5"))
+	writeFile(t, filepath.Join(root, "30", "net", "tcp"), procNetTable(
+		procNetLine(procNetAddr("172.17.0.2", 8080), 201),
+	))
+	writeFile(t, filepath.Join(root, "30", "net", "udp"), procNetTable(
+		procNetLine(procNetAddr("0.0.0.0", 53), 200),
+	))
+
+	table := &processTable{procRoot: root}
+	if err := table.refresh(); err != nil {
+		t.Fatalf("refresh() error = %v", err)
+	}
+
+	tests := []struct {
+		name      string
+		protocol  layers.IPProtocol
+		localIP   string
+		port      uint16
+		wildcard  bool
+		wantPID   int
+		wantName  string
+		container string
+	}{
+		{"wildcard listener", layers.IPProtocolTCP, "192.0.2.1", 80, true, 10, "nginx", ""},
+		{"forwarded traffic", layers.IPProtocolTCP, "10.0.0.9", 80, false, 0, "", ""},
+		{"outbound connection", layers.IPProtocolTCP, "192.0.2.1", 40000, false, 10, "nginx", ""},
+		{"container", layers.IPProtocolUDP, "172.17.0.2", 53, false, 30, "coredns", id},
+		{"unknown port", layers.IPProtocolTCP, "192.0.2.1", 22, true, 0, "", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			info := table.lookup(tt.protocol, tt.localIP, tt.port, tt.wildcard)
+			if tt.wantPID == 0 {
+				if info != nil {
+					t.Errorf("lookup() = %+v, want nil", info)
+				}
+				return
+			}
+			if info == nil {
+				t.Fatal("lookup() = nil")
+			}
+			if info.PID != tt.wantPID || info.Name != tt.wantName || info.ContainerID != tt.container {
+				t.Errorf("lookup() = %+v, want pid %d name %s container %q", info, tt.wantPID, tt.wantName, tt.container)
+			}
+		})
+	}
+}
@@ -0,0 +1,136 @@
+package core
+
+import "time"
+
+const (
+	historyMinutes      = 60 // 分钟级历史保留的点数（1小时）
+	maxSecondsHistories = 32 // 每个分片最多为多少个远程IP保留秒级历史，限制伪造源地址时的内存占用
+)
+
+// RatePoint 历史速率中的一个点
+type RatePoint struct {
+	Time            time.Time `json:"time"` // 时间段起点
+	BytesSentPerSec float64   `json:"bytes_sent_per_sec"`
+	BytesRecvPerSec float64   `json:"bytes_recv_per_sec"`
+}
+
+// RateHistory 单个远程IP的历史速率，按时间从旧到新排列
+type RateHistory struct {
+	RemoteIP string      `json:"remote_ip"`
+	Seconds  []RatePoint `json:"seconds"` // 每秒速率
+	Minutes  []RatePoint `json:"minutes"` // 每分钟平均速率
+}
+
+// historyPoint 一个时间段内的双向字节数
+type historyPoint struct {
+	sent uint64
+	recv uint64
+}
+
+// historyRing 定长的历史环，每个时间段一个点，超出长度的旧点被覆盖
+// 不带锁，由所属分片的锁保护
+type historyRing struct {
+	interval time.Duration
+	points   []historyPoint
+	newest   int64 // 最新写入的时间段序号，0表示尚未写入
+}
+
+// newHistoryRing 创建历史环
+func newHistoryRing(interval time.Duration, length int) *historyRing {
+	return &historyRing{
+		interval: interval,
+		points:   make([]historyPoint, max(length, 1)),
+	}
+}
+
+// slotOf 返回时间所在的时间段序号
+func (r *historyRing) slotOf(now time.Time) int64 {
+	return now.UnixNano() / int64(r.interval)
+}
+
+// index 返回时间段在环中的位置
+func (r *historyRing) index(slot int64) int {
+	return int(slot % int64(len(r.points)))
+}
+
+// add 在时间所在的时间段中累加
+func (r *historyRing) add(now time.Time, bytes uint64, isSent bool) {
+	slot := r.slotOf(now)
+	length := int64(len(r.points))
+	switch {
+	case r.newest == 0:
+		r.newest = slot
+	case slot > r.newest:
+		// 清空跳过的时间段，它们在环中的位置上还是一轮之前的数据
+		for s := r.newest + 1; s <= slot && s <= r.newest+length; s++ {
+			r.points[r.index(s)] = historyPoint{}
+		}
+		r.newest = slot
+	case slot <= r.newest-length:
+		// 时钟回拨到环之外，丢弃
+		return
+	}
+
+	point := &r.points[r.index(slot)]
+	if isSent {
+		point.sent += bytes
+	} else {
+		point.recv += bytes
+	}
+}
+
+// series 返回截至当前时间的完整序列，没有流量的时间段速率为0
+func (r *historyRing) series(now time.Time) []RatePoint {
+	length := int64(len(r.points))
+	current := r.slotOf(now)
+	seconds := r.interval.Seconds()
+
+	result := make([]RatePoint, 0, length)
+	for slot := current - length + 1; slot <= current; slot++ {
+		point := RatePoint{Time: time.Unix(0, slot*int64(r.interval))}
+		if r.newest != 0 && slot <= r.newest && slot > r.newest-length {
+			p := r.points[r.index(slot)]
+			point.BytesSentPerSec = float64(p.sent) / seconds
+			point.BytesRecvPerSec = float64(p.recv) / seconds
+		}
+		result = append(result, point)
+	}
+	return result
+}
+
+// rateHistory 单个远程IP的秒级和分钟级历史速率
+// 秒级历史占用较大，只在远程IP成为流量排行候选或被查询历史时才分配
+type rateHistory struct {
+	seconds *historyRing // 未分配时为nil
+	minutes *historyRing
+}
+
+// newRateHistory 创建只有分钟级历史的历史速率
+func newRateHistory() *rateHistory {
+	return &rateHistory{
+		minutes: newHistoryRing(time.Minute, historyMinutes),
+	}
+}
+
+// enableSeconds 分配秒级历史，secondsLength为保留的点数，已分配时不做改变
+func (h *rateHistory) enableSeconds(secondsLength int) {
+	if h.seconds == nil {
+		h.seconds = newHistoryRing(time.Second, secondsLength)
+	}
+}
+
+// add 累加一次流量
+func (h *rateHistory) add(now time.Time, bytes uint64, isSent bool) {
+	if h.seconds != nil {
+		h.seconds.add(now, bytes, isSent)
+	}
+	h.minutes.add(now, bytes, isSent)
+}
+
+// secondsSeries 返回秒级历史，未分配时返回空序列
+func (h *rateHistory) secondsSeries(now time.Time) []RatePoint {
+	if h.seconds == nil {
+		return []RatePoint{}
+	}
+	return h.seconds.series(now)
+}
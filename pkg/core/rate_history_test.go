@@ -0,0 +1,120 @@
+package core
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func Test_historyRing_series(t *testing.T) {
+	start := time.Unix(1700000000, 0)
+
+	type add struct {
+		at     time.Duration
+		bytes  uint64
+		isSent bool
+	}
+	tests := []struct {
+		name     string
+		adds     []add
+		at       time.Duration
+		wantSent []float64
+		wantRecv []float64
+	}{
+		{
+			name:     "empty",
+			at:       0,
+			wantSent: []float64{0, 0, 0, 0},
+			wantRecv: []float64{0, 0, 0, 0},
+		},
+		{
+			name:     "partial",
+			adds:     []add{{at: 0, bytes: 100, isSent: true}, {at: time.Second, bytes: 50}, {at: 1500 * time.Millisecond, bytes: 30}},
+			at:       2 * time.Second,
+			wantSent: []float64{0, 100, 0, 0},
+			wantRecv: []float64{0, 0, 80, 0},
+		},
+		{
+			name:     "wrap_and_gap",
+			adds:     []add{{at: 0, bytes: 100, isSent: true}, {at: 5 * time.Second, bytes: 10, isSent: true}, {at: 7 * time.Second, bytes: 20, isSent: true}},
+			at:       7 * time.Second,
+			wantSent: []float64{0, 10, 0, 20},
+			wantRecv: []float64{0, 0, 0, 0},
+		},
+		{
+			name:     "stale",
+			adds:     []add{{at: 0, bytes: 100, isSent: true}},
+			at:       10 * time.Second,
+			wantSent: []float64{0, 0, 0, 0},
+			wantRecv: []float64{0, 0, 0, 0},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := newHistoryRing(time.Second, 4)
+			for _, a := range tt.adds {
+				r.add(start.Add(a.at), a.bytes, a.isSent)
+			}
+			series := r.series(start.Add(tt.at))
+			if len(series) != len(tt.wantSent) {
+				t.Fatalf("len(series) = %d, want %d", len(series), len(tt.wantSent))
+			}
+			for i, point := range series {
+				if point.BytesSentPerSec != tt.wantSent[i] || point.BytesRecvPerSec != tt.wantRecv[i] {
+					t.Errorf("series[%d] = %v/%v, want %v/%v", i, point.BytesSentPerSec, point.BytesRecvPerSec, tt.wantSent[i], tt.wantRecv[i])
+				}
+			}
+			if want := start.Add(tt.at).Truncate(time.Second); !series[len(series)-1].Time.Equal(want) {
+				t.Errorf("last point time = %v, want %v", series[len(series)-1].Time, want)
+			}
+		})
+	}
+}
+
+func Test_statsShard_enableSecondsHistory(t *testing.T) {
+	shard := newStatsShards(0, 2, func() *flowTable { return newFlowTable(time.Minute, time.Minute) })[0]
+	add := func(ip string) *internalTrafficStats {
+		stats := &internalTrafficStats{remoteIP: ip, history: newRateHistory()}
+		shard.stats[ip] = stats
+		return stats
+	}
+
+	// 上限内直接分配
+	var first *internalTrafficStats
+	for i := 0; i < maxSecondsHistories; i++ {
+		stats := add(fmt.Sprintf("192.0.2.%d", i))
+		shard.enableSecondsHistory(stats, 60)
+		if i == 0 {
+			first = stats
+		}
+	}
+	if len(shard.histories) != maxSecondsHistories {
+		t.Fatalf("histories = %d, want %d", len(shard.histories), maxSecondsHistories)
+	}
+
+	// 全部是流量排行候选时不回收
+	for ip := range shard.histories {
+		shard.heavy.estimates[ip] = 1
+	}
+	extra := add("198.51.100.1")
+	shard.enableSecondsHistory(extra, 60)
+	if extra.history.seconds != nil {
+		t.Fatal("seconds history allocated beyond the limit")
+	}
+	if got := extra.history.secondsSeries(time.Now()); len(got) != 0 {
+		t.Errorf("secondsSeries() without ring = %d points, want 0", len(got))
+	}
+
+	// 不再是候选的IP被回收
+	delete(shard.heavy.estimates, first.remoteIP)
+	shard.enableSecondsHistory(extra, 60)
+	if extra.history.seconds == nil || first.history.seconds != nil {
+		t.Fatal("seconds history not reclaimed from a non-candidate")
+	}
+
+	// 删除统计时释放
+	shard.remove(extra.remoteIP, extra)
+	if _, exists := shard.histories[extra.remoteIP]; exists || len(shard.histories) != maxSecondsHistories-1 {
+		t.Errorf("histories after remove = %d", len(shard.histories))
+	}
+}
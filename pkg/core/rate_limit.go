@@ -0,0 +1,128 @@
+package core
+
+import (
+	"fmt"
+	"hash/crc32"
+	"log/slog"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/coreos/go-iptables/iptables"
+)
+
+// limitHookChains 挂载限速链的内置链，OUTPUT限制本机发出的流量，FORWARD限制转发给容器或内网主机的流量
+var limitHookChains = []string{"OUTPUT", "FORWARD"}
+
+// rateLimiter 使用hashlimit限制发往远程IP或网段的速率，iptables和ipset防火墙共用，只支持IPv4
+// 限速规则放在单独的链中并挂到OUTPUT和FORWARD链，避免与INPUT方向按源地址匹配的封禁规则互相影响
+type rateLimiter struct {
+	ipt   *iptables.IPTables
+	chain string
+
+	mutex sync.Mutex
+	rules map[string][]string // 各网段当前的限速规则，删除时需要完整的规则参数
+}
+
+// limitChainName 限速链的名称
+func limitChainName(chain string) string {
+	return chain + "_LIMIT"
+}
+
+// init 创建或清空限速链，并挂到OUTPUT和FORWARD链
+func (r *rateLimiter) init(ipt *iptables.IPTables, chain string) error {
+	r.ipt = ipt
+	r.chain = limitChainName(chain)
+	r.rules = make(map[string][]string)
+
+	chains, err := ipt.ListChains("filter")
+	if err != nil {
+		return err
+	}
+	if slices.Contains(chains, r.chain) {
+		slog.Info("清空限速链中的所有规则", "cmd", "iptables -F "+r.chain)
+		_ = ipt.ClearChain("filter", r.chain)
+	} else {
+		slog.Info("创建限速链", "cmd", "iptables -N "+r.chain)
+		_ = ipt.NewChain("filter", r.chain)
+	}
+
+	for _, hook := range limitHookChains {
+		rules, err := ipt.List("filter", hook)
+		if err != nil {
+			return err
+		}
+		if !slices.Contains(rules, "-A "+hook+" -j "+r.chain) {
+			slog.Info("初始化限速链", "cmd", "iptables -I "+hook+" 1 -j "+r.chain)
+			if err := ipt.Insert("filter", hook, 1, "-j", r.chain); err != nil {
+				return fmt.Errorf("挂载限速链失败: %w", err)
+			}
+		}
+	}
+	return nil
+}
+
+// limit 将发往ipNet的流量限制在bytesPerSec以内，超出部分丢弃，已有限速时替换为新的速率
+func (r *rateLimiter) limit(ipNet string, bytesPerSec uint64) error {
+	if r.ipt == nil {
+		return fmt.Errorf("限速链未初始化")
+	}
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if err := r.revertLocked(ipNet); err != nil {
+		return err
+	}
+
+	// hashlimit的名称最长15个字符，使用网段的哈希区分不同规则
+	name := fmt.Sprintf("nbq%08x", crc32.ChecksumIEEE([]byte(ipNet)))
+	rate := fmt.Sprintf("%dkb/s", max(bytesPerSec>>10, 1))
+	spec := []string{"-d", ipNet, "-m", "hashlimit", "--hashlimit-name", name, "--hashlimit-above", rate, "-j", "DROP"}
+
+	slog.Info("添加限速规则", "ip", ipNet, "cmd", "iptables -A "+r.chain+" "+strings.Join(spec, " "))
+	if err := r.ipt.AppendUnique("filter", r.chain, spec...); err != nil {
+		return fmt.Errorf("添加限速规则失败: %w", err)
+	}
+	r.rules[ipNet] = spec
+	return nil
+}
+
+// revert 删除ipNet的限速规则，不存在时视为成功
+func (r *rateLimiter) revert(ipNet string) error {
+	if r.ipt == nil {
+		return nil
+	}
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.revertLocked(ipNet)
+}
+
+func (r *rateLimiter) revertLocked(ipNet string) error {
+	spec, exists := r.rules[ipNet]
+	if !exists {
+		return nil
+	}
+	slog.Info("删除限速规则", "ip", ipNet, "cmd", "iptables -D "+r.chain+" "+strings.Join(spec, " "))
+	err := r.ipt.Delete("filter", r.chain, spec...)
+	if err != nil && !strings.Contains(err.Error(), "Bad rule") {
+		return fmt.Errorf("删除限速规则失败: %w", err)
+	}
+	delete(r.rules, ipNet)
+	return nil
+}
+
+// cleanup 从OUTPUT和FORWARD链移除限速链并删除
+func (r *rateLimiter) cleanup(ipt *iptables.IPTables, chain string) {
+	chain = limitChainName(chain)
+	for _, hook := range limitHookChains {
+		for {
+			if err := ipt.Delete("filter", hook, "-j", chain); err != nil {
+				break
+			}
+			slog.Info("清除限速链的规则", "cmd", "iptables -D "+hook+" -j "+chain)
+		}
+	}
+	slog.Info("删除限速链", "cmd", "iptables -X "+chain)
+	_ = ipt.ClearChain("filter", chain)
+	_ = ipt.DeleteChain("filter", chain)
+}
@@ -0,0 +1,445 @@
+package core
+
+import (
+	"log/slog"
+	"math"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/graydovee/netbouncer/pkg/config"
+)
+
+const (
+	MetricInboundRate   = "inbound_rate"   // 入站字节速率（字节/秒）
+	MetricNewFlows      = "new_flows"      // 新建连接速率（个/秒）
+	MetricUniqueSources = "unique_sources" // 每个周期访问的不同远程IP数
+
+	maxBaselineServices  = 1024 // 最多学习基线的本地端口数量
+	maxServiceSources    = 128  // 每个分片每个端口每个周期最多记录的远程IP数量，所有分片合计每个端口4096个
+	maxAnomalies         = 1000 // 保留的异常事件数量
+	baselineMinRate      = 1024 // 入站速率标准差的下限（字节/秒），避免流量很小的端口频繁告警
+	baselineMinFlows     = 0.1  // 新建连接速率标准差的下限（个/秒）
+	baselineMinSources   = 1.0  // 不同远程IP数标准差的下限
+	baselineMinFraction  = 0.1  // 标准差不低于均值的该比例
+	baselineStaleAfter   = 24   // 连续多少个周期没有流量的端口不再跟踪
+	baselineDefaultAlpha = 0.1  // 默认EWMA平滑系数
+	baselineDefaultSigma = 3.0  // 默认偏离阈值（标准差倍数）
+)
+
+// ewma 指数加权移动平均及方差
+type ewma struct {
+	mean     float64
+	variance float64
+	samples  int
+}
+
+// update 加入一个观测值
+func (e *ewma) update(x, alpha float64) {
+	if e.samples == 0 {
+		e.mean = x
+		e.samples = 1
+		return
+	}
+	diff := x - e.mean
+	incr := alpha * diff
+	e.mean += incr
+	e.variance = (1 - alpha) * (e.variance + diff*incr)
+	e.samples++
+}
+
+// stddev 返回标准差，不低于minStd和均值的一定比例
+func (e *ewma) stddev(minStd float64) float64 {
+	return max(math.Sqrt(e.variance), minStd, math.Abs(e.mean)*baselineMinFraction)
+}
+
+// contribution 某个远程IP在一个周期内对某个端口的贡献
+type contribution struct {
+	bytes    uint64
+	newFlows uint64
+}
+
+// serviceWindow 一个周期内某个本地端口的入站流量，按分片累加，周期结束时合并
+type serviceWindow struct {
+	bytesIn  uint64
+	newFlows uint64
+	sources  map[string]*contribution
+	overflow *hyperLogLog // 远程IP表满后创建，包含本周期出现过的全部IP，用于估计不同IP数
+}
+
+// recordService 累加远程发起的连接在本地端口上的入站流量，调用方需持有写锁
+func (s *statsShard) recordService(sample trafficSample) {
+	if sample.servicePort == 0 || sample.isSent {
+		return
+	}
+	key := portKey{protocol: protocolName(sample.protocol), port: sample.servicePort}
+	window, exists := s.services[key]
+	if !exists {
+		if len(s.services) >= maxBaselineServices {
+			return
+		}
+		window = &serviceWindow{sources: make(map[string]*contribution)}
+		s.services[key] = window
+	}
+	window.bytesIn += sample.bytes
+	window.newFlows += sample.newFlows
+
+	source, exists := window.sources[sample.remoteIP]
+	if !exists {
+		if len(window.sources) >= maxServiceSources {
+			window.startOverflow()
+			window.overflow.add(sample.remoteIP)
+			// 表满后只有流量排行候选才替换贡献最小的IP，大量伪造源地址的IP只计入不同IP数
+			if _, heavy := s.heavy.estimates[sample.remoteIP]; !heavy {
+				return
+			}
+			window.evictSmallest()
+		}
+		source = &contribution{}
+		window.sources[sample.remoteIP] = source
+	}
+	source.bytes += sample.bytes
+	source.newFlows += sample.newFlows
+}
+
+// startOverflow 远程IP表满时创建基数估计，并加入表中已有的IP
+func (w *serviceWindow) startOverflow() {
+	if w.overflow != nil {
+		return
+	}
+	w.overflow = &hyperLogLog{}
+	for ip := range w.sources {
+		w.overflow.add(ip)
+	}
+}
+
+// evictSmallest 从远程IP表中移除入站字节数最少的IP，它已计入基数估计
+func (w *serviceWindow) evictSmallest() {
+	var victim string
+	var smallest *contribution
+	for ip, source := range w.sources {
+		if smallest == nil || source.bytes < smallest.bytes {
+			victim, smallest = ip, source
+		}
+	}
+	delete(w.sources, victim)
+}
+
+// merge 合并另一个分片的同一端口的窗口，同一远程IP总在同一分片中，远程IP表不会重复
+func (w *serviceWindow) merge(other *serviceWindow) {
+	w.bytesIn += other.bytesIn
+	w.newFlows += other.newFlows
+	if w.overflow != nil || other.overflow != nil {
+		w.startOverflow()
+		if other.overflow != nil {
+			w.overflow.merge(other.overflow)
+		} else {
+			for ip := range other.sources {
+				w.overflow.add(ip)
+			}
+		}
+	}
+	for ip, source := range other.sources {
+		w.sources[ip] = source
+	}
+}
+
+// uniqueSources 返回本周期访问端口的不同远程IP数，远程IP表满过时为估计值
+func (w *serviceWindow) uniqueSources() uint64 {
+	if w.overflow != nil {
+		return w.overflow.estimate()
+	}
+	return uint64(len(w.sources))
+}
+
+// AnomalyContributor 异常周期内对端口贡献最多的远程IP
+type AnomalyContributor struct {
+	RemoteIP string `json:"remote_ip"`
+	Bytes    uint64 `json:"bytes"`     // 周期内的入站字节数
+	NewFlows uint64 `json:"new_flows"` // 周期内新建的连接数
+}
+
+// Anomaly 本地端口的某项指标偏离基线
+type Anomaly struct {
+	ID              uint64               `json:"id"`        // 递增的事件ID，用于增量拉取
+	Protocol        string               `json:"protocol"`  // tcp 或 udp
+	Port            uint16               `json:"port"`      // 本地端口
+	Metric          string               `json:"metric"`    // 偏离的指标
+	Value           float64              `json:"value"`     // 本周期的观测值
+	Mean            float64              `json:"mean"`      // 基线均值
+	StdDev          float64              `json:"stddev"`    // 基线标准差
+	Deviation       float64              `json:"deviation"` // 偏离的标准差倍数，负数表示低于基线
+	TopContributors []AnomalyContributor `json:"top_contributors"`
+	Time            time.Time            `json:"time"`
+}
+
+// ServiceBaseline 本地端口当前学习到的基线
+type ServiceBaseline struct {
+	Protocol string                    `json:"protocol"`
+	Port     uint16                    `json:"port"`
+	Samples  int                       `json:"samples"` // 已学习的周期数
+	Metrics  map[string]BaselineMetric `json:"metrics"`
+	LastSeen time.Time                 `json:"last_seen"` // 最后一次有入站流量的时间
+}
+
+// BaselineMetric 某项指标的基线和最近的观测值
+type BaselineMetric struct {
+	Mean   float64 `json:"mean"`
+	StdDev float64 `json:"stddev"`
+	Last   float64 `json:"last"`
+}
+
+// serviceBaseline 单个本地端口的各项指标基线
+type serviceBaseline struct {
+	metrics  map[string]*ewma
+	last     map[string]float64
+	idle     int // 连续没有流量的周期数
+	lastSeen time.Time
+}
+
+// baselineTracker 按本地端口学习入站流量基线并检测异常
+type baselineTracker struct {
+	interval        time.Duration
+	alpha           float64
+	threshold       float64
+	warmUp          int
+	topContributors int
+
+	mutex     sync.Mutex
+	services  map[portKey]*serviceBaseline
+	nextID    uint64
+	anomalies []Anomaly
+}
+
+// newBaselineTracker 根据配置创建基线学习器，未启用时返回nil
+func newBaselineTracker(cfg *config.BaselineConfig) *baselineTracker {
+	if !cfg.Enabled {
+		return nil
+	}
+	b := &baselineTracker{
+		interval:        time.Duration(cfg.Interval) * time.Second,
+		alpha:           cfg.Alpha,
+		threshold:       cfg.Threshold,
+		warmUp:          cfg.WarmUp,
+		topContributors: cfg.TopContributors,
+		services:        make(map[portKey]*serviceBaseline),
+	}
+	if b.interval <= 0 {
+		b.interval = time.Minute
+	}
+	if b.alpha <= 0 || b.alpha >= 1 {
+		b.alpha = baselineDefaultAlpha
+	}
+	if b.threshold <= 0 {
+		b.threshold = baselineDefaultSigma
+	}
+	if b.warmUp <= 0 {
+		b.warmUp = 10
+	}
+	if b.topContributors <= 0 {
+		b.topContributors = 5
+	}
+	return b
+}
+
+// evaluate 用一个周期内合并后的各端口入站流量更新基线，偏离超过阈值时产生异常事件
+func (b *baselineTracker) evaluate(windows map[portKey]*serviceWindow, now time.Time) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	for key := range windows {
+		if _, exists := b.services[key]; !exists && len(b.services) < maxBaselineServices {
+			b.services[key] = &serviceBaseline{metrics: make(map[string]*ewma), last: make(map[string]float64)}
+		}
+	}
+
+	seconds := b.interval.Seconds()
+	for key, service := range b.services {
+		window := windows[key]
+		if window == nil {
+			// 没有流量的周期按0计入基线，长期没有流量的端口不再跟踪
+			if service.idle++; service.idle >= baselineStaleAfter {
+				delete(b.services, key)
+				continue
+			}
+			window = &serviceWindow{}
+		} else {
+			service.idle = 0
+			service.lastSeen = now
+		}
+
+		values := map[string]float64{
+			MetricInboundRate:   float64(window.bytesIn) / seconds,
+			MetricNewFlows:      float64(window.newFlows) / seconds,
+			MetricUniqueSources: float64(window.uniqueSources()),
+		}
+		for _, metric := range []string{MetricInboundRate, MetricNewFlows, MetricUniqueSources} {
+			value := values[metric]
+			e, exists := service.metrics[metric]
+			if !exists {
+				e = &ewma{}
+				service.metrics[metric] = e
+			}
+			if e.samples >= b.warmUp {
+				stddev := e.stddev(baselineMinStd(metric))
+				if deviation := (value - e.mean) / stddev; math.Abs(deviation) > b.threshold {
+					b.raise(key, metric, value, e.mean, stddev, deviation, window, now)
+				}
+			}
+			e.update(value, b.alpha)
+			service.last[metric] = value
+		}
+	}
+}
+
+// baselineMinStd 返回指标标准差的下限
+func baselineMinStd(metric string) float64 {
+	switch metric {
+	case MetricInboundRate:
+		return baselineMinRate
+	case MetricNewFlows:
+		return baselineMinFlows
+	default:
+		return baselineMinSources
+	}
+}
+
+// raise 记录异常事件，调用方需持有锁
+func (b *baselineTracker) raise(key portKey, metric string, value, mean, stddev, deviation float64, window *serviceWindow, now time.Time) {
+	b.nextID++
+	b.anomalies = append(b.anomalies, Anomaly{
+		ID:              b.nextID,
+		Protocol:        key.protocol,
+		Port:            key.port,
+		Metric:          metric,
+		Value:           value,
+		Mean:            mean,
+		StdDev:          stddev,
+		Deviation:       deviation,
+		TopContributors: topContributors(window.sources, metric, b.topContributors),
+		Time:            now,
+	})
+	if len(b.anomalies) > maxAnomalies {
+		b.anomalies = b.anomalies[len(b.anomalies)-maxAnomalies:]
+	}
+
+	slog.Warn("端口流量偏离基线", "protocol", key.protocol, "port", key.port, "metric", metric,
+		"value", value, "mean", mean, "deviation", deviation)
+}
+
+// topContributors 返回贡献最多的n个远程IP，入站速率按字节数排序，其他指标按新建连接数排序
+func topContributors(sources map[string]*contribution, metric string, n int) []AnomalyContributor {
+	result := make([]AnomalyContributor, 0, len(sources))
+	for ip, source := range sources {
+		result = append(result, AnomalyContributor{RemoteIP: ip, Bytes: source.bytes, NewFlows: source.newFlows})
+	}
+	slices.SortFunc(result, func(a, b AnomalyContributor) int {
+		x, y := [2]uint64{a.NewFlows, a.Bytes}, [2]uint64{b.NewFlows, b.Bytes}
+		if metric == MetricInboundRate {
+			x, y = [2]uint64{a.Bytes, a.NewFlows}, [2]uint64{b.Bytes, b.NewFlows}
+		}
+		for i := range x {
+			if x[i] != y[i] {
+				if x[i] > y[i] {
+					return -1
+				}
+				return 1
+			}
+		}
+		return strings.Compare(a.RemoteIP, b.RemoteIP)
+	})
+	if len(result) > n {
+		result = result[:n]
+	}
+	return result
+}
+
+// since 返回ID大于since的异常事件，最新的在前，limit为0时返回全部
+func (b *baselineTracker) since(since uint64, limit int) []Anomaly {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	result := make([]Anomaly, 0)
+	for i := len(b.anomalies) - 1; i >= 0 && b.anomalies[i].ID > since; i-- {
+		if limit > 0 && len(result) >= limit {
+			break
+		}
+		result = append(result, b.anomalies[i])
+	}
+	return result
+}
+
+// baselines 返回各端口当前的基线，按协议和端口排序
+func (b *baselineTracker) baselines() []ServiceBaseline {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	result := make([]ServiceBaseline, 0, len(b.services))
+	for key, service := range b.services {
+		baseline := ServiceBaseline{
+			Protocol: key.protocol,
+			Port:     key.port,
+			Metrics:  make(map[string]BaselineMetric, len(service.metrics)),
+			LastSeen: service.lastSeen,
+		}
+		for metric, e := range service.metrics {
+			baseline.Samples = max(baseline.Samples, e.samples)
+			baseline.Metrics[metric] = BaselineMetric{
+				Mean:   e.mean,
+				StdDev: e.stddev(baselineMinStd(metric)),
+				Last:   service.last[metric],
+			}
+		}
+		result = append(result, baseline)
+	}
+	slices.SortFunc(result, func(a, b ServiceBaseline) int {
+		if c := strings.Compare(a.Protocol, b.Protocol); c != 0 {
+			return c
+		}
+		return int(a.Port) - int(b.Port)
+	})
+	return result
+}
+
+// evaluateBaselines 取出各分片本周期的端口流量，合并后更新基线
+func (m *Monitor) evaluateBaselines() {
+	merged := make(map[portKey]*serviceWindow)
+	for _, shard := range m.shards {
+		shard.mutex.Lock()
+		services := shard.services
+		shard.services = make(map[portKey]*serviceWindow)
+		shard.mutex.Unlock()
+
+		for key, window := range services {
+			total, exists := merged[key]
+			if !exists {
+				merged[key] = window
+				continue
+			}
+			total.merge(window)
+		}
+	}
+	m.baseline.evaluate(merged, time.Now())
+}
+
+// BaselineEnabled 是否启用了按端口的流量基线学习
+func (m *Monitor) BaselineEnabled() bool {
+	return m.baseline != nil
+}
+
+// GetAnomalies 获取ID大于since的异常事件，最新的在前，limit为0时返回全部
+func (m *Monitor) GetAnomalies(since uint64, limit int) []Anomaly {
+	if m.baseline == nil {
+		return []Anomaly{}
+	}
+	return m.baseline.since(since, limit)
+}
+
+// GetBaselines 获取各本地端口当前的流量基线
+func (m *Monitor) GetBaselines() []ServiceBaseline {
+	if m.baseline == nil {
+		return []ServiceBaseline{}
+	}
+	return m.baseline.baselines()
+}
@@ -0,0 +1,176 @@
+package core
+
+import (
+	"fmt"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/google/gopacket/layers"
+	"github.com/graydovee/netbouncer/pkg/config"
+)
+
+func Test_ewma(t *testing.T) {
+	var e ewma
+	for i := 0; i < 200; i++ {
+		// 在90和110之间交替，均值100，标准差10
+		e.update(100+float64(i%2*20-10), 0.1)
+	}
+	if math.Abs(e.mean-100) > 1 {
+		t.Errorf("mean = %v, want ~100", e.mean)
+	}
+	if stddev := math.Sqrt(e.variance); math.Abs(stddev-10) > 1 {
+		t.Errorf("stddev = %v, want ~10", stddev)
+	}
+	if got := e.stddev(50); got != 50 {
+		t.Errorf("stddev(50) = %v, want floor 50", got)
+	}
+}
+
+func Test_baselineTracker_evaluate(t *testing.T) {
+	b := newBaselineTracker(&config.BaselineConfig{Enabled: true, Interval: 60, Threshold: 3, WarmUp: 5, TopContributors: 2})
+	shard := &statsShard{services: make(map[portKey]*serviceWindow)}
+	key := portKey{protocol: ProtocolTCP, port: 443}
+	now := time.Now()
+
+	record := func(remoteIP string, bytes, newFlows uint64) {
+		shard.recordService(trafficSample{
+			remoteIP:    remoteIP,
+			protocol:    layers.IPProtocolTCP,
+			servicePort: key.port,
+			bytes:       bytes,
+			newFlows:    newFlows,
+		})
+	}
+	drain := func() map[portKey]*serviceWindow {
+		windows := shard.services
+		shard.services = make(map[portKey]*serviceWindow)
+		return windows
+	}
+
+	// 学习期：每分钟两个客户端，各1MB
+	for i := 0; i < 10; i++ {
+		record("1.1.1.1", 1<<20, 1)
+		record("2.2.2.2", 1<<20, 1)
+		b.evaluate(drain(), now.Add(time.Duration(i)*time.Minute))
+	}
+	if got := b.since(0, 0); len(got) != 0 {
+		t.Fatalf("anomalies during steady state = %+v", got)
+	}
+
+	// 一个IP突然新建大量连接
+	record("1.1.1.1", 1<<20, 1)
+	record("2.2.2.2", 1<<20, 1)
+	record("3.3.3.3", 1<<10, 3000)
+	b.evaluate(drain(), now.Add(10*time.Minute))
+
+	anomalies := b.since(0, 0)
+	if len(anomalies) != 1 || anomalies[0].Metric != MetricNewFlows || anomalies[0].Deviation <= 3 {
+		t.Fatalf("anomalies = %+v, want one new_flows anomaly", anomalies)
+	}
+	contributors := anomalies[0].TopContributors
+	if len(contributors) != 2 || contributors[0].RemoteIP != "3.3.3.3" {
+		t.Errorf("top contributors = %+v, want 3.3.3.3 first", contributors)
+	}
+
+	baselines := b.baselines()
+	if len(baselines) != 1 || baselines[0].Port != 443 || baselines[0].Samples != 11 {
+		t.Errorf("baselines() = %+v", baselines)
+	}
+}
+
+func Test_serviceWindow_uniqueSources(t *testing.T) {
+	key := portKey{protocol: ProtocolTCP, port: 80}
+	sample := func(remoteIP string) trafficSample {
+		return trafficSample{remoteIP: remoteIP, protocol: layers.IPProtocolTCP, servicePort: key.port, bytes: 100, newFlows: 1}
+	}
+
+	tests := []struct {
+		name    string
+		fill    int // 先记录的不同IP数
+		repeat  int // 之后同一个IP新建的连接数
+		wantMin uint64
+		wantMax uint64
+	}{
+		{name: "一个IP大量连接", fill: 0, repeat: 1000, wantMin: 1, wantMax: 1},
+		{name: "少量IP", fill: 10, repeat: 1000, wantMin: 11, wantMax: 11},
+		{name: "远程IP表已满", fill: maxServiceSources, repeat: 1000, wantMin: maxServiceSources - 3, wantMax: maxServiceSources + 5},
+		{name: "超出部分为估计值", fill: 10000, repeat: 1000, wantMin: 9500, wantMax: 10500},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			shard := &statsShard{services: make(map[portKey]*serviceWindow), heavy: newHeavyHitters(20)}
+			for i := 0; i < tt.fill; i++ {
+				shard.recordService(sample(fmt.Sprintf("10.%d.%d.%d", i>>16&0xff, i>>8&0xff, i&0xff)))
+			}
+			for i := 0; i < tt.repeat; i++ {
+				shard.recordService(sample("203.0.113.1"))
+			}
+			window := shard.services[key]
+			if got := window.uniqueSources(); got < tt.wantMin || got > tt.wantMax {
+				t.Errorf("uniqueSources() = %d, want [%d, %d]", got, tt.wantMin, tt.wantMax)
+			}
+			if window.newFlows != uint64(tt.fill+tt.repeat) {
+				t.Errorf("newFlows = %d, want %d", window.newFlows, tt.fill+tt.repeat)
+			}
+		})
+	}
+}
+
+func Test_baselineTracker_evaluate_uniqueSources(t *testing.T) {
+	b := newBaselineTracker(&config.BaselineConfig{Enabled: true, Interval: 10, Threshold: 3, WarmUp: 5})
+	shard := &statsShard{services: make(map[portKey]*serviceWindow)}
+	for i := 0; i < 500; i++ {
+		shard.recordService(trafficSample{remoteIP: "203.0.113.1", protocol: layers.IPProtocolTCP, servicePort: 22, bytes: 100, newFlows: 1})
+	}
+	shard.recordService(trafficSample{remoteIP: "203.0.113.2", protocol: layers.IPProtocolTCP, servicePort: 22, bytes: 100, newFlows: 1})
+	b.evaluate(shard.services, time.Now())
+
+	baselines := b.baselines()
+	if len(baselines) != 1 {
+		t.Fatalf("baselines() = %+v", baselines)
+	}
+	if got := baselines[0].Metrics[MetricUniqueSources].Last; got != 2 {
+		t.Errorf("unique_sources = %v, want 2", got)
+	}
+}
+
+func Test_serviceWindow_overflow(t *testing.T) {
+	key := portKey{protocol: ProtocolTCP, port: 80}
+	sample := func(remoteIP string, bytes uint64) trafficSample {
+		return trafficSample{remoteIP: remoteIP, protocol: layers.IPProtocolTCP, servicePort: key.port, bytes: bytes, newFlows: 1}
+	}
+	// 两个分片各自填满远程IP表
+	shards := make([]*statsShard, 2)
+	for n := range shards {
+		shards[n] = &statsShard{services: make(map[portKey]*serviceWindow), heavy: newHeavyHitters(20)}
+		for i := 0; i < maxServiceSources*4; i++ {
+			shards[n].recordService(sample(fmt.Sprintf("10.%d.%d.%d", n, i>>8&0xff, i&0xff), 100))
+		}
+	}
+
+	// 表满后普通IP不进入远程IP表，流量排行候选替换贡献最小的IP
+	shards[0].recordService(sample("203.0.113.1", 1<<20))
+	shards[0].heavy.offer("203.0.113.2", 1<<30)
+	shards[0].recordService(sample("203.0.113.2", 1<<20))
+	window := shards[0].services[key]
+	if len(window.sources) != maxServiceSources {
+		t.Fatalf("sources = %d, want %d", len(window.sources), maxServiceSources)
+	}
+	if _, exists := window.sources["203.0.113.1"]; exists {
+		t.Error("non heavy hitter recorded after the table was full")
+	}
+	if _, exists := window.sources["203.0.113.2"]; !exists {
+		t.Error("heavy hitter not recorded after the table was full")
+	}
+
+	// 合并后不同IP数为两个分片的并集
+	window.merge(shards[1].services[key])
+	want := float64(maxServiceSources*8 + 2)
+	if got := float64(window.uniqueSources()); math.Abs(got-want)/want > 0.05 {
+		t.Errorf("merged uniqueSources() = %v, want about %v", got, want)
+	}
+	if len(window.sources) != maxServiceSources*2 {
+		t.Errorf("merged sources = %d, want %d", len(window.sources), maxServiceSources*2)
+	}
+}
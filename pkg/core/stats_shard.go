@@ -0,0 +1,141 @@
+package core
+
+import (
+	"sync"
+
+	"github.com/graydovee/netbouncer/pkg/config"
+)
+
+// statsShardCount 统计分片数量，按远程IP哈希分散锁竞争
+const statsShardCount = 32
+
+// evictionSamples 淘汰时抽样比较的条目数，近似LRU/最少流量而无需维护全局排序
+const evictionSamples = 16
+
+// statsShard 统计分片，每个分片独立加锁，同一远程IP的统计和连接都在同一分片中
+type statsShard struct {
+	mutex     sync.RWMutex
+	stats     map[string]*internalTrafficStats
+	flows     *flowTable
+	limit     int                        // 分片内最多跟踪的远程IP数量，0表示不限制
+	evictions uint64                     // 因超出上限被淘汰的远程IP数量
+	sketch    *countMinSketch            // 所有远程IP（包括未跟踪的）的流量估计
+	heavy     *heavyHitters              // 分片内流量最大的远程IP
+	services  map[portKey]*serviceWindow // 本周期各本地端口的入站流量，用于学习基线
+	histories map[string]struct{}        // 分配了秒级历史的远程IP
+
+	exports       map[exportKey]*exportFlow // 等待导出的单向流
+	exportDropped uint64                    // 等待导出的流达到上限后未记录的流数量
+}
+
+// newStatsShards 创建全部统计分片，maxTracked为所有分片合计的上限
+func newStatsShards(maxTracked int, heavyHitterCount int, newFlows func() *flowTable) []*statsShard {
+	limit := 0
+	if maxTracked > 0 {
+		limit = max((maxTracked+statsShardCount-1)/statsShardCount, 1)
+	}
+	shards := make([]*statsShard, statsShardCount)
+	for i := range shards {
+		shards[i] = &statsShard{
+			stats:     make(map[string]*internalTrafficStats),
+			flows:     newFlows(),
+			limit:     limit,
+			sketch:    &countMinSketch{},
+			heavy:     newHeavyHitters(heavyHitterCount),
+			services:  make(map[portKey]*serviceWindow),
+			histories: make(map[string]struct{}),
+			exports:   make(map[exportKey]*exportFlow),
+		}
+	}
+	return shards
+}
+
+// shardIndex 使用FNV-1a哈希确定远程IP所在的分片
+func shardIndex(remoteIP string) int {
+	hash := uint32(2166136261)
+	for i := 0; i < len(remoteIP); i++ {
+		hash ^= uint32(remoteIP[i])
+		hash *= 16777619
+	}
+	return int(hash % statsShardCount)
+}
+
+// observe 在sketch中累加远程IP的流量并更新heavy hitter候选，调用方需持有写锁
+func (s *statsShard) observe(remoteIP string, bytes uint64) {
+	s.heavy.offer(remoteIP, s.sketch.add(remoteIP, bytes))
+}
+
+// decay sketch和heavy hitter估计值减半，调用方需持有写锁
+func (s *statsShard) decay() {
+	s.sketch.decay()
+	s.heavy.decay()
+}
+
+// full 分片是否已达到跟踪上限
+func (s *statsShard) full() bool {
+	return s.limit > 0 && len(s.stats) >= s.limit
+}
+
+// evict 按策略抽样淘汰一个远程IP，调用方需持有写锁
+func (s *statsShard) evict(policy config.EvictionPolicy) {
+	var victimIP string
+	var victim *internalTrafficStats
+	sampled := 0
+	// map遍历起点随机，取前若干个条目作为样本
+	for ip, stats := range s.stats {
+		if victim == nil || evictBefore(policy, stats, victim) {
+			victimIP, victim = ip, stats
+		}
+		if sampled++; sampled >= evictionSamples {
+			break
+		}
+	}
+	if victim == nil {
+		return
+	}
+
+	s.remove(victimIP, victim)
+	s.evictions++
+}
+
+// remove 删除远程IP的统计，调用方需持有写锁
+func (s *statsShard) remove(remoteIP string, stats *internalTrafficStats) {
+	delete(s.histories, remoteIP)
+	delete(s.stats, remoteIP)
+	s.flows.removeRemote(remoteIP)
+}
+
+// enableSecondsHistory 为远程IP分配秒级历史，调用方需持有写锁
+// 分片内已分配的数量达到上限时，回收一个已不是流量排行候选的IP的秒级历史，没有可回收的则不分配
+func (s *statsShard) enableSecondsHistory(stats *internalTrafficStats, secondsLength int) {
+	if stats.history.seconds != nil {
+		return
+	}
+	if len(s.histories) >= maxSecondsHistories {
+		reclaimed := false
+		for ip := range s.histories {
+			if _, heavy := s.heavy.estimates[ip]; heavy {
+				continue
+			}
+			if victim, exists := s.stats[ip]; exists {
+				victim.history.seconds = nil
+			}
+			delete(s.histories, ip)
+			reclaimed = true
+			break
+		}
+		if !reclaimed {
+			return
+		}
+	}
+	stats.history.enableSeconds(secondsLength)
+	s.histories[stats.remoteIP] = struct{}{}
+}
+
+// evictBefore a是否应当比b先被淘汰
+func evictBefore(policy config.EvictionPolicy, a, b *internalTrafficStats) bool {
+	if policy == config.EvictionPolicyLeastTraffic {
+		return a.bytesSent+a.bytesRecv < b.bytesSent+b.bytesRecv
+	}
+	return a.lastSeen.Before(b.lastSeen)
+}
@@ -0,0 +1,283 @@
+package core
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	maxFingerprints        = 10000 // 全局最多统计的指纹数量
+	maxPendingObservations = 10000 // 等待规则匹配的指纹记录上限，超出的丢弃
+)
+
+// isGREASE 判断是否为RFC 8701保留的GREASE值，计算指纹时忽略
+func isGREASE(value uint16) bool {
+	return value&0x0f0f == 0x0a0a && value>>8 == value&0xff
+}
+
+// withoutGREASE 去掉GREASE值
+func withoutGREASE(values []uint16) []uint16 {
+	result := make([]uint16, 0, len(values))
+	for _, value := range values {
+		if !isGREASE(value) {
+			result = append(result, value)
+		}
+	}
+	return result
+}
+
+// joinUint 将数值列表按十进制以"-"连接
+func joinUint[T uint8 | uint16](values []T) string {
+	parts := make([]string, len(values))
+	for i, value := range values {
+		parts[i] = strconv.Itoa(int(value))
+	}
+	return strings.Join(parts, "-")
+}
+
+// joinHex 将数值列表按4位十六进制以","连接
+func joinHex(values []uint16) string {
+	parts := make([]string, len(values))
+	for i, value := range values {
+		parts[i] = fmt.Sprintf("%04x", value)
+	}
+	return strings.Join(parts, ",")
+}
+
+// ja3String 返回计算JA3哈希前的原始字符串：版本,密码套件,扩展,椭圆曲线,点格式
+func (h *clientHello) ja3String() string {
+	return strings.Join([]string{
+		strconv.Itoa(int(h.version)),
+		joinUint(withoutGREASE(h.cipherSuites)),
+		joinUint(withoutGREASE(h.extensions)),
+		joinUint(withoutGREASE(h.supportedGroups)),
+		joinUint(h.pointFormats),
+	}, ",")
+}
+
+// ja3 计算JA3指纹
+func (h *clientHello) ja3() string {
+	sum := md5.Sum([]byte(h.ja3String()))
+	return hex.EncodeToString(sum[:])
+}
+
+// ja4Version 返回JA4中的TLS版本，优先使用supported_versions中的最高版本
+func (h *clientHello) ja4Version() string {
+	version := h.version
+	if versions := withoutGREASE(h.supportedVersions); len(versions) > 0 {
+		version = slices.Max(versions)
+	}
+	switch version {
+	case 0x0304:
+		return "13"
+	case 0x0303:
+		return "12"
+	case 0x0302:
+		return "11"
+	case 0x0301:
+		return "10"
+	case 0x0300:
+		return "s3"
+	default:
+		return "00"
+	}
+}
+
+// ja4ALPN 返回第一个ALPN协议的首尾字符，非字母数字时使用其十六进制表示的首尾字符
+func (h *clientHello) ja4ALPN() string {
+	if h.alpn == "" {
+		return "00"
+	}
+	first, last := h.alpn[0], h.alpn[len(h.alpn)-1]
+	if isAlphanumeric(first) && isAlphanumeric(last) {
+		return string([]byte{first, last})
+	}
+	encoded := hex.EncodeToString([]byte(h.alpn))
+	return string([]byte{encoded[0], encoded[len(encoded)-1]})
+}
+
+// isAlphanumeric 是否为ASCII字母或数字
+func isAlphanumeric(c byte) bool {
+	return c >= '0' && c <= '9' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+}
+
+// ja4Parts 返回JA4的三段：协议版本等摘要、排序后的密码套件、排序后的扩展和签名算法（后两段为哈希前的原始字符串）
+func (h *clientHello) ja4Parts() (string, string, string) {
+	ciphers := withoutGREASE(h.cipherSuites)
+	extensions := withoutGREASE(h.extensions)
+
+	sni := "i"
+	if h.hasServerName {
+		sni = "d"
+	}
+	a := fmt.Sprintf("t%s%s%02d%02d%s", h.ja4Version(), sni, min(len(ciphers), 99), min(len(extensions), 99), h.ja4ALPN())
+
+	slices.Sort(ciphers)
+	b := joinHex(ciphers)
+
+	// SNI和ALPN已体现在第一段中，不参与扩展排序
+	sorted := make([]uint16, 0, len(extensions))
+	for _, ext := range extensions {
+		if ext != tlsExtServerName && ext != tlsExtALPN {
+			sorted = append(sorted, ext)
+		}
+	}
+	slices.Sort(sorted)
+	c := joinHex(sorted)
+	if len(h.signatureAlgorithms) > 0 {
+		c += "_" + joinHex(withoutGREASE(h.signatureAlgorithms))
+	}
+	return a, b, c
+}
+
+// ja4 计算JA4指纹
+func (h *clientHello) ja4() string {
+	a, b, c := h.ja4Parts()
+	return a + "_" + ja4Hash(b) + "_" + ja4Hash(c)
+}
+
+// ja4Hash 取SHA256的前12个十六进制字符，空列表为全0
+func ja4Hash(value string) string {
+	if value == "" {
+		return "000000000000"
+	}
+	sum := sha256.Sum256([]byte(value))
+	return hex.EncodeToString(sum[:])[:12]
+}
+
+// TLSFingerprint 一对JA3/JA4指纹的全局统计
+type TLSFingerprint struct {
+	JA3       string    `json:"ja3"`
+	JA4       string    `json:"ja4"`
+	Count     uint64    `json:"count"`      // 出现的ClientHello数量
+	RemoteIPs uint64    `json:"remote_ips"` // 出现过该指纹的远程IP数量
+	LastSeen  time.Time `json:"last_seen"`
+}
+
+// FingerprintObservation 某个远程IP首次出现某个指纹，用于匹配指纹规则
+type FingerprintObservation struct {
+	RemoteIP string
+	JA3      string
+	JA4      string
+}
+
+// fingerprintTable 全局指纹统计和等待规则匹配的记录
+type fingerprintTable struct {
+	mutex   sync.Mutex
+	entries map[string]*TLSFingerprint
+	pending []FingerprintObservation
+	dropped uint64 // 因等待队列已满丢弃的记录数
+}
+
+// newFingerprintTable 创建指纹统计表
+func newFingerprintTable() *fingerprintTable {
+	return &fingerprintTable{
+		entries: make(map[string]*TLSFingerprint),
+	}
+}
+
+// observe 记录一次ClientHello，newForIP表示该远程IP最近没有出现过这个指纹
+func (t *fingerprintTable) observe(remoteIP, ja3, ja4 string, newForIP bool, now time.Time) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	key := ja3 + " " + ja4
+	entry, exists := t.entries[key]
+	if !exists {
+		if len(t.entries) >= maxFingerprints {
+			t.evict()
+		}
+		entry = &TLSFingerprint{JA3: ja3, JA4: ja4}
+		t.entries[key] = entry
+	}
+	entry.Count++
+	entry.LastSeen = now
+	if !newForIP {
+		return
+	}
+
+	entry.RemoteIPs++
+	if len(t.pending) >= maxPendingObservations {
+		t.dropped++
+		return
+	}
+	t.pending = append(t.pending, FingerprintObservation{RemoteIP: remoteIP, JA3: ja3, JA4: ja4})
+}
+
+// evict 抽样淘汰出现次数最少的指纹，调用方需持有锁
+func (t *fingerprintTable) evict() {
+	var victimKey string
+	var victim *TLSFingerprint
+	sampled := 0
+	for key, entry := range t.entries {
+		if victim == nil || entry.Count < victim.Count {
+			victimKey, victim = key, entry
+		}
+		if sampled++; sampled >= evictionSamples {
+			break
+		}
+	}
+	if victim != nil {
+		delete(t.entries, victimKey)
+	}
+}
+
+// drain 取出全部等待匹配的记录
+func (t *fingerprintTable) drain() []FingerprintObservation {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	pending := t.pending
+	t.pending = nil
+	return pending
+}
+
+// top 返回出现次数最多的n个指纹
+func (t *fingerprintTable) top(n int) []TLSFingerprint {
+	t.mutex.Lock()
+	result := make([]TLSFingerprint, 0, len(t.entries))
+	for _, entry := range t.entries {
+		result = append(result, *entry)
+	}
+	t.mutex.Unlock()
+
+	slices.SortFunc(result, func(a, b TLSFingerprint) int {
+		if a.Count != b.Count {
+			if a.Count > b.Count {
+				return -1
+			}
+			return 1
+		}
+		return strings.Compare(a.JA4, b.JA4)
+	})
+	if n > 0 && len(result) > n {
+		result = result[:n]
+	}
+	return result
+}
+
+// recordFingerprint 计算ClientHello的指纹并记录到远程IP和全局统计中，调用方需持有分片写锁
+func (m *Monitor) recordFingerprint(stats *internalTrafficStats, hello *clientHello, now time.Time) {
+	ja3, ja4 := hello.ja3(), hello.ja4()
+	newForIP := !slices.Contains(stats.ja3, ja3) || !slices.Contains(stats.ja4, ja4)
+	stats.ja3 = pushRecent(stats.ja3, ja3, recentValuesPerIP)
+	stats.ja4 = pushRecent(stats.ja4, ja4, recentValuesPerIP)
+	m.fingerprints.observe(stats.remoteIP, ja3, ja4, newForIP, now)
+}
+
+// GetFingerprints 获取出现次数最多的TLS客户端指纹，n为0时返回全部
+func (m *Monitor) GetFingerprints(n int) []TLSFingerprint {
+	return m.fingerprints.top(n)
+}
+
+// CollectFingerprintObservations 取出自上次调用以来远程IP首次出现的指纹，用于匹配指纹规则
+func (m *Monitor) CollectFingerprintObservations() []FingerprintObservation {
+	return m.fingerprints.drain()
+}
@@ -0,0 +1,154 @@
+package core
+
+import (
+	"encoding/binary"
+	"regexp"
+	"testing"
+	"time"
+)
+
+// buildClientHello 按给定字段构造一个ClientHello记录
+func buildClientHello(version uint16, ciphers []uint16, extensions [][2][]byte) []byte {
+	u16 := func(v int) []byte { return binary.BigEndian.AppendUint16(nil, uint16(v)) }
+
+	body := u16(int(version))
+	body = append(body, make([]byte, 32)...) // 随机数
+	body = append(body, 0)                   // 会话ID
+	body = append(body, u16(len(ciphers)*2)...)
+	for _, cipher := range ciphers {
+		body = append(body, u16(int(cipher))...)
+	}
+	body = append(body, 1, 0) // 压缩方法
+
+	var exts []byte
+	for _, ext := range extensions {
+		exts = append(exts, ext[0]...)
+		exts = append(exts, u16(len(ext[1]))...)
+		exts = append(exts, ext[1]...)
+	}
+	body = append(body, u16(len(exts))...)
+	body = append(body, exts...)
+
+	handshake := append([]byte{0x01, 0, byte(len(body) >> 8), byte(len(body))}, body...)
+	return append([]byte{0x16, 0x03, 0x01, byte(len(handshake) >> 8), byte(len(handshake))}, handshake...)
+}
+
+func Test_clientHello_fingerprint(t *testing.T) {
+	ext := func(extType uint16, data ...byte) [2][]byte {
+		return [2][]byte{binary.BigEndian.AppendUint16(nil, extType), data}
+	}
+	payload := buildClientHello(0x0303,
+		[]uint16{0x0a0a, 0x1301, 0xc02f, 0x1302},
+		[][2][]byte{
+			ext(0x1a1a),
+			ext(tlsExtServerName, 0, 14, 0, 0, 11, 'e', 'x', 'a', 'm', 'p', 'l', 'e', '.', 'c', 'o', 'm'),
+			ext(tlsExtSupportedGroups, 0, 6, 0x2a, 0x2a, 0, 0x1d, 0, 0x17),
+			ext(tlsExtECPointFormats, 1, 0),
+			ext(tlsExtSignatureAlgorithms, 0, 4, 0x04, 0x03, 0x08, 0x04),
+			ext(tlsExtALPN, 0, 3, 2, 'h', '2'),
+			ext(tlsExtSupportedVersions, 4, 0x3a, 0x3a, 0x03, 0x04),
+		})
+
+	hello, ok := parseClientHello(payload)
+	if !ok {
+		t.Fatal("parseClientHello failed")
+	}
+	if hello.serverName != "example.com" || hello.alpn != "h2" {
+		t.Errorf("serverName = %q, alpn = %q", hello.serverName, hello.alpn)
+	}
+
+	wantJA3 := "771,4865-49199-4866,0-10-11-13-16-43,29-23,0"
+	if got := hello.ja3String(); got != wantJA3 {
+		t.Errorf("ja3String() = %q, want %q", got, wantJA3)
+	}
+
+	a, b, c := hello.ja4Parts()
+	if want := "t13d0306h2"; a != want {
+		t.Errorf("ja4 a = %q, want %q", a, want)
+	}
+	if want := "1301,1302,c02f"; b != want {
+		t.Errorf("ja4 b = %q, want %q", b, want)
+	}
+	if want := "000a,000b,000d,002b_0403,0804"; c != want {
+		t.Errorf("ja4 c = %q, want %q", c, want)
+	}
+	if got := hello.ja4(); !regexp.MustCompile(`^t13d0306h2_[0-9a-f]{12}_[0-9a-f]{12}$`).MatchString(got) {
+		t.Errorf("ja4() = %q", got)
+	}
+	if got := hello.ja3(); len(got) != 32 {
+		t.Errorf("ja3() = %q", got)
+	}
+}
+
+func Test_clientHello_ja4ALPN(t *testing.T) {
+	tests := []struct {
+		alpn string
+		want string
+	}{
+		{alpn: "", want: "00"},
+		{alpn: "h2", want: "h2"},
+		{alpn: "http/1.1", want: "h1"},
+		{alpn: "h", want: "hh"},
+		{alpn: "\xabh2", want: "a2"},
+	}
+	for _, tt := range tests {
+		hello := &clientHello{alpn: tt.alpn}
+		if got := hello.ja4ALPN(); got != tt.want {
+			t.Errorf("ja4ALPN(%q) = %q, want %q", tt.alpn, got, tt.want)
+		}
+	}
+}
+
+func Test_fingerprintTable(t *testing.T) {
+	table := newFingerprintTable()
+	now := time.Now()
+	table.observe("1.1.1.1", "ja3-a", "ja4-a", true, now)
+	table.observe("1.1.1.1", "ja3-a", "ja4-a", false, now)
+	table.observe("2.2.2.2", "ja3-a", "ja4-a", true, now)
+	table.observe("3.3.3.3", "ja3-b", "ja4-b", true, now)
+
+	top := table.top(0)
+	if len(top) != 2 || top[0].JA4 != "ja4-a" || top[0].Count != 3 || top[0].RemoteIPs != 2 {
+		t.Errorf("top() = %+v", top)
+	}
+	if pending := table.drain(); len(pending) != 3 {
+		t.Errorf("drain() = %+v, want 3 observations", pending)
+	}
+	if pending := table.drain(); len(pending) != 0 {
+		t.Errorf("second drain() = %+v, want empty", pending)
+	}
+}
+
+func Test_inspectPayload_truncatedClientHello(t *testing.T) {
+	ext := func(extType uint16, data ...byte) [2][]byte {
+		return [2][]byte{binary.BigEndian.AppendUint16(nil, extType), data}
+	}
+	payload := buildClientHello(0x0303, []uint16{0x1301, 0x1302},
+		[][2][]byte{
+			ext(tlsExtServerName, 0, 14, 0, 0, 11, 'e', 'x', 'a', 'm', 'p', 'l', 'e', '.', 'c', 'o', 'm'),
+			ext(tlsExtSupportedGroups, 0, 4, 0, 0x1d, 0, 0x17),
+			ext(tlsExtSupportedVersions, 2, 0x03, 0x04),
+		})
+
+	// 截断在最后一个扩展中间，SNI仍可提取但不计算指纹
+	truncated := payload[:len(payload)-2]
+	hello, ok := parseClientHello(truncated)
+	if !ok || !hello.truncated || hello.serverName != "example.com" {
+		t.Fatalf("parseClientHello() = %+v, %v, want truncated hello with server name", hello, ok)
+	}
+	if hello, ok := parseClientHello(payload); !ok || hello.truncated {
+		t.Fatalf("parseClientHello() complete hello truncated = %v, %v", hello.truncated, ok)
+	}
+
+	m := &Monitor{fingerprints: newFingerprintTable()}
+	stats := &internalTrafficStats{remoteIP: "203.0.113.5"}
+	m.inspectPayload(stats, truncated, time.Now())
+	if len(stats.tlsServerNames) != 1 || len(stats.ja3) != 0 || len(stats.ja4) != 0 || len(m.fingerprints.top(0)) != 0 {
+		t.Errorf("truncated hello: server names %v, ja3 %v, ja4 %v, fingerprints %v", stats.tlsServerNames, stats.ja3, stats.ja4, m.fingerprints.top(0))
+	}
+
+	m.inspectPayload(stats, payload, time.Now())
+	if len(stats.ja3) != 1 || len(stats.ja4) != 1 || len(m.fingerprints.top(0)) != 1 {
+		t.Errorf("complete hello: ja3 %v, ja4 %v", stats.ja3, stats.ja4)
+	}
+}
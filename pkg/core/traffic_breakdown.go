@@ -0,0 +1,248 @@
+package core
+
+import (
+	"sort"
+	"time"
+
+	"github.com/google/gopacket/layers"
+)
+
+const (
+	ProtocolTCP   = "tcp"
+	ProtocolUDP   = "udp"
+	ProtocolICMP  = "icmp"
+	ProtocolOther = "other"
+)
+
+// protocolName 将IP协议号归类为tcp/udp/icmp/other
+func protocolName(protocol layers.IPProtocol) string {
+	switch protocol {
+	case layers.IPProtocolTCP:
+		return ProtocolTCP
+	case layers.IPProtocolUDP:
+		return ProtocolUDP
+	case layers.IPProtocolICMPv4, layers.IPProtocolICMPv6:
+		return ProtocolICMP
+	default:
+		return ProtocolOther
+	}
+}
+
+// icmpMessage 需要单独计数的ICMP消息类型
+type icmpMessage int
+
+const (
+	icmpOther       icmpMessage = iota // 其他ICMP消息或非ICMP包
+	icmpEchoRequest                    // 回显请求（ping）
+	icmpUnreachable                    // 目的不可达
+)
+
+// icmpv4Message 返回ICMPv4消息类型
+func icmpv4Message(icmp *layers.ICMPv4) icmpMessage {
+	switch icmp.TypeCode.Type() {
+	case layers.ICMPv4TypeEchoRequest:
+		return icmpEchoRequest
+	case layers.ICMPv4TypeDestinationUnreachable:
+		return icmpUnreachable
+	default:
+		return icmpOther
+	}
+}
+
+// icmpv6Message 返回ICMPv6消息类型
+func icmpv6Message(icmp *layers.ICMPv6) icmpMessage {
+	switch icmp.TypeCode.Type() {
+	case layers.ICMPv6TypeEchoRequest:
+		return icmpEchoRequest
+	case layers.ICMPv6TypeDestinationUnreachable:
+		return icmpUnreachable
+	default:
+		return icmpOther
+	}
+}
+
+// ProtocolStats 按协议划分的流量统计
+type ProtocolStats struct {
+	Protocol    string `json:"protocol"`
+	BytesSent   uint64 `json:"bytes_sent"`
+	BytesRecv   uint64 `json:"bytes_recv"`
+	PacketsSent uint64 `json:"packets_sent"`
+	PacketsRecv uint64 `json:"packets_recv"`
+}
+
+// PortStats 按本地服务端口划分的流量统计
+type PortStats struct {
+	Port        uint16 `json:"port"`
+	Protocol    string `json:"protocol"`
+	BytesSent   uint64 `json:"bytes_sent"`
+	BytesRecv   uint64 `json:"bytes_recv"`
+	PacketsSent uint64 `json:"packets_sent"`
+	PacketsRecv uint64 `json:"packets_recv"`
+}
+
+// LocalStats 远程IP与某个本地IP之间的流量统计
+type LocalStats struct {
+	LocalIP     string    `json:"local_ip"`
+	BytesSent   uint64    `json:"bytes_sent"`
+	BytesRecv   uint64    `json:"bytes_recv"`
+	PacketsSent uint64    `json:"packets_sent"`
+	PacketsRecv uint64    `json:"packets_recv"`
+	FirstSeen   time.Time `json:"first_seen"`
+	LastSeen    time.Time `json:"last_seen"`
+}
+
+// PeerStats 某个本地IP上的一个远程对端，用于按本地IP反查
+type PeerStats struct {
+	RemoteIP string `json:"remote_ip"`
+	LocalStats
+}
+
+// TrafficDelta 远程IP在一段时间内新增的流量
+type TrafficDelta struct {
+	RemoteIP    string `json:"remote_ip"`
+	BytesSent   uint64 `json:"bytes_sent"`
+	BytesRecv   uint64 `json:"bytes_recv"`
+	PacketsSent uint64 `json:"packets_sent"`
+	PacketsRecv uint64 `json:"packets_recv"`
+}
+
+// trafficCounters 双向字节数和包数计数
+type trafficCounters struct {
+	bytesSent   uint64
+	bytesRecv   uint64
+	packetsSent uint64
+	packetsRecv uint64
+}
+
+// add 累加一次流量
+func (c *trafficCounters) add(bytes uint64, packets uint64, isSent bool) {
+	if isSent {
+		c.bytesSent += bytes
+		c.packetsSent += packets
+	} else {
+		c.bytesRecv += bytes
+		c.packetsRecv += packets
+	}
+}
+
+// total 返回双向总字节数
+func (c *trafficCounters) total() uint64 {
+	return c.bytesSent + c.bytesRecv
+}
+
+// localTrafficStats 远程IP与某个本地IP之间的计数
+type localTrafficStats struct {
+	trafficCounters
+	firstSeen time.Time
+	lastSeen  time.Time
+}
+
+// toLocalStats 转换为对外暴露的统计
+func (l *localTrafficStats) toLocalStats(localIP string) LocalStats {
+	return LocalStats{
+		LocalIP:     localIP,
+		BytesSent:   l.bytesSent,
+		BytesRecv:   l.bytesRecv,
+		PacketsSent: l.packetsSent,
+		PacketsRecv: l.packetsRecv,
+		FirstSeen:   l.firstSeen,
+		LastSeen:    l.lastSeen,
+	}
+}
+
+// localStats 将各本地IP的计数转换为对外暴露的统计，按流量排序
+func localStats(locals map[string]*localTrafficStats) []LocalStats {
+	result := make([]LocalStats, 0, len(locals))
+	for localIP, local := range locals {
+		result = append(result, local.toLocalStats(localIP))
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].BytesSent+result[i].BytesRecv > result[j].BytesSent+result[j].BytesRecv
+	})
+	return result
+}
+
+// portKey 本地服务端口
+type portKey struct {
+	protocol string
+	port     uint16
+}
+
+// portCounters 有上限的端口计数表，超过上限时淘汰流量最小的端口
+type portCounters struct {
+	limit    int
+	counters map[portKey]*trafficCounters
+}
+
+// newPortCounters 创建端口计数表
+func newPortCounters(limit int) *portCounters {
+	return &portCounters{
+		limit:    limit,
+		counters: make(map[portKey]*trafficCounters),
+	}
+}
+
+// add 累加某个端口的流量
+func (pc *portCounters) add(key portKey, bytes uint64, packets uint64, isSent bool) {
+	counter, exists := pc.counters[key]
+	if !exists {
+		if len(pc.counters) >= pc.limit {
+			pc.evictSmallest()
+		}
+		counter = &trafficCounters{}
+		pc.counters[key] = counter
+	}
+	counter.add(bytes, packets, isSent)
+}
+
+// evictSmallest 淘汰流量最小的端口，为新端口腾出位置
+func (pc *portCounters) evictSmallest() {
+	var smallestKey portKey
+	var smallest *trafficCounters
+	for key, counter := range pc.counters {
+		if smallest == nil || counter.total() < smallest.total() {
+			smallestKey, smallest = key, counter
+		}
+	}
+	delete(pc.counters, smallestKey)
+}
+
+// top 返回流量最大的n个端口
+func (pc *portCounters) top(n int) []PortStats {
+	result := make([]PortStats, 0, len(pc.counters))
+	for key, counter := range pc.counters {
+		result = append(result, PortStats{
+			Port:        key.port,
+			Protocol:    key.protocol,
+			BytesSent:   counter.bytesSent,
+			BytesRecv:   counter.bytesRecv,
+			PacketsSent: counter.packetsSent,
+			PacketsRecv: counter.packetsRecv,
+		})
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].BytesSent+result[i].BytesRecv > result[j].BytesSent+result[j].BytesRecv
+	})
+	if len(result) > n {
+		result = result[:n]
+	}
+	return result
+}
+
+// protocolStats 将按协议的计数转换为对外暴露的统计，按协议名排序
+func protocolStats(counters map[string]*trafficCounters) []ProtocolStats {
+	result := make([]ProtocolStats, 0, len(counters))
+	for protocol, counter := range counters {
+		result = append(result, ProtocolStats{
+			Protocol:    protocol,
+			BytesSent:   counter.bytesSent,
+			BytesRecv:   counter.bytesRecv,
+			PacketsSent: counter.packetsSent,
+			PacketsRecv: counter.packetsRecv,
+		})
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Protocol < result[j].Protocol
+	})
+	return result
+}
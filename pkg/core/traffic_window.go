@@ -0,0 +1,91 @@
+package core
+
+import "time"
+
+// trafficWindow 基于固定时间桶的环形滑动窗口，更新为O(1)，内存占用与流量大小无关
+// 不带锁，由所属分片的锁保护
+type trafficWindow struct {
+	bucketSize time.Duration  // 单个时间桶的长度
+	buckets    []windowBucket // 环形时间桶
+	start      time.Time      // 首次写入时间，窗口未满时用于计算速率
+}
+
+// windowBucket 时间桶
+type windowBucket struct {
+	slot  int64  // 桶对应的时间序号（时间戳/桶长度），用于判断桶是否过期
+	value uint64 // 桶内累计值
+}
+
+// newTrafficWindow 创建新的流量滑动窗口，窗口大小按桶长度向上取整
+func newTrafficWindow(windowSize time.Duration, bucketSize time.Duration) *trafficWindow {
+	if windowSize <= 0 {
+		windowSize = 30 * time.Second
+	}
+	if bucketSize <= 0 {
+		bucketSize = time.Second
+	}
+	count := int((windowSize + bucketSize - 1) / bucketSize)
+	return &trafficWindow{
+		bucketSize: bucketSize,
+		buckets:    make([]windowBucket, max(count, 1)),
+	}
+}
+
+// slotOf 返回时间所在的桶序号
+func (tw *trafficWindow) slotOf(now time.Time) int64 {
+	return now.UnixNano() / int64(tw.bucketSize)
+}
+
+// add 在当前时间桶中累加
+func (tw *trafficWindow) add(now time.Time, increment uint64) {
+	if tw.start.IsZero() {
+		tw.start = now
+	}
+
+	slot := tw.slotOf(now)
+	bucket := &tw.buckets[slot%int64(len(tw.buckets))]
+	if bucket.slot != slot {
+		// 桶中是一轮之前的旧数据，直接覆盖
+		bucket.slot = slot
+		bucket.value = 0
+	}
+	bucket.value += increment
+}
+
+// sum 返回窗口内的累计值
+func (tw *trafficWindow) sum(now time.Time) uint64 {
+	current := tw.slotOf(now)
+	oldest := current - int64(len(tw.buckets)) + 1
+
+	var total uint64
+	for _, bucket := range tw.buckets {
+		if bucket.slot >= oldest && bucket.slot <= current {
+			total += bucket.value
+		}
+	}
+	return total
+}
+
+// rate 计算当前速率（每秒），窗口未满时按实际经过的时间计算
+func (tw *trafficWindow) rate(now time.Time) float64 {
+	if tw.start.IsZero() {
+		return 0
+	}
+
+	total := tw.sum(now)
+	if total == 0 {
+		return 0
+	}
+
+	// 窗口覆盖的时长：最早的桶起点到当前时间
+	oldest := tw.slotOf(now) - int64(len(tw.buckets)) + 1
+	covered := now.Sub(time.Unix(0, oldest*int64(tw.bucketSize)))
+	if elapsed := now.Sub(tw.start); elapsed < covered {
+		covered = elapsed
+	}
+	if covered < tw.bucketSize {
+		covered = tw.bucketSize
+	}
+
+	return float64(total) / covered.Seconds()
+}
@@ -0,0 +1,34 @@
+package core
+
+import (
+	"testing"
+	"time"
+)
+
+func Test_trafficWindow_rate(t *testing.T) {
+	start := time.Unix(1700000000, 0)
+
+	tests := []struct {
+		name   string
+		adds   []time.Duration // 相对start的写入时间，每次写入100
+		at     time.Duration   // 相对start的查询时间
+		want   float64
+		window time.Duration
+	}{
+		{name: "empty", at: time.Second, want: 0, window: 10 * time.Second},
+		{name: "not_full", adds: []time.Duration{0, time.Second}, at: 2 * time.Second, want: 100, window: 10 * time.Second},
+		{name: "full", adds: []time.Duration{0, 5 * time.Second, 15 * time.Second}, at: 19 * time.Second, want: 100.0 / 9, window: 10 * time.Second},
+		{name: "expired", adds: []time.Duration{0}, at: 30 * time.Second, want: 0, window: 10 * time.Second},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tw := newTrafficWindow(tt.window, time.Second)
+			for _, d := range tt.adds {
+				tw.add(start.Add(d), 100)
+			}
+			if got := tw.rate(start.Add(tt.at)); got != tt.want {
+				t.Errorf("rate() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
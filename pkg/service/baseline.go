@@ -0,0 +1,30 @@
+package service
+
+// GetAnomalies 获取ID大于since的端口流量异常事件，最新的在前
+func (s *NetService) GetAnomalies(since uint64, limit int) ([]Anomaly, error) {
+	bannedIpNets, allowIpNets, err := s.loadBanIpNets()
+	if err != nil {
+		return nil, err
+	}
+	watchIpNets, err := s.loadWatchIpNets()
+	if err != nil {
+		return nil, err
+	}
+
+	anomalies := s.monitor.GetAnomalies(since, limit)
+	result := make([]Anomaly, 0, len(anomalies))
+	for _, anomaly := range anomalies {
+		result = append(result, convertToAnomaly(anomaly, bannedIpNets, allowIpNets, watchIpNets))
+	}
+	return result, nil
+}
+
+// GetBaselines 获取各本地端口当前学习到的流量基线
+func (s *NetService) GetBaselines() []ServiceBaseline {
+	baselines := s.monitor.GetBaselines()
+	result := make([]ServiceBaseline, 0, len(baselines))
+	for _, baseline := range baselines {
+		result = append(result, convertToServiceBaseline(baseline))
+	}
+	return result
+}
@@ -0,0 +1,43 @@
+package service
+
+import (
+	"time"
+
+	"github.com/graydovee/netbouncer/pkg/core"
+)
+
+var (
+	// ErrCaptureNotFound 抓包任务不存在
+	ErrCaptureNotFound = core.ErrCaptureNotFound
+	// ErrCaptureBusy 同时进行的抓包任务已达上限
+	ErrCaptureBusy = core.ErrCaptureBusy
+)
+
+// StartCapture 开始针对IP或CIDR的按需抓包，duration为秒
+func (s *NetService) StartCapture(target string, duration int, packetLimit int) (PacketCapture, error) {
+	capture, err := s.capturer.Start(target, time.Duration(duration)*time.Second, packetLimit)
+	if err != nil {
+		return PacketCapture{}, err
+	}
+	return convertToPacketCapture(capture), nil
+}
+
+// ListCaptures 获取全部抓包任务，最新的在前
+func (s *NetService) ListCaptures() []PacketCapture {
+	captures := s.capturer.List()
+	result := make([]PacketCapture, 0, len(captures))
+	for _, capture := range captures {
+		result = append(result, convertToPacketCapture(capture))
+	}
+	return result
+}
+
+// CaptureFile 获取已完成的抓包任务的pcap文件路径
+func (s *NetService) CaptureFile(id uint64) (string, error) {
+	return s.capturer.File(id)
+}
+
+// DeleteCapture 删除抓包任务及其文件，正在进行的任务先停止
+func (s *NetService) DeleteCapture(id uint64) error {
+	return s.capturer.Delete(id)
+}
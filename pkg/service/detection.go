@@ -0,0 +1,104 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/graydovee/netbouncer/pkg/config"
+	"github.com/graydovee/netbouncer/pkg/store"
+	"gorm.io/gorm"
+)
+
+// detectionInterval 处理新检测事件的周期
+const detectionInterval = 5 * time.Second
+
+// StartDetectionRoutine 启动定期封禁被检测到端口扫描或SYN洪水的IP的协程
+func (s *NetService) StartDetectionRoutine(cfg *config.DetectionConfig) error {
+	group, err := s.detectionGroup(cfg.AutoBanGroup)
+	if err != nil {
+		return err
+	}
+
+	go func() {
+		ticker := time.NewTicker(detectionInterval)
+		defer ticker.Stop()
+
+		var lastID uint64
+		for range ticker.C {
+			lastID = s.banDetections(lastID, group.ID)
+		}
+	}()
+
+	slog.Info("检测事件自动封禁已启用", "group", group.Name, "interval", detectionInterval)
+	return nil
+}
+
+// detectionGroup 返回自动封禁使用的组，指定的组不存在时创建
+func (s *NetService) detectionGroup(name string) (*store.IpNetGroup, error) {
+	if name == "" {
+		return s.store.IpNetGroupStore.FindDefault()
+	}
+	group, err := s.store.IpNetGroupStore.FindByName(name)
+	if err == nil {
+		return group, nil
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, err
+	}
+	group, err = s.store.IpNetGroupStore.Create(name, "端口扫描和SYN洪水检测自动封禁的IP")
+	if err != nil {
+		return nil, fmt.Errorf("创建自动封禁组失败: %w", err)
+	}
+	return group, nil
+}
+
+// banDetections 封禁ID大于lastID的检测事件中的IP，返回处理到的最大ID
+func (s *NetService) banDetections(lastID uint64, groupId uint) uint64 {
+	detections := s.monitor.GetDetections(lastID, 0)
+	if len(detections) == 0 {
+		return lastID
+	}
+
+	bannedIpNets, allowIpNets, err := s.loadBanIpNets()
+	if err != nil {
+		slog.Error("加载封禁规则失败", "error", err)
+		return lastID
+	}
+
+	// 事件按最新的在前返回，从最早的开始处理
+	for i := len(detections) - 1; i >= 0; i-- {
+		detection := detections[i]
+		ipNet := parseIpNet(detection.RemoteIP)
+		if !shouldAutoBan(ipNet, bannedIpNets, allowIpNets) {
+			continue
+		}
+		if err := s.CreateOrUpdateIpNet(detection.RemoteIP, groupId, store.ActionBan); err != nil {
+			slog.Error("按检测事件封禁失败", "ip", detection.RemoteIP, "type", detection.Type, "error", err)
+			continue
+		}
+		bannedIpNets = append(bannedIpNets, ipNet)
+		slog.Info("按检测事件封禁", "ip", detection.RemoteIP, "type", detection.Type, "value", detection.Value)
+	}
+	return detections[0].ID
+}
+
+// GetDetections 获取ID大于since的检测事件，最新的在前
+func (s *NetService) GetDetections(since uint64, limit int) ([]Detection, error) {
+	bannedIpNets, allowIpNets, err := s.loadBanIpNets()
+	if err != nil {
+		return nil, err
+	}
+	watchIpNets, err := s.loadWatchIpNets()
+	if err != nil {
+		return nil, err
+	}
+
+	detections := s.monitor.GetDetections(since, limit)
+	result := make([]Detection, 0, len(detections))
+	for _, detection := range detections {
+		result = append(result, convertToDetection(detection, GetVerdict(bannedIpNets, allowIpNets, watchIpNets, detection.RemoteIP)))
+	}
+	return result, nil
+}
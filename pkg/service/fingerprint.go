@@ -0,0 +1,224 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/graydovee/netbouncer/pkg/core"
+	"github.com/graydovee/netbouncer/pkg/store"
+	"gorm.io/gorm"
+)
+
+// fingerprintInterval 匹配指纹规则的周期
+const fingerprintInterval = 5 * time.Second
+
+// StartFingerprintRoutine 启动定期将新出现的TLS指纹与指纹规则匹配的协程
+func (s *NetService) StartFingerprintRoutine() {
+	go func() {
+		ticker := time.NewTicker(fingerprintInterval)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			if err := s.applyFingerprintRules(); err != nil {
+				slog.Error("匹配指纹规则失败", "error", err)
+			}
+		}
+	}()
+
+	slog.Info("TLS指纹规则匹配已启用", "interval", fingerprintInterval)
+}
+
+// applyFingerprintRules 处理远程IP新出现的指纹
+func (s *NetService) applyFingerprintRules() error {
+	observations := s.monitor.CollectFingerprintObservations()
+	if len(observations) == 0 {
+		return nil
+	}
+	return s.matchFingerprintRules(observations)
+}
+
+// matchFingerprintRules 将指纹与规则匹配，命中规则的IP记录下来并加入规则的组
+// ban规则同时封禁该IP，flag规则以观察行为加入，不修改防火墙
+func (s *NetService) matchFingerprintRules(observations []core.FingerprintObservation) error {
+	rules, err := s.store.FingerprintRuleStore.FindAll()
+	if err != nil {
+		return err
+	}
+	if len(rules) == 0 {
+		return nil
+	}
+	ja3Rules, ja4Rules := make(map[string]*store.FingerprintRule), make(map[string]*store.FingerprintRule)
+	for i := range rules {
+		if rules[i].Type == store.FingerprintJA3 {
+			ja3Rules[rules[i].Fingerprint] = &rules[i]
+		} else {
+			ja4Rules[rules[i].Fingerprint] = &rules[i]
+		}
+	}
+
+	bannedIpNets, allowIpNets, err := s.loadBanIpNets()
+	if err != nil {
+		return err
+	}
+
+	for _, observation := range observations {
+		// JA3和JA4可能同时命中规则，取最强的动作（ban优先于flag）只写入一次，结果与规则顺序无关
+		var strongest *store.FingerprintRule
+		for _, rule := range []*store.FingerprintRule{ja3Rules[observation.JA3], ja4Rules[observation.JA4]} {
+			if rule == nil {
+				continue
+			}
+			if err := s.store.FingerprintMatchStore.Record(rule.ID, observation.RemoteIP); err != nil {
+				return err
+			}
+			if rule.Action != store.ActionBan {
+				slog.Info("远程IP命中指纹规则", "ip", observation.RemoteIP, "rule", rule.ID, "fingerprint", rule.Fingerprint)
+			}
+			if strongest == nil || (strongest.Action != store.ActionBan && rule.Action == store.ActionBan) {
+				strongest = rule
+			}
+		}
+		if strongest == nil {
+			continue
+		}
+
+		ipNet := parseIpNet(observation.RemoteIP)
+		if !shouldAutoBan(ipNet, bannedIpNets, allowIpNets) {
+			continue
+		}
+		if strongest.Action == store.FingerprintActionFlag {
+			// 已在观察或已有规则的IP不做修改
+			if s.store.IpNetStore.ExistsByIpNet(observation.RemoteIP) {
+				continue
+			}
+			if err := s.CreateOrUpdateIpNet(observation.RemoteIP, strongest.GroupID, store.ActionWatch); err != nil {
+				slog.Error("按指纹规则标记失败", "ip", observation.RemoteIP, "rule", strongest.ID, "error", err)
+			}
+			continue
+		}
+		// 只被观察的IP升级为封禁
+		if err := s.CreateOrUpdateIpNet(observation.RemoteIP, strongest.GroupID, store.ActionBan); err != nil {
+			slog.Error("按指纹规则封禁失败", "ip", observation.RemoteIP, "rule", strongest.ID, "error", err)
+			continue
+		}
+		bannedIpNets = append(bannedIpNets, ipNet)
+		slog.Info("按指纹规则封禁", "ip", observation.RemoteIP, "rule", strongest.ID, "fingerprint", strongest.Fingerprint)
+	}
+	return nil
+}
+
+// GetFingerprints 获取出现次数最多的TLS客户端指纹及匹配的规则
+func (s *NetService) GetFingerprints(limit int) ([]TLSFingerprint, error) {
+	rules, err := s.store.FingerprintRuleStore.FindAll()
+	if err != nil {
+		return nil, err
+	}
+	ruleIDs := make(map[string][]uint)
+	for _, rule := range rules {
+		ruleIDs[rule.Fingerprint] = append(ruleIDs[rule.Fingerprint], rule.ID)
+	}
+
+	fingerprints := s.monitor.GetFingerprints(limit)
+	result := make([]TLSFingerprint, 0, len(fingerprints))
+	for _, fp := range fingerprints {
+		result = append(result, convertToTLSFingerprint(fp, append(ruleIDs[fp.JA3], ruleIDs[fp.JA4]...)))
+	}
+	return result, nil
+}
+
+// ListFingerprintRules 获取全部指纹规则
+func (s *NetService) ListFingerprintRules() ([]FingerprintRule, error) {
+	rules, err := s.store.FingerprintRuleStore.FindAll()
+	if err != nil {
+		return nil, err
+	}
+	groups, err := s.store.IpNetGroupStore.FindAll()
+	if err != nil {
+		return nil, err
+	}
+	groupMap := make(map[uint]IpGroup, len(groups))
+	for _, group := range groups {
+		groupMap[group.ID] = convertToIpNetGroup(&group)
+	}
+
+	result := make([]FingerprintRule, 0, len(rules))
+	for _, rule := range rules {
+		var group *IpGroup
+		if g, ok := groupMap[rule.GroupID]; ok {
+			group = &g
+		}
+		result = append(result, convertToFingerprintRule(&rule, group))
+	}
+	return result, nil
+}
+
+// CreateFingerprintRule 创建指纹规则，未指定组时使用默认组
+func (s *NetService) CreateFingerprintRule(fingerprintType, fingerprint, action string, groupId uint, description string) (FingerprintRule, error) {
+	if action != store.ActionBan && action != store.FingerprintActionFlag {
+		return FingerprintRule{}, fmt.Errorf("不支持的指纹规则动作: %s", action)
+	}
+
+	var group *store.IpNetGroup
+	var err error
+	if groupId == 0 {
+		group, err = s.store.IpNetGroupStore.FindDefault()
+	} else {
+		group, err = s.store.IpNetGroupStore.FindByID(groupId)
+	}
+	if err != nil {
+		return FingerprintRule{}, fmt.Errorf("指定的组不存在: %w", err)
+	}
+
+	rule, err := s.store.FingerprintRuleStore.Create(fingerprintType, fingerprint, action, group.ID, description)
+	if err != nil {
+		return FingerprintRule{}, fmt.Errorf("创建指纹规则失败: %w", err)
+	}
+	g := convertToIpNetGroup(group)
+	return convertToFingerprintRule(rule, &g), nil
+}
+
+// DeleteFingerprintRule 删除指纹规则，已封禁或标记的IP保留在组中
+func (s *NetService) DeleteFingerprintRule(id uint) error {
+	if err := s.store.FingerprintRuleStore.DeleteByID(id); err != nil {
+		return fmt.Errorf("删除指纹规则失败: %w", err)
+	}
+	return nil
+}
+
+// ListFingerprintMatches 获取命中某条指纹规则的远程IP
+func (s *NetService) ListFingerprintMatches(ruleId uint) ([]FingerprintMatch, error) {
+	if _, err := s.store.FingerprintRuleStore.FindByID(ruleId); err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, fmt.Errorf("指纹规则不存在: %w", err)
+		}
+		return nil, err
+	}
+
+	matches, err := s.store.FingerprintMatchStore.FindByRuleID(ruleId)
+	if err != nil {
+		return nil, err
+	}
+	bannedIpNets, allowIpNets, err := s.loadBanIpNets()
+	if err != nil {
+		return nil, err
+	}
+	watchIpNets, err := s.loadWatchIpNets()
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]FingerprintMatch, 0, len(matches))
+	for _, match := range matches {
+		verdict := GetVerdict(bannedIpNets, allowIpNets, watchIpNets, match.RemoteIP)
+		result = append(result, FingerprintMatch{
+			RemoteIP:  match.RemoteIP,
+			FirstSeen: match.CreatedAt.Format(time.RFC3339),
+			LastSeen:  match.UpdatedAt.Format(time.RFC3339),
+			IsBanned:  verdict == VerdictBan,
+			Verdict:   verdict,
+		})
+	}
+	return result, nil
+}
@@ -0,0 +1,152 @@
+package service
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/graydovee/netbouncer/pkg/config"
+	"github.com/graydovee/netbouncer/pkg/core"
+	"github.com/graydovee/netbouncer/pkg/store"
+)
+
+func Test_NetService_matchFingerprintRules_flag(t *testing.T) {
+	st, err := store.NewStore(&config.DatabaseConfig{Driver: "sqlite", Database: filepath.Join(t.TempDir(), "test.db"), LogLevel: "silent"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	group, err := st.IpNetGroupStore.Create("scanners", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rule, err := st.FingerprintRuleStore.Create(store.FingerprintJA4, "t13d1516h2_8daaf6152771_02713d6af862", store.FingerprintActionFlag, group.ID, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 已放行的IP不会被加入组
+	if _, err := st.IpNetStore.Create("198.51.100.0/24", group.ID, store.ActionAllow); err != nil {
+		t.Fatal(err)
+	}
+
+	// flag规则只使用观察行为，不需要防火墙
+	s := &NetService{store: st}
+	err = s.matchFingerprintRules([]core.FingerprintObservation{
+		{RemoteIP: "203.0.113.5", JA4: rule.Fingerprint},
+		{RemoteIP: "198.51.100.7", JA4: rule.Fingerprint},
+		{RemoteIP: "203.0.113.6", JA4: "t13d0000h2_000000000000_000000000000"},
+	})
+	if err != nil {
+		t.Fatalf("matchFingerprintRules() error = %v", err)
+	}
+
+	ipNets, err := st.IpNetStore.FindByGroupID(group.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ipNets) != 2 || ipNets[1].IpNet != "203.0.113.5" || ipNets[1].Action != store.ActionWatch {
+		t.Fatalf("group ip nets = %+v, want allow rule and 203.0.113.5 watched", ipNets)
+	}
+	matches, err := st.FingerprintMatchStore.FindByRuleID(rule.ID)
+	if err != nil || len(matches) != 2 {
+		t.Errorf("matches = %d, error %v, want 2", len(matches), err)
+	}
+}
+
+// newTestNetService 创建使用临时sqlite数据库和mock防火墙的服务
+func newTestNetService(t *testing.T) *NetService {
+	t.Helper()
+	st, err := store.NewStore(&config.DatabaseConfig{Driver: "sqlite", Database: filepath.Join(t.TempDir(), "test.db"), LogLevel: "silent"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &NetService{store: st, firewall: core.NewFirewall(&core.MockFirewallCore{})}
+}
+
+func Test_NetService_autoBanWatched(t *testing.T) {
+	s := newTestNetService(t)
+	group, err := s.store.IpNetGroupStore.Create("watched", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, ip := range []string{"203.0.113.5", "203.0.113.6"} {
+		if _, err := s.store.IpNetStore.Create(ip, group.ID, store.ActionWatch); err != nil {
+			t.Fatal(err)
+		}
+	}
+	action := func(ip string) string {
+		t.Helper()
+		ipNet, err := s.store.IpNetStore.FindByIpNet(ip)
+		if err != nil {
+			t.Fatalf("FindByIpNet(%s) error = %v", ip, err)
+		}
+		return ipNet.Action
+	}
+
+	// 命中ban指纹规则的观察IP升级为封禁
+	rule, err := s.store.FingerprintRuleStore.Create(store.FingerprintJA4, "t13d1516h2_8daaf6152771_02713d6af862", store.ActionBan, group.ID, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.matchFingerprintRules([]core.FingerprintObservation{{RemoteIP: "203.0.113.5", JA4: rule.Fingerprint}}); err != nil {
+		t.Fatalf("matchFingerprintRules() error = %v", err)
+	}
+	if got := action("203.0.113.5"); got != store.ActionBan {
+		t.Errorf("fingerprint: action = %s, want ban", got)
+	}
+
+	// 超出ban配额的观察IP升级为封禁，周期结束时恢复为观察
+	now := time.Now()
+	quota := &store.QuotaRule{ID: 1, Target: "203.0.113.0/24", PerIP: true, Budget: 1, Period: store.QuotaPeriodDay, Action: store.ActionBan, GroupID: group.ID}
+	violation, err := s.enforceQuota(quotaExceeded{rule: quota, key: "203.0.113.6", usage: 2, start: now, end: now.Add(time.Hour)}, nil, nil)
+	if err != nil {
+		t.Fatalf("enforceQuota() error = %v", err)
+	}
+	if !violation.Applied || !violation.Watched || action("203.0.113.6") != store.ActionBan {
+		t.Fatalf("quota: violation = %+v, action = %s, want applied ban", violation, action("203.0.113.6"))
+	}
+	if err := s.releaseQuotaViolation(violation); err != nil {
+		t.Fatalf("releaseQuotaViolation() error = %v", err)
+	}
+	if got := action("203.0.113.6"); got != store.ActionWatch {
+		t.Errorf("quota release: action = %s, want watch", got)
+	}
+}
+
+func Test_NetService_matchFingerprintRules_strongest(t *testing.T) {
+	s := newTestNetService(t)
+	flagGroup, err := s.store.IpNetGroupStore.Create("flagged", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	banGroup, err := s.store.IpNetGroupStore.Create("banned", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ja3, err := s.store.FingerprintRuleStore.Create(store.FingerprintJA3, "cd08e31494f9531f560d64c695473da9", store.FingerprintActionFlag, flagGroup.ID, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ja4, err := s.store.FingerprintRuleStore.Create(store.FingerprintJA4, "t13d1516h2_8daaf6152771_02713d6af862", store.ActionBan, banGroup.ID, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 同一个ClientHello的JA3命中flag规则，JA4命中ban规则
+	err = s.matchFingerprintRules([]core.FingerprintObservation{{RemoteIP: "203.0.113.5", JA3: ja3.Fingerprint, JA4: ja4.Fingerprint}})
+	if err != nil {
+		t.Fatalf("matchFingerprintRules() error = %v", err)
+	}
+
+	ipNet, err := s.store.IpNetStore.FindByIpNet("203.0.113.5")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ipNet.Action != store.ActionBan || ipNet.GroupID != banGroup.ID {
+		t.Errorf("ip net = %+v, want banned in group %d", ipNet, banGroup.ID)
+	}
+	for _, rule := range []*store.FingerprintRule{ja3, ja4} {
+		if matches, err := s.store.FingerprintMatchStore.FindByRuleID(rule.ID); err != nil || len(matches) != 1 {
+			t.Errorf("rule %d matches = %d, error %v, want 1", rule.ID, len(matches), err)
+		}
+	}
+}
@@ -5,15 +5,23 @@ import (
 	"regexp"
 	"time"
 
+	"github.com/graydovee/netbouncer/pkg/core"
 	"github.com/graydovee/netbouncer/pkg/store"
 )
 
 func parseIpNet(ipNet string) *net.IPNet {
 	// 首先尝试解析为IP地址
 	if ip := net.ParseIP(ipNet); ip != nil {
+		if ip4 := ip.To4(); ip4 != nil {
+			return &net.IPNet{
+				IP:   ip4,
+				Mask: net.CIDRMask(32, 32),
+			}
+		}
+		// IPv6地址使用/128，否则会被当作覆盖整个/32的网段
 		return &net.IPNet{
 			IP:   ip,
-			Mask: net.CIDRMask(32, 32),
+			Mask: net.CIDRMask(128, 128),
 		}
 	}
 
@@ -36,6 +44,250 @@ func convertToIpNet(ipNet ...store.IpNet) []*net.IPNet {
 	return ipNets
 }
 
+func convertToTrafficData(stat *core.TrafficStats, verdict Verdict) TrafficData {
+	var processes []ProcessTraffic
+	for _, p := range stat.Processes {
+		processes = append(processes, ProcessTraffic{
+			PID:             p.PID,
+			Name:            p.Name,
+			Cgroup:          p.Cgroup,
+			ContainerID:     p.ContainerID,
+			TotalBytesIn:    p.BytesRecv,
+			TotalBytesOut:   p.BytesSent,
+			TotalPacketsIn:  p.PacketsRecv,
+			TotalPacketsOut: p.PacketsSent,
+			LastSeen:        p.LastSeen.Format(time.RFC3339),
+		})
+	}
+
+	return TrafficData{
+		RemoteIP:         stat.RemoteIP,
+		LocalIP:          stat.LocalIP,
+		LocalIPs:         stat.LocalIPs,
+		TotalBytesIn:     stat.BytesRecv,
+		TotalBytesOut:    stat.BytesSent,
+		TotalPacketsIn:   stat.PacketsRecv,
+		TotalPacketsOut:  stat.PacketsSent,
+		BytesInPerSec:    stat.BytesRecvPerSec,
+		BytesOutPerSec:   stat.BytesSentPerSec,
+		Connections:      stat.Connections,
+		TCPFlows:         stat.TCPFlows,
+		UDPFlows:         stat.UDPFlows,
+		NewFlowsPerSec:   stat.NewFlowsPerSec,
+		ICMPPackets:      stat.ICMPPackets,
+		ICMPEchoRequests: stat.ICMPEchoRequests,
+		ICMPUnreachables: stat.ICMPUnreachables,
+		OtherPackets:     stat.OtherPackets,
+		OtherBytes:       stat.OtherBytes,
+		DomainNames:      stat.DomainNames,
+		TLSServerNames:   stat.TLSServerNames,
+		HTTPHosts:        stat.HTTPHosts,
+		JA3:              stat.JA3,
+		JA4:              stat.JA4,
+		Processes:        processes,
+		Sampled:          stat.Sampled,
+		SampleRate:       stat.SampleRate,
+		FirstSeen:        stat.FirstSeen.Format(time.RFC3339),
+		LastSeen:         stat.LastSeen.Format(time.RFC3339),
+		IsBanned:         verdict == VerdictBan,
+		Verdict:          verdict,
+	}
+}
+
+func convertToTrafficDetail(detail *core.TrafficDetail, verdict Verdict) *TrafficDetail {
+	protocols := make([]ProtocolTraffic, 0, len(detail.Protocols))
+	for _, p := range detail.Protocols {
+		protocols = append(protocols, ProtocolTraffic{
+			Protocol:        p.Protocol,
+			TotalBytesIn:    p.BytesRecv,
+			TotalBytesOut:   p.BytesSent,
+			TotalPacketsIn:  p.PacketsRecv,
+			TotalPacketsOut: p.PacketsSent,
+		})
+	}
+
+	ports := make([]PortTraffic, 0, len(detail.TopPorts))
+	for _, p := range detail.TopPorts {
+		ports = append(ports, PortTraffic{
+			Port:            p.Port,
+			Protocol:        p.Protocol,
+			TotalBytesIn:    p.BytesRecv,
+			TotalBytesOut:   p.BytesSent,
+			TotalPacketsIn:  p.PacketsRecv,
+			TotalPacketsOut: p.PacketsSent,
+		})
+	}
+
+	locals := make([]LocalTraffic, 0, len(detail.Locals))
+	for _, l := range detail.Locals {
+		locals = append(locals, convertToLocalTraffic(&l))
+	}
+
+	return &TrafficDetail{
+		TrafficData: convertToTrafficData(&detail.TrafficStats, verdict),
+		Locals:      locals,
+		Protocols:   protocols,
+		TopPorts:    ports,
+	}
+}
+
+func convertToRatePoints(points []core.RatePoint) []RatePoint {
+	result := make([]RatePoint, 0, len(points))
+	for _, p := range points {
+		result = append(result, RatePoint{
+			Time:           p.Time.Format(time.RFC3339),
+			BytesInPerSec:  p.BytesRecvPerSec,
+			BytesOutPerSec: p.BytesSentPerSec,
+		})
+	}
+	return result
+}
+
+func convertToCaptureHealth(h core.CaptureHealth) CaptureHealth {
+	health := CaptureHealth{
+		Source:           h.Source,
+		StatsSupported:   h.StatsSupported,
+		PacketsReceived:  h.PacketsReceived,
+		PacketsDropped:   h.PacketsDropped,
+		PacketsIfDropped: h.PacketsIfDropped,
+		DropRatio:        h.DropRatio,
+		Processed:        h.Processed,
+		AvgLatencyMs:     h.AvgLatencyMs,
+		MaxLatencyMs:     h.MaxLatencyMs,
+		Warning:          h.Warning,
+	}
+	if !h.SampledAt.IsZero() {
+		health.SampledAt = h.SampledAt.Format(time.RFC3339)
+	}
+	return health
+}
+
+func convertToDetection(d core.Detection, verdict Verdict) Detection {
+	return Detection{
+		ID:        d.ID,
+		Type:      d.Type,
+		RemoteIP:  d.RemoteIP,
+		Value:     d.Value,
+		Threshold: d.Threshold,
+		Time:      d.Time.Format(time.RFC3339),
+		IsBanned:  verdict == VerdictBan,
+		Verdict:   verdict,
+	}
+}
+
+func convertToAnomaly(a core.Anomaly, bannedIpNets, allowIpNets, watchIpNets []*net.IPNet) Anomaly {
+	contributors := make([]AnomalyContributor, 0, len(a.TopContributors))
+	for _, c := range a.TopContributors {
+		verdict := GetVerdict(bannedIpNets, allowIpNets, watchIpNets, c.RemoteIP)
+		contributors = append(contributors, AnomalyContributor{
+			RemoteIP: c.RemoteIP,
+			Bytes:    c.Bytes,
+			NewFlows: c.NewFlows,
+			IsBanned: verdict == VerdictBan,
+			Verdict:  verdict,
+		})
+	}
+	return Anomaly{
+		ID:              a.ID,
+		Protocol:        a.Protocol,
+		Port:            a.Port,
+		Metric:          a.Metric,
+		Value:           a.Value,
+		Mean:            a.Mean,
+		StdDev:          a.StdDev,
+		Deviation:       a.Deviation,
+		TopContributors: contributors,
+		Time:            a.Time.Format(time.RFC3339),
+	}
+}
+
+func convertToServiceBaseline(b core.ServiceBaseline) ServiceBaseline {
+	metrics := make(map[string]BaselineMetric, len(b.Metrics))
+	for name, m := range b.Metrics {
+		metrics[name] = BaselineMetric{Mean: m.Mean, StdDev: m.StdDev, Last: m.Last}
+	}
+	baseline := ServiceBaseline{
+		Protocol: b.Protocol,
+		Port:     b.Port,
+		Samples:  b.Samples,
+		Metrics:  metrics,
+	}
+	if !b.LastSeen.IsZero() {
+		baseline.LastSeen = b.LastSeen.Format(time.RFC3339)
+	}
+	return baseline
+}
+
+func convertToPacketCapture(c core.PacketCapture) PacketCapture {
+	capture := PacketCapture{
+		ID:          c.ID,
+		Target:      c.Target,
+		Filter:      c.Filter,
+		Duration:    c.Duration,
+		PacketLimit: c.PacketLimit,
+		Packets:     c.Packets,
+		FileSize:    c.FileSize,
+		Truncated:   c.Truncated,
+		Status:      c.Status,
+		Error:       c.Error,
+		StartedAt:   c.StartedAt.Format(time.RFC3339),
+	}
+	if !c.FinishedAt.IsZero() {
+		capture.FinishedAt = c.FinishedAt.Format(time.RFC3339)
+	}
+	return capture
+}
+
+func convertToQuotaRule(rule *store.QuotaRule, group *IpGroup, start, end time.Time, used uint64, exceeded int) QuotaRule {
+	return QuotaRule{
+		ID:          rule.ID,
+		Target:      rule.Target,
+		PerIP:       rule.PerIP,
+		Budget:      rule.Budget,
+		Period:      rule.Period,
+		Action:      rule.Action,
+		LimitRate:   rule.LimitRate,
+		Group:       group,
+		Description: rule.Description,
+		PeriodStart: start.Format(time.RFC3339),
+		PeriodEnd:   end.Format(time.RFC3339),
+		Used:        used,
+		Exceeded:    exceeded,
+		CreatedAt:   rule.CreatedAt.Format(time.RFC3339),
+	}
+}
+
+func convertToQuotaUsage(rule *store.QuotaRule, key string, used uint64, violation *store.QuotaViolation, bannedIpNets, allowIpNets []*net.IPNet) QuotaUsage {
+	usage := QuotaUsage{
+		Key:     key,
+		Used:    used,
+		Budget:  rule.Budget,
+		Percent: float64(used) * 100 / float64(rule.Budget),
+	}
+	if ipNet := parseIpNet(key); ipNet != nil {
+		usage.IsBanned = banCoverage(ipNet, bannedIpNets, allowIpNets) == BanCoverageFull
+	}
+	if violation != nil {
+		usage.Exceeded = true
+		usage.Action = violation.Action
+		usage.Applied = violation.Applied
+		usage.ExceededAt = violation.CreatedAt.Format(time.RFC3339)
+	}
+	return usage
+}
+
+func convertToLocalTraffic(l *core.LocalStats) LocalTraffic {
+	return LocalTraffic{
+		LocalIP:         l.LocalIP,
+		TotalBytesIn:    l.BytesRecv,
+		TotalBytesOut:   l.BytesSent,
+		TotalPacketsIn:  l.PacketsRecv,
+		TotalPacketsOut: l.PacketsSent,
+		FirstSeen:       l.FirstSeen.Format(time.RFC3339),
+		LastSeen:        l.LastSeen.Format(time.RFC3339),
+	}
+}
+
 func convertToIpNetGroup(storeGroup *store.IpNetGroup) IpGroup {
 	return IpGroup{
 		ID:          storeGroup.ID,
@@ -47,6 +299,32 @@ func convertToIpNetGroup(storeGroup *store.IpNetGroup) IpGroup {
 	}
 }
 
+func convertToTLSFingerprint(fp core.TLSFingerprint, ruleIDs []uint) TLSFingerprint {
+	if ruleIDs == nil {
+		ruleIDs = []uint{}
+	}
+	return TLSFingerprint{
+		JA3:       fp.JA3,
+		JA4:       fp.JA4,
+		Count:     fp.Count,
+		RemoteIPs: fp.RemoteIPs,
+		LastSeen:  fp.LastSeen.Format(time.RFC3339),
+		RuleIDs:   ruleIDs,
+	}
+}
+
+func convertToFingerprintRule(rule *store.FingerprintRule, group *IpGroup) FingerprintRule {
+	return FingerprintRule{
+		ID:          rule.ID,
+		Type:        rule.Type,
+		Fingerprint: rule.Fingerprint,
+		Action:      rule.Action,
+		Group:       group,
+		Description: rule.Description,
+		CreatedAt:   rule.CreatedAt.Format(time.RFC3339),
+	}
+}
+
 func isContainIpNet(ipNet []*net.IPNet, ip string) bool {
 	ipAddr := net.ParseIP(ip)
 	if ipAddr == nil {
@@ -61,16 +339,114 @@ func isContainIpNet(ipNet []*net.IPNet, ip string) bool {
 	return false
 }
 
-func IsBanned(bannedIpNets, allowIpNets []*net.IPNet, ip string) bool {
-	if isContainIpNet(allowIpNets, ip) {
+const (
+	BanCoverageFull    = "full"
+	BanCoveragePartial = "partial"
+	BanCoverageNone    = "none"
+)
+
+// ipNetContains outer是否完整包含inner，两者需为同一地址族
+func ipNetContains(outer, inner *net.IPNet) bool {
+	outerOnes, outerBits := outer.Mask.Size()
+	innerOnes, innerBits := inner.Mask.Size()
+	return outerBits == innerBits && outerOnes <= innerOnes && outer.Contains(inner.IP)
+}
+
+// ipNetOverlaps 两个网段是否有交集，CIDR网段之间只可能包含或不相交
+func ipNetOverlaps(a, b *net.IPNet) bool {
+	return ipNetContains(a, b) || ipNetContains(b, a)
+}
+
+// ipNetOverlapsAny ipNet是否与ipNets中的任意网段有交集
+func ipNetOverlapsAny(ipNets []*net.IPNet, ipNet *net.IPNet) bool {
+	for _, n := range ipNets {
+		if ipNetOverlaps(n, ipNet) {
+			return true
+		}
+	}
+	return false
+}
+
+// shouldAutoBan 自动封禁前检查IP或网段，与放行规则相交的不做修改，避免覆盖人工设置的放行
+// 已被完全封禁的无需重复封禁；只被观察的IP或网段应当升级为封禁
+func shouldAutoBan(ipNet *net.IPNet, bannedIpNets, allowIpNets []*net.IPNet) bool {
+	if ipNet == nil || ipNetOverlapsAny(allowIpNets, ipNet) {
 		return false
 	}
+	return banCoverage(ipNet, bannedIpNets, allowIpNets) != BanCoverageFull
+}
+
+// banCoverage 判断网段被封禁规则覆盖的程度
+// 被某条封禁规则完整包含且没有放行规则与之相交时为full，与封禁规则有交集时为partial
+func banCoverage(prefix *net.IPNet, bannedIpNets, allowIpNets []*net.IPNet) string {
+	full, partial := false, false
+	for _, banned := range bannedIpNets {
+		if ipNetContains(banned, prefix) {
+			full = true
+			break
+		}
+		if ipNetContains(prefix, banned) {
+			partial = true
+		}
+	}
+
+	if full {
+		for _, allow := range allowIpNets {
+			if ipNetOverlaps(allow, prefix) {
+				return BanCoveragePartial
+			}
+		}
+		return BanCoverageFull
+	}
+	if partial {
+		return BanCoveragePartial
+	}
+	return BanCoverageNone
+}
+
+// ipPrefix 返回IP所在的网段，IPv4和IPv6分别使用各自的前缀长度
+func ipPrefix(ip string, v4Len, v6Len int) *net.IPNet {
+	addr := net.ParseIP(ip)
+	if addr == nil {
+		return nil
+	}
+	if v4 := addr.To4(); v4 != nil {
+		mask := net.CIDRMask(v4Len, 32)
+		return &net.IPNet{IP: v4.Mask(mask), Mask: mask}
+	}
+	mask := net.CIDRMask(v6Len, 128)
+	return &net.IPNet{IP: addr.Mask(mask), Mask: mask}
+}
+
+// Verdict 远程IP命中规则后的结论
+type Verdict string
+
+const (
+	VerdictNone  Verdict = "none"  // 未命中任何规则
+	VerdictAllow Verdict = "allow" // 被放行规则覆盖
+	VerdictBan   Verdict = "ban"   // 被封禁
+	VerdictWatch Verdict = "watch" // 被观察，流量不受影响
+)
+
+// GetVerdict 判断IP命中的规则，放行优先于封禁，封禁优先于观察
+func GetVerdict(bannedIpNets, allowIpNets, watchIpNets []*net.IPNet, ip string) Verdict {
+	if isContainIpNet(allowIpNets, ip) {
+		return VerdictAllow
+	}
 
 	if isContainIpNet(bannedIpNets, ip) {
-		return true
+		return VerdictBan
 	}
 
-	return false
+	if isContainIpNet(watchIpNets, ip) {
+		return VerdictWatch
+	}
+
+	return VerdictNone
+}
+
+func IsBanned(bannedIpNets, allowIpNets []*net.IPNet, ip string) bool {
+	return GetVerdict(bannedIpNets, allowIpNets, nil, ip) == VerdictBan
 }
 
 var ipOrCidrRex = regexp.MustCompile(`(?:(?:25[0-5]|2[0-4][0-9]|1[0-9]{2}|[1-9]?[0-9])\.){3}(?:25[0-5]|2[0-4][0-9]|1[0-9]{2}|[1-9]?[0-9])(?:\/(?:[0-9]|[12][0-9]|3[0-2]))?\b`)
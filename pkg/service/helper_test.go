@@ -1,6 +1,7 @@
 package service
 
 import (
+	"net"
 	"reflect"
 	"testing"
 )
@@ -75,3 +76,92 @@ func Test_extractIPsAndCIDRs(t *testing.T) {
 		})
 	}
 }
+
+func Test_banCoverage(t *testing.T) {
+	parse := func(cidrs ...string) []*net.IPNet {
+		var result []*net.IPNet
+		for _, cidr := range cidrs {
+			result = append(result, parseIpNet(cidr))
+		}
+		return result
+	}
+
+	tests := []struct {
+		name   string
+		prefix string
+		banned []string
+		allow  []string
+		want   string
+	}{
+		{name: "none", prefix: "10.0.0.0/24", banned: []string{"10.0.1.0/24"}, want: BanCoverageNone},
+		{name: "exact", prefix: "10.0.0.0/24", banned: []string{"10.0.0.0/24"}, want: BanCoverageFull},
+		{name: "covered_by_larger", prefix: "10.0.0.0/24", banned: []string{"10.0.0.0/8"}, want: BanCoverageFull},
+		{name: "single_ip", prefix: "10.0.0.0/24", banned: []string{"10.0.0.5"}, want: BanCoveragePartial},
+		{name: "smaller_subnet", prefix: "10.0.0.0/24", banned: []string{"10.0.0.128/25"}, want: BanCoveragePartial},
+		{name: "allow_inside", prefix: "10.0.0.0/24", banned: []string{"10.0.0.0/8"}, allow: []string{"10.0.0.1"}, want: BanCoveragePartial},
+		{name: "allow_elsewhere", prefix: "10.0.0.0/24", banned: []string{"10.0.0.0/8"}, allow: []string{"10.0.1.1"}, want: BanCoverageFull},
+		{name: "ipv6", prefix: "2001:db8::/64", banned: []string{"2001:db8::/32"}, want: BanCoverageFull},
+		{name: "family_mismatch", prefix: "2001:db8::/64", banned: []string{"0.0.0.0/0"}, want: BanCoverageNone},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := banCoverage(parseIpNet(tt.prefix), parse(tt.banned...), parse(tt.allow...)); got != tt.want {
+				t.Errorf("banCoverage() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_GetVerdict(t *testing.T) {
+	banned := []*net.IPNet{parseIpNet("10.0.0.0/8")}
+	allow := []*net.IPNet{parseIpNet("10.0.0.1")}
+	watch := []*net.IPNet{parseIpNet("10.0.0.0/24"), parseIpNet("192.0.2.0/24")}
+
+	tests := []struct {
+		ip   string
+		want Verdict
+	}{
+		{ip: "10.0.0.1", want: VerdictAllow},
+		{ip: "10.0.0.2", want: VerdictBan},
+		{ip: "192.0.2.7", want: VerdictWatch},
+		{ip: "198.51.100.1", want: VerdictNone},
+		{ip: "invalid", want: VerdictNone},
+	}
+	for _, tt := range tests {
+		t.Run(tt.ip, func(t *testing.T) {
+			if got := GetVerdict(banned, allow, watch, tt.ip); got != tt.want {
+				t.Errorf("GetVerdict() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_parseIpNet(t *testing.T) {
+	tests := []struct {
+		ipNet    string
+		want     string
+		contains string
+		excludes string
+	}{
+		{ipNet: "192.0.2.1", want: "192.0.2.1/32", contains: "192.0.2.1", excludes: "192.0.2.2"},
+		{ipNet: "2001:db8::1", want: "2001:db8::1/128", contains: "2001:db8::1", excludes: "2001:db8::2"},
+		{ipNet: "10.0.0.0/8", want: "10.0.0.0/8", contains: "10.1.2.3", excludes: "11.0.0.1"},
+		{ipNet: "2001:db8::/64", want: "2001:db8::/64", contains: "2001:db8::abcd", excludes: "2001:db8:0:1::1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.ipNet, func(t *testing.T) {
+			got := parseIpNet(tt.ipNet)
+			if got == nil || got.String() != tt.want {
+				t.Fatalf("parseIpNet() = %v, want %v", got, tt.want)
+			}
+			if !got.Contains(net.ParseIP(tt.contains)) || got.Contains(net.ParseIP(tt.excludes)) {
+				t.Errorf("parseIpNet(%s) contains %s = %v, %s = %v", tt.ipNet, tt.contains, got.Contains(net.ParseIP(tt.contains)),
+					tt.excludes, got.Contains(net.ParseIP(tt.excludes)))
+			}
+		})
+	}
+
+	if got := parseIpNet("invalid"); got != nil {
+		t.Errorf("parseIpNet(invalid) = %v, want nil", got)
+	}
+}
@@ -4,6 +4,8 @@ import (
 	"errors"
 	"fmt"
 	"log/slog"
+	"net"
+	"sort"
 	"time"
 
 	"github.com/graydovee/netbouncer/pkg/config"
@@ -14,17 +16,29 @@ import (
 
 const DefaultGroupName = "default"
 
+// ErrTrafficNotFound 监控器中没有该IP的流量统计
+var ErrTrafficNotFound = errors.New("未找到该IP的流量统计")
+
+// ErrIPv6NotSupported 防火墙不支持为IPv6地址或网段添加规则
+var ErrIPv6NotSupported = core.ErrIPv6NotSupported
+
 type NetService struct {
 	monitor  *core.Monitor
 	firewall *core.Firewall
+	capturer *core.Capturer
+	quotas   *quotaTracker
+	watches  *watchTracker
 
 	store *store.Store
 }
 
-func NewNetService(monitor *core.Monitor, firewall *core.Firewall, store *store.Store) *NetService {
+func NewNetService(monitor *core.Monitor, firewall *core.Firewall, capturer *core.Capturer, store *store.Store) *NetService {
 	svc := &NetService{
 		monitor:  monitor,
 		firewall: firewall,
+		capturer: capturer,
+		quotas:   newQuotaTracker(),
+		watches:  newWatchTracker(),
 		store:    store,
 	}
 
@@ -101,36 +115,19 @@ func (s *NetService) GetAllStats() ([]TrafficData, error) {
 	stats := s.monitor.GetAllStats()
 	trafficData := make([]TrafficData, 0, len(stats))
 
-	bannedipNetEntity, err := s.store.IpNetStore.FindByAction(store.ActionBan)
+	bannedIpNets, allowIpNets, err := s.loadBanIpNets()
 	if err != nil {
 		return nil, err
 	}
-
-	allowIpNetEntity, err := s.store.IpNetStore.FindByAction(store.ActionAllow)
+	watchIpNets, err := s.loadWatchIpNets()
 	if err != nil {
 		return nil, err
 	}
 
-	bannedIpNets := convertToIpNet(bannedipNetEntity...)
-	allowIpNets := convertToIpNet(allowIpNetEntity...)
-
 	for _, stat := range stats {
-		isBanned := IsBanned(bannedIpNets, allowIpNets, stat.RemoteIP)
-
-		trafficData = append(trafficData, TrafficData{
-			RemoteIP:        stat.RemoteIP,
-			LocalIP:         stat.LocalIP,
-			TotalBytesIn:    stat.BytesRecv,
-			TotalBytesOut:   stat.BytesSent,
-			TotalPacketsIn:  stat.PacketsRecv,
-			TotalPacketsOut: stat.PacketsSent,
-			BytesInPerSec:   stat.BytesRecvPerSec,
-			BytesOutPerSec:  stat.BytesSentPerSec,
-			Connections:     stat.Connections,
-			FirstSeen:       stat.FirstSeen.Format(time.RFC3339),
-			LastSeen:        stat.LastSeen.Format(time.RFC3339),
-			IsBanned:        isBanned,
-		})
+		verdict := GetVerdict(bannedIpNets, allowIpNets, watchIpNets, stat.RemoteIP)
+
+		trafficData = append(trafficData, convertToTrafficData(stat, verdict))
 	}
 	return trafficData, nil
 }
@@ -140,38 +137,193 @@ func (s *NetService) GetStats() ([]TrafficData, error) {
 	stats := s.monitor.GetStats()
 	trafficData := make([]TrafficData, 0, len(stats))
 
-	bannedipNetEntity, err := s.store.IpNetStore.FindByAction(store.ActionBan)
+	bannedIpNets, allowIpNets, err := s.loadBanIpNets()
 	if err != nil {
 		return nil, err
 	}
-
-	allowIpNetEntity, err := s.store.IpNetStore.FindByAction(store.ActionAllow)
+	watchIpNets, err := s.loadWatchIpNets()
 	if err != nil {
 		return nil, err
 	}
 
-	bannedIpNets := convertToIpNet(bannedipNetEntity...)
-	allowIpNets := convertToIpNet(allowIpNetEntity...)
+	for _, stat := range stats {
+		verdict := GetVerdict(bannedIpNets, allowIpNets, watchIpNets, stat.RemoteIP)
 
+		trafficData = append(trafficData, convertToTrafficData(stat, verdict))
+	}
+	return trafficData, nil
+}
+
+// GetPrefixStats 按网段聚合过滤后的流量统计，IPv4和IPv6分别使用各自的前缀长度
+func (s *NetService) GetPrefixStats(v4Len, v6Len int) ([]PrefixTraffic, error) {
+	stats := s.monitor.GetStats()
+
+	bannedIpNets, allowIpNets, err := s.loadBanIpNets()
+	if err != nil {
+		return nil, err
+	}
+
+	prefixes := make(map[string]*PrefixTraffic)
 	for _, stat := range stats {
-		isBanned := IsBanned(bannedIpNets, allowIpNets, stat.RemoteIP)
-
-		trafficData = append(trafficData, TrafficData{
-			RemoteIP:        stat.RemoteIP,
-			LocalIP:         stat.LocalIP,
-			TotalBytesIn:    stat.BytesRecv,
-			TotalBytesOut:   stat.BytesSent,
-			TotalPacketsIn:  stat.PacketsRecv,
-			TotalPacketsOut: stat.PacketsSent,
-			BytesInPerSec:   stat.BytesRecvPerSec,
-			BytesOutPerSec:  stat.BytesSentPerSec,
-			Connections:     stat.Connections,
-			FirstSeen:       stat.FirstSeen.Format(time.RFC3339),
-			LastSeen:        stat.LastSeen.Format(time.RFC3339),
-			IsBanned:        isBanned,
+		prefix := ipPrefix(stat.RemoteIP, v4Len, v6Len)
+		if prefix == nil {
+			continue
+		}
+
+		key := prefix.String()
+		traffic, exists := prefixes[key]
+		if !exists {
+			family := "ipv6"
+			if prefix.IP.To4() != nil {
+				family = "ipv4"
+			}
+			traffic = &PrefixTraffic{
+				Prefix:      key,
+				Family:      family,
+				BanCoverage: banCoverage(prefix, bannedIpNets, allowIpNets),
+			}
+			prefixes[key] = traffic
+		}
+
+		traffic.IPCount++
+		traffic.TotalBytesIn += stat.BytesRecv
+		traffic.TotalBytesOut += stat.BytesSent
+		traffic.TotalPacketsIn += stat.PacketsRecv
+		traffic.TotalPacketsOut += stat.PacketsSent
+		traffic.BytesInPerSec += stat.BytesRecvPerSec
+		traffic.BytesOutPerSec += stat.BytesSentPerSec
+		traffic.Connections += stat.Connections
+	}
+
+	result := make([]PrefixTraffic, 0, len(prefixes))
+	for _, traffic := range prefixes {
+		result = append(result, *traffic)
+	}
+	// 按总速率降序，速率相同时按网段排序，避免轮询时顺序跳动
+	sort.Slice(result, func(i, j int) bool {
+		ri := result[i].BytesInPerSec + result[i].BytesOutPerSec
+		rj := result[j].BytesInPerSec + result[j].BytesOutPerSec
+		if ri != rj {
+			return ri > rj
+		}
+		return result[i].Prefix < result[j].Prefix
+	})
+	return result, nil
+}
+
+// GetTrafficDetail 获取单个远程IP的流量详情
+func (s *NetService) GetTrafficDetail(ip string) (*TrafficDetail, error) {
+	detail, ok := s.monitor.GetDetail(ip)
+	if !ok {
+		return nil, ErrTrafficNotFound
+	}
+
+	bannedIpNets, allowIpNets, err := s.loadBanIpNets()
+	if err != nil {
+		return nil, err
+	}
+	watchIpNets, err := s.loadWatchIpNets()
+	if err != nil {
+		return nil, err
+	}
+
+	return convertToTrafficDetail(detail, GetVerdict(bannedIpNets, allowIpNets, watchIpNets, detail.RemoteIP)), nil
+}
+
+// GetTrafficHistory 获取单个远程IP的历史速率
+func (s *NetService) GetTrafficHistory(ip string) (*TrafficHistory, error) {
+	history, ok := s.monitor.GetHistory(ip)
+	if !ok {
+		return nil, ErrTrafficNotFound
+	}
+
+	return &TrafficHistory{
+		RemoteIP: history.RemoteIP,
+		Seconds:  convertToRatePoints(history.Seconds),
+		Minutes:  convertToRatePoints(history.Minutes),
+	}, nil
+}
+
+// GetTrafficByLocal 获取与指定本地IP通信的全部远程IP
+func (s *NetService) GetTrafficByLocal(localIP string) ([]PeerTraffic, error) {
+	peers := s.monitor.GetStatsByLocal(localIP)
+
+	bannedIpNets, allowIpNets, err := s.loadBanIpNets()
+	if err != nil {
+		return nil, err
+	}
+	watchIpNets, err := s.loadWatchIpNets()
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]PeerTraffic, 0, len(peers))
+	for _, peer := range peers {
+		verdict := GetVerdict(bannedIpNets, allowIpNets, watchIpNets, peer.RemoteIP)
+		result = append(result, PeerTraffic{
+			RemoteIP:     peer.RemoteIP,
+			LocalTraffic: convertToLocalTraffic(&peer.LocalStats),
+			IsBanned:     verdict == VerdictBan,
+			Verdict:      verdict,
 		})
 	}
-	return trafficData, nil
+	return result, nil
+}
+
+// GetCaptureHealth 获取流量数据源的丢包和处理延迟
+func (s *NetService) GetCaptureHealth() CaptureHealth {
+	return convertToCaptureHealth(s.monitor.GetCaptureHealth())
+}
+
+// GetTopTalkers 获取流量最大的远程IP
+func (s *NetService) GetTopTalkers() ([]TopTalker, error) {
+	hitters := s.monitor.GetHeavyHitters()
+
+	bannedIpNets, allowIpNets, err := s.loadBanIpNets()
+	if err != nil {
+		return nil, err
+	}
+	watchIpNets, err := s.loadWatchIpNets()
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]TopTalker, 0, len(hitters))
+	for _, hitter := range hitters {
+		verdict := GetVerdict(bannedIpNets, allowIpNets, watchIpNets, hitter.RemoteIP)
+		result = append(result, TopTalker{
+			RemoteIP: hitter.RemoteIP,
+			Bytes:    hitter.Bytes,
+			Tracked:  hitter.Tracked,
+			IsBanned: verdict == VerdictBan,
+			Verdict:  verdict,
+		})
+	}
+	return result, nil
+}
+
+// loadBanIpNets 加载封禁和允许的网段，用于判断IP是否被封禁
+func (s *NetService) loadBanIpNets() ([]*net.IPNet, []*net.IPNet, error) {
+	bannedIpNetEntity, err := s.store.IpNetStore.FindByAction(store.ActionBan)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	allowIpNetEntity, err := s.store.IpNetStore.FindByAction(store.ActionAllow)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return convertToIpNet(bannedIpNetEntity...), convertToIpNet(allowIpNetEntity...), nil
+}
+
+// loadWatchIpNets 加载观察的网段，用于标记需要关注的IP
+func (s *NetService) loadWatchIpNets() ([]*net.IPNet, error) {
+	watchIpNetEntity, err := s.store.IpNetStore.FindByAction(store.ActionWatch)
+	if err != nil {
+		return nil, err
+	}
+	return convertToIpNet(watchIpNetEntity...), nil
 }
 
 // CreateOrUpdateIpNet 创建或更新IP网络
@@ -202,6 +354,12 @@ func (s *NetService) CreateOrUpdateIpNet(ipnet string, groupId uint, action stri
 		}
 	}
 
+	if action != store.ActionWatch {
+		if err := s.firewall.CheckIpNet(ipnet); err != nil {
+			return err
+		}
+	}
+
 	if s.store.IpNetStore.ExistsByIpNet(ipnet) {
 		// 如果IP网络已存在，则更新action, 忽略组信息
 		ipNet, err := s.store.IpNetStore.FindByIpNet(ipnet)
@@ -237,6 +395,8 @@ func (s *NetService) applyAction(ipNet *store.IpNet) error {
 		return s.firewall.Ban(ipNet.IpNet)
 	case store.ActionAllow:
 		return s.firewall.Allow(ipNet.IpNet)
+	case store.ActionWatch:
+		return nil
 	default:
 		return fmt.Errorf("不支持的防火墙动作: %s", ipNet.Action)
 	}
@@ -248,6 +408,8 @@ func (s *NetService) revertAction(ipNet *store.IpNet) error {
 		return s.firewall.RevertBan(ipNet.IpNet)
 	case store.ActionAllow:
 		return s.firewall.RevertAllow(ipNet.IpNet)
+	case store.ActionWatch:
+		return nil
 	default:
 		return fmt.Errorf("不支持的防火墙动作: %s", ipNet.Action)
 	}
@@ -259,9 +421,11 @@ func (s *NetService) DeleteIpNet(id uint) error {
 		return err
 	}
 
-	err = s.firewall.CleanupIpNet(ipNet.IpNet)
-	if err != nil {
-		return fmt.Errorf("撤销原有行为失败: %w", err)
+	if ipNet.Action != store.ActionWatch {
+		err = s.firewall.CleanupIpNet(ipNet.IpNet)
+		if err != nil {
+			return fmt.Errorf("撤销原有行为失败: %w", err)
+		}
 	}
 
 	err = s.store.IpNetStore.DeleteByID(id)
@@ -281,6 +445,11 @@ func (s *NetService) UpdateIpNetAction(id uint, action string) error {
 	if action == ipNet.Action {
 		return nil
 	}
+	if action != store.ActionWatch {
+		if err := s.firewall.CheckIpNet(ipNet.IpNet); err != nil {
+			return err
+		}
+	}
 
 	err = s.revertAction(ipNet)
 	if err != nil {
@@ -409,6 +578,14 @@ func (s *NetService) DeleteGroup(id uint) error {
 		}
 	}
 
+	// 指纹规则和配额规则封禁的IP同样改为加入默认组
+	if err := s.store.FingerprintRuleStore.UpdateGroupID(id, defaultGroup.ID); err != nil {
+		return err
+	}
+	if err := s.store.QuotaRuleStore.UpdateGroupID(id, defaultGroup.ID); err != nil {
+		return err
+	}
+
 	return s.store.IpNetGroupStore.DeleteByID(id)
 }
 
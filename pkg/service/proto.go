@@ -1,8 +1,83 @@
 package service
 
 type TrafficData struct {
-	RemoteIP        string  `json:"remote_ip"`         // 远程IP
-	LocalIP         string  `json:"local_ip"`          // 本地IP
+	RemoteIP         string           `json:"remote_ip"`          // 远程IP
+	LocalIP          string           `json:"local_ip"`           // 最近通信的本地IP
+	LocalIPs         []string         `json:"local_ips"`          // 通信过的全部本地IP
+	TotalBytesIn     uint64           `json:"total_bytes_in"`     // 总接收字节数
+	TotalBytesOut    uint64           `json:"total_bytes_out"`    // 总发送字节数
+	TotalPacketsIn   uint64           `json:"total_packets_in"`   // 总接收包数
+	TotalPacketsOut  uint64           `json:"total_packets_out"`  // 总发送包数
+	BytesInPerSec    float64          `json:"bytes_in_per_sec"`   // 每秒接收字节数
+	BytesOutPerSec   float64          `json:"bytes_out_per_sec"`  // 每秒发送字节数
+	Connections      int              `json:"connections"`        // 连接数
+	TCPFlows         int              `json:"tcp_flows"`          // 活动TCP连接数
+	UDPFlows         int              `json:"udp_flows"`          // 活动UDP流数
+	NewFlowsPerSec   float64          `json:"new_flows_per_sec"`  // 每秒新建连接数
+	ICMPPackets      uint64           `json:"icmp_packets"`       // ICMP/ICMPv6总包数
+	ICMPEchoRequests uint64           `json:"icmp_echo_requests"` // 远程发来的ICMP回显请求数
+	ICMPUnreachables uint64           `json:"icmp_unreachables"`  // 远程发来的ICMP不可达消息数
+	OtherPackets     uint64           `json:"other_packets"`      // 其他协议（如GRE、ESP）总包数
+	OtherBytes       uint64           `json:"other_bytes"`        // 其他协议总字节数
+	DomainNames      []string         `json:"domain_names"`       // 解析到该IP的域名（被动DNS）
+	TLSServerNames   []string         `json:"tls_server_names"`   // 该IP发起TLS连接时的SNI
+	HTTPHosts        []string         `json:"http_hosts"`         // 该IP发起明文HTTP请求时的Host
+	JA3              []string         `json:"ja3"`                // 该IP的TLS客户端JA3指纹
+	JA4              []string         `json:"ja4"`                // 该IP的TLS客户端JA4指纹
+	Processes        []ProcessTraffic `json:"processes"`          // 与该IP通信的本地进程，按流量降序
+	Sampled          bool             `json:"sampled"`            // 统计是否来自抽样估算
+	SampleRate       int              `json:"sample_rate"`        // 抽样率，每N个包处理1个
+	FirstSeen        string           `json:"first_seen"`         // 首次发现时间
+	LastSeen         string           `json:"last_seen"`          // 最后活动时间
+	IsBanned         bool             `json:"is_banned"`          // 是否被ban
+	Verdict          Verdict          `json:"verdict"`            // 命中规则的结论：none, allow, ban, watch
+}
+
+// TrafficDetail 单个远程IP的流量详情
+type TrafficDetail struct {
+	TrafficData
+	Locals    []LocalTraffic    `json:"locals"`    // 按本地IP划分的流量
+	Protocols []ProtocolTraffic `json:"protocols"` // 按协议划分的流量
+	TopPorts  []PortTraffic     `json:"top_ports"` // 访问最多的本地服务端口
+}
+
+// ProcessTraffic 远程IP与某个本地进程之间的流量
+type ProcessTraffic struct {
+	PID             int    `json:"pid"`               // 进程ID
+	Name            string `json:"name"`              // 进程名
+	Cgroup          string `json:"cgroup"`            // 进程所在的cgroup路径
+	ContainerID     string `json:"container_id"`      // 容器ID，不在容器中时为空
+	TotalBytesIn    uint64 `json:"total_bytes_in"`    // 总接收字节数
+	TotalBytesOut   uint64 `json:"total_bytes_out"`   // 总发送字节数
+	TotalPacketsIn  uint64 `json:"total_packets_in"`  // 总接收包数
+	TotalPacketsOut uint64 `json:"total_packets_out"` // 总发送包数
+	LastSeen        string `json:"last_seen"`         // 最后活动时间
+}
+
+// LocalTraffic 远程IP与某个本地IP之间的流量
+type LocalTraffic struct {
+	LocalIP         string `json:"local_ip"`          // 本地IP
+	TotalBytesIn    uint64 `json:"total_bytes_in"`    // 总接收字节数
+	TotalBytesOut   uint64 `json:"total_bytes_out"`   // 总发送字节数
+	TotalPacketsIn  uint64 `json:"total_packets_in"`  // 总接收包数
+	TotalPacketsOut uint64 `json:"total_packets_out"` // 总发送包数
+	FirstSeen       string `json:"first_seen"`        // 首次发现时间
+	LastSeen        string `json:"last_seen"`         // 最后活动时间
+}
+
+// PeerTraffic 与某个本地IP通信的远程IP，流量只包含该本地IP上的部分
+type PeerTraffic struct {
+	RemoteIP string `json:"remote_ip"` // 远程IP
+	LocalTraffic
+	IsBanned bool    `json:"is_banned"` // 是否被ban
+	Verdict  Verdict `json:"verdict"`   // 命中规则的结论：none, allow, ban, watch
+}
+
+// PrefixTraffic 按网段聚合的流量
+type PrefixTraffic struct {
+	Prefix          string  `json:"prefix"`            // 网段（CIDR）
+	Family          string  `json:"family"`            // 地址族：ipv4, ipv6
+	IPCount         int     `json:"ip_count"`          // 网段内有流量的远程IP数量
 	TotalBytesIn    uint64  `json:"total_bytes_in"`    // 总接收字节数
 	TotalBytesOut   uint64  `json:"total_bytes_out"`   // 总发送字节数
 	TotalPacketsIn  uint64  `json:"total_packets_in"`  // 总接收包数
@@ -10,9 +85,216 @@ type TrafficData struct {
 	BytesInPerSec   float64 `json:"bytes_in_per_sec"`  // 每秒接收字节数
 	BytesOutPerSec  float64 `json:"bytes_out_per_sec"` // 每秒发送字节数
 	Connections     int     `json:"connections"`       // 连接数
-	FirstSeen       string  `json:"first_seen"`        // 首次发现时间
-	LastSeen        string  `json:"last_seen"`         // 最后活动时间
+	BanCoverage     string  `json:"ban_coverage"`      // 封禁覆盖程度：full, partial, none
+}
+
+// TrafficHistory 单个远程IP的历史速率，按时间从旧到新排列
+type TrafficHistory struct {
+	RemoteIP string      `json:"remote_ip"` // 远程IP
+	Seconds  []RatePoint `json:"seconds"`   // 每秒速率
+	Minutes  []RatePoint `json:"minutes"`   // 每分钟平均速率
+}
+
+// RatePoint 历史速率中的一个点
+type RatePoint struct {
+	Time           string  `json:"time"`              // 时间段起点
+	BytesInPerSec  float64 `json:"bytes_in_per_sec"`  // 每秒接收字节数
+	BytesOutPerSec float64 `json:"bytes_out_per_sec"` // 每秒发送字节数
+}
+
+// TopTalker 流量排行中的远程IP，即使超出跟踪上限也能准确上报
+type TopTalker struct {
+	RemoteIP string  `json:"remote_ip"` // 远程IP
+	Bytes    uint64  `json:"bytes"`     // 估计的近期总字节数
+	Tracked  bool    `json:"tracked"`   // 是否有完整的流量统计
+	IsBanned bool    `json:"is_banned"` // 是否被ban
+	Verdict  Verdict `json:"verdict"`   // 命中规则的结论：none, allow, ban, watch
+}
+
+// HistoricalTalker 过去某段时间内的远程IP流量合计，来自持久化的流量快照
+type HistoricalTalker struct {
+	RemoteIP        string  `json:"remote_ip"`         // 远程IP
+	TotalBytesIn    uint64  `json:"total_bytes_in"`    // 总接收字节数
+	TotalBytesOut   uint64  `json:"total_bytes_out"`   // 总发送字节数
+	TotalPacketsIn  uint64  `json:"total_packets_in"`  // 总接收包数
+	TotalPacketsOut uint64  `json:"total_packets_out"` // 总发送包数
 	IsBanned        bool    `json:"is_banned"`         // 是否被ban
+	Verdict         Verdict `json:"verdict"`           // 命中规则的结论：none, allow, ban, watch
+}
+
+// CaptureHealth 流量数据源健康状况，丢包率和处理延迟按最近一个采样周期（10秒）计算
+type CaptureHealth struct {
+	Source           string  `json:"source"`             // 数据源名称
+	StatsSupported   bool    `json:"stats_supported"`    // 数据源是否提供抓包计数
+	PacketsReceived  uint64  `json:"packets_received"`   // 累计接收包数
+	PacketsDropped   uint64  `json:"packets_dropped"`    // 累计内核丢包数
+	PacketsIfDropped uint64  `json:"packets_if_dropped"` // 累计网卡丢包数
+	DropRatio        float64 `json:"drop_ratio"`         // 最近周期的丢包率
+	Processed        uint64  `json:"processed"`          // 最近周期处理的包数（conntrack为轮询次数）
+	AvgLatencyMs     float64 `json:"avg_latency_ms"`     // 最近周期的平均处理延迟（毫秒）
+	MaxLatencyMs     float64 `json:"max_latency_ms"`     // 最近周期的最大处理延迟（毫秒）
+	Warning          bool    `json:"warning"`            // 丢包率是否超过告警阈值
+	SampledAt        string  `json:"sampled_at"`         // 采样时间，尚未采样时为空
+}
+
+// TLSFingerprint TLS客户端指纹的全局统计
+type TLSFingerprint struct {
+	JA3       string `json:"ja3"`
+	JA4       string `json:"ja4"`
+	Count     uint64 `json:"count"`      // 出现的ClientHello数量
+	RemoteIPs uint64 `json:"remote_ips"` // 出现过该指纹的远程IP数量
+	LastSeen  string `json:"last_seen"`  // 最后出现时间
+	RuleIDs   []uint `json:"rule_ids"`   // 匹配该指纹的规则
+}
+
+// FingerprintRule TLS客户端指纹规则
+type FingerprintRule struct {
+	ID          uint     `json:"id"`
+	Type        string   `json:"type"`        // ja3 或 ja4
+	Fingerprint string   `json:"fingerprint"` // 指纹值
+	Action      string   `json:"action"`      // ban 或 flag
+	Group       *IpGroup `json:"group"`       // 命中的IP加入的组
+	Description string   `json:"description"`
+	CreatedAt   string   `json:"created_at"`
+}
+
+// FingerprintMatch 命中指纹规则的远程IP
+type FingerprintMatch struct {
+	RemoteIP  string  `json:"remote_ip"`
+	FirstSeen string  `json:"first_seen"` // 首次命中时间
+	LastSeen  string  `json:"last_seen"`  // 最近命中时间
+	IsBanned  bool    `json:"is_banned"`  // 是否被ban
+	Verdict   Verdict `json:"verdict"`    // 命中规则的结论：none, allow, ban, watch
+}
+
+// Detection 端口扫描或SYN洪水检测事件
+type Detection struct {
+	ID        uint64  `json:"id"`        // 递增的事件ID，可作为since参数增量拉取
+	Type      string  `json:"type"`      // port_scan 或 syn_flood
+	RemoteIP  string  `json:"remote_ip"` // 可疑的远程IP
+	Value     int     `json:"value"`     // 触发时的观测值：不同端口数或半开连接数
+	Threshold int     `json:"threshold"` // 配置的阈值
+	Time      string  `json:"time"`      // 检测时间
+	IsBanned  bool    `json:"is_banned"` // 是否被ban
+	Verdict   Verdict `json:"verdict"`   // 命中规则的结论：none, allow, ban, watch
+}
+
+// WatchEvent 观察的IP出现在流量统计中
+type WatchEvent struct {
+	ID            uint64 `json:"id"`              // 递增的事件ID，可作为since参数增量拉取
+	RemoteIP      string `json:"remote_ip"`       // 出现的远程IP
+	IpNet         string `json:"ip_net"`          // 命中的观察规则
+	GroupID       uint   `json:"group_id"`        // 观察规则所在的组
+	LocalIP       string `json:"local_ip"`        // 最近通信的本地IP
+	TotalBytesIn  uint64 `json:"total_bytes_in"`  // 出现时已统计的入站字节数
+	TotalBytesOut uint64 `json:"total_bytes_out"` // 出现时已统计的出站字节数
+	FirstSeen     string `json:"first_seen"`      // 首次发现时间
+	Time          string `json:"time"`            // 事件时间
+}
+
+// Anomaly 本地端口的流量指标偏离基线
+type Anomaly struct {
+	ID              uint64               `json:"id"`               // 递增的事件ID，可作为since参数增量拉取
+	Protocol        string               `json:"protocol"`         // tcp 或 udp
+	Port            uint16               `json:"port"`             // 本地端口
+	Metric          string               `json:"metric"`           // inbound_rate, new_flows 或 unique_sources
+	Value           float64              `json:"value"`            // 本周期的观测值
+	Mean            float64              `json:"mean"`             // 基线均值
+	StdDev          float64              `json:"stddev"`           // 基线标准差
+	Deviation       float64              `json:"deviation"`        // 偏离的标准差倍数，负数表示低于基线
+	TopContributors []AnomalyContributor `json:"top_contributors"` // 本周期贡献最多的远程IP
+	Time            string               `json:"time"`             // 检测时间
+}
+
+// AnomalyContributor 异常周期内对端口贡献最多的远程IP
+type AnomalyContributor struct {
+	RemoteIP string  `json:"remote_ip"`
+	Bytes    uint64  `json:"bytes"`     // 周期内的入站字节数
+	NewFlows uint64  `json:"new_flows"` // 周期内新建的连接数
+	IsBanned bool    `json:"is_banned"` // 是否被ban
+	Verdict  Verdict `json:"verdict"`   // 命中规则的结论：none, allow, ban, watch
+}
+
+// ServiceBaseline 本地端口当前学习到的流量基线
+type ServiceBaseline struct {
+	Protocol string                    `json:"protocol"`
+	Port     uint16                    `json:"port"`
+	Samples  int                       `json:"samples"`   // 已学习的周期数
+	Metrics  map[string]BaselineMetric `json:"metrics"`   // 按指标名
+	LastSeen string                    `json:"last_seen"` // 最后一次有入站流量的时间
+}
+
+// BaselineMetric 某项指标的基线和最近一个周期的观测值
+type BaselineMetric struct {
+	Mean   float64 `json:"mean"`
+	StdDev float64 `json:"stddev"`
+	Last   float64 `json:"last"`
+}
+
+// PacketCapture 按需抓包任务
+type PacketCapture struct {
+	ID          uint64 `json:"id"`
+	Target      string `json:"target"`       // 抓包的IP或CIDR
+	Filter      string `json:"filter"`       // 使用的BPF过滤器
+	Duration    int    `json:"duration"`     // 最长抓包时间（秒）
+	PacketLimit int    `json:"packet_limit"` // 最多抓取的包数
+	Packets     int    `json:"packets"`      // 已抓取的包数
+	FileSize    int64  `json:"file_size"`    // pcap文件大小（字节）
+	Truncated   bool   `json:"truncated"`    // 是否因达到文件大小上限而提前结束
+	Status      string `json:"status"`       // running, completed 或 failed
+	Error       string `json:"error"`        // 失败原因
+	StartedAt   string `json:"started_at"`
+	FinishedAt  string `json:"finished_at"`
+}
+
+// QuotaRule 带宽配额规则及当前周期的用量
+type QuotaRule struct {
+	ID          uint     `json:"id"`
+	Target      string   `json:"target"`     // IP或CIDR
+	PerIP       bool     `json:"per_ip"`     // Target内每个IP单独计算配额，否则整个网段共用
+	Budget      uint64   `json:"budget"`     // 每个周期允许的流量（字节）
+	Period      string   `json:"period"`     // hour、day、week 或 month
+	Action      string   `json:"action"`     // limit、ban 或 notify
+	LimitRate   uint64   `json:"limit_rate"` // limit动作的限速（字节/秒）
+	Group       *IpGroup `json:"group"`      // ban动作封禁的IP加入的组
+	Description string   `json:"description"`
+	PeriodStart string   `json:"period_start"` // 当前周期开始时间
+	PeriodEnd   string   `json:"period_end"`   // 当前周期结束时间
+	Used        uint64   `json:"used"`         // 当前周期的用量，每个IP单独计算时为用量最大的IP
+	Exceeded    int      `json:"exceeded"`     // 当前周期已超额的IP或网段数
+	CreatedAt   string   `json:"created_at"`
+}
+
+// QuotaUsage 配额规则下一个远程IP或网段在当前周期的用量
+type QuotaUsage struct {
+	Key        string  `json:"key"`         // 远程IP，整个网段共用配额时为网段
+	Used       uint64  `json:"used"`        // 当前周期的用量（字节）
+	Budget     uint64  `json:"budget"`      // 每个周期允许的流量（字节）
+	Percent    float64 `json:"percent"`     // 用量占配额的百分比
+	Exceeded   bool    `json:"exceeded"`    // 是否已超额并执行了动作
+	Action     string  `json:"action"`      // 超额时执行的动作
+	Applied    bool    `json:"applied"`     // 动作是否修改了防火墙，被放行规则覆盖或已有规则时不修改
+	ExceededAt string  `json:"exceeded_at"` // 超额时间
+	IsBanned   bool    `json:"is_banned"`   // 是否被ban
+}
+
+// ProtocolTraffic 按协议划分的流量
+type ProtocolTraffic struct {
+	Protocol        string `json:"protocol"`          // 协议：tcp, udp, icmp, other
+	TotalBytesIn    uint64 `json:"total_bytes_in"`    // 总接收字节数
+	TotalBytesOut   uint64 `json:"total_bytes_out"`   // 总发送字节数
+	TotalPacketsIn  uint64 `json:"total_packets_in"`  // 总接收包数
+	TotalPacketsOut uint64 `json:"total_packets_out"` // 总发送包数
+}
+
+// PortTraffic 按本地服务端口划分的流量
+type PortTraffic struct {
+	Port            uint16 `json:"port"`              // 本地端口
+	Protocol        string `json:"protocol"`          // 协议：tcp, udp
+	TotalBytesIn    uint64 `json:"total_bytes_in"`    // 总接收字节数
+	TotalBytesOut   uint64 `json:"total_bytes_out"`   // 总发送字节数
+	TotalPacketsIn  uint64 `json:"total_packets_in"`  // 总接收包数
+	TotalPacketsOut uint64 `json:"total_packets_out"` // 总发送包数
 }
 
 type IpNet struct {
@@ -0,0 +1,556 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/graydovee/netbouncer/pkg/config"
+	"github.com/graydovee/netbouncer/pkg/core"
+	"github.com/graydovee/netbouncer/pkg/store"
+	"gorm.io/gorm"
+)
+
+// quotaUsage 配额规则下一个远程IP或整个网段在当前周期的用量
+type quotaUsage struct {
+	periodStart time.Time
+	bytes       uint64
+}
+
+// quotaExceeded 当前周期用量达到配额的远程IP或网段
+type quotaExceeded struct {
+	rule       *store.QuotaRule
+	key        string
+	usage      uint64
+	start, end time.Time
+}
+
+// quotaTracker 在内存中累计各配额规则当前周期的用量，启动时从流量快照恢复
+type quotaTracker struct {
+	mutex sync.Mutex
+	usage map[uint]map[string]*quotaUsage // 规则ID -> 远程IP或网段 -> 用量
+}
+
+func newQuotaTracker() *quotaTracker {
+	return &quotaTracker{usage: make(map[uint]map[string]*quotaUsage)}
+}
+
+// quotaPeriod 返回now所在周期的起止时间，按本地时间划分，每周从周一开始
+func quotaPeriod(period string, now time.Time) (time.Time, time.Time) {
+	year, month, day := now.Date()
+	switch period {
+	case store.QuotaPeriodHour:
+		start := time.Date(year, month, day, now.Hour(), 0, 0, 0, now.Location())
+		return start, start.Add(time.Hour)
+	case store.QuotaPeriodWeek:
+		offset := (int(now.Weekday()) + 6) % 7
+		start := time.Date(year, month, day-offset, 0, 0, 0, 0, now.Location())
+		return start, start.AddDate(0, 0, 7)
+	case store.QuotaPeriodMonth:
+		start := time.Date(year, month, 1, 0, 0, 0, 0, now.Location())
+		return start, start.AddDate(0, 1, 0)
+	default:
+		start := time.Date(year, month, day, 0, 0, 0, 0, now.Location())
+		return start, start.AddDate(0, 0, 1)
+	}
+}
+
+// add 将各远程IP新增的流量计入所属配额规则，返回当前周期用量达到配额的远程IP或网段
+func (t *quotaTracker) add(rules []store.QuotaRule, deltas []core.TrafficDelta, now time.Time) []quotaExceeded {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	// 丢弃已删除的规则的用量
+	ruleIDs := make(map[uint]bool, len(rules))
+	for _, rule := range rules {
+		ruleIDs[rule.ID] = true
+	}
+	for id := range t.usage {
+		if !ruleIDs[id] {
+			delete(t.usage, id)
+		}
+	}
+
+	var result []quotaExceeded
+	for i := range rules {
+		rule := &rules[i]
+		target := parseIpNet(rule.Target)
+		if target == nil {
+			continue
+		}
+		start, end := quotaPeriod(rule.Period, now)
+		usages, exists := t.usage[rule.ID]
+		if !exists {
+			usages = make(map[string]*quotaUsage)
+			t.usage[rule.ID] = usages
+		}
+
+		for _, delta := range deltas {
+			ip := net.ParseIP(delta.RemoteIP)
+			if ip == nil || !target.Contains(ip) {
+				continue
+			}
+			key := rule.Target
+			if rule.PerIP {
+				key = delta.RemoteIP
+			}
+			usage, exists := usages[key]
+			if !exists || !usage.periodStart.Equal(start) {
+				usage = &quotaUsage{periodStart: start}
+				usages[key] = usage
+			}
+			usage.bytes += delta.BytesSent + delta.BytesRecv
+		}
+
+		for key, usage := range usages {
+			// 上一个周期的用量不再计入
+			if !usage.periodStart.Equal(start) {
+				delete(usages, key)
+				continue
+			}
+			if usage.bytes >= rule.Budget {
+				result = append(result, quotaExceeded{rule: rule, key: key, usage: usage.bytes, start: start, end: end})
+			}
+		}
+	}
+	return result
+}
+
+// seed 用各远程IP在周期内已记录的流量设置某条规则的用量
+func (t *quotaTracker) seed(rule *store.QuotaRule, totals []store.TrafficTotal, start time.Time) {
+	target := parseIpNet(rule.Target)
+	if target == nil {
+		return
+	}
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	usages := make(map[string]*quotaUsage)
+	for _, total := range totals {
+		ip := net.ParseIP(total.RemoteIP)
+		if ip == nil || !target.Contains(ip) {
+			continue
+		}
+		key := rule.Target
+		if rule.PerIP {
+			key = total.RemoteIP
+		}
+		usage, exists := usages[key]
+		if !exists {
+			usage = &quotaUsage{periodStart: start}
+			usages[key] = usage
+		}
+		usage.bytes += total.BytesIn + total.BytesOut
+	}
+	t.usage[rule.ID] = usages
+}
+
+// usages 返回某条规则当前周期内各远程IP或网段的用量
+func (t *quotaTracker) usages(ruleID uint, periodStart time.Time) map[string]uint64 {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	result := make(map[string]uint64)
+	for key, usage := range t.usage[ruleID] {
+		if usage.periodStart.Equal(periodStart) {
+			result[key] = usage.bytes
+		}
+	}
+	return result
+}
+
+// remove 删除某条规则的用量
+func (t *quotaTracker) remove(ruleID uint) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	delete(t.usage, ruleID)
+}
+
+// StartQuotaRoutine 启动定期累计远程IP流量并在超出配额时执行动作的协程
+func (s *NetService) StartQuotaRoutine(cfg *config.QuotaConfig) error {
+	interval := time.Duration(cfg.Interval) * time.Second
+	if interval <= 0 {
+		interval = 10 * time.Second
+	}
+
+	// 防火墙初始化时清空了限速规则，恢复尚未到期的限速
+	if err := s.restoreQuotaLimits(time.Now()); err != nil {
+		return err
+	}
+	if err := s.seedQuotaUsage(time.Now()); err != nil {
+		return fmt.Errorf("恢复配额用量失败: %w", err)
+	}
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			if err := s.applyQuotas(time.Now()); err != nil {
+				slog.Error("检查带宽配额失败", "error", err)
+			}
+		}
+	}()
+
+	slog.Info("带宽配额已启用", "interval", interval)
+	return nil
+}
+
+// seedQuotaUsage 从流量快照恢复各配额规则当前周期已用的流量，未启用快照时没有记录可用
+func (s *NetService) seedQuotaUsage(now time.Time) error {
+	rules, err := s.store.QuotaRuleStore.FindAll()
+	if err != nil {
+		return err
+	}
+
+	// 同一周期的规则共用一次查询
+	totals := make(map[time.Time][]store.TrafficTotal)
+	for i := range rules {
+		rule := &rules[i]
+		start, _ := quotaPeriod(rule.Period, now)
+		periodTotals, exists := totals[start]
+		if !exists {
+			periodTotals, err = s.store.TrafficSnapshotStore.Totals(start, now)
+			if err != nil {
+				return err
+			}
+			totals[start] = periodTotals
+		}
+		s.quotas.seed(rule, periodTotals, start)
+	}
+	return nil
+}
+
+// restoreQuotaLimits 重新应用尚未到期的配额限速
+func (s *NetService) restoreQuotaLimits(now time.Time) error {
+	violations, err := s.store.QuotaViolationStore.FindActive(now)
+	if err != nil {
+		return err
+	}
+	for _, violation := range violations {
+		if !violation.Applied || violation.Action != store.QuotaActionLimit {
+			continue
+		}
+		rule, err := s.store.QuotaRuleStore.FindByID(violation.RuleID)
+		if err != nil {
+			continue
+		}
+		if err := s.firewall.Limit(violation.Key, rule.LimitRate); err != nil {
+			return fmt.Errorf("恢复配额限速失败: %w", err)
+		}
+	}
+	return nil
+}
+
+// applyQuotas 累计新增的流量，对本周期首次超出配额的IP或网段执行规则的动作，并解除已到期的动作
+func (s *NetService) applyQuotas(now time.Time) error {
+	deltas := s.monitor.CollectQuotaDeltas()
+	rules, err := s.store.QuotaRuleStore.FindAll()
+	if err != nil {
+		return err
+	}
+
+	exceeded := s.quotas.add(rules, deltas, now)
+	if len(exceeded) > 0 {
+		if err := s.enforceQuotas(exceeded, now); err != nil {
+			return err
+		}
+	}
+	return s.releaseQuotaViolations(now)
+}
+
+// enforceQuotas 对尚未触发过的超额执行动作并记录
+func (s *NetService) enforceQuotas(exceeded []quotaExceeded, now time.Time) error {
+	active, err := s.store.QuotaViolationStore.FindActive(now)
+	if err != nil {
+		return err
+	}
+	type violationKey struct {
+		ruleID uint
+		key    string
+	}
+	fired := make(map[violationKey]bool, len(active))
+	for _, violation := range active {
+		fired[violationKey{violation.RuleID, violation.Key}] = true
+	}
+
+	bannedIpNets, allowIpNets, err := s.loadBanIpNets()
+	if err != nil {
+		return err
+	}
+
+	for _, e := range exceeded {
+		if fired[violationKey{e.rule.ID, e.key}] {
+			continue
+		}
+		violation, err := s.enforceQuota(e, bannedIpNets, allowIpNets)
+		if err != nil {
+			slog.Error("执行带宽配额动作失败", "target", e.key, "rule", e.rule.ID, "action", e.rule.Action, "error", err)
+			continue
+		}
+		if violation.Applied && violation.Action == store.ActionBan {
+			if ipNet := parseIpNet(e.key); ipNet != nil {
+				bannedIpNets = append(bannedIpNets, ipNet)
+			}
+		}
+	}
+	return nil
+}
+
+// enforceQuota 执行规则的动作，ban和limit在周期结束时解除
+func (s *NetService) enforceQuota(e quotaExceeded, bannedIpNets, allowIpNets []*net.IPNet) (*store.QuotaViolation, error) {
+	violation := &store.QuotaViolation{
+		RuleID:      e.rule.ID,
+		Key:         e.key,
+		PeriodStart: e.start,
+		Usage:       e.usage,
+		Action:      e.rule.Action,
+		ExpiresAt:   e.end,
+	}
+
+	ipNet := parseIpNet(e.key)
+	if ipNet == nil {
+		return nil, fmt.Errorf("无效的IP地址或CIDR格式: %s", e.key)
+	}
+
+	switch e.rule.Action {
+	case store.ActionBan:
+		// 已被封禁的IP或网段不做修改，周期结束时也不会被解除
+		if !shouldAutoBan(ipNet, bannedIpNets, allowIpNets) {
+			break
+		}
+		// 只被观察的IP或网段升级为封禁，周期结束时恢复为观察
+		existing, err := s.store.IpNetStore.FindByIpNet(e.key)
+		if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, err
+		}
+		violation.Watched = err == nil && existing.Action == store.ActionWatch
+		if err := s.CreateOrUpdateIpNet(e.key, e.rule.GroupID, store.ActionBan); err != nil {
+			return nil, fmt.Errorf("按配额封禁失败: %w", err)
+		}
+		violation.Applied = true
+	case store.QuotaActionLimit:
+		// 与放行规则相交的IP或网段不限速
+		if ipNetOverlapsAny(allowIpNets, ipNet) {
+			break
+		}
+		if err := s.firewall.Limit(e.key, e.rule.LimitRate); err != nil {
+			return nil, fmt.Errorf("按配额限速失败: %w", err)
+		}
+		violation.Applied = true
+	}
+
+	if err := s.store.QuotaViolationStore.Create(violation); err != nil {
+		return nil, fmt.Errorf("记录超额失败: %w", err)
+	}
+	slog.Warn("超出带宽配额", "target", e.key, "rule", e.rule.ID, "usage", e.usage, "budget", e.rule.Budget,
+		"action", e.rule.Action, "applied", violation.Applied, "until", e.end)
+	return violation, nil
+}
+
+// releaseQuotaViolations 解除已到期的限速和封禁，并删除到期的超额记录
+func (s *NetService) releaseQuotaViolations(now time.Time) error {
+	violations, err := s.store.QuotaViolationStore.FindExpired(now)
+	if err != nil {
+		return err
+	}
+	for i := range violations {
+		if err := s.releaseQuotaViolation(&violations[i]); err != nil {
+			slog.Error("解除带宽配额动作失败", "target", violations[i].Key, "rule", violations[i].RuleID, "error", err)
+		}
+	}
+	return nil
+}
+
+// releaseQuotaViolation 撤销超额时执行的动作并删除记录，期间被人工改为放行的规则保留
+func (s *NetService) releaseQuotaViolation(violation *store.QuotaViolation) error {
+	if violation.Applied {
+		switch violation.Action {
+		case store.ActionBan:
+			ipNet, err := s.store.IpNetStore.FindByIpNet(violation.Key)
+			if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+				return err
+			}
+			if err == nil && ipNet.Action == store.ActionBan {
+				if violation.Watched {
+					if err := s.UpdateIpNetAction(ipNet.ID, store.ActionWatch); err != nil {
+						return err
+					}
+				} else if err := s.DeleteIpNet(ipNet.ID); err != nil {
+					return err
+				}
+			}
+		case store.QuotaActionLimit:
+			if err := s.firewall.RevertLimit(violation.Key); err != nil {
+				return err
+			}
+		}
+		slog.Info("带宽配额周期结束，解除动作", "target", violation.Key, "rule", violation.RuleID, "action", violation.Action)
+	}
+	return s.store.QuotaViolationStore.DeleteByID(violation.ID)
+}
+
+// ListQuotaRules 获取全部配额规则及当前周期的用量
+func (s *NetService) ListQuotaRules() ([]QuotaRule, error) {
+	rules, err := s.store.QuotaRuleStore.FindAll()
+	if err != nil {
+		return nil, err
+	}
+	groups, err := s.store.IpNetGroupStore.FindAll()
+	if err != nil {
+		return nil, err
+	}
+	groupMap := make(map[uint]IpGroup, len(groups))
+	for _, group := range groups {
+		groupMap[group.ID] = convertToIpNetGroup(&group)
+	}
+	now := time.Now()
+	active, err := s.store.QuotaViolationStore.FindActive(now)
+	if err != nil {
+		return nil, err
+	}
+	exceeded := make(map[uint]int)
+	for _, violation := range active {
+		exceeded[violation.RuleID]++
+	}
+
+	result := make([]QuotaRule, 0, len(rules))
+	for _, rule := range rules {
+		var group *IpGroup
+		if g, ok := groupMap[rule.GroupID]; ok {
+			group = &g
+		}
+		start, end := quotaPeriod(rule.Period, now)
+		var used uint64
+		for _, bytes := range s.quotas.usages(rule.ID, start) {
+			used = max(used, bytes)
+		}
+		result = append(result, convertToQuotaRule(&rule, group, start, end, used, exceeded[rule.ID]))
+	}
+	return result, nil
+}
+
+// CreateQuotaRule 创建配额规则，未指定组时使用默认组
+func (s *NetService) CreateQuotaRule(target string, perIP bool, budget uint64, period, action string, limitRate uint64, groupId uint, description string) (QuotaRule, error) {
+	if parseIpNet(target) == nil {
+		return QuotaRule{}, fmt.Errorf("无效的IP地址或CIDR格式: %s", target)
+	}
+	if budget == 0 {
+		return QuotaRule{}, fmt.Errorf("配额必须大于0")
+	}
+	switch period {
+	case store.QuotaPeriodHour, store.QuotaPeriodDay, store.QuotaPeriodWeek, store.QuotaPeriodMonth:
+	default:
+		return QuotaRule{}, fmt.Errorf("不支持的配额周期: %s", period)
+	}
+	switch action {
+	case store.ActionBan, store.QuotaActionNotify:
+	case store.QuotaActionLimit:
+		if limitRate == 0 {
+			return QuotaRule{}, fmt.Errorf("limit动作需要指定限速")
+		}
+	default:
+		return QuotaRule{}, fmt.Errorf("不支持的配额动作: %s", action)
+	}
+	// 限速和封禁需要防火墙规则，创建时拒绝防火墙不支持的地址族，避免超额时才失败
+	if action != store.QuotaActionNotify {
+		if err := s.firewall.CheckIpNet(target); err != nil {
+			return QuotaRule{}, err
+		}
+	}
+
+	var group *store.IpNetGroup
+	var err error
+	if groupId == 0 {
+		group, err = s.store.IpNetGroupStore.FindDefault()
+	} else {
+		group, err = s.store.IpNetGroupStore.FindByID(groupId)
+	}
+	if err != nil {
+		return QuotaRule{}, fmt.Errorf("指定的组不存在: %w", err)
+	}
+
+	rule := &store.QuotaRule{
+		Target:      target,
+		PerIP:       perIP,
+		Budget:      budget,
+		Period:      period,
+		Action:      action,
+		LimitRate:   limitRate,
+		GroupID:     group.ID,
+		Description: description,
+	}
+	if err := s.store.QuotaRuleStore.Create(rule); err != nil {
+		return QuotaRule{}, fmt.Errorf("创建配额规则失败: %w", err)
+	}
+	g := convertToIpNetGroup(group)
+	start, end := quotaPeriod(rule.Period, time.Now())
+	return convertToQuotaRule(rule, &g, start, end, 0, 0), nil
+}
+
+// DeleteQuotaRule 删除配额规则，并解除该规则尚未到期的限速和封禁
+func (s *NetService) DeleteQuotaRule(id uint) error {
+	violations, err := s.store.QuotaViolationStore.FindByRuleID(id)
+	if err != nil {
+		return err
+	}
+	for i := range violations {
+		if err := s.releaseQuotaViolation(&violations[i]); err != nil {
+			return fmt.Errorf("解除配额动作失败: %w", err)
+		}
+	}
+
+	if err := s.store.QuotaRuleStore.DeleteByID(id); err != nil {
+		return fmt.Errorf("删除配额规则失败: %w", err)
+	}
+	s.quotas.remove(id)
+	return nil
+}
+
+// GetQuotaUsage 获取配额规则当前周期内各远程IP或网段的用量，用量最大的在前
+func (s *NetService) GetQuotaUsage(ruleId uint, limit int) ([]QuotaUsage, error) {
+	rule, err := s.store.QuotaRuleStore.FindByID(ruleId)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, fmt.Errorf("配额规则不存在: %w", err)
+		}
+		return nil, err
+	}
+	violations, err := s.store.QuotaViolationStore.FindByRuleID(ruleId)
+	if err != nil {
+		return nil, err
+	}
+	bannedIpNets, allowIpNets, err := s.loadBanIpNets()
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	start, _ := quotaPeriod(rule.Period, now)
+	usages := s.quotas.usages(rule.ID, start)
+	fired := make(map[string]*store.QuotaViolation)
+	for i, violation := range violations {
+		if violation.ExpiresAt.After(now) {
+			fired[violation.Key] = &violations[i]
+			// 重启后内存中的用量从零开始，至少显示超额时的用量
+			usages[violation.Key] = max(usages[violation.Key], violation.Usage)
+		}
+	}
+
+	result := make([]QuotaUsage, 0, len(usages))
+	for key, used := range usages {
+		result = append(result, convertToQuotaUsage(rule, key, used, fired[key], bannedIpNets, allowIpNets))
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Used > result[j].Used
+	})
+	if limit > 0 && len(result) > limit {
+		result = result[:limit]
+	}
+	return result, nil
+}
@@ -0,0 +1,134 @@
+package service
+
+import (
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/graydovee/netbouncer/pkg/core"
+	"github.com/graydovee/netbouncer/pkg/store"
+)
+
+func Test_quotaPeriod(t *testing.T) {
+	// 2024-03-13 是周三
+	now := time.Date(2024, 3, 13, 15, 42, 7, 0, time.UTC)
+	tests := []struct {
+		period string
+		start  time.Time
+		end    time.Time
+	}{
+		{store.QuotaPeriodHour, time.Date(2024, 3, 13, 15, 0, 0, 0, time.UTC), time.Date(2024, 3, 13, 16, 0, 0, 0, time.UTC)},
+		{store.QuotaPeriodDay, time.Date(2024, 3, 13, 0, 0, 0, 0, time.UTC), time.Date(2024, 3, 14, 0, 0, 0, 0, time.UTC)},
+		{store.QuotaPeriodWeek, time.Date(2024, 3, 11, 0, 0, 0, 0, time.UTC), time.Date(2024, 3, 18, 0, 0, 0, 0, time.UTC)},
+		{store.QuotaPeriodMonth, time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC), time.Date(2024, 4, 1, 0, 0, 0, 0, time.UTC)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.period, func(t *testing.T) {
+			start, end := quotaPeriod(tt.period, now)
+			if !start.Equal(tt.start) || !end.Equal(tt.end) {
+				t.Errorf("quotaPeriod() = %v, %v, want %v, %v", start, end, tt.start, tt.end)
+			}
+		})
+	}
+
+	// 周日属于上一周
+	start, _ := quotaPeriod(store.QuotaPeriodWeek, time.Date(2024, 3, 17, 23, 0, 0, 0, time.UTC))
+	if want := time.Date(2024, 3, 11, 0, 0, 0, 0, time.UTC); !start.Equal(want) {
+		t.Errorf("quotaPeriod(sunday) = %v, want %v", start, want)
+	}
+}
+
+func Test_quotaTracker_add(t *testing.T) {
+	rules := []store.QuotaRule{
+		{ID: 1, Target: "10.0.0.0/24", PerIP: true, Budget: 1000, Period: store.QuotaPeriodDay},
+		{ID: 2, Target: "10.0.0.0/24", Budget: 1500, Period: store.QuotaPeriodDay},
+	}
+	now := time.Date(2024, 3, 13, 15, 0, 0, 0, time.UTC)
+	tracker := newQuotaTracker()
+
+	exceeded := tracker.add(rules, []core.TrafficDelta{
+		{RemoteIP: "10.0.0.1", BytesSent: 600, BytesRecv: 100},
+		{RemoteIP: "10.0.0.2", BytesSent: 500},
+		{RemoteIP: "192.168.0.1", BytesSent: 5000},
+	}, now)
+	if len(exceeded) != 0 {
+		t.Fatalf("add() exceeded = %v, want none", exceeded)
+	}
+
+	exceeded = tracker.add(rules, []core.TrafficDelta{
+		{RemoteIP: "10.0.0.1", BytesRecv: 300},
+	}, now.Add(time.Hour))
+	got := make([]string, 0, len(exceeded))
+	for _, e := range exceeded {
+		got = append(got, e.key)
+	}
+	sort.Strings(got)
+	if len(got) != 2 || got[0] != "10.0.0.0/24" || got[1] != "10.0.0.1" {
+		t.Errorf("add() exceeded keys = %v, want [10.0.0.0/24 10.0.0.1]", got)
+	}
+	if usage := tracker.usages(2, exceeded[0].start)["10.0.0.0/24"]; usage != 1500 {
+		t.Errorf("usages() = %d, want 1500", usage)
+	}
+
+	// 新周期的用量从零开始
+	exceeded = tracker.add(rules, []core.TrafficDelta{
+		{RemoteIP: "10.0.0.1", BytesRecv: 10},
+	}, now.Add(24*time.Hour))
+	if len(exceeded) != 0 {
+		t.Errorf("add() next period exceeded = %v, want none", exceeded)
+	}
+	start, _ := quotaPeriod(store.QuotaPeriodDay, now.Add(24*time.Hour))
+	if usages := tracker.usages(1, start); len(usages) != 1 || usages["10.0.0.1"] != 10 {
+		t.Errorf("usages() next period = %v, want map[10.0.0.1:10]", usages)
+	}
+
+	// 删除的规则不再保留用量
+	tracker.add(rules[:1], nil, now.Add(24*time.Hour))
+	if usages := tracker.usages(2, start); len(usages) != 0 {
+		t.Errorf("usages() removed rule = %v, want empty", usages)
+	}
+}
+
+func Test_NetService_seedQuotaUsage(t *testing.T) {
+	s := newTestNetService(t)
+	s.quotas = newQuotaTracker()
+	now := time.Date(2024, 3, 13, 15, 30, 0, 0, time.Local)
+	rules := []*store.QuotaRule{
+		{Target: "10.0.0.0/24", PerIP: true, Budget: 1000, Period: store.QuotaPeriodDay, Action: store.QuotaActionNotify},
+		{Target: "10.0.0.0/24", Budget: 1000, Period: store.QuotaPeriodHour, Action: store.QuotaActionNotify},
+	}
+	for _, rule := range rules {
+		if err := s.store.QuotaRuleStore.Create(rule); err != nil {
+			t.Fatal(err)
+		}
+	}
+	err := s.store.TrafficSnapshotStore.BatchAdd([]store.TrafficSnapshot{
+		// 前一天的流量不计入
+		{Resolution: store.ResolutionHour, Time: now.Add(-24 * time.Hour), RemoteIP: "10.0.0.1", BytesIn: 5000},
+		{Resolution: store.ResolutionHour, Time: now.Add(-2 * time.Hour).Truncate(time.Hour), RemoteIP: "10.0.0.1", BytesIn: 300, BytesOut: 100},
+		{Resolution: store.ResolutionMinute, Time: now.Add(-10 * time.Minute), RemoteIP: "10.0.0.1", BytesIn: 200},
+		{Resolution: store.ResolutionMinute, Time: now.Add(-10 * time.Minute), RemoteIP: "10.0.0.2", BytesOut: 50},
+		{Resolution: store.ResolutionMinute, Time: now.Add(-10 * time.Minute), RemoteIP: "192.168.0.1", BytesOut: 7000},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.seedQuotaUsage(now); err != nil {
+		t.Fatalf("seedQuotaUsage() error = %v", err)
+	}
+	dayStart, _ := quotaPeriod(store.QuotaPeriodDay, now)
+	if usages := s.quotas.usages(rules[0].ID, dayStart); len(usages) != 2 || usages["10.0.0.1"] != 600 || usages["10.0.0.2"] != 50 {
+		t.Errorf("usages() day = %v, want map[10.0.0.1:600 10.0.0.2:50]", usages)
+	}
+	hourStart, _ := quotaPeriod(store.QuotaPeriodHour, now)
+	if usages := s.quotas.usages(rules[1].ID, hourStart); len(usages) != 1 || usages["10.0.0.0/24"] != 250 {
+		t.Errorf("usages() hour = %v, want map[10.0.0.0/24:250]", usages)
+	}
+
+	// 恢复的用量与之后新增的流量一起计算
+	exceeded := s.quotas.add([]store.QuotaRule{*rules[0], *rules[1]}, []core.TrafficDelta{{RemoteIP: "10.0.0.1", BytesRecv: 400}}, now)
+	if len(exceeded) != 1 || exceeded[0].key != "10.0.0.1" || exceeded[0].usage != 1000 {
+		t.Errorf("add() exceeded = %v, want 10.0.0.1 with 1000", exceeded)
+	}
+}
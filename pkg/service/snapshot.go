@@ -0,0 +1,118 @@
+package service
+
+import (
+	"log/slog"
+	"time"
+
+	"github.com/graydovee/netbouncer/pkg/config"
+	"github.com/graydovee/netbouncer/pkg/store"
+)
+
+const day = 24 * time.Hour
+
+// StartSnapshotRoutine 启动定期将流量写入数据库的协程，并定期合并和清理过期记录
+func (s *NetService) StartSnapshotRoutine(cfg *config.SnapshotConfig) {
+	interval := time.Duration(cfg.Interval) * time.Second
+	if interval <= 0 {
+		interval = time.Minute
+	}
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		compactTicker := time.NewTicker(time.Hour)
+		defer compactTicker.Stop()
+
+		s.compactSnapshots(cfg)
+		for {
+			select {
+			case <-ticker.C:
+				if err := s.flushSnapshots(); err != nil {
+					slog.Error("写入流量快照失败", "error", err)
+				}
+			case <-compactTicker.C:
+				s.compactSnapshots(cfg)
+			}
+		}
+	}()
+
+	slog.Info("流量快照已启用", "interval", interval)
+}
+
+// flushSnapshots 将各远程IP自上次写入以来新增的流量写入分钟级记录
+func (s *NetService) flushSnapshots() error {
+	deltas := s.monitor.CollectDeltas()
+	if len(deltas) == 0 {
+		return nil
+	}
+
+	now := time.Now().Truncate(time.Minute)
+	snapshots := make([]store.TrafficSnapshot, 0, len(deltas))
+	for _, delta := range deltas {
+		snapshots = append(snapshots, store.TrafficSnapshot{
+			Resolution: store.ResolutionMinute,
+			Time:       now,
+			RemoteIP:   delta.RemoteIP,
+			BytesIn:    delta.BytesRecv,
+			BytesOut:   delta.BytesSent,
+			PacketsIn:  delta.PacketsRecv,
+			PacketsOut: delta.PacketsSent,
+		})
+	}
+	return s.store.TrafficSnapshotStore.BatchAdd(snapshots)
+}
+
+// compactSnapshots 按保留时间将分钟级记录合并为小时级、小时级合并为天级，并删除过期的天级记录
+func (s *NetService) compactSnapshots(cfg *config.SnapshotConfig) {
+	now := time.Now()
+	snapshotStore := s.store.TrafficSnapshotStore
+
+	minuteBefore := now.Add(-time.Duration(max(cfg.MinuteRetention, 1)) * day)
+	if err := snapshotStore.Downsample(store.ResolutionMinute, store.ResolutionHour, time.Hour, minuteBefore); err != nil {
+		slog.Error("合并分钟级流量快照失败", "error", err)
+	}
+
+	hourBefore := now.Add(-time.Duration(max(cfg.HourRetention, 1)) * day)
+	if err := snapshotStore.Downsample(store.ResolutionHour, store.ResolutionDay, day, hourBefore); err != nil {
+		slog.Error("合并小时级流量快照失败", "error", err)
+	}
+
+	if cfg.DayRetention > 0 {
+		dayBefore := now.Add(-time.Duration(cfg.DayRetention) * day)
+		if err := snapshotStore.DeleteBefore(store.ResolutionDay, dayBefore); err != nil {
+			slog.Error("清理过期流量快照失败", "error", err)
+		}
+	}
+}
+
+// GetHistoricalTopTalkers 获取过去某段时间内总流量最大的远程IP
+func (s *NetService) GetHistoricalTopTalkers(start, end time.Time, limit int) ([]HistoricalTalker, error) {
+	totals, err := s.store.TrafficSnapshotStore.TopTalkers(start, end, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	bannedIpNets, allowIpNets, err := s.loadBanIpNets()
+	if err != nil {
+		return nil, err
+	}
+	watchIpNets, err := s.loadWatchIpNets()
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]HistoricalTalker, 0, len(totals))
+	for _, total := range totals {
+		verdict := GetVerdict(bannedIpNets, allowIpNets, watchIpNets, total.RemoteIP)
+		result = append(result, HistoricalTalker{
+			RemoteIP:        total.RemoteIP,
+			TotalBytesIn:    total.BytesIn,
+			TotalBytesOut:   total.BytesOut,
+			TotalPacketsIn:  total.PacketsIn,
+			TotalPacketsOut: total.PacketsOut,
+			IsBanned:        verdict == VerdictBan,
+			Verdict:         verdict,
+		})
+	}
+	return result, nil
+}
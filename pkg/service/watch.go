@@ -0,0 +1,156 @@
+package service
+
+import (
+	"log/slog"
+	"maps"
+	"net"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/graydovee/netbouncer/pkg/core"
+	"github.com/graydovee/netbouncer/pkg/store"
+)
+
+const (
+	watchInterval  = 5 * time.Second // 检查观察IP的周期
+	watchIdleGap   = 5 * time.Minute // 观察IP停止活动超过该时间后再次出现时重新产生事件
+	maxWatchEvents = 1000            // 保留的观察事件数量
+)
+
+// watchTracker 在内存中记录观察IP的出现事件，重启后从零开始
+type watchTracker struct {
+	mutex  sync.Mutex
+	active map[string]struct{} // 上次检查时正在活动的观察IP
+	nextID uint64
+	events []WatchEvent // 按时间顺序保留最近的事件
+}
+
+func newWatchTracker() *watchTracker {
+	return &watchTracker{active: make(map[string]struct{})}
+}
+
+// observe 找出stats中命中观察规则且仍在活动的远程IP，上次检查时不在活动中的产生事件，返回新产生的事件
+// match返回远程IP命中的观察规则，未命中时返回nil
+func (w *watchTracker) observe(stats map[string]*core.TrafficStats, match func(remoteIP string) *store.IpNet, now time.Time) []WatchEvent {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	var raised []WatchEvent
+	active := make(map[string]struct{})
+	for _, remoteIP := range slices.Sorted(maps.Keys(stats)) {
+		stat := stats[remoteIP]
+		if now.Sub(stat.LastSeen) > watchIdleGap {
+			continue
+		}
+		rule := match(remoteIP)
+		if rule == nil {
+			continue
+		}
+		active[remoteIP] = struct{}{}
+		if _, exists := w.active[remoteIP]; exists {
+			continue
+		}
+
+		w.nextID++
+		raised = append(raised, WatchEvent{
+			ID:            w.nextID,
+			RemoteIP:      remoteIP,
+			IpNet:         rule.IpNet,
+			GroupID:       rule.GroupID,
+			LocalIP:       stat.LocalIP,
+			TotalBytesIn:  stat.BytesRecv,
+			TotalBytesOut: stat.BytesSent,
+			FirstSeen:     stat.FirstSeen.Format(time.RFC3339),
+			Time:          now.Format(time.RFC3339),
+		})
+	}
+	// 不再活动、统计已过期或规则已删除的IP下次出现时重新产生事件
+	w.active = active
+
+	w.events = append(w.events, raised...)
+	if len(w.events) > maxWatchEvents {
+		w.events = w.events[len(w.events)-maxWatchEvents:]
+	}
+	return raised
+}
+
+// since 返回ID大于since的事件，最新的在前，limit为0时返回全部
+func (w *watchTracker) since(since uint64, limit int) []WatchEvent {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	result := make([]WatchEvent, 0)
+	for i := len(w.events) - 1; i >= 0 && w.events[i].ID > since; i-- {
+		if limit > 0 && len(result) >= limit {
+			break
+		}
+		result = append(result, w.events[i])
+	}
+	return result
+}
+
+// StartWatchRoutine 启动定期检查观察IP是否出现在流量统计中的协程
+func (s *NetService) StartWatchRoutine() {
+	go func() {
+		ticker := time.NewTicker(watchInterval)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			if err := s.checkWatches(time.Now()); err != nil {
+				slog.Error("检查观察IP失败", "error", err)
+			}
+		}
+	}()
+
+	slog.Info("观察IP事件已启用", "interval", watchInterval)
+}
+
+// checkWatches 将流量统计中的远程IP与观察规则匹配，新出现的观察IP产生事件
+func (s *NetService) checkWatches(now time.Time) error {
+	rules, err := s.store.IpNetStore.FindByAction(store.ActionWatch)
+	if err != nil {
+		return err
+	}
+	if len(rules) == 0 {
+		s.watches.observe(nil, nil, now)
+		return nil
+	}
+
+	bannedIpNets, allowIpNets, err := s.loadBanIpNets()
+	if err != nil {
+		return err
+	}
+	watchIpNets := make([]*net.IPNet, len(rules))
+	for i := range rules {
+		watchIpNets[i] = parseIpNet(rules[i].IpNet)
+	}
+
+	match := func(remoteIP string) *store.IpNet {
+		// 同时被放行或封禁的IP以放行和封禁为准
+		if GetVerdict(bannedIpNets, allowIpNets, nil, remoteIP) != VerdictNone {
+			return nil
+		}
+		ip := net.ParseIP(remoteIP)
+		if ip == nil {
+			return nil
+		}
+		for i, ipNet := range watchIpNets {
+			if ipNet != nil && ipNet.Contains(ip) {
+				return &rules[i]
+			}
+		}
+		return nil
+	}
+
+	for _, event := range s.watches.observe(s.monitor.GetAllStats(), match, now) {
+		slog.Warn("观察的IP出现在流量中", "ip", event.RemoteIP, "rule", event.IpNet, "local_ip", event.LocalIP,
+			"bytes_in", event.TotalBytesIn, "bytes_out", event.TotalBytesOut)
+	}
+	return nil
+}
+
+// GetWatchEvents 获取ID大于since的观察事件，最新的在前，limit为0时返回全部
+func (s *NetService) GetWatchEvents(since uint64, limit int) []WatchEvent {
+	return s.watches.since(since, limit)
+}
@@ -0,0 +1,53 @@
+package service
+
+import (
+	"testing"
+	"time"
+
+	"github.com/graydovee/netbouncer/pkg/core"
+	"github.com/graydovee/netbouncer/pkg/store"
+)
+
+func Test_watchTracker_observe(t *testing.T) {
+	now := time.Date(2024, 3, 13, 15, 0, 0, 0, time.UTC)
+	rule := &store.IpNet{IpNet: "192.0.2.0/24", GroupID: 2, Action: store.ActionWatch}
+	match := func(remoteIP string) *store.IpNet {
+		if parseIpNet(rule.IpNet).Contains(parseIpNet(remoteIP).IP) {
+			return rule
+		}
+		return nil
+	}
+	stats := map[string]*core.TrafficStats{
+		"192.0.2.7":    {RemoteIP: "192.0.2.7", LocalIP: "10.0.0.1", BytesRecv: 100, LastSeen: now},
+		"192.0.2.8":    {RemoteIP: "192.0.2.8", LastSeen: now.Add(-watchIdleGap - time.Second)},
+		"198.51.100.1": {RemoteIP: "198.51.100.1", LastSeen: now},
+	}
+
+	w := newWatchTracker()
+	raised := w.observe(stats, match, now)
+	if len(raised) != 1 || raised[0].RemoteIP != "192.0.2.7" || raised[0].IpNet != "192.0.2.0/24" || raised[0].GroupID != 2 || raised[0].TotalBytesIn != 100 {
+		t.Fatalf("observe() = %+v, want event for 192.0.2.7", raised)
+	}
+
+	// 持续活动的IP不重复产生事件，空闲的IP重新活动后产生新事件
+	stats["192.0.2.8"].LastSeen = now.Add(watchInterval)
+	raised = w.observe(stats, match, now.Add(watchInterval))
+	if len(raised) != 1 || raised[0].RemoteIP != "192.0.2.8" || raised[0].ID != 2 {
+		t.Fatalf("observe() = %+v, want event 2 for 192.0.2.8", raised)
+	}
+
+	// 统计过期后再次出现
+	delete(stats, "192.0.2.7")
+	w.observe(stats, match, now.Add(2*watchInterval))
+	stats["192.0.2.7"] = &core.TrafficStats{RemoteIP: "192.0.2.7", LastSeen: now.Add(3 * watchInterval)}
+	if raised = w.observe(stats, match, now.Add(3*watchInterval)); len(raised) != 1 || raised[0].RemoteIP != "192.0.2.7" {
+		t.Fatalf("observe() = %+v, want event for reappeared 192.0.2.7", raised)
+	}
+
+	if got := w.since(1, 0); len(got) != 2 || got[0].ID != 3 || got[1].ID != 2 {
+		t.Errorf("since(1, 0) = %+v, want events 3 and 2", got)
+	}
+	if got := w.since(0, 1); len(got) != 1 || got[0].ID != 3 {
+		t.Errorf("since(0, 1) = %+v, want event 3", got)
+	}
+}
@@ -5,6 +5,7 @@ import "time"
 const (
 	ActionAllow = "allow"
 	ActionBan   = "ban"
+	ActionWatch = "watch" // 只观察不修改防火墙，出现在流量中时产生事件
 )
 
 // IpModel 数据库模型
@@ -34,3 +35,111 @@ type IpNetGroup struct {
 func (IpNetGroup) TableName() string {
 	return "banned_ip_net_group"
 }
+
+const (
+	ResolutionMinute = "minute"
+	ResolutionHour   = "hour"
+	ResolutionDay    = "day"
+)
+
+// TrafficSnapshot 某个远程IP在一个时间段内的流量汇总，用于历史查询
+type TrafficSnapshot struct {
+	ID         uint      `gorm:"primarykey"`
+	Resolution string    `gorm:"type:varchar(10);not null;uniqueIndex:idx_traffic_snapshot_bucket,priority:1"`
+	Time       time.Time `gorm:"not null;uniqueIndex:idx_traffic_snapshot_bucket,priority:2"` // 时间段起点
+	RemoteIP   string    `gorm:"not null;uniqueIndex:idx_traffic_snapshot_bucket,priority:3;index"`
+	BytesIn    uint64    `gorm:"not null;default:0"`
+	BytesOut   uint64    `gorm:"not null;default:0"`
+	PacketsIn  uint64    `gorm:"not null;default:0"`
+	PacketsOut uint64    `gorm:"not null;default:0"`
+}
+
+func (TrafficSnapshot) TableName() string {
+	return "traffic_snapshot"
+}
+
+const (
+	FingerprintJA3 = "ja3"
+	FingerprintJA4 = "ja4"
+
+	// FingerprintActionFlag 记录匹配的IP并以观察行为加入组，不修改防火墙
+	FingerprintActionFlag = "flag"
+)
+
+// FingerprintRule TLS客户端指纹规则，出现该指纹的远程IP按Action处理并加入GroupID对应的组
+type FingerprintRule struct {
+	ID          uint   `gorm:"primarykey"`
+	Type        string `gorm:"type:varchar(10);not null"` // ja3 或 ja4
+	Fingerprint string `gorm:"uniqueIndex;not null"`
+	Action      string `gorm:"type:varchar(10);not null"` // ban 或 flag
+	GroupID     uint   `gorm:"index"`
+	Description string `gorm:"type:text"`
+	CreatedAt   time.Time
+	UpdatedAt   time.Time
+}
+
+func (FingerprintRule) TableName() string {
+	return "fingerprint_rule"
+}
+
+// FingerprintMatch 命中指纹规则的远程IP
+type FingerprintMatch struct {
+	ID        uint   `gorm:"primarykey"`
+	RuleID    uint   `gorm:"not null;uniqueIndex:idx_fingerprint_match,priority:1"`
+	RemoteIP  string `gorm:"not null;uniqueIndex:idx_fingerprint_match,priority:2"`
+	CreatedAt time.Time
+	UpdatedAt time.Time // 最近一次命中的时间
+}
+
+func (FingerprintMatch) TableName() string {
+	return "fingerprint_match"
+}
+
+const (
+	QuotaPeriodHour  = "hour"
+	QuotaPeriodDay   = "day"
+	QuotaPeriodWeek  = "week"
+	QuotaPeriodMonth = "month"
+
+	// QuotaActionLimit 限制发往超额IP的速率，直到周期结束
+	QuotaActionLimit = "limit"
+	// QuotaActionNotify 只记录超额，不修改防火墙
+	QuotaActionNotify = "notify"
+)
+
+// QuotaRule 带宽配额规则，Target内的远程IP在每个周期内的流量超过Budget后按Action处理
+type QuotaRule struct {
+	ID          uint   `gorm:"primarykey"`
+	Target      string `gorm:"not null"`                  // IP或CIDR
+	PerIP       bool   `gorm:"not null;default:false"`    // 为true时Target内每个IP单独计算配额，否则整个网段共用
+	Budget      uint64 `gorm:"not null"`                  // 每个周期允许的流量（字节，发送和接收合计）
+	Period      string `gorm:"type:varchar(10);not null"` // hour、day、week 或 month
+	Action      string `gorm:"type:varchar(10);not null"` // limit、ban 或 notify
+	LimitRate   uint64 `gorm:"not null;default:0"`        // limit动作的限速（字节/秒）
+	GroupID     uint   `gorm:"index"`                     // ban动作封禁的IP加入的组
+	Description string `gorm:"type:text"`
+	CreatedAt   time.Time
+	UpdatedAt   time.Time
+}
+
+func (QuotaRule) TableName() string {
+	return "quota_rule"
+}
+
+// QuotaViolation 某条配额规则在一个周期内被超出，用于避免重复触发并在周期结束时解除限速和封禁
+type QuotaViolation struct {
+	ID          uint      `gorm:"primarykey"`
+	RuleID      uint      `gorm:"not null;uniqueIndex:idx_quota_violation,priority:1"`
+	Key         string    `gorm:"not null;uniqueIndex:idx_quota_violation,priority:2"` // 超额的远程IP，整个网段共用配额时为规则的Target
+	PeriodStart time.Time `gorm:"not null;uniqueIndex:idx_quota_violation,priority:3"`
+	Usage       uint64    `gorm:"not null;default:0"`        // 超额时的用量
+	Action      string    `gorm:"type:varchar(10);not null"` // 实际执行的动作
+	Applied     bool      `gorm:"not null;default:false"`    // 是否修改了防火墙，周期结束时需要撤销
+	Watched     bool      `gorm:"not null;default:false"`    // 封禁前是否为观察规则，周期结束时恢复为观察
+	ExpiresAt   time.Time `gorm:"not null;index"`            // 周期结束时间
+	CreatedAt   time.Time
+}
+
+func (QuotaViolation) TableName() string {
+	return "quota_violation"
+}
@@ -0,0 +1,42 @@
+package store
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// FingerprintMatchStore 处理 FingerprintMatch 表的数据库操作
+type FingerprintMatchStore struct {
+	db *gorm.DB
+}
+
+// NewFingerprintMatchStore 创建新的 FingerprintMatchStore 实例
+func NewFingerprintMatchStore(db *gorm.DB) *FingerprintMatchStore {
+	return &FingerprintMatchStore{db: db}
+}
+
+// Record 记录一次命中，已有记录时只更新命中时间
+func (s *FingerprintMatchStore) Record(ruleID uint, remoteIP string) error {
+	now := time.Now()
+	model := FingerprintMatch{
+		RuleID:    ruleID,
+		RemoteIP:  remoteIP,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	return s.db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "rule_id"}, {Name: "remote_ip"}},
+		DoUpdates: clause.AssignmentColumns([]string{"updated_at"}),
+	}).Create(&model).Error
+}
+
+// FindByRuleID 获取某条规则的命中记录，最近命中的在前
+func (s *FingerprintMatchStore) FindByRuleID(ruleID uint) ([]FingerprintMatch, error) {
+	var models []FingerprintMatch
+	if err := s.db.Where("rule_id = ?", ruleID).Order("updated_at DESC").Find(&models).Error; err != nil {
+		return nil, err
+	}
+	return models, nil
+}
@@ -0,0 +1,68 @@
+package store
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// FingerprintRuleStore 处理 FingerprintRule 表的数据库操作
+type FingerprintRuleStore struct {
+	db *gorm.DB
+}
+
+// NewFingerprintRuleStore 创建新的 FingerprintRuleStore 实例
+func NewFingerprintRuleStore(db *gorm.DB) *FingerprintRuleStore {
+	return &FingerprintRuleStore{db: db}
+}
+
+// Create 创建新的指纹规则
+func (s *FingerprintRuleStore) Create(fingerprintType, fingerprint, action string, groupID uint, description string) (*FingerprintRule, error) {
+	model := FingerprintRule{
+		Type:        fingerprintType,
+		Fingerprint: fingerprint,
+		Action:      action,
+		GroupID:     groupID,
+		Description: description,
+		CreatedAt:   time.Now(),
+		UpdatedAt:   time.Now(),
+	}
+
+	if err := s.db.Create(&model).Error; err != nil {
+		return nil, err
+	}
+	return &model, nil
+}
+
+// FindByID 根据ID查找指纹规则
+func (s *FingerprintRuleStore) FindByID(id uint) (*FingerprintRule, error) {
+	var model FingerprintRule
+	if err := s.db.First(&model, id).Error; err != nil {
+		return nil, err
+	}
+	return &model, nil
+}
+
+// FindAll 获取所有指纹规则
+func (s *FingerprintRuleStore) FindAll() ([]FingerprintRule, error) {
+	var models []FingerprintRule
+	if err := s.db.Find(&models).Error; err != nil {
+		return nil, err
+	}
+	return models, nil
+}
+
+// DeleteByID 根据ID删除指纹规则及其命中记录
+func (s *FingerprintRuleStore) DeleteByID(id uint) error {
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("rule_id = ?", id).Delete(&FingerprintMatch{}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(&FingerprintRule{}, id).Error
+	})
+}
+
+// UpdateGroupID 将指向某个组的规则改为指向另一个组
+func (s *FingerprintRuleStore) UpdateGroupID(fromGroupID, toGroupID uint) error {
+	return s.db.Model(&FingerprintRule{}).Where("group_id = ?", fromGroupID).Update("group_id", toGroupID).Error
+}
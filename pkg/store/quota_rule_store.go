@@ -0,0 +1,57 @@
+package store
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// QuotaRuleStore 处理 QuotaRule 表的数据库操作
+type QuotaRuleStore struct {
+	db *gorm.DB
+}
+
+// NewQuotaRuleStore 创建新的 QuotaRuleStore 实例
+func NewQuotaRuleStore(db *gorm.DB) *QuotaRuleStore {
+	return &QuotaRuleStore{db: db}
+}
+
+// Create 创建新的配额规则
+func (s *QuotaRuleStore) Create(rule *QuotaRule) error {
+	rule.CreatedAt = time.Now()
+	rule.UpdatedAt = time.Now()
+	return s.db.Create(rule).Error
+}
+
+// FindByID 根据ID查找配额规则
+func (s *QuotaRuleStore) FindByID(id uint) (*QuotaRule, error) {
+	var model QuotaRule
+	if err := s.db.First(&model, id).Error; err != nil {
+		return nil, err
+	}
+	return &model, nil
+}
+
+// FindAll 获取所有配额规则
+func (s *QuotaRuleStore) FindAll() ([]QuotaRule, error) {
+	var models []QuotaRule
+	if err := s.db.Find(&models).Error; err != nil {
+		return nil, err
+	}
+	return models, nil
+}
+
+// DeleteByID 根据ID删除配额规则及其超额记录
+func (s *QuotaRuleStore) DeleteByID(id uint) error {
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("rule_id = ?", id).Delete(&QuotaViolation{}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(&QuotaRule{}, id).Error
+	})
+}
+
+// UpdateGroupID 将指向某个组的规则改为指向另一个组
+func (s *QuotaRuleStore) UpdateGroupID(fromGroupID, toGroupID uint) error {
+	return s.db.Model(&QuotaRule{}).Where("group_id = ?", fromGroupID).Update("group_id", toGroupID).Error
+}
@@ -0,0 +1,58 @@
+package store
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// QuotaViolationStore 处理 QuotaViolation 表的数据库操作
+type QuotaViolationStore struct {
+	db *gorm.DB
+}
+
+// NewQuotaViolationStore 创建新的 QuotaViolationStore 实例
+func NewQuotaViolationStore(db *gorm.DB) *QuotaViolationStore {
+	return &QuotaViolationStore{db: db}
+}
+
+// Create 记录一次超额
+func (s *QuotaViolationStore) Create(violation *QuotaViolation) error {
+	violation.CreatedAt = time.Now()
+	// sqlite以文本保存时间，统一使用UTC保证比较顺序正确
+	violation.PeriodStart = violation.PeriodStart.UTC()
+	violation.ExpiresAt = violation.ExpiresAt.UTC()
+	return s.db.Create(violation).Error
+}
+
+// FindActive 获取尚未到期的超额记录
+func (s *QuotaViolationStore) FindActive(now time.Time) ([]QuotaViolation, error) {
+	var models []QuotaViolation
+	if err := s.db.Where("expires_at > ?", now.UTC()).Find(&models).Error; err != nil {
+		return nil, err
+	}
+	return models, nil
+}
+
+// FindExpired 获取已到期的超额记录
+func (s *QuotaViolationStore) FindExpired(now time.Time) ([]QuotaViolation, error) {
+	var models []QuotaViolation
+	if err := s.db.Where("expires_at <= ?", now.UTC()).Find(&models).Error; err != nil {
+		return nil, err
+	}
+	return models, nil
+}
+
+// FindByRuleID 获取某条规则的超额记录
+func (s *QuotaViolationStore) FindByRuleID(ruleID uint) ([]QuotaViolation, error) {
+	var models []QuotaViolation
+	if err := s.db.Where("rule_id = ?", ruleID).Find(&models).Error; err != nil {
+		return nil, err
+	}
+	return models, nil
+}
+
+// DeleteByID 根据ID删除超额记录
+func (s *QuotaViolationStore) DeleteByID(id uint) error {
+	return s.db.Delete(&QuotaViolation{}, id).Error
+}
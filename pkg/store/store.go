@@ -9,6 +9,14 @@ import (
 type Store struct {
 	IpNetStore      *IpNetStore
 	IpNetGroupStore *IpNetGroupStore
+
+	TrafficSnapshotStore *TrafficSnapshotStore
+
+	FingerprintRuleStore  *FingerprintRuleStore
+	FingerprintMatchStore *FingerprintMatchStore
+
+	QuotaRuleStore      *QuotaRuleStore
+	QuotaViolationStore *QuotaViolationStore
 }
 
 func NewStore(cfg *config.DatabaseConfig) (*Store, error) {
@@ -20,15 +28,28 @@ func NewStore(cfg *config.DatabaseConfig) (*Store, error) {
 	}
 
 	// 自动迁移数据库表结构
-	if err := db.AutoMigrate(IpNet{}, IpNetGroup{}); err != nil {
+	if err := db.AutoMigrate(IpNet{}, IpNetGroup{}, TrafficSnapshot{}, FingerprintRule{}, FingerprintMatch{}, QuotaRule{}, QuotaViolation{}); err != nil {
 		return nil, fmt.Errorf("数据库迁移失败: %w", err)
 	}
 
 	ipNetStore := NewIpNetStore(db)
 	ipNetGroupStore := NewIpNetGroupStore(db)
+	trafficSnapshotStore := NewTrafficSnapshotStore(db)
+	fingerprintRuleStore := NewFingerprintRuleStore(db)
+	fingerprintMatchStore := NewFingerprintMatchStore(db)
+	quotaRuleStore := NewQuotaRuleStore(db)
+	quotaViolationStore := NewQuotaViolationStore(db)
 
 	return &Store{
 		IpNetStore:      ipNetStore,
 		IpNetGroupStore: ipNetGroupStore,
+
+		TrafficSnapshotStore: trafficSnapshotStore,
+
+		FingerprintRuleStore:  fingerprintRuleStore,
+		FingerprintMatchStore: fingerprintMatchStore,
+
+		QuotaRuleStore:      quotaRuleStore,
+		QuotaViolationStore: quotaViolationStore,
 	}, nil
 }
@@ -0,0 +1,140 @@
+package store
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// TrafficSnapshotStore 处理 TrafficSnapshot 表的数据库操作
+type TrafficSnapshotStore struct {
+	db *gorm.DB
+}
+
+// NewTrafficSnapshotStore 创建新的 TrafficSnapshotStore 实例
+func NewTrafficSnapshotStore(db *gorm.DB) *TrafficSnapshotStore {
+	return &TrafficSnapshotStore{db: db}
+}
+
+// TrafficTotal 某个远程IP在一段时间内的流量合计
+type TrafficTotal struct {
+	RemoteIP   string
+	BytesIn    uint64
+	BytesOut   uint64
+	PacketsIn  uint64
+	PacketsOut uint64
+}
+
+// upsertClause 同一时间段内已存在的记录累加流量
+var upsertClause = clause.OnConflict{
+	Columns: []clause.Column{{Name: "resolution"}, {Name: "time"}, {Name: "remote_ip"}},
+	DoUpdates: clause.Assignments(map[string]interface{}{
+		"bytes_in":    gorm.Expr("traffic_snapshot.bytes_in + excluded.bytes_in"),
+		"bytes_out":   gorm.Expr("traffic_snapshot.bytes_out + excluded.bytes_out"),
+		"packets_in":  gorm.Expr("traffic_snapshot.packets_in + excluded.packets_in"),
+		"packets_out": gorm.Expr("traffic_snapshot.packets_out + excluded.packets_out"),
+	}),
+}
+
+// BatchAdd 批量写入快照，同一时间段内已存在的记录累加流量
+func (s *TrafficSnapshotStore) BatchAdd(snapshots []TrafficSnapshot) error {
+	if len(snapshots) == 0 {
+		return nil
+	}
+	// sqlite以文本保存时间，统一使用UTC保证比较顺序正确
+	for i := range snapshots {
+		snapshots[i].Time = snapshots[i].Time.UTC()
+	}
+	return s.db.Clauses(upsertClause).CreateInBatches(&snapshots, 500).Error
+}
+
+// Downsample 将from精度中早于before的记录按bucket长度合并为to精度的记录，并删除原记录
+func (s *TrafficSnapshotStore) Downsample(from, to string, bucket time.Duration, before time.Time) error {
+	before = before.UTC()
+	var oldest TrafficSnapshot
+	err := s.db.Where("resolution = ? AND time < ?", from, before).Order("time").Limit(1).Find(&oldest).Error
+	if err != nil || oldest.ID == 0 {
+		return err
+	}
+
+	// 逐个时间段合并，避免一次加载过多记录
+	for start := oldest.Time.UTC().Truncate(bucket); start.Before(before); start = start.Add(bucket) {
+		end := start.Add(bucket)
+		if end.After(before) {
+			// 只合并完整的时间段
+			break
+		}
+
+		err := s.db.Transaction(func(tx *gorm.DB) error {
+			var totals []TrafficTotal
+			if err := tx.Model(&TrafficSnapshot{}).
+				Select("remote_ip, SUM(bytes_in) AS bytes_in, SUM(bytes_out) AS bytes_out, SUM(packets_in) AS packets_in, SUM(packets_out) AS packets_out").
+				Where("resolution = ? AND time >= ? AND time < ?", from, start, end).
+				Group("remote_ip").
+				Scan(&totals).Error; err != nil {
+				return err
+			}
+			if len(totals) == 0 {
+				return nil
+			}
+
+			snapshots := make([]TrafficSnapshot, 0, len(totals))
+			for _, total := range totals {
+				snapshots = append(snapshots, TrafficSnapshot{
+					Resolution: to,
+					Time:       start,
+					RemoteIP:   total.RemoteIP,
+					BytesIn:    total.BytesIn,
+					BytesOut:   total.BytesOut,
+					PacketsIn:  total.PacketsIn,
+					PacketsOut: total.PacketsOut,
+				})
+			}
+			if err := tx.Clauses(upsertClause).CreateInBatches(&snapshots, 500).Error; err != nil {
+				return err
+			}
+
+			return tx.Where("resolution = ? AND time >= ? AND time < ?", from, start, end).Delete(&TrafficSnapshot{}).Error
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// DeleteBefore 删除某个精度中早于before的记录
+func (s *TrafficSnapshotStore) DeleteBefore(resolution string, before time.Time) error {
+	return s.db.Where("resolution = ? AND time < ?", resolution, before.UTC()).Delete(&TrafficSnapshot{}).Error
+}
+
+// TopTalkers 统计时间段起点在[start, end)内的记录，返回总流量最大的远程IP
+func (s *TrafficSnapshotStore) TopTalkers(start, end time.Time, limit int) ([]TrafficTotal, error) {
+	var totals []TrafficTotal
+	err := s.db.Model(&TrafficSnapshot{}).
+		Select("remote_ip, SUM(bytes_in) AS bytes_in, SUM(bytes_out) AS bytes_out, SUM(packets_in) AS packets_in, SUM(packets_out) AS packets_out").
+		Where("time >= ? AND time < ?", start.UTC(), end.UTC()).
+		Group("remote_ip").
+		Order("SUM(bytes_in) + SUM(bytes_out) DESC").
+		Limit(limit).
+		Scan(&totals).Error
+	if err != nil {
+		return nil, err
+	}
+	return totals, nil
+}
+
+// Totals 统计时间段起点在[start, end)内的记录，返回各远程IP的流量合计
+func (s *TrafficSnapshotStore) Totals(start, end time.Time) ([]TrafficTotal, error) {
+	var totals []TrafficTotal
+	err := s.db.Model(&TrafficSnapshot{}).
+		Select("remote_ip, SUM(bytes_in) AS bytes_in, SUM(bytes_out) AS bytes_out, SUM(packets_in) AS packets_in, SUM(packets_out) AS packets_out").
+		Where("time >= ? AND time < ?", start.UTC(), end.UTC()).
+		Group("remote_ip").
+		Scan(&totals).Error
+	if err != nil {
+		return nil, err
+	}
+	return totals, nil
+}
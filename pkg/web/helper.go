@@ -1,11 +1,15 @@
 package web
 
 import (
+	"errors"
 	"log/slog"
 	"net"
 	"net/http"
+	"regexp"
+	"strconv"
 	"time"
 
+	"github.com/graydovee/netbouncer/pkg/store"
 	"github.com/labstack/echo/v4"
 )
 
@@ -68,3 +72,44 @@ func validateIpNet(input string) error {
 
 	return echo.NewHTTPError(http.StatusBadRequest, "无效的IP地址或CIDR格式")
 }
+
+var (
+	ja3Rex = regexp.MustCompile(`^[0-9a-f]{32}$`)
+	ja4Rex = regexp.MustCompile(`^[a-z0-9]{10}_[0-9a-f]{12}_[0-9a-f]{12}$`)
+)
+
+// validateFingerprint 验证指纹类型和格式，JA3为32位十六进制MD5，JA4为a_b_c三段
+func validateFingerprint(fingerprintType, fingerprint string) error {
+	switch fingerprintType {
+	case store.FingerprintJA3:
+		if ja3Rex.MatchString(fingerprint) {
+			return nil
+		}
+	case store.FingerprintJA4:
+		if ja4Rex.MatchString(fingerprint) {
+			return nil
+		}
+	}
+	return echo.NewHTTPError(http.StatusBadRequest, "无效的指纹类型或格式")
+}
+
+// parseEventQuery 解析事件列表的since和limit参数，since为上次拉取到的最大事件ID，limit默认100
+func parseEventQuery(c echo.Context) (uint64, int, error) {
+	var since uint64
+	if v := c.QueryParam("since"); v != "" {
+		n, err := strconv.ParseUint(v, 10, 64)
+		if err != nil {
+			return 0, 0, errors.New("since必须是非负整数")
+		}
+		since = n
+	}
+	limit := 100
+	if v := c.QueryParam("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > 1000 {
+			return 0, 0, errors.New("limit必须在1到1000之间")
+		}
+		limit = n
+	}
+	return since, limit, nil
+}
@@ -77,3 +77,31 @@ type UpdateGroupRequest struct {
 	Name        string `json:"name"`
 	Description string `json:"description"`
 }
+
+// CreateFingerprintRuleRequest 创建指纹规则请求
+type CreateFingerprintRuleRequest struct {
+	Type        string `json:"type"`
+	Fingerprint string `json:"fingerprint"`
+	Action      string `json:"action"`
+	GroupId     uint   `json:"group_id"`
+	Description string `json:"description"`
+}
+
+// CreateQuotaRuleRequest 创建配额规则请求
+type CreateQuotaRuleRequest struct {
+	Target      string `json:"target"`     // IP或CIDR
+	PerIP       bool   `json:"per_ip"`     // 网段内每个IP单独计算配额
+	Budget      uint64 `json:"budget"`     // 每个周期允许的流量（字节）
+	Period      string `json:"period"`     // hour、day、week 或 month
+	Action      string `json:"action"`     // limit、ban 或 notify
+	LimitRate   uint64 `json:"limit_rate"` // limit动作的限速（字节/秒）
+	GroupId     uint   `json:"group_id"`
+	Description string `json:"description"`
+}
+
+// StartCaptureRequest 按需抓包请求
+type StartCaptureRequest struct {
+	Target      string `json:"target"`       // IP或CIDR
+	Duration    int    `json:"duration"`     // 抓包时间（秒），0表示使用上限
+	PacketLimit int    `json:"packet_limit"` // 最多抓取的包数，0表示使用上限
+}
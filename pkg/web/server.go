@@ -1,10 +1,15 @@
 package web
 
 import (
+	"errors"
 	"io"
 	"log/slog"
+	"net"
 	"net/http"
+	"path/filepath"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/graydovee/netbouncer/pkg/service"
 	"github.com/graydovee/netbouncer/pkg/store"
@@ -44,6 +49,36 @@ func NewServer(netService *service.NetService, authHandler AuthHandler) *Server
 
 	// API路由
 	e.GET("/api/traffic", svr.handleGetTraffic)
+	e.GET("/api/traffic/top", svr.handleGetTopTalkers)
+	e.GET("/api/traffic/prefix", svr.handleGetPrefixTraffic)
+	e.GET("/api/traffic/:ip", svr.handleGetTrafficDetail)
+	e.GET("/api/traffic/:ip/history", svr.handleGetTrafficHistory)
+	e.GET("/api/traffic/local/:ip", svr.handleGetTrafficByLocal)
+
+	e.GET("/api/history/top", svr.handleGetHistoricalTopTalkers)
+
+	e.GET("/api/health/capture", svr.handleGetCaptureHealth)
+
+	e.GET("/api/detections", svr.handleGetDetections)
+	e.GET("/api/watch/events", svr.handleGetWatchEvents)
+	e.GET("/api/anomalies", svr.handleGetAnomalies)
+	e.GET("/api/baselines", svr.handleGetBaselines)
+
+	e.GET("/api/capture", svr.handleListCaptures)
+	e.POST("/api/capture", svr.handleStartCapture)
+	e.GET("/api/capture/:id/download", svr.handleDownloadCapture)
+	e.DELETE("/api/capture/:id", svr.handleDeleteCapture)
+
+	e.GET("/api/fingerprint", svr.handleGetFingerprints)
+	e.GET("/api/fingerprint/rule", svr.handleListFingerprintRules)
+	e.POST("/api/fingerprint/rule", svr.handleCreateFingerprintRule)
+	e.DELETE("/api/fingerprint/rule/:id", svr.handleDeleteFingerprintRule)
+	e.GET("/api/fingerprint/rule/:id/match", svr.handleListFingerprintMatches)
+
+	e.GET("/api/quota", svr.handleListQuotaRules)
+	e.POST("/api/quota", svr.handleCreateQuotaRule)
+	e.DELETE("/api/quota/:id", svr.handleDeleteQuotaRule)
+	e.GET("/api/quota/:id/usage", svr.handleGetQuotaUsage)
 
 	e.GET("/api/ip", svr.handleListAllIpNets)
 	e.GET("/api/ip/:groupId", svr.handleListIpNetsByGroup)
@@ -86,6 +121,378 @@ func (s *Server) handleGetTraffic(c echo.Context) error {
 	return c.JSON(http.StatusOK, Success(trafficData))
 }
 
+// handleGetTopTalkers 获取流量最大的远程IP
+func (s *Server) handleGetTopTalkers(c echo.Context) error {
+	talkers, err := s.netService.GetTopTalkers()
+	if err != nil {
+		return c.JSON(http.StatusOK, Error(500, err.Error()))
+	}
+	return c.JSON(http.StatusOK, Success(talkers))
+}
+
+// handleGetCaptureHealth 获取流量数据源的丢包率和处理延迟
+func (s *Server) handleGetCaptureHealth(c echo.Context) error {
+	return c.JSON(http.StatusOK, Success(s.netService.GetCaptureHealth()))
+}
+
+// handleGetDetections 获取端口扫描和SYN洪水检测事件，since为上次拉取到的最大事件ID
+func (s *Server) handleGetDetections(c echo.Context) error {
+	since, limit, err := parseEventQuery(c)
+	if err != nil {
+		return c.JSON(http.StatusOK, Error(400, err.Error()))
+	}
+
+	detections, err := s.netService.GetDetections(since, limit)
+	if err != nil {
+		return c.JSON(http.StatusOK, Error(500, err.Error()))
+	}
+	return c.JSON(http.StatusOK, Success(detections))
+}
+
+// handleGetWatchEvents 获取观察IP出现在流量中的事件，since为上次拉取到的最大事件ID
+func (s *Server) handleGetWatchEvents(c echo.Context) error {
+	since, limit, err := parseEventQuery(c)
+	if err != nil {
+		return c.JSON(http.StatusOK, Error(400, err.Error()))
+	}
+	return c.JSON(http.StatusOK, Success(s.netService.GetWatchEvents(since, limit)))
+}
+
+// handleGetAnomalies 获取端口流量偏离基线的异常事件，since为上次拉取到的最大事件ID
+func (s *Server) handleGetAnomalies(c echo.Context) error {
+	since, limit, err := parseEventQuery(c)
+	if err != nil {
+		return c.JSON(http.StatusOK, Error(400, err.Error()))
+	}
+
+	anomalies, err := s.netService.GetAnomalies(since, limit)
+	if err != nil {
+		return c.JSON(http.StatusOK, Error(500, err.Error()))
+	}
+	return c.JSON(http.StatusOK, Success(anomalies))
+}
+
+// handleGetBaselines 获取各本地端口当前学习到的流量基线
+func (s *Server) handleGetBaselines(c echo.Context) error {
+	return c.JSON(http.StatusOK, Success(s.netService.GetBaselines()))
+}
+
+// handleListCaptures 获取全部抓包任务
+func (s *Server) handleListCaptures(c echo.Context) error {
+	return c.JSON(http.StatusOK, Success(s.netService.ListCaptures()))
+}
+
+// handleStartCapture 开始针对IP或CIDR的按需抓包
+func (s *Server) handleStartCapture(c echo.Context) error {
+	var r StartCaptureRequest
+	if err := c.Bind(&r); err != nil {
+		return c.JSON(http.StatusOK, Error(400, "参数错误"))
+	}
+	r.Target = strings.TrimSpace(r.Target)
+	if err := validateIpNet(r.Target); err != nil {
+		return c.JSON(http.StatusOK, Error(400, "无效的IP地址或CIDR格式"))
+	}
+	if r.Duration < 0 || r.PacketLimit < 0 {
+		return c.JSON(http.StatusOK, Error(400, "抓包时间和包数不能为负数"))
+	}
+
+	capture, err := s.netService.StartCapture(r.Target, r.Duration, r.PacketLimit)
+	if errors.Is(err, service.ErrCaptureBusy) {
+		return c.JSON(http.StatusOK, Error(429, err.Error()))
+	} else if err != nil {
+		return c.JSON(http.StatusOK, Error(500, err.Error()))
+	}
+	return c.JSON(http.StatusOK, Success(capture))
+}
+
+// handleDownloadCapture 下载已完成的抓包文件
+func (s *Server) handleDownloadCapture(c echo.Context) error {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		return c.JSON(http.StatusOK, Error(400, "无效的抓包任务ID"))
+	}
+
+	path, err := s.netService.CaptureFile(id)
+	if errors.Is(err, service.ErrCaptureNotFound) {
+		return c.JSON(http.StatusOK, Error(404, err.Error()))
+	} else if err != nil {
+		return c.JSON(http.StatusOK, Error(400, err.Error()))
+	}
+	return c.Attachment(path, filepath.Base(path))
+}
+
+// handleDeleteCapture 删除抓包任务及其文件，正在进行的任务先停止
+func (s *Server) handleDeleteCapture(c echo.Context) error {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		return c.JSON(http.StatusOK, Error(400, "无效的抓包任务ID"))
+	}
+
+	if err := s.netService.DeleteCapture(id); errors.Is(err, service.ErrCaptureNotFound) {
+		return c.JSON(http.StatusOK, Error(404, err.Error()))
+	} else if err != nil {
+		return c.JSON(http.StatusOK, Error(500, err.Error()))
+	}
+	return c.JSON(http.StatusOK, Success("已删除"))
+}
+
+// handleGetFingerprints 获取出现次数最多的TLS客户端指纹
+func (s *Server) handleGetFingerprints(c echo.Context) error {
+	limit := 100
+	if v := c.QueryParam("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > 10000 {
+			return c.JSON(http.StatusOK, Error(400, "limit必须在1到10000之间"))
+		}
+		limit = n
+	}
+
+	fingerprints, err := s.netService.GetFingerprints(limit)
+	if err != nil {
+		return c.JSON(http.StatusOK, Error(500, err.Error()))
+	}
+	return c.JSON(http.StatusOK, Success(fingerprints))
+}
+
+// handleListFingerprintRules 获取全部指纹规则
+func (s *Server) handleListFingerprintRules(c echo.Context) error {
+	rules, err := s.netService.ListFingerprintRules()
+	if err != nil {
+		return c.JSON(http.StatusOK, Error(500, err.Error()))
+	}
+	return c.JSON(http.StatusOK, Success(rules))
+}
+
+// handleCreateFingerprintRule 创建指纹规则
+func (s *Server) handleCreateFingerprintRule(c echo.Context) error {
+	var r CreateFingerprintRuleRequest
+	if err := c.Bind(&r); err != nil {
+		return c.JSON(http.StatusOK, Error(400, "参数错误"))
+	}
+	r.Fingerprint = strings.ToLower(strings.TrimSpace(r.Fingerprint))
+	if err := validateFingerprint(r.Type, r.Fingerprint); err != nil {
+		return c.JSON(http.StatusOK, Error(400, "无效的指纹类型或格式"))
+	}
+	if r.Action != store.ActionBan && r.Action != store.FingerprintActionFlag {
+		return c.JSON(http.StatusOK, Error(400, "动作必须为ban或flag"))
+	}
+
+	rule, err := s.netService.CreateFingerprintRule(r.Type, r.Fingerprint, r.Action, r.GroupId, r.Description)
+	if err != nil {
+		return c.JSON(http.StatusOK, Error(500, err.Error()))
+	}
+	return c.JSON(http.StatusOK, Success(rule))
+}
+
+// handleDeleteFingerprintRule 删除指纹规则
+func (s *Server) handleDeleteFingerprintRule(c echo.Context) error {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		return c.JSON(http.StatusOK, Error(400, "无效的规则ID"))
+	}
+
+	if err := s.netService.DeleteFingerprintRule(uint(id)); err != nil {
+		return c.JSON(http.StatusOK, Error(500, err.Error()))
+	}
+	return c.JSON(http.StatusOK, Success("已删除"))
+}
+
+// handleListFingerprintMatches 获取命中指纹规则的远程IP
+func (s *Server) handleListFingerprintMatches(c echo.Context) error {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		return c.JSON(http.StatusOK, Error(400, "无效的规则ID"))
+	}
+
+	matches, err := s.netService.ListFingerprintMatches(uint(id))
+	if err != nil {
+		return c.JSON(http.StatusOK, Error(500, err.Error()))
+	}
+	return c.JSON(http.StatusOK, Success(matches))
+}
+
+// handleListQuotaRules 获取全部配额规则及当前周期的用量
+func (s *Server) handleListQuotaRules(c echo.Context) error {
+	rules, err := s.netService.ListQuotaRules()
+	if err != nil {
+		return c.JSON(http.StatusOK, Error(500, err.Error()))
+	}
+	return c.JSON(http.StatusOK, Success(rules))
+}
+
+// handleCreateQuotaRule 创建配额规则
+func (s *Server) handleCreateQuotaRule(c echo.Context) error {
+	var r CreateQuotaRuleRequest
+	if err := c.Bind(&r); err != nil {
+		return c.JSON(http.StatusOK, Error(400, "参数错误"))
+	}
+	r.Target = strings.TrimSpace(r.Target)
+	if r.Target == "" {
+		return c.JSON(http.StatusOK, Error(400, "IP或CIDR不能为空"))
+	}
+	if r.Budget == 0 {
+		return c.JSON(http.StatusOK, Error(400, "配额必须大于0"))
+	}
+	if r.Action != store.ActionBan && r.Action != store.QuotaActionLimit && r.Action != store.QuotaActionNotify {
+		return c.JSON(http.StatusOK, Error(400, "动作必须为limit、ban或notify"))
+	}
+	if r.Action == store.QuotaActionLimit && r.LimitRate == 0 {
+		return c.JSON(http.StatusOK, Error(400, "limit动作需要指定限速"))
+	}
+
+	rule, err := s.netService.CreateQuotaRule(r.Target, r.PerIP, r.Budget, r.Period, r.Action, r.LimitRate, r.GroupId, r.Description)
+	if errors.Is(err, service.ErrIPv6NotSupported) {
+		return c.JSON(http.StatusOK, Error(400, err.Error()))
+	} else if err != nil {
+		return c.JSON(http.StatusOK, Error(500, err.Error()))
+	}
+	return c.JSON(http.StatusOK, Success(rule))
+}
+
+// handleDeleteQuotaRule 删除配额规则
+func (s *Server) handleDeleteQuotaRule(c echo.Context) error {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		return c.JSON(http.StatusOK, Error(400, "无效的规则ID"))
+	}
+
+	if err := s.netService.DeleteQuotaRule(uint(id)); err != nil {
+		return c.JSON(http.StatusOK, Error(500, err.Error()))
+	}
+	return c.JSON(http.StatusOK, Success("已删除"))
+}
+
+// handleGetQuotaUsage 获取配额规则当前周期内各远程IP或网段的用量
+func (s *Server) handleGetQuotaUsage(c echo.Context) error {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		return c.JSON(http.StatusOK, Error(400, "无效的规则ID"))
+	}
+	limit := 100
+	if v := c.QueryParam("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > 10000 {
+			return c.JSON(http.StatusOK, Error(400, "limit必须在1到10000之间"))
+		}
+		limit = n
+	}
+
+	usages, err := s.netService.GetQuotaUsage(uint(id), limit)
+	if err != nil {
+		return c.JSON(http.StatusOK, Error(500, err.Error()))
+	}
+	return c.JSON(http.StatusOK, Success(usages))
+}
+
+// handleGetPrefixTraffic 按网段聚合流量统计
+func (s *Server) handleGetPrefixTraffic(c echo.Context) error {
+	v4Len, v6Len := 24, 64
+	if v := c.QueryParam("v4"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 || n > 32 {
+			return c.JSON(http.StatusOK, Error(400, "IPv4前缀长度必须在0到32之间"))
+		}
+		v4Len = n
+	}
+	if v := c.QueryParam("v6"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 || n > 128 {
+			return c.JSON(http.StatusOK, Error(400, "IPv6前缀长度必须在0到128之间"))
+		}
+		v6Len = n
+	}
+
+	prefixes, err := s.netService.GetPrefixStats(v4Len, v6Len)
+	if err != nil {
+		return c.JSON(http.StatusOK, Error(500, err.Error()))
+	}
+	return c.JSON(http.StatusOK, Success(prefixes))
+}
+
+// handleGetTrafficDetail 获取单个远程IP的流量详情
+func (s *Server) handleGetTrafficDetail(c echo.Context) error {
+	ip := c.Param("ip")
+	if net.ParseIP(ip) == nil {
+		return c.JSON(http.StatusOK, Error(400, "无效的IP地址"))
+	}
+
+	detail, err := s.netService.GetTrafficDetail(ip)
+	if errors.Is(err, service.ErrTrafficNotFound) {
+		return c.JSON(http.StatusOK, Error(404, err.Error()))
+	} else if err != nil {
+		return c.JSON(http.StatusOK, Error(500, err.Error()))
+	}
+	return c.JSON(http.StatusOK, Success(detail))
+}
+
+// handleGetTrafficHistory 获取单个远程IP的历史速率
+func (s *Server) handleGetTrafficHistory(c echo.Context) error {
+	ip := c.Param("ip")
+	if net.ParseIP(ip) == nil {
+		return c.JSON(http.StatusOK, Error(400, "无效的IP地址"))
+	}
+
+	history, err := s.netService.GetTrafficHistory(ip)
+	if errors.Is(err, service.ErrTrafficNotFound) {
+		return c.JSON(http.StatusOK, Error(404, err.Error()))
+	} else if err != nil {
+		return c.JSON(http.StatusOK, Error(500, err.Error()))
+	}
+	return c.JSON(http.StatusOK, Success(history))
+}
+
+// handleGetTrafficByLocal 获取与指定本地IP通信的全部远程IP
+func (s *Server) handleGetTrafficByLocal(c echo.Context) error {
+	ip := net.ParseIP(c.Param("ip"))
+	if ip == nil {
+		return c.JSON(http.StatusOK, Error(400, "无效的IP地址"))
+	}
+
+	// 统计中的本地IP是规范格式，大写或IPv4映射的IPv6地址需要先转换
+	peers, err := s.netService.GetTrafficByLocal(ip.String())
+	if err != nil {
+		return c.JSON(http.StatusOK, Error(500, err.Error()))
+	}
+	return c.JSON(http.StatusOK, Success(peers))
+}
+
+// handleGetHistoricalTopTalkers 获取过去某段时间内总流量最大的远程IP
+func (s *Server) handleGetHistoricalTopTalkers(c echo.Context) error {
+	end := time.Now()
+	if v := c.QueryParam("end"); v != "" {
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			return c.JSON(http.StatusOK, Error(400, "无效的结束时间"))
+		}
+		end = t
+	}
+	start := end.Add(-24 * time.Hour)
+	if v := c.QueryParam("start"); v != "" {
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			return c.JSON(http.StatusOK, Error(400, "无效的开始时间"))
+		}
+		start = t
+	}
+	if !start.Before(end) {
+		return c.JSON(http.StatusOK, Error(400, "开始时间必须早于结束时间"))
+	}
+	limit := 20
+	if v := c.QueryParam("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 || n > 1000 {
+			return c.JSON(http.StatusOK, Error(400, "limit必须在1到1000之间"))
+		}
+		limit = n
+	}
+
+	talkers, err := s.netService.GetHistoricalTopTalkers(start, end, limit)
+	if err != nil {
+		return c.JSON(http.StatusOK, Error(500, err.Error()))
+	}
+	return c.JSON(http.StatusOK, Success(talkers))
+}
+
 func (s *Server) handleCreateIpNet(c echo.Context) error {
 	var r CreateIPNetRequest
 	if err := c.Bind(&r); err != nil || r.IpNet == "" {
@@ -98,7 +505,9 @@ func (s *Server) handleCreateIpNet(c echo.Context) error {
 	}
 
 	err := s.netService.CreateOrUpdateIpNet(r.IpNet, r.GroupId, r.Action)
-	if err != nil {
+	if errors.Is(err, service.ErrIPv6NotSupported) {
+		return c.JSON(http.StatusOK, Error(400, err.Error()))
+	} else if err != nil {
 		return c.JSON(http.StatusOK, Error(500, err.Error()))
 	}
 	return c.JSON(http.StatusOK, Success("已禁用"))
@@ -143,6 +552,7 @@ func (s *Server) handleListAllActions(c echo.Context) error {
 	actions := []string{
 		store.ActionBan,
 		store.ActionAllow,
+		store.ActionWatch,
 	}
 	return c.JSON(http.StatusOK, Success(actions))
 }
@@ -154,7 +564,9 @@ func (s *Server) handleUpdateIpNetAction(c echo.Context) error {
 	}
 
 	err := s.netService.UpdateIpNetAction(r.ID, r.Action)
-	if err != nil {
+	if errors.Is(err, service.ErrIPv6NotSupported) {
+		return c.JSON(http.StatusOK, Error(400, err.Error()))
+	} else if err != nil {
 		return c.JSON(http.StatusOK, Error(500, err.Error()))
 	}
 	return c.JSON(http.StatusOK, Success("批量禁用成功"))
@@ -7,6 +7,7 @@ import { AuthProvider } from './context/AuthContext';
 import TrafficMonitor from './pages/TrafficMonitor';
 import IPManagement from './pages/IPManagement';
 import GroupManagement from './pages/GroupManagement';
+import PacketCapture from './pages/PacketCapture';
 import NotFound from './pages/NotFound';
 
 const theme = createTheme({
@@ -42,6 +43,7 @@ function App() {
                 <Route path="/" element={<TrafficMonitor />} />
                 <Route path="/ip-management" element={<IPManagement />} />
                 <Route path="/groups" element={<GroupManagement />} />
+                <Route path="/captures" element={<PacketCapture />} />
                 <Route path="*" element={<NotFound />} />
               </Routes>
             </Layout>
@@ -25,6 +25,7 @@ import {
   Monitor as MonitorIcon,
   Block as BlockIcon,
   Group as GroupIcon,
+  NetworkCheck as CaptureIcon,
   ChevronLeft as ChevronLeftIcon,
   ChevronRight as ChevronRightIcon,
   AccountCircle as AccountCircleIcon,
@@ -38,6 +39,7 @@ const menuItems = [
   { text: '网络流量监控', icon: <MonitorIcon />, path: '/' },
   { text: 'IP管理', icon: <BlockIcon />, path: '/ip-management' },
   { text: '组管理', icon: <GroupIcon />, path: '/groups' },
+  { text: '抓包', icon: <CaptureIcon />, path: '/captures' },
 ];
 
 function Layout({ children }) {
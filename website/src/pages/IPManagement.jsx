@@ -445,6 +445,8 @@ function IPManagement() {
         return '禁用';
       case 'allow':
         return '允许';
+      case 'watch':
+        return '观察';
       default:
         return action;
     }
@@ -457,6 +459,8 @@ function IPManagement() {
         return 'error';
       case 'allow':
         return 'success';
+      case 'watch':
+        return 'warning';
       default:
         return 'default';
     }
@@ -0,0 +1,338 @@
+import { useState, useEffect, useCallback } from 'react';
+import { useSearchParams } from 'react-router-dom';
+import {
+  Box,
+  Typography,
+  Paper,
+  Table,
+  TableBody,
+  TableCell,
+  TableContainer,
+  TableHead,
+  TableRow,
+  Button,
+  Alert,
+  CircularProgress,
+  Tooltip,
+  TextField,
+  IconButton,
+  Chip,
+  Grid,
+} from '@mui/material';
+import {
+  Refresh as RefreshIcon,
+  PlayArrow as PlayIcon,
+  Download as DownloadIcon,
+  Delete as DeleteIcon,
+} from '@mui/icons-material';
+import { useMessageSnackbar, MessageSnackbar } from '../components/MessageSnackbar';
+import { useConfirmDialog, ConfirmDialog } from '../components/ConfirmDialog';
+
+// 格式化字节数
+const formatBytes = (bytes) => {
+  if (!bytes || bytes <= 0) return '0 B';
+  const units = ['B', 'KB', 'MB', 'GB'];
+  const i = Math.min(Math.floor(Math.log(bytes) / Math.log(1024)), units.length - 1);
+  return (bytes / Math.pow(1024, i)).toFixed(i === 0 ? 0 : 1) + ' ' + units[i];
+};
+
+// 格式化时间戳
+const formatTimestamp = (timestamp) => {
+  if (!timestamp) return '-';
+  const date = new Date(timestamp);
+  return date.toLocaleString('zh-CN', {
+    year: 'numeric',
+    month: '2-digit',
+    day: '2-digit',
+    hour: '2-digit',
+    minute: '2-digit',
+    second: '2-digit'
+  });
+};
+
+const statusChips = {
+  running: { label: '抓包中', color: 'info' },
+  completed: { label: '已完成', color: 'success' },
+  failed: { label: '失败', color: 'error' },
+};
+
+function PacketCapture() {
+  const [searchParams] = useSearchParams();
+  const [captures, setCaptures] = useState([]);
+  const [loading, setLoading] = useState(false);
+  const [error, setError] = useState(null);
+
+  // 新建抓包表单，从流量监控页跳转时带入目标IP
+  const [target, setTarget] = useState(searchParams.get('target') || '');
+  const [duration, setDuration] = useState(60);
+  const [packetLimit, setPacketLimit] = useState(10000);
+  const [startLoading, setStartLoading] = useState(false);
+
+  // 使用消息提示Hook
+  const { snackbar, showMessage, hideMessage } = useMessageSnackbar();
+
+  // 使用确认对话框Hook
+  const { confirmDialog, showConfirm, handleConfirm, handleCancel } = useConfirmDialog();
+
+  // 获取抓包任务列表
+  const fetchCaptures = useCallback(async (showLoading = true) => {
+    if (showLoading) setLoading(true);
+    setError(null);
+    try {
+      const response = await fetch('/api/capture');
+      const result = await response.json();
+      if (result.code === 200) {
+        setCaptures(result.data || []);
+      } else {
+        setError('获取抓包任务失败: ' + result.message);
+      }
+    } catch (error) {
+      setError('网络请求失败');
+      console.error('获取抓包任务失败:', error);
+    } finally {
+      if (showLoading) setLoading(false);
+    }
+  }, []);
+
+  // 开始抓包
+  const startCapture = async () => {
+    if (!target.trim()) {
+      showMessage('请输入IP或CIDR', 'warning');
+      return;
+    }
+
+    setStartLoading(true);
+    try {
+      const response = await fetch('/api/capture', {
+        method: 'POST',
+        headers: { 'Content-Type': 'application/json' },
+        body: JSON.stringify({
+          target: target.trim(),
+          duration: Number(duration) || 0,
+          packet_limit: Number(packetLimit) || 0
+        })
+      });
+      const result = await response.json();
+      if (result.code === 200) {
+        showMessage('已开始抓包');
+        await fetchCaptures(false);
+      } else {
+        showMessage('抓包失败: ' + result.message, 'error');
+      }
+    } catch (error) {
+      showMessage('抓包失败: 网络错误', 'error');
+    } finally {
+      setStartLoading(false);
+    }
+  };
+
+  // 删除抓包任务
+  const deleteCapture = (capture) => {
+    showConfirm(
+      '确认删除',
+      capture.status === 'running'
+        ? `抓包任务 ${capture.target} 正在进行，确定要停止并删除吗？`
+        : `确定要删除抓包任务 ${capture.target} 及其文件吗？`,
+      'warning',
+      async () => {
+        try {
+          const response = await fetch(`/api/capture/${capture.id}`, {
+            method: 'DELETE'
+          });
+          const result = await response.json();
+          if (result.code === 200) {
+            showMessage('已删除');
+            await fetchCaptures(false);
+          } else {
+            showMessage('删除失败: ' + result.message, 'error');
+          }
+        } catch (error) {
+          showMessage('删除失败: 网络错误', 'error');
+        }
+      }
+    );
+  };
+
+  // 初始化
+  useEffect(() => {
+    fetchCaptures();
+  }, [fetchCaptures]);
+
+  // 有进行中的任务时定期刷新
+  const hasRunning = captures.some((capture) => capture.status === 'running');
+  useEffect(() => {
+    if (!hasRunning) return;
+    const timer = setInterval(() => fetchCaptures(false), 2000);
+    return () => clearInterval(timer);
+  }, [hasRunning, fetchCaptures]);
+
+  return (
+    <Box>
+      <Typography variant="h4" gutterBottom>
+        抓包
+      </Typography>
+
+      {error && (
+        <Alert severity="error" sx={{ mb: 2 }}>
+          {error}
+        </Alert>
+      )}
+
+      {/* 新建抓包 */}
+      <Paper sx={{ p: 2, mb: 2 }}>
+        <Grid container spacing={2} alignItems="center">
+          <Grid item xs={12} md={4}>
+            <TextField
+              fullWidth
+              size="small"
+              label="IP或CIDR"
+              placeholder="例如: 203.0.113.7 或 203.0.113.0/24"
+              value={target}
+              onChange={(e) => setTarget(e.target.value)}
+              disabled={startLoading}
+            />
+          </Grid>
+          <Grid item xs={6} md={2}>
+            <TextField
+              fullWidth
+              size="small"
+              type="number"
+              label="时长（秒）"
+              value={duration}
+              onChange={(e) => setDuration(e.target.value)}
+              disabled={startLoading}
+            />
+          </Grid>
+          <Grid item xs={6} md={2}>
+            <TextField
+              fullWidth
+              size="small"
+              type="number"
+              label="最多包数"
+              value={packetLimit}
+              onChange={(e) => setPacketLimit(e.target.value)}
+              disabled={startLoading}
+            />
+          </Grid>
+          <Grid item xs={12} md={4}>
+            <Box sx={{ display: 'flex', gap: 2 }}>
+              <Button
+                variant="contained"
+                onClick={startCapture}
+                startIcon={startLoading ? <CircularProgress size={16} /> : <PlayIcon />}
+                disabled={startLoading || !target.trim()}
+              >
+                开始抓包
+              </Button>
+              <Button
+                variant="outlined"
+                onClick={() => fetchCaptures()}
+                startIcon={<RefreshIcon />}
+                disabled={loading}
+              >
+                刷新列表
+              </Button>
+            </Box>
+          </Grid>
+        </Grid>
+      </Paper>
+
+      {/* 抓包任务列表 */}
+      <Paper>
+        <TableContainer>
+          <Table>
+            <TableHead>
+              <TableRow>
+                <TableCell sx={{ fontWeight: 'bold' }}>目标</TableCell>
+                <TableCell sx={{ fontWeight: 'bold' }}>状态</TableCell>
+                <TableCell sx={{ fontWeight: 'bold' }}>包数</TableCell>
+                <TableCell sx={{ fontWeight: 'bold' }}>文件大小</TableCell>
+                <TableCell sx={{ fontWeight: 'bold' }}>开始时间</TableCell>
+                <TableCell sx={{ fontWeight: 'bold' }}>结束时间</TableCell>
+                <TableCell sx={{ fontWeight: 'bold' }}>操作</TableCell>
+              </TableRow>
+            </TableHead>
+            <TableBody>
+              {loading ? (
+                <TableRow>
+                  <TableCell colSpan={7} align="center">
+                    <CircularProgress size={24} />
+                    <Typography sx={{ ml: 1 }}>加载中...</Typography>
+                  </TableCell>
+                </TableRow>
+              ) : captures.length === 0 ? (
+                <TableRow>
+                  <TableCell colSpan={7} align="center">
+                    暂无抓包任务
+                  </TableCell>
+                </TableRow>
+              ) : (
+                captures.map((capture) => {
+                  const status = statusChips[capture.status] || { label: capture.status, color: 'default' };
+                  return (
+                    <TableRow key={capture.id} hover>
+                      <TableCell sx={{ fontFamily: 'monospace' }}>
+                        <Tooltip title={capture.filter}>
+                          <span>{capture.target}</span>
+                        </Tooltip>
+                      </TableCell>
+                      <TableCell>
+                        <Tooltip title={capture.error || (capture.truncated ? '已达到文件大小上限' : '')}>
+                          <Chip label={status.label} size="small" color={status.color} />
+                        </Tooltip>
+                      </TableCell>
+                      <TableCell>
+                        {capture.packets.toLocaleString()} / {capture.packet_limit.toLocaleString()}
+                      </TableCell>
+                      <TableCell>{formatBytes(capture.file_size)}</TableCell>
+                      <TableCell sx={{ color: 'text.secondary', fontSize: '0.875rem' }}>
+                        {formatTimestamp(capture.started_at)}
+                      </TableCell>
+                      <TableCell sx={{ color: 'text.secondary', fontSize: '0.875rem' }}>
+                        {formatTimestamp(capture.finished_at)}
+                      </TableCell>
+                      <TableCell>
+                        <Box sx={{ display: 'flex', gap: 1 }}>
+                          <Tooltip title={capture.status === 'completed' ? '下载pcap文件' : '抓包完成后可下载'}>
+                            <span>
+                              <IconButton
+                                size="small"
+                                color="primary"
+                                href={`/api/capture/${capture.id}/download`}
+                                disabled={capture.status !== 'completed'}
+                              >
+                                <DownloadIcon />
+                              </IconButton>
+                            </span>
+                          </Tooltip>
+                          <Tooltip title={capture.status === 'running' ? '停止并删除' : '删除'}>
+                            <IconButton
+                              size="small"
+                              color="error"
+                              onClick={() => deleteCapture(capture)}
+                            >
+                              <DeleteIcon />
+                            </IconButton>
+                          </Tooltip>
+                        </Box>
+                      </TableCell>
+                    </TableRow>
+                  );
+                })
+              )}
+            </TableBody>
+          </Table>
+        </TableContainer>
+      </Paper>
+
+      <MessageSnackbar snackbar={snackbar} onClose={hideMessage} />
+      <ConfirmDialog
+        confirmDialog={confirmDialog}
+        onConfirm={handleConfirm}
+        onCancel={handleCancel}
+      />
+    </Box>
+  );
+}
+
+export default PacketCapture;
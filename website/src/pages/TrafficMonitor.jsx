@@ -1,4 +1,5 @@
 import { useState, useEffect, useCallback } from 'react';
+import { useNavigate } from 'react-router-dom';
 import {
   Box,
   Typography,
@@ -66,6 +67,14 @@ const formatBytesPerSec = (bytesPerSec) => {
   }
 };
 
+// 进程名，容器中的进程附带容器ID前12位
+const formatProcess = (process) => {
+  if (process.container_id) {
+    return `${process.name} (${process.container_id.slice(0, 12)})`;
+  }
+  return `${process.name} (${process.pid})`;
+};
+
 const formatTimestamp = (timestamp) => {
   const date = new Date(timestamp);
   return date.toLocaleString('zh-CN', {
@@ -93,6 +102,82 @@ const columns = [
   { key: 'actions', label: '操作', sortable: false },
 ];
 
+const banCoverageLabels = {
+  full: { label: '已全部禁用', color: 'error' },
+  partial: { label: '部分禁用', color: 'warning' },
+  none: { label: '未禁用', color: 'default' },
+};
+
+// 按网段聚合的流量表格
+function PrefixTrafficTable({ data, onBan }) {
+  const sorted = [...data].sort((a, b) => b.bytes_in_per_sec - a.bytes_in_per_sec);
+
+  return (
+    <TableContainer>
+      <Table>
+        <TableHead>
+          <TableRow>
+            {['网段', 'IP数', '总接收流量', '总发送流量', '接收速率', '发送速率', '连接数', '禁用状态', '操作'].map((label) => (
+              <TableCell key={label} sx={{ fontWeight: 'bold' }}>{label}</TableCell>
+            ))}
+          </TableRow>
+        </TableHead>
+        <TableBody>
+          {sorted.length === 0 ? (
+            <TableRow>
+              <TableCell colSpan={9} align="center">
+                暂无数据
+              </TableCell>
+            </TableRow>
+          ) : (
+            sorted.map((row) => (
+              <TableRow key={row.prefix} hover>
+                <TableCell sx={{ fontFamily: 'monospace' }}>{row.prefix}</TableCell>
+                <TableCell sx={{ fontFamily: 'monospace' }}>{row.ip_count}</TableCell>
+                <TableCell sx={{ fontFamily: 'monospace' }}>{formatBytes(row.total_bytes_in)}</TableCell>
+                <TableCell sx={{ fontFamily: 'monospace' }}>{formatBytes(row.total_bytes_out)}</TableCell>
+                <TableCell sx={{ fontFamily: 'monospace' }}>{formatBytesPerSec(row.bytes_in_per_sec)}</TableCell>
+                <TableCell sx={{ fontFamily: 'monospace' }}>{formatBytesPerSec(row.bytes_out_per_sec)}</TableCell>
+                <TableCell sx={{ fontFamily: 'monospace' }}>{row.connections}</TableCell>
+                <TableCell>
+                  <Chip
+                    label={banCoverageLabels[row.ban_coverage]?.label || row.ban_coverage}
+                    color={banCoverageLabels[row.ban_coverage]?.color || 'default'}
+                    size="small"
+                  />
+                </TableCell>
+                <TableCell>
+                  <Tooltip
+                    title={
+                      row.ban_coverage === 'full'
+                        ? '已禁用'
+                        : row.family === 'ipv6'
+                          ? '防火墙暂不支持IPv6网段'
+                          : '禁用整个网段'
+                    }
+                  >
+                    <span>
+                      <Button
+                        variant="outlined"
+                        size="small"
+                        color="error"
+                        disabled={row.ban_coverage === 'full' || row.family === 'ipv6'}
+                        onClick={() => onBan(row.prefix)}
+                      >
+                        {row.ban_coverage === 'full' ? '已禁用' : '禁用网段'}
+                      </Button>
+                    </span>
+                  </Tooltip>
+                </TableCell>
+              </TableRow>
+            ))
+          )}
+        </TableBody>
+      </Table>
+    </TableContainer>
+  );
+}
+
 function TrafficMonitor() {
   const [trafficData, setTrafficData] = useState([]);
   const [loading, setLoading] = useState(false);
@@ -114,9 +199,16 @@ function TrafficMonitor() {
   const [filterRemoteIP, setFilterRemoteIP] = useState('');
   const [filterLocalIP, setFilterLocalIP] = useState('');
   const [showFilters, setShowFilters] = useState(false);
+
+  // 网段聚合相关状态
+  const [aggregate, setAggregate] = useState('ip');
+  const [v4Prefix, setV4Prefix] = useState(24);
+  const [v6Prefix, setV6Prefix] = useState(64);
+  const [prefixData, setPrefixData] = useState([]);
   
   // 使用消息提示Hook
   const { snackbar, showMessage, hideMessage } = useMessageSnackbar();
+  const navigate = useNavigate();
 
   // 获取流量数据
   const fetchTrafficData = useCallback(async (showLoading = false) => {
@@ -125,10 +217,17 @@ function TrafficMonitor() {
     }
     setError(null);
     try {
-      const response = await fetch('/api/traffic');
+      const url = aggregate === 'prefix'
+        ? `/api/traffic/prefix?v4=${v4Prefix}&v6=${v6Prefix}`
+        : '/api/traffic';
+      const response = await fetch(url);
       const result = await response.json();
       if (result.code === 200) {
-        setTrafficData(result.data);
+        if (aggregate === 'prefix') {
+          setPrefixData(result.data);
+        } else {
+          setTrafficData(result.data);
+        }
         setLastUpdate(new Date());
       } else {
         setError('获取数据失败: ' + result.message);
@@ -140,7 +239,7 @@ function TrafficMonitor() {
       setLoading(false);
       setInitialLoading(false);
     }
-  }, []);
+  }, [aggregate, v4Prefix, v6Prefix]);
 
   // 禁用IP
   const banIP = async (ip) => {
@@ -158,7 +257,7 @@ function TrafficMonitor() {
       if (result.code === 200) {
         // 更新本地数据，将对应IP标记为已禁用
         setTrafficData(prev => prev.map(item => 
-          item.remote_ip === ip ? { ...item, is_banned: true } : item
+          item.remote_ip === ip ? { ...item, is_banned: true, verdict: 'ban' } : item
         ));
         showMessage(`成功禁用 ${ip}`);
       } else {
@@ -169,6 +268,32 @@ function TrafficMonitor() {
     }
   };
 
+  // 禁用整个网段
+  const banPrefix = async (prefix) => {
+    try {
+      const response = await fetch('/api/ip', {
+        method: 'POST',
+        headers: { 'Content-Type': 'application/json' },
+        body: JSON.stringify({
+          ip_net: prefix,
+          group_id: 0, // 使用默认组
+          action: 'ban'
+        })
+      });
+      const result = await response.json();
+      if (result.code === 200) {
+        setPrefixData(prev => prev.map(item =>
+          item.prefix === prefix ? { ...item, ban_coverage: 'full' } : item
+        ));
+        showMessage(`成功禁用网段 ${prefix}`);
+      } else {
+        showMessage('禁用失败: ' + result.message, 'error');
+      }
+    } catch (error) {
+      showMessage('禁用失败: 网络错误', 'error');
+    }
+  };
+
   // 分页处理函数
   const handleChangePage = (event, newPage) => {
     setPage(newPage);
@@ -322,6 +447,11 @@ function TrafficMonitor() {
     }
   }, [refreshInterval, startRefresh]);
 
+  // 切换聚合方式或前缀长度时重新加载
+  useEffect(() => {
+    fetchTrafficData(false);
+  }, [aggregate, v4Prefix, v6Prefix]);
+
   return (
     <Box>
       <Typography variant="h4" gutterBottom>
@@ -334,6 +464,12 @@ function TrafficMonitor() {
         </Alert>
       )}
 
+      {trafficData.some(item => item.sampled) && (
+        <Alert severity="info" sx={{ mb: 2 }}>
+          当前为抽样统计（每{trafficData.find(item => item.sampled).sample_rate}个包处理1个），流量、包数和新建连接数为估算值
+        </Alert>
+      )}
+
       {/* 刷新控制 */}
       <Paper sx={{ p: 2, mb: 2 }}>
         <Box sx={{ display: 'flex', alignItems: 'center', gap: 2, flexWrap: 'wrap' }}>
@@ -390,6 +526,41 @@ function TrafficMonitor() {
           </Typography>
         </Box>
 
+        {/* 聚合方式 */}
+        <Box sx={{ mt: 2, display: 'flex', alignItems: 'center', gap: 2, flexWrap: 'wrap' }}>
+          <Typography variant="body2" color="text.secondary">
+            聚合方式：
+          </Typography>
+          <FormControl size="small" sx={{ minWidth: 120 }}>
+            <Select value={aggregate} onChange={(e) => setAggregate(e.target.value)}>
+              <MenuItem value="ip">单个IP</MenuItem>
+              <MenuItem value="prefix">按网段</MenuItem>
+            </Select>
+          </FormControl>
+          {aggregate === 'prefix' && (
+            <>
+              <TextField
+                size="small"
+                type="number"
+                label="IPv4前缀长度"
+                value={v4Prefix}
+                onChange={(e) => setV4Prefix(Math.min(32, Math.max(0, parseInt(e.target.value) || 0)))}
+                inputProps={{ min: 0, max: 32 }}
+                sx={{ width: 130 }}
+              />
+              <TextField
+                size="small"
+                type="number"
+                label="IPv6前缀长度"
+                value={v6Prefix}
+                onChange={(e) => setV6Prefix(Math.min(128, Math.max(0, parseInt(e.target.value) || 0)))}
+                inputProps={{ min: 0, max: 128 }}
+                sx={{ width: 130 }}
+              />
+            </>
+          )}
+        </Box>
+
         {/* 过滤面板 */}
         <Box sx={{ mt: 2, display: 'flex', alignItems: 'center', gap: 2, flexWrap: 'wrap' }}>
           <Button
@@ -479,115 +650,163 @@ function TrafficMonitor() {
         </Box>
       </Paper>
 
-      {/* 数据表格 */}
-      <Paper>
-        <TableContainer>
-          <Table>
-            <TableHead>
-              <TableRow>
-                {columns.map((column) => (
-                  <TableCell
-                    key={column.key}
-                    sx={{
-                      fontWeight: 'bold',
-                      cursor: column.sortable ? 'pointer' : 'default',
-                      '&:hover': column.sortable ? { backgroundColor: 'action.hover' } : {},
-                    }}
-                    onClick={() => column.sortable && handleSort(column.key)}
-                  >
-                    <Box sx={{ display: 'flex', alignItems: 'center' }}>
-                      {column.label}
-                      {column.sortable && sortKey === column.key && (
-                        <Box component="span" sx={{ ml: 0.5 }}>
-                          {sortAsc ? <ArrowUpIcon fontSize="small" /> : <ArrowDownIcon fontSize="small" />}
-                        </Box>
-                      )}
-                    </Box>
-                  </TableCell>
-                ))}
-              </TableRow>
-            </TableHead>
-            <TableBody>
-              {initialLoading ? (
+      {/* 数据表格，按网段聚合时显示网段表格 */}
+      {aggregate === 'prefix' ? (
+        <Paper>
+          <PrefixTrafficTable data={prefixData} onBan={banPrefix} />
+        </Paper>
+      ) : (
+        <Paper>
+          <TableContainer>
+            <Table>
+              <TableHead>
                 <TableRow>
-                  <TableCell colSpan={columns.length} align="center">
-                    <CircularProgress size={24} />
-                    <Typography sx={{ ml: 1 }}>加载中...</Typography>
-                  </TableCell>
-                </TableRow>
-              ) : getCurrentPageData().length === 0 ? (
-                <TableRow>
-                  <TableCell colSpan={columns.length} align="center">
-                    暂无数据
-                  </TableCell>
-                </TableRow>
-              ) : (
-                getCurrentPageData().map((row, index) => (
-                  <TableRow key={index} hover>
-                    <TableCell sx={{ fontFamily: 'monospace' }}>{row.remote_ip}</TableCell>
-                    <TableCell sx={{ fontFamily: 'monospace' }}>{row.local_ip}</TableCell>
-                    <TableCell sx={{ fontFamily: 'monospace' }}>
-                      {formatBytes(row.total_bytes_in)}
-                    </TableCell>
-                    <TableCell sx={{ fontFamily: 'monospace' }}>
-                      {formatBytes(row.total_bytes_out)}
-                    </TableCell>
-                    <TableCell sx={{ fontFamily: 'monospace' }}>
-                      {row.total_packets_in.toLocaleString()}
-                    </TableCell>
-                    <TableCell sx={{ fontFamily: 'monospace' }}>
-                      {row.total_packets_out.toLocaleString()}
-                    </TableCell>
-                    <TableCell sx={{ fontFamily: 'monospace' }}>
-                      {formatBytesPerSec(row.bytes_in_per_sec)}
-                    </TableCell>
-                    <TableCell sx={{ fontFamily: 'monospace' }}>
-                      {formatBytesPerSec(row.bytes_out_per_sec)}
+                  {columns.map((column) => (
+                    <TableCell
+                      key={column.key}
+                      sx={{
+                        fontWeight: 'bold',
+                        cursor: column.sortable ? 'pointer' : 'default',
+                        '&:hover': column.sortable ? { backgroundColor: 'action.hover' } : {},
+                      }}
+                      onClick={() => column.sortable && handleSort(column.key)}
+                    >
+                      <Box sx={{ display: 'flex', alignItems: 'center' }}>
+                        {column.label}
+                        {column.sortable && sortKey === column.key && (
+                          <Box component="span" sx={{ ml: 0.5 }}>
+                            {sortAsc ? <ArrowUpIcon fontSize="small" /> : <ArrowDownIcon fontSize="small" />}
+                          </Box>
+                        )}
+                      </Box>
                     </TableCell>
-                    <TableCell sx={{ fontFamily: 'monospace' }}>
-                      {row.connections}
-                    </TableCell>
-                    <TableCell sx={{ color: 'text.secondary', fontSize: '0.875rem' }}>
-                      {formatTimestamp(row.first_seen)}
-                    </TableCell>
-                    <TableCell sx={{ color: 'text.secondary', fontSize: '0.875rem' }}>
-                      {formatTimestamp(row.last_seen)}
+                  ))}
+                </TableRow>
+              </TableHead>
+              <TableBody>
+                {initialLoading ? (
+                  <TableRow>
+                    <TableCell colSpan={columns.length} align="center">
+                      <CircularProgress size={24} />
+                      <Typography sx={{ ml: 1 }}>加载中...</Typography>
                     </TableCell>
-                    <TableCell>
-                      <Tooltip title={row.is_banned ? '已禁用' : '禁用此IP'}>
-                        <Button
-                          variant="outlined"
-                          size="small"
-                          color="error"
-                          disabled={row.is_banned}
-                          onClick={() => banIP(row.remote_ip)}
-                        >
-                          {row.is_banned ? '已禁用' : '禁用'}
-                        </Button>
-                      </Tooltip>
+                  </TableRow>
+                ) : getCurrentPageData().length === 0 ? (
+                  <TableRow>
+                    <TableCell colSpan={columns.length} align="center">
+                      暂无数据
                     </TableCell>
                   </TableRow>
-                ))
-              )}
-            </TableBody>
-          </Table>
-        </TableContainer>
+                ) : (
+                  getCurrentPageData().map((row, index) => (
+                    <TableRow
+                      key={index}
+                      hover
+                      sx={row.verdict === 'watch' ? { bgcolor: 'rgba(237, 108, 2, 0.08)' } : undefined}
+                    >
+                      <TableCell sx={{ fontFamily: 'monospace' }}>
+                        {row.remote_ip}
+                        {row.verdict === 'watch' && (
+                          <Tooltip title="命中观察规则，流量未被拦截">
+                            <Chip label="观察" size="small" color="warning" variant="outlined" sx={{ ml: 1 }} />
+                          </Tooltip>
+                        )}
+                        {row.domain_names?.length > 0 && (
+                          <Tooltip title={row.domain_names.join(', ')}>
+                            <Typography variant="caption" color="text.secondary" display="block" noWrap sx={{ maxWidth: 240 }}>
+                              {row.domain_names[0]}
+                              {row.domain_names.length > 1 && ` +${row.domain_names.length - 1}`}
+                            </Typography>
+                          </Tooltip>
+                        )}
+                      </TableCell>
+                      <TableCell sx={{ fontFamily: 'monospace' }}>
+                        {row.local_ip}
+                        {row.processes?.length > 0 && (
+                          <Tooltip title={row.processes.map(formatProcess).join(', ')}>
+                            <Typography variant="caption" color="text.secondary" display="block" noWrap sx={{ maxWidth: 240 }}>
+                              {formatProcess(row.processes[0])}
+                              {row.processes.length > 1 && ` +${row.processes.length - 1}`}
+                            </Typography>
+                          </Tooltip>
+                        )}
+                      </TableCell>
+                      <TableCell sx={{ fontFamily: 'monospace' }}>
+                        {formatBytes(row.total_bytes_in)}
+                      </TableCell>
+                      <TableCell sx={{ fontFamily: 'monospace' }}>
+                        {formatBytes(row.total_bytes_out)}
+                      </TableCell>
+                      <TableCell sx={{ fontFamily: 'monospace' }}>
+                        {row.total_packets_in.toLocaleString()}
+                      </TableCell>
+                      <TableCell sx={{ fontFamily: 'monospace' }}>
+                        {row.total_packets_out.toLocaleString()}
+                      </TableCell>
+                      <TableCell sx={{ fontFamily: 'monospace' }}>
+                        {formatBytesPerSec(row.bytes_in_per_sec)}
+                      </TableCell>
+                      <TableCell sx={{ fontFamily: 'monospace' }}>
+                        {formatBytesPerSec(row.bytes_out_per_sec)}
+                      </TableCell>
+                      <TableCell sx={{ fontFamily: 'monospace' }}>
+                        {row.connections}
+                      </TableCell>
+                      <TableCell sx={{ color: 'text.secondary', fontSize: '0.875rem' }}>
+                        {formatTimestamp(row.first_seen)}
+                      </TableCell>
+                      <TableCell sx={{ color: 'text.secondary', fontSize: '0.875rem' }}>
+                        {formatTimestamp(row.last_seen)}
+                      </TableCell>
+                      <TableCell>
+                        <Box sx={{ display: 'flex', gap: 1 }}>
+                          <Tooltip title={row.is_banned ? '已禁用' : '禁用此IP'}>
+                            <span>
+                              <Button
+                                variant="outlined"
+                                size="small"
+                                color="error"
+                                disabled={row.is_banned}
+                                onClick={() => banIP(row.remote_ip)}
+                              >
+                                {row.is_banned ? '已禁用' : '禁用'}
+                              </Button>
+                            </span>
+                          </Tooltip>
+                          <Tooltip title="抓取该IP的数据包">
+                            <Button
+                              variant="outlined"
+                              size="small"
+                              onClick={() => navigate(`/captures?target=${encodeURIComponent(row.remote_ip)}`)}
+                            >
+                              抓包
+                            </Button>
+                          </Tooltip>
+                        </Box>
+                      </TableCell>
+                    </TableRow>
+                  ))
+                )}
+              </TableBody>
+            </Table>
+          </TableContainer>
         
-        {/* 分页组件 */}
-        <TablePagination
-          component="div"
-          count={getFilteredDataCount()}
-          page={page}
-          onPageChange={handleChangePage}
-          rowsPerPage={rowsPerPage}
-          onRowsPerPageChange={handleChangeRowsPerPage}
-          rowsPerPageOptions={[10, 25, 50, 100, customRowsPerPage].filter((value, index, self) => self.indexOf(value) === index).sort((a, b) => a - b)}
-          labelRowsPerPage="每页显示:"
-          labelDisplayedRows={({ from, to, count }) => `${from}-${to} / ${count}`}
-          showFirstButton
-          showLastButton
-        />
-      </Paper>
+          {/* 分页组件 */}
+          <TablePagination
+            component="div"
+            count={getFilteredDataCount()}
+            page={page}
+            onPageChange={handleChangePage}
+            rowsPerPage={rowsPerPage}
+            onRowsPerPageChange={handleChangeRowsPerPage}
+            rowsPerPageOptions={[10, 25, 50, 100, customRowsPerPage].filter((value, index, self) => self.indexOf(value) === index).sort((a, b) => a - b)}
+            labelRowsPerPage="每页显示:"
+            labelDisplayedRows={({ from, to, count }) => `${from}-${to} / ${count}`}
+            showFirstButton
+            showLastButton
+          />
+        </Paper>
+      )}
       <MessageSnackbar snackbar={snackbar} onClose={hideMessage} />
     </Box>
   );